@@ -39,6 +39,9 @@ func init() {
 	rootCmd.AddCommand(commands.NewSSLCommand())
 	rootCmd.AddCommand(commands.NewTestCommand())
 	rootCmd.AddCommand(commands.NewConfigCommand())
+	rootCmd.AddCommand(commands.NewDeadLetterCommand())
+	rootCmd.AddCommand(commands.NewSuppressionCommand())
+	rootCmd.AddCommand(commands.NewStorageCommand())
 	rootCmd.AddCommand(commands.ValidateCommand())
 }
 
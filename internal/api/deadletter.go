@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/middleware"
+	"github.com/grumpyguvner/gomail/internal/storage"
+)
+
+// handleListDeadLetters returns every message that exhausted its webhook retries
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := s.queue.ListDeadLetters(r.Context())
+	if err != nil {
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to list dead letters", err))
+		return
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"dead_letters": letters,
+		"count":        len(letters),
+	})
+}
+
+// handleReplayDeadLetter moves a single dead letter back to the inbox
+func (s *Server) handleReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if err := s.queue.ReplayDeadLetter(r.Context(), id); err != nil {
+		middleware.SendErrorResponse(w, deadLetterError(err))
+		return
+	}
+
+	logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Infof("Dead letter replayed: %s", id)
+	writeJSON(w, r, map[string]interface{}{
+		"status":   "success",
+		"replayed": []string{id},
+	})
+}
+
+// handleReplayAllDeadLetters moves every dead letter back to the inbox
+func (s *Server) handleReplayAllDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := s.queue.ListDeadLetters(r.Context())
+	if err != nil {
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to list dead letters", err))
+		return
+	}
+
+	replayed := []string{}
+	for _, letter := range letters {
+		if err := s.queue.ReplayDeadLetter(r.Context(), letter.ID); err != nil {
+			middleware.SendErrorResponse(w, deadLetterError(err))
+			return
+		}
+		replayed = append(replayed, letter.ID)
+	}
+
+	logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Infof("Replayed %d dead letters", len(replayed))
+	writeJSON(w, r, map[string]interface{}{
+		"status":   "success",
+		"replayed": replayed,
+	})
+}
+
+// handlePurgeDeadLetter permanently deletes a single dead letter
+func (s *Server) handlePurgeDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if err := s.purgeDeadLetter(r, id); err != nil {
+		middleware.SendErrorResponse(w, deadLetterError(err))
+		return
+	}
+
+	logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Infof("Dead letter purged: %s", id)
+	writeJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"purged": []string{id},
+	})
+}
+
+// handlePurgeAllDeadLetters permanently deletes every dead letter
+func (s *Server) handlePurgeAllDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := s.queue.ListDeadLetters(r.Context())
+	if err != nil {
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to list dead letters", err))
+		return
+	}
+
+	purged := []string{}
+	for _, letter := range letters {
+		if err := s.purgeDeadLetter(r, letter.ID); err != nil {
+			middleware.SendErrorResponse(w, deadLetterError(err))
+			return
+		}
+		purged = append(purged, letter.ID)
+	}
+
+	logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Infof("Purged %d dead letters", len(purged))
+	writeJSON(w, r, map[string]interface{}{
+		"status": "success",
+		"purged": purged,
+	})
+}
+
+// purgeDeadLetter deletes a message, refusing ones that are not dead-lettered
+func (s *Server) purgeDeadLetter(r *http.Request, id string) error {
+	status, err := s.queue.Status(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if status != storage.FolderDeadLetter {
+		return fmt.Errorf("message %s is %s: %w", id, status, storage.ErrNotFound)
+	}
+
+	return s.storage.Delete(r.Context(), id)
+}
+
+// deadLetterError maps storage errors to API errors
+func deadLetterError(err error) *errors.AppError {
+	switch {
+	case stderrors.Is(err, storage.ErrInvalidMessageID):
+		return errors.BadRequestError("Invalid message ID")
+	case stderrors.Is(err, storage.ErrNotFound):
+		return errors.NotFoundError("Dead letter not found")
+	default:
+		return errors.StorageError("Dead letter operation failed", err)
+	}
+}
+
+// writeJSON encodes response as the JSON body of a successful request
+func writeJSON(w http.ResponseWriter, r *http.Request, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Errorf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDeadLetterServer(t *testing.T) (*Server, string) {
+	cfg := &config.Config{
+		BearerToken: "test-token",
+		DataDir:     t.TempDir(),
+	}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	id, _, err := storage.StoreEmail(ctx, server.storage, &mail.EmailData{
+		Sender:     "sender@example.com",
+		Recipient:  "recipient@example.com",
+		ReceivedAt: time.Now(),
+		Raw:        "Subject: Test\r\n\r\nBody",
+	})
+	require.NoError(t, err)
+
+	err = server.queue.MarkDeadLetter(ctx, id, storage.DeadLetter{
+		LastError:  "webhook returned HTTP 503: unavailable",
+		HTTPStatus: http.StatusServiceUnavailable,
+		Attempts:   5,
+		FailedAt:   time.Now(),
+	})
+	require.NoError(t, err)
+
+	letters, err := server.queue.ListDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, id, letters[0].ID)
+
+	return server, id
+}
+
+func serveDeadLetter(server *Server, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestDeadLetter_List(t *testing.T) {
+	server, id := newDeadLetterServer(t)
+
+	w := serveDeadLetter(server, "GET", "/api/deadletter")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		DeadLetters []storage.DeadLetter `json:"dead_letters"`
+		Count       int                  `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Count)
+	assert.Equal(t, id, response.DeadLetters[0].ID)
+	assert.Equal(t, 5, response.DeadLetters[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, response.DeadLetters[0].HTTPStatus)
+}
+
+func TestDeadLetter_RequiresAuth(t *testing.T) {
+	server, _ := newDeadLetterServer(t)
+
+	req := httptest.NewRequest("GET", "/api/deadletter", nil)
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestDeadLetter_Replay(t *testing.T) {
+	server, id := newDeadLetterServer(t)
+
+	w := serveDeadLetter(server, "POST", "/api/deadletter/"+id+"/replay")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	pending, err := server.queue.Pending(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+
+	// Already replayed
+	w = serveDeadLetter(server, "POST", "/api/deadletter/"+id+"/replay")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serveDeadLetter(server, "POST", "/api/deadletter/not-an-id/replay")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDeadLetter_ReplayAll(t *testing.T) {
+	server, id := newDeadLetterServer(t)
+
+	w := serveDeadLetter(server, "POST", "/api/deadletter/replay")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), id)
+
+	letters, err := server.queue.ListDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+func TestDeadLetter_Purge(t *testing.T) {
+	server, id := newDeadLetterServer(t)
+
+	w := serveDeadLetter(server, "DELETE", "/api/deadletter/"+id)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	letters, err := server.queue.ListDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+
+	pending, err := server.queue.Pending(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	w = serveDeadLetter(server, "DELETE", "/api/deadletter/"+id)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeadLetter_PurgeRefusesLiveMessages(t *testing.T) {
+	server, _ := newDeadLetterServer(t)
+
+	id, _, err := storage.StoreEmail(context.Background(), server.storage, &mail.EmailData{
+		Sender:    "sender@example.com",
+		Recipient: "recipient@example.com",
+	})
+	require.NoError(t, err)
+
+	w := serveDeadLetter(server, "DELETE", "/api/deadletter/"+id)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	pending, err := server.queue.Pending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, pending)
+}
+
+func TestDeadLetter_PurgeAll(t *testing.T) {
+	server, _ := newDeadLetterServer(t)
+
+	w := serveDeadLetter(server, "DELETE", "/api/deadletter")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	letters, err := server.queue.ListDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
@@ -0,0 +1,431 @@
+package api
+
+import (
+	stderrors "errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/middleware"
+	"github.com/grumpyguvner/gomail/internal/storage"
+)
+
+const (
+	defaultEmailPageSize = 50
+	maxEmailPageSize     = 500
+)
+
+// emailSummary is a stored email as returned by the list endpoint
+type emailSummary struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status,omitempty"`
+	Sender     string    `json:"sender"`
+	Recipient  string    `json:"recipient"`
+	Recipients []string  `json:"recipients,omitempty"`
+	Subject    string    `json:"subject"`
+	MessageID  string    `json:"message_id"`
+	ReceivedAt time.Time `json:"received_at"`
+	SizeBytes  int       `json:"size_bytes"`
+}
+
+// emailDetail is a stored email as returned by the get endpoint
+type emailDetail struct {
+	ID     string `json:"id"`
+	Status string `json:"status,omitempty"`
+	*mail.EmailData
+}
+
+// emailQuery holds the parsed list filters
+type emailQuery struct {
+	since     time.Time
+	until     time.Time
+	sender    string
+	recipient string
+	limit     int
+	offset    int
+}
+
+// handleListEmails returns stored emails, newest first
+func (s *Server) handleListEmails(w http.ResponseWriter, r *http.Request) {
+	query, err := parseEmailQuery(r)
+	if err != nil {
+		middleware.SendErrorResponse(w, err)
+		return
+	}
+
+	// Indexed backends filter and paginate without loading each message
+	if searcher, ok := s.storage.(storage.Searcher); ok {
+		s.searchEmails(w, r, searcher, query)
+		return
+	}
+
+	ids, listErr := s.storage.List(r.Context())
+	if listErr != nil {
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to list emails", listErr))
+		return
+	}
+
+	// Without sender or recipient filters the IDs alone give the total, so
+	// only the requested page is loaded
+	if query.sender == "" && query.recipient == "" {
+		s.listEmailPage(w, r, ids, query)
+		return
+	}
+
+	// Sender and recipient filters need the message contents, so the page
+	// is cut after filtering to keep totals accurate
+	emails := []emailSummary{}
+	total := 0
+	for i := len(ids) - 1; i >= 0; i-- {
+		id := ids[i]
+		if !query.includes(id) {
+			continue
+		}
+
+		email, loadErr := storage.LoadEmail(r.Context(), s.storage, id)
+		if loadErr != nil {
+			// The message may have been deleted since it was listed
+			logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Debugf("Skipping %s: %v", id, loadErr)
+			continue
+		}
+		if !containsFold(email.Sender, query.sender) || !anyContainsFold(email.EnvelopeRecipients(), query.recipient) {
+			continue
+		}
+
+		if total >= query.offset && len(emails) < query.limit {
+			emails = append(emails, s.summarize(r, id, email))
+		}
+		total++
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"emails": emails,
+		"total":  total,
+		"limit":  query.limit,
+		"offset": query.offset,
+	})
+}
+
+// listEmailPage serves an unfiltered list, counting the IDs in the date
+// range and loading only the messages on the requested page
+func (s *Server) listEmailPage(w http.ResponseWriter, r *http.Request, ids []string, query emailQuery) {
+	var matched []string
+	for i := len(ids) - 1; i >= 0; i-- {
+		if query.includes(ids[i]) {
+			matched = append(matched, ids[i])
+		}
+	}
+
+	emails := []emailSummary{}
+	if query.offset < len(matched) {
+		end := min(query.offset+query.limit, len(matched))
+		for _, id := range matched[query.offset:end] {
+			email, err := storage.LoadEmail(r.Context(), s.storage, id)
+			if err != nil {
+				// The message may have been deleted since it was listed
+				logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Debugf("Skipping %s: %v", id, err)
+				continue
+			}
+			emails = append(emails, s.summarize(r, id, email))
+		}
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"emails": emails,
+		"total":  len(matched),
+		"limit":  query.limit,
+		"offset": query.offset,
+	})
+}
+
+// summarize returns the list entry for a loaded message
+func (s *Server) summarize(r *http.Request, id string, email *mail.EmailData) emailSummary {
+	return emailSummary{
+		ID:         id,
+		Status:     s.emailStatus(r, id),
+		Sender:     email.Sender,
+		Recipient:  email.Recipient,
+		Recipients: email.EnvelopeRecipients(),
+		Subject:    email.Subject,
+		MessageID:  email.MessageID,
+		ReceivedAt: email.ReceivedAt,
+		SizeBytes:  len(email.Raw),
+	}
+}
+
+// searchEmails serves the list endpoint from a backend's metadata index
+func (s *Server) searchEmails(w http.ResponseWriter, r *http.Request, searcher storage.Searcher, query emailQuery) {
+	summaries, total, err := searcher.Search(r.Context(), storage.MessageQuery{
+		Since:     query.since,
+		Until:     query.until,
+		Sender:    query.sender,
+		Recipient: query.recipient,
+		Limit:     query.limit,
+		Offset:    query.offset,
+	})
+	if err != nil {
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to list emails", err))
+		return
+	}
+
+	emails := make([]emailSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		emails = append(emails, emailSummary{
+			ID:         summary.ID,
+			Status:     summary.Status,
+			Sender:     summary.Sender,
+			Recipient:  summary.Recipient,
+			Recipients: summary.Recipients,
+			Subject:    summary.Subject,
+			MessageID:  summary.MessageID,
+			ReceivedAt: summary.ReceivedAt,
+			SizeBytes:  summary.SizeBytes,
+		})
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"emails": emails,
+		"total":  total,
+		"limit":  query.limit,
+		"offset": query.offset,
+	})
+}
+
+// handleGetEmail returns a single stored email
+func (s *Server) handleGetEmail(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	email, err := s.loadEmail(r, id)
+	if err != nil {
+		middleware.SendErrorResponse(w, err)
+		return
+	}
+
+	writeJSON(w, r, emailDetail{
+		ID:        id,
+		Status:    s.emailStatus(r, id),
+		EmailData: email,
+	})
+}
+
+// handleGetEmailRaw returns the original RFC 5322 message
+func (s *Server) handleGetEmailRaw(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	email, err := s.loadEmail(r, id)
+	if err != nil {
+		middleware.SendErrorResponse(w, err)
+		return
+	}
+
+	// Put extracted attachments back so the message is complete again
+	raw, restoreErr := storage.RestoreAttachments(email, s.blobs)
+	if restoreErr != nil {
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to restore attachments", restoreErr))
+		return
+	}
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.eml"`)
+	if _, err := w.Write([]byte(raw)); err != nil {
+		logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Errorf("Failed to write raw email: %v", err)
+	}
+}
+
+// handleGetAttachment returns the decoded content of an attachment; n is
+// its index in the email's attachments array
+func (s *Server) handleGetAttachment(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	email, err := s.loadEmail(r, id)
+	if err != nil {
+		middleware.SendErrorResponse(w, err)
+		return
+	}
+
+	n, convErr := strconv.Atoi(r.PathValue("n"))
+	if convErr != nil || n < 0 || n >= len(email.Attachments) {
+		middleware.SendErrorResponse(w, errors.NotFoundError("Attachment not found"))
+		return
+	}
+	att := email.Attachments[n]
+
+	content, contentErr := storage.AttachmentContent(email, att, s.blobs)
+	if stderrors.Is(contentErr, storage.ErrNotFound) {
+		middleware.SendErrorResponse(w, errors.NotFoundError("Attachment content not found"))
+		return
+	}
+	if contentErr != nil {
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to read attachment", contentErr))
+		return
+	}
+
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	filename := att.Filename
+	if filename == "" {
+		filename = "attachment-" + strconv.Itoa(n)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(content); err != nil {
+		logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Errorf("Failed to write attachment: %v", err)
+	}
+}
+
+// handleDeleteEmail permanently deletes a stored email
+func (s *Server) handleDeleteEmail(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if err := s.storage.Delete(r.Context(), id); err != nil {
+		middleware.SendErrorResponse(w, emailError(err))
+		return
+	}
+
+	logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Infof("Email deleted: %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadEmail loads a stored email by ID
+func (s *Server) loadEmail(r *http.Request, id string) (*mail.EmailData, *errors.AppError) {
+	if !storage.ValidMessageID(id) {
+		return nil, emailError(storage.ErrInvalidMessageID)
+	}
+
+	email, err := storage.LoadEmail(r.Context(), s.storage, id)
+	if err != nil {
+		return nil, emailError(err)
+	}
+
+	return email, nil
+}
+
+// emailStatus returns the delivery state of a message, or "" when the
+// backend does not track delivery
+func (s *Server) emailStatus(r *http.Request, id string) string {
+	if s.queue == nil {
+		return ""
+	}
+
+	status, err := s.queue.Status(r.Context(), id)
+	if err != nil {
+		logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Debugf("No status for %s: %v", id, err)
+		return ""
+	}
+	return status
+}
+
+// includes reports whether the storage time encoded in id falls within
+// the query's date range
+func (q emailQuery) includes(id string) bool {
+	storedAt, ok := storage.MessageTime(id)
+	if !ok {
+		return false
+	}
+	if !q.since.IsZero() && storedAt.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && storedAt.After(q.until) {
+		return false
+	}
+	return true
+}
+
+// parseEmailQuery reads pagination, date-range, and address filters.
+// Dates accept RFC 3339 timestamps or YYYY-MM-DD; a bare until date
+// includes the whole day.
+func parseEmailQuery(r *http.Request) (emailQuery, *errors.AppError) {
+	q := r.URL.Query()
+	query := emailQuery{
+		sender:    strings.TrimSpace(q.Get("sender")),
+		recipient: strings.TrimSpace(q.Get("recipient")),
+		limit:     defaultEmailPageSize,
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxEmailPageSize {
+			return query, errors.BadRequestError("limit must be between 1 and " + strconv.Itoa(maxEmailPageSize))
+		}
+		query.limit = limit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return query, errors.BadRequestError("offset must be a non-negative integer")
+		}
+		query.offset = offset
+	}
+
+	var err error
+	if v := q.Get("since"); v != "" {
+		if query.since, _, err = parseQueryTime(v); err != nil {
+			return query, errors.BadRequestError("since must be an RFC 3339 timestamp or YYYY-MM-DD date")
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		var dateOnly bool
+		if query.until, dateOnly, err = parseQueryTime(v); err != nil {
+			return query, errors.BadRequestError("until must be an RFC 3339 timestamp or YYYY-MM-DD date")
+		}
+		if dateOnly {
+			query.until = query.until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+
+	if !query.since.IsZero() && !query.until.IsZero() && query.until.Before(query.since) {
+		return query, errors.BadRequestError("until must not be before since")
+	}
+
+	return query, nil
+}
+
+// parseQueryTime parses an RFC 3339 timestamp or a YYYY-MM-DD date (UTC)
+func parseQueryTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	return t, true, err
+}
+
+// containsFold reports whether s contains substr, ignoring case
+func containsFold(s, substr string) bool {
+	return substr == "" || strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// anyContainsFold reports whether any of values contains substr, ignoring case
+func anyContainsFold(values []string, substr string) bool {
+	if substr == "" {
+		return true
+	}
+	for _, value := range values {
+		if containsFold(value, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// emailError maps storage errors to API errors
+func emailError(err error) *errors.AppError {
+	switch {
+	case stderrors.Is(err, storage.ErrInvalidMessageID):
+		return errors.BadRequestError("Invalid email ID")
+	case stderrors.Is(err, storage.ErrNotFound):
+		return errors.NotFoundError("Email not found")
+	default:
+		return errors.StorageError("Failed to access email", err)
+	}
+}
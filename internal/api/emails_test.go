@@ -0,0 +1,330 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type listEmailsResponse struct {
+	Emails []emailSummary `json:"emails"`
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+func newEmailServer(t *testing.T) *Server {
+	server, err := NewServer(&config.Config{
+		BearerToken: "test-token",
+		DataDir:     t.TempDir(),
+	})
+	require.NoError(t, err)
+	return server
+}
+
+func storeEmail(t *testing.T, server *Server, sender, recipient string) string {
+	id, _, err := storage.StoreEmail(context.Background(), server.storage, &mail.EmailData{
+		Sender:     sender,
+		Recipient:  recipient,
+		Subject:    "Hello " + recipient,
+		ReceivedAt: time.Now(),
+		Raw:        "Subject: Hello\r\n\r\nBody",
+	})
+	require.NoError(t, err)
+	return id
+}
+
+func serveEmails(server *Server, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	return w
+}
+
+func listEmails(t *testing.T, server *Server, query string) listEmailsResponse {
+	w := serveEmails(server, "GET", "/api/emails"+query)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response listEmailsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func TestListEmails(t *testing.T) {
+	server := newEmailServer(t)
+	storeEmail(t, server, "alice@example.com", "support@shop.example")
+	storeEmail(t, server, "bob@example.org", "sales@shop.example")
+	storeEmail(t, server, "carol@example.com", "support@other.example")
+
+	response := listEmails(t, server, "")
+	assert.Equal(t, 3, response.Total)
+	assert.Len(t, response.Emails, 3)
+	assert.Equal(t, defaultEmailPageSize, response.Limit)
+	for _, email := range response.Emails {
+		assert.True(t, strings.HasPrefix(email.ID, "msg_"), email.ID)
+		assert.NotContains(t, email.ID, "/")
+		assert.Equal(t, "inbox", email.Status)
+	}
+
+	// Filters are case-insensitive substring matches
+	response = listEmails(t, server, "?sender=EXAMPLE.COM")
+	assert.Equal(t, 2, response.Total)
+
+	response = listEmails(t, server, "?recipient=support@&sender=carol")
+	require.Equal(t, 1, response.Total)
+	assert.Equal(t, "support@other.example", response.Emails[0].Recipient)
+
+	// Pagination reports the filtered total
+	response = listEmails(t, server, "?limit=2")
+	assert.Equal(t, 3, response.Total)
+	assert.Len(t, response.Emails, 2)
+
+	response = listEmails(t, server, "?limit=2&offset=2")
+	assert.Equal(t, 3, response.Total)
+	assert.Len(t, response.Emails, 1)
+}
+
+// countingStorage counts the messages read through it
+type countingStorage struct {
+	storage.Storage
+	retrieved atomic.Int32
+}
+
+func (c *countingStorage) Retrieve(ctx context.Context, emailID string) ([]byte, error) {
+	c.retrieved.Add(1)
+	return c.Storage.Retrieve(ctx, emailID)
+}
+
+func TestListEmails_LoadsOnlyPage(t *testing.T) {
+	server := newEmailServer(t)
+	for i := 0; i < 5; i++ {
+		storeEmail(t, server, "alice@example.com", "bob@example.com")
+	}
+	counting := &countingStorage{Storage: server.storage}
+	server.storage = counting
+
+	response := listEmails(t, server, "?limit=2&offset=1")
+	assert.Equal(t, 5, response.Total)
+	require.Len(t, response.Emails, 2)
+	assert.Equal(t, int32(2), counting.retrieved.Load(), "only the page is loaded")
+	assert.Equal(t, "Hello bob@example.com", response.Emails[0].Subject)
+
+	// Filters on the message contents still read every message
+	counting.retrieved.Store(0)
+	response = listEmails(t, server, "?limit=2&sender=alice")
+	assert.Equal(t, 5, response.Total)
+	assert.Equal(t, int32(5), counting.retrieved.Load())
+
+	response = listEmails(t, server, "?offset=10")
+	assert.Equal(t, 5, response.Total)
+	assert.Empty(t, response.Emails)
+}
+
+func TestListEmails_DateRange(t *testing.T) {
+	server := newEmailServer(t)
+	storeEmail(t, server, "alice@example.com", "bob@example.com")
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+
+	assert.Equal(t, 1, listEmails(t, server, "?since="+today+"&until="+today).Total)
+	assert.Equal(t, 0, listEmails(t, server, "?since="+tomorrow).Total)
+	assert.Equal(t, 1, listEmails(t, server, "?since="+time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)).Total)
+}
+
+func TestListEmails_InvalidQuery(t *testing.T) {
+	server := newEmailServer(t)
+
+	for _, query := range []string{
+		"?limit=0",
+		"?limit=abc",
+		"?limit=100000",
+		"?offset=-1",
+		"?since=yesterday",
+		"?until=2024-13-01",
+		"?since=2024-02-01&until=2024-01-01",
+	} {
+		w := serveEmails(server, "GET", "/api/emails"+query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestGetEmail(t *testing.T) {
+	server := newEmailServer(t)
+	id := storeEmail(t, server, "alice@example.com", "bob@example.com")
+
+	w := serveEmails(server, "GET", "/api/emails/"+id)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, id, response["id"])
+	assert.Equal(t, "inbox", response["status"])
+	assert.Equal(t, "alice@example.com", response["sender"])
+	assert.Equal(t, "Subject: Hello\r\n\r\nBody", response["raw"])
+
+	// The ID stays valid once the message has been delivered
+	require.NoError(t, server.queue.MarkProcessed(context.Background(), id))
+
+	w = serveEmails(server, "GET", "/api/emails/"+id)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"processed"`)
+
+	assert.Equal(t, http.StatusNotFound, serveEmails(server, "GET", "/api/emails/msg_1_abcdef01").Code)
+	assert.Equal(t, http.StatusBadRequest, serveEmails(server, "GET", "/api/emails/not-an-id").Code)
+}
+
+func TestGetEmailRaw(t *testing.T) {
+	server := newEmailServer(t)
+	id := storeEmail(t, server, "alice@example.com", "bob@example.com")
+
+	w := serveEmails(server, "GET", "/api/emails/"+id+"/raw")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "message/rfc822", w.Header().Get("Content-Type"))
+	assert.Equal(t, "Subject: Hello\r\n\r\nBody", w.Body.String())
+}
+
+func TestGetAttachment(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		BearerToken:            "test-token",
+		DataDir:                t.TempDir(),
+		AttachmentExtract:      true,
+		AttachmentExtractMinKB: 1,
+	})
+	require.NoError(t, err)
+
+	large := strings.Repeat("0123456789abcdef", 128)
+	rawEmail := "From: alice@example.com\r\nTo: bob@example.com\r\nSubject: Files\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+		"--b\r\nContent-Type: text/csv\r\nContent-Disposition: attachment; filename=\"small.csv\"\r\n\r\na,b\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\nContent-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"Rechnung März.pdf\"\r\n\r\n" +
+		mail.EncodeTransfer("base64", []byte(large), "\r\n") + "\r\n--b--\r\n"
+
+	req := httptest.NewRequest("POST", "/mail/inbound", strings.NewReader(rawEmail))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Content-Type", "message/rfc822")
+	recorder := httptest.NewRecorder()
+	server.handleMailInbound(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	var stored map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &stored))
+	id := stored["id"].(string)
+
+	email, err := storage.LoadEmail(context.Background(), server.storage, id)
+	require.NoError(t, err)
+	require.Len(t, email.Attachments, 2)
+	assert.False(t, email.Attachments[0].Extracted)
+	assert.True(t, email.Attachments[1].Extracted)
+	assert.NotContains(t, email.Raw, mail.EncodeTransfer("base64", []byte(large), "\r\n"))
+
+	w := serveEmails(server, "GET", "/api/emails/"+id+"/attachments/0")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "a,b", w.Body.String())
+
+	w = serveEmails(server, "GET", "/api/emails/"+id+"/attachments/1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.Equal(t, "attachment; filename*=utf-8''Rechnung%20M%C3%A4rz.pdf", w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, large, w.Body.String())
+
+	for _, n := range []string{"2", "-1", "x"} {
+		assert.Equal(t, http.StatusNotFound, serveEmails(server, "GET", "/api/emails/"+id+"/attachments/"+n).Code, n)
+	}
+
+	// The raw message comes back complete
+	w = serveEmails(server, "GET", "/api/emails/"+id+"/raw")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, rawEmail, w.Body.String())
+}
+
+func TestDeleteEmail(t *testing.T) {
+	server := newEmailServer(t)
+	id := storeEmail(t, server, "alice@example.com", "bob@example.com")
+
+	w := serveEmails(server, "DELETE", "/api/emails/"+id)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	assert.Equal(t, http.StatusNotFound, serveEmails(server, "GET", "/api/emails/"+id).Code)
+	assert.Equal(t, http.StatusNotFound, serveEmails(server, "DELETE", "/api/emails/"+id).Code)
+}
+
+func TestEmails_RequireAuth(t *testing.T) {
+	server := newEmailServer(t)
+
+	req := httptest.NewRequest("GET", "/api/emails", nil)
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestEmails_PooledStorage(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		BearerToken:    "test-token",
+		DataDir:        t.TempDir(),
+		MaxConnections: 4,
+		MaxIdleConns:   2,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, server.queue, "pooled file storage still tracks delivery")
+
+	id := storeEmail(t, server, "alice@example.com", "bob@example.com")
+
+	w := serveEmails(server, "GET", "/api/emails/"+id)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"inbox"`)
+
+	assert.Equal(t, http.StatusOK, serveEmails(server, "GET", "/api/deadletter").Code)
+}
+
+func TestEmails_SQLiteBackend(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		BearerToken:    "test-token",
+		DataDir:        t.TempDir(),
+		StorageBackend: "sqlite",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, server.queue)
+
+	storeEmail(t, server, "alice@example.com", "support@shop.example")
+	storeEmail(t, server, "bob@example.org", "sales@shop.example")
+	id := storeEmail(t, server, "carol@example.com", "support@other.example")
+
+	// Listing is served from the metadata index with the same semantics
+	response := listEmails(t, server, "?sender=EXAMPLE.COM&limit=1")
+	assert.Equal(t, 2, response.Total)
+	assert.Len(t, response.Emails, 1)
+
+	response = listEmails(t, server, "?sender=Carol")
+	require.Equal(t, 1, response.Total)
+	assert.Equal(t, id, response.Emails[0].ID)
+	assert.Equal(t, "inbox", response.Emails[0].Status)
+	assert.Equal(t, len("Subject: Hello\r\n\r\nBody"), response.Emails[0].SizeBytes)
+
+	today := time.Now().UTC().Format("2006-01-02")
+	assert.Equal(t, 3, listEmails(t, server, "?since="+today+"&until="+today).Total)
+
+	w := serveEmails(server, "GET", "/api/emails/"+id+"/raw")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Subject: Hello\r\n\r\nBody", w.Body.String())
+
+	assert.Equal(t, http.StatusNoContent, serveEmails(server, "DELETE", "/api/emails/"+id).Code)
+	assert.Equal(t, http.StatusNotFound, serveEmails(server, "GET", "/api/emails/"+id).Code)
+	assert.Equal(t, http.StatusOK, serveEmails(server, "GET", "/api/deadletter").Code)
+}
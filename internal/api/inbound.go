@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/auth"
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/grumpyguvner/gomail/internal/smtp"
+	"github.com/grumpyguvner/gomail/internal/storage"
+	"go.uber.org/zap"
+)
+
+// inbound is a parsed message on its way into storage, whether it arrived
+// over HTTP or SMTP
+type inbound struct {
+	email    *mail.EmailData
+	body     []byte
+	sourceIP net.IP
+	helo     string
+	auth     *auth.AuthenticationResult // already verified, e.g. by the milter
+	logger   *zap.SugaredLogger
+	start    time.Time
+}
+
+// receipt tells the caller where an accepted message was stored
+type receipt struct {
+	id        string
+	location  string
+	duplicate bool
+}
+
+// receive validates, authenticates and stores a message. Rejections and
+// failures are returned as *errors.AppError.
+func (s *Server) receive(ctx context.Context, in inbound) (receipt, error) {
+	emailData := in.email
+
+	// Record email size metric
+	metrics.EmailSize.Observe(float64(len(in.body)))
+
+	// Validate email data
+	if err := s.validator.Validate(emailData); err != nil {
+		in.logger.Errorf("Email validation failed: %v", err)
+		metrics.EmailsProcessed.WithLabelValues("rejected").Inc()
+		metrics.EmailProcessingDuration.Observe(time.Since(in.start).Seconds())
+		return receipt{}, errors.ValidationError("Email validation failed", map[string]string{"error": err.Error()})
+	}
+
+	// Only results computed below are indexed, never ones the caller supplied
+	emailData.Authentication.Results = ""
+
+	// Perform email authentication if configured
+	if s.authMiddleware != nil {
+		mailFrom := emailData.Sender
+
+		// Perform authentication checks unless the caller already has
+		authResult := in.auth
+		if authResult == nil {
+			var err error
+			authResult, err = s.authMiddleware.VerifyInbound(ctx, in.sourceIP, in.helo, mailFrom, in.body)
+			if err != nil {
+				in.logger.Warnf("Authentication verification error: %v", err)
+			}
+		}
+
+		// Add Authentication-Results to email data
+		if authResult != nil {
+			hostname := s.config.MailHostname
+			if hostname == "" {
+				hostname = "localhost"
+			}
+			authResultsHeader := s.authMiddleware.FormatAuthenticationResults(authResult, hostname)
+
+			// Store authentication results in the DMARC metadata
+			emailData.Authentication.DMARC.AuthenticationResults = authResultsHeader
+			emailData.Authentication.Results = authResultsHeader
+
+			// Check if email should be rejected based on authentication
+			if authResult.Action == "reject" {
+				in.logger.Warnf("Email rejected by authentication policy: from=%s", mailFrom)
+				metrics.EmailsProcessed.WithLabelValues("rejected").Inc()
+				metrics.EmailProcessingDuration.Observe(time.Since(in.start).Seconds())
+				return receipt{}, errors.ValidationError("Email rejected by authentication policy",
+					map[string]string{"reason": "DMARC policy violation"})
+			}
+
+			// Add quarantine marker to raw email if needed
+			if authResult.Action == "quarantine" {
+				// Prepend quarantine header to raw email
+				emailData.Raw = "X-Quarantine-Reason: DMARC policy\r\n" + emailData.Raw
+			}
+		}
+	}
+
+	// Move large attachments into the blob store before the message is stored
+	if s.blobs != nil {
+		minSize := s.config.AttachmentExtractMinKB * 1024
+		if err := storage.ExtractAttachments(emailData, s.blobs, minSize); err != nil {
+			in.logger.Errorf("Failed to extract attachments: %v", err)
+			metrics.StorageOperations.WithLabelValues("write", "error").Inc()
+			metrics.EmailsProcessed.WithLabelValues("error").Inc()
+			metrics.EmailProcessingDuration.Observe(time.Since(in.start).Seconds())
+			return receipt{}, errors.StorageError("Failed to store attachments", err)
+		}
+	}
+
+	// Store email
+	var stored receipt
+	var err error
+	if s.dedup != nil {
+		stored.id, stored.location, stored.duplicate, err = s.dedup.StoreEmail(ctx, emailData)
+	} else {
+		stored.id, stored.location, err = storage.StoreEmail(ctx, s.storage, emailData)
+	}
+	if err != nil {
+		in.logger.Errorf("Failed to store email: %v", err)
+		metrics.StorageOperations.WithLabelValues("write", "error").Inc()
+		metrics.EmailsProcessed.WithLabelValues("error").Inc()
+		metrics.EmailProcessingDuration.Observe(time.Since(in.start).Seconds())
+		return receipt{}, errors.StorageError("Failed to store email", err)
+	}
+
+	if stored.duplicate {
+		metrics.EmailsProcessed.WithLabelValues("duplicate").Inc()
+		metrics.EmailProcessingDuration.Observe(time.Since(in.start).Seconds())
+		in.logger.Infow("Duplicate email ignored",
+			"from", emailData.Sender,
+			"to", strings.Join(emailData.EnvelopeRecipients(), ","),
+			"message_id", emailData.MessageID,
+			"original", stored.id)
+		return stored, nil
+	}
+
+	// Record successful storage
+	metrics.StorageOperations.WithLabelValues("write", "success").Inc()
+
+	// Update metrics
+	s.metrics.TotalEmails.Add(1)
+	s.metrics.TotalBytes.Add(int64(len(in.body)))
+	s.metrics.LastReceived.Store(time.Now())
+
+	// Record successful email processing
+	metrics.EmailsProcessed.WithLabelValues("success").Inc()
+	metrics.EmailProcessingDuration.Observe(time.Since(in.start).Seconds())
+
+	in.logger.Infow("Email received",
+		"from", emailData.Sender,
+		"to", strings.Join(emailData.EnvelopeRecipients(), ","),
+		"size", len(in.body),
+		"stored", stored.location)
+
+	// Stop sending to addresses that bounced hard or complained
+	s.suppressReported(emailData, in.logger)
+	return stored, nil
+}
+
+// Receive implements smtp.Handler, feeding messages accepted by the SMTP
+// receiver into the same pipeline as /mail/inbound
+func (s *Server) Receive(ctx context.Context, env *smtp.Envelope) error {
+	start := time.Now()
+	logger := logging.With("session", env.SessionID)
+
+	// The envelope takes the place of the headers the Postfix pipe sets
+	headers := map[string]string{
+		"X-Original-Sender":         env.From,
+		"X-Original-Recipient":      strings.Join(env.Recipients, ","),
+		"X-Original-Client-Address": env.ClientIP.String(),
+		"X-Original-Helo":           env.Helo,
+		"X-Original-Mail-From":      env.From,
+	}
+
+	emailData, err := mail.ParseRawEmail(string(env.Data), headers)
+	if err != nil {
+		logger.Errorf("Failed to parse email: %v", err)
+		metrics.EmailSize.Observe(float64(len(env.Data)))
+		metrics.EmailsProcessed.WithLabelValues("error").Inc()
+		metrics.EmailProcessingDuration.Observe(time.Since(start).Seconds())
+		return errors.ValidationError("Failed to parse email", map[string]string{"error": err.Error()})
+	}
+
+	_, err = s.receive(ctx, inbound{
+		email:    emailData,
+		body:     env.Data,
+		sourceIP: env.ClientIP,
+		helo:     env.Helo,
+		auth:     env.Auth,
+		logger:   logger,
+		start:    start,
+	})
+	return err
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/grumpyguvner/gomail/internal/middleware"
+	"github.com/grumpyguvner/gomail/internal/outbound"
+)
+
+// handleMailSend accepts a message to send, either as JSON fields or as a
+// ready-made RFC 822 message, DKIM-signs it and queues it for delivery
+func (s *Server) handleMailSend(w http.ResponseWriter, r *http.Request) {
+	requestID := middleware.GetRequestIDFromRequest(r)
+	logger := logging.WithRequestID(requestID)
+	now := time.Now().UTC()
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 26214400)) // 25MB limit
+	if err != nil {
+		middleware.SendErrorResponse(w, errors.BadRequestError("Failed to read request body"))
+		return
+	}
+
+	var out *mail.Outgoing
+	if strings.Contains(r.Header.Get("Content-Type"), "message/rfc822") {
+		out, err = mail.PrepareRaw(body, s.config.MailHostname, now)
+	} else {
+		var email mail.OutgoingEmail
+		if err := json.Unmarshal(body, &email); err != nil {
+			metrics.OutboundSubmissions.WithLabelValues("rejected").Inc()
+			middleware.SendErrorResponse(w, errors.ValidationError("Invalid JSON", map[string]string{"error": err.Error()}))
+			return
+		}
+		out, err = email.Compose(s.config.MailHostname, now)
+	}
+	if err != nil {
+		metrics.OutboundSubmissions.WithLabelValues("rejected").Inc()
+		middleware.SendErrorResponse(w, errors.ValidationError("Invalid message", map[string]string{"error": err.Error()}))
+		return
+	}
+
+	// Suppressed recipients are dropped; the message goes to the rest
+	var recipients, suppressed []string
+	for _, recipient := range out.Recipients {
+		if _, err := s.suppressions.Get(recipient); err == nil {
+			suppressed = append(suppressed, recipient)
+			continue
+		} else if !stderrors.Is(err, outbound.ErrNotSuppressed) {
+			metrics.OutboundSubmissions.WithLabelValues("error").Inc()
+			middleware.SendErrorResponse(w, errors.StorageError("Failed to check suppression list", err))
+			return
+		}
+		recipients = append(recipients, recipient)
+	}
+	if len(suppressed) > 0 {
+		metrics.OutboundSuppressed.Add(float64(len(suppressed)))
+		logger.Infow("Suppressed recipients dropped", "suppressed", strings.Join(suppressed, ","))
+	}
+	if len(recipients) == 0 {
+		metrics.OutboundSubmissions.WithLabelValues("rejected").Inc()
+		middleware.SendErrorResponse(w, errors.ValidationError("All recipients are suppressed",
+			map[string]interface{}{"suppressed": suppressed}))
+		return
+	}
+
+	// Sign before queueing, so retries send the same signed message
+	signed := out.Raw
+	if s.authMiddleware != nil {
+		signed, err = s.authMiddleware.SignOutbound(r.Context(), out.Raw)
+		if err != nil {
+			metrics.OutboundSubmissions.WithLabelValues("error").Inc()
+			middleware.SendErrorResponse(w, errors.InternalError("Failed to sign message", err))
+			return
+		}
+	}
+
+	id, err := outbound.NewID(now)
+	if err != nil {
+		metrics.OutboundSubmissions.WithLabelValues("error").Inc()
+		middleware.SendErrorResponse(w, errors.InternalError("Failed to queue message", err))
+		return
+	}
+	msg := &outbound.Message{
+		ID:         id,
+		RequestID:  requestID,
+		From:       out.From,
+		Recipients: outbound.NewRecipients(recipients),
+		MessageID:  out.MessageID,
+		QueuedAt:   now,
+	}
+	if err := s.outbound.Enqueue(msg, signed); err != nil {
+		logger.Errorf("Failed to queue outbound message: %v", err)
+		metrics.OutboundSubmissions.WithLabelValues("error").Inc()
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to queue message", err))
+		return
+	}
+
+	metrics.OutboundSubmissions.WithLabelValues("queued").Inc()
+	logger.Infow("Outbound message queued",
+		"id", id,
+		"from", out.From,
+		"to", strings.Join(recipients, ","),
+		"message_id", out.MessageID,
+		"size", len(signed))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	response := map[string]interface{}{
+		"status":     "queued",
+		"id":         id,
+		"request_id": requestID,
+		"message_id": out.MessageID,
+		"recipients": recipients,
+	}
+	if len(suppressed) > 0 {
+		response["suppressed"] = suppressed
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Errorf("Failed to encode response: %v", err)
+	}
+}
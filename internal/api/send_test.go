@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSendServer(t *testing.T) *Server {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "private.key")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+
+	server, err := NewServer(&config.Config{
+		BearerToken:        "test-token",
+		DataDir:            t.TempDir(),
+		MailHostname:       "mx.example.com",
+		PrimaryDomain:      "example.com",
+		DKIMEnabled:        true,
+		DKIMSelector:       "mail",
+		DKIMPrivateKeyPath: keyPath,
+		OutboundEnabled:    true,
+	})
+	require.NoError(t, err)
+	return server
+}
+
+func postSend(server *Server, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/mail/send", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Request-ID", "req-42")
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleMailSend_JSON(t *testing.T) {
+	server := newSendServer(t)
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"from":    "Alice <alice@example.com>",
+		"to":      []string{"bob@example.net"},
+		"bcc":     []string{"audit@example.com"},
+		"subject": "Invoice",
+		"text":    "Attached.",
+		"attachments": []map[string]string{
+			{"filename": "invoice.txt", "content": "SW52b2ljZQ=="},
+		},
+	})
+	require.NoError(t, err)
+
+	w := postSend(server, "application/json", string(payload))
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "queued", response["status"])
+	assert.Equal(t, "req-42", response["request_id"])
+	assert.Equal(t, []interface{}{"bob@example.net", "audit@example.com"}, response["recipients"])
+
+	id := response["id"].(string)
+	msg, err := server.outbound.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, "req-42", msg.RequestID)
+	assert.Equal(t, "alice@example.com", msg.From)
+	assert.Equal(t, response["message_id"], msg.MessageID)
+
+	// The queued message is signed and keeps Bcc recipients out of sight
+	raw, err := server.outbound.Raw(id)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(raw, []byte("DKIM-Signature: ")), string(raw[:40]))
+	assert.Contains(t, string(raw), "d=example.com")
+	assert.Contains(t, string(raw), "s=mail")
+	assert.NotContains(t, string(raw), "audit@example.com")
+	assert.Equal(t, len(raw), msg.Size)
+}
+
+func TestHandleMailSend_Raw(t *testing.T) {
+	server := newSendServer(t)
+
+	w := postSend(server, "message/rfc822", "From: alice@example.com\r\nTo: bob@example.net\r\nSubject: Raw\r\n\r\nHello\r\n")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Contains(t, response["message_id"], "@mx.example.com>")
+
+	raw, err := server.outbound.Raw(response["id"].(string))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "Message-ID: "+response["message_id"].(string)+"\r\n")
+}
+
+func TestHandleMailSend_Errors(t *testing.T) {
+	server := newSendServer(t)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"malformed JSON", "application/json", "{", http.StatusBadRequest},
+		{"no recipients", "application/json", `{"from": "alice@example.com", "text": "x"}`, http.StatusBadRequest},
+		{"bad sender", "application/json", `{"from": "alice", "to": ["bob@example.net"]}`, http.StatusBadRequest},
+		{"raw without From", "message/rfc822", "To: bob@example.net\r\n\r\nHi\r\n", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postSend(server, tt.contentType, tt.body)
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+
+	messages, err := server.outbound.List()
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	// Sending needs the bearer token
+	req := httptest.NewRequest(http.MethodPost, "/mail/send", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHandleMailSend_Disabled(t *testing.T) {
+	server := newEmailServer(t)
+
+	w := postSend(server, "application/json", `{"from": "alice@example.com", "to": ["bob@example.net"]}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -21,8 +21,10 @@ import (
 	"github.com/grumpyguvner/gomail/internal/mail"
 	"github.com/grumpyguvner/gomail/internal/metrics"
 	"github.com/grumpyguvner/gomail/internal/middleware"
+	"github.com/grumpyguvner/gomail/internal/outbound"
 	"github.com/grumpyguvner/gomail/internal/storage"
 	"github.com/grumpyguvner/gomail/internal/validation"
+	"github.com/grumpyguvner/gomail/pkg/webhooksig"
 )
 
 type Server struct {
@@ -30,10 +32,16 @@ type Server struct {
 	httpServer      *http.Server
 	listener        net.Listener
 	listenerMu      sync.RWMutex
-	storage         *storage.FileStorage
+	storage         storage.Storage
+	queue           storage.DeliveryStore
+	dedup           *storage.Deduplicator
+	blobs           *storage.BlobStore
+	outbound        *outbound.Queue
+	suppressions    *outbound.Suppressions
 	metrics         *Metrics
 	validator       *validation.EmailValidator
 	authMiddleware  *auth.Middleware
+	verifier        *webhooksig.Verifier
 	activeRequests  atomic.Int64
 	shutdownStarted atomic.Bool
 }
@@ -48,7 +56,7 @@ type Metrics struct {
 
 func NewServer(cfg *config.Config) (*Server, error) {
 	// Initialize storage
-	store, err := storage.NewFileStorage(cfg.DataDir)
+	store, err := storage.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
@@ -67,6 +75,46 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		authMiddleware: authMiddleware,
 	}
 
+	// Accept inbound mail signed with the inbound secret
+	if cfg.InboundSecret != "" {
+		tolerance := time.Duration(cfg.WebhookSignatureTolerance) * time.Second
+		s.verifier = webhooksig.NewVerifier(tolerance, cfg.InboundSecret, cfg.InboundSecretPrevious)
+	}
+
+	// Dead-letter handling needs a backend that tracks delivery state
+	if queue, ok := store.(storage.DeliveryStore); ok {
+		s.queue = queue
+	}
+
+	// Store redelivered copies of a message only once
+	if cfg.DedupWindow > 0 {
+		window := time.Duration(cfg.DedupWindow) * time.Minute
+		s.dedup, err = storage.NewDeduplicator(context.Background(), store, window)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Attachments moved out of stored messages live in the blob store
+	s.blobs, err = storage.BlobsFor(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize attachment store: %w", err)
+	}
+
+	// Messages sent through the API wait in the outbound queue
+	if cfg.OutboundEnabled {
+		s.outbound, err = outbound.NewQueue(filepath.Join(cfg.DataDir, "outbound"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Addresses that bounced hard or complained are not sent to again
+	s.suppressions, err = outbound.NewSuppressions(filepath.Join(cfg.DataDir, "suppressions"))
+	if err != nil {
+		return nil, err
+	}
+
 	s.metrics = &Metrics{
 		StartTime:      time.Now(),
 		ActiveRequests: &s.activeRequests,
@@ -74,11 +122,38 @@ func NewServer(cfg *config.Config) (*Server, error) {
 
 	// Setup HTTP server
 	mux := http.NewServeMux()
-	mux.HandleFunc("/mail/inbound", s.requireAuth(s.handleMailInbound))
-	mux.HandleFunc("/email", s.requireAuth(s.handleMailInbound)) // Legacy endpoint
+	mux.HandleFunc("/mail/inbound", s.requireInboundAuth(s.handleMailInbound))
+	mux.HandleFunc("/email", s.requireInboundAuth(s.handleMailInbound)) // Legacy endpoint
 	mux.HandleFunc("/health", s.handleHealth)
 	mux.HandleFunc("/metrics", s.handleMetrics)
 
+	// Stored email access, used by the web admin
+	mux.HandleFunc("GET /api/emails", s.requireAuth(s.handleListEmails))
+	mux.HandleFunc("GET /api/emails/{id}", s.requireAuth(s.handleGetEmail))
+	mux.HandleFunc("GET /api/emails/{id}/raw", s.requireAuth(s.handleGetEmailRaw))
+	mux.HandleFunc("GET /api/emails/{id}/attachments/{n}", s.requireAuth(s.handleGetAttachment))
+	mux.HandleFunc("DELETE /api/emails/{id}", s.requireAuth(s.handleDeleteEmail))
+
+	// Outbound mail
+	if s.outbound != nil {
+		mux.HandleFunc("POST /mail/send", s.requireAuth(s.handleMailSend))
+	}
+
+	// Suppression list management
+	mux.HandleFunc("GET /api/suppressions", s.requireAuth(s.handleListSuppressions))
+	mux.HandleFunc("POST /api/suppressions", s.requireAuth(s.handleAddSuppression))
+	mux.HandleFunc("GET /api/suppressions/{address}", s.requireAuth(s.handleGetSuppression))
+	mux.HandleFunc("DELETE /api/suppressions/{address}", s.requireAuth(s.handleRemoveSuppression))
+
+	// Dead-letter management
+	if s.queue != nil {
+		mux.HandleFunc("GET /api/deadletter", s.requireAuth(s.handleListDeadLetters))
+		mux.HandleFunc("POST /api/deadletter/replay", s.requireAuth(s.handleReplayAllDeadLetters))
+		mux.HandleFunc("POST /api/deadletter/{id}/replay", s.requireAuth(s.handleReplayDeadLetter))
+		mux.HandleFunc("DELETE /api/deadletter", s.requireAuth(s.handlePurgeAllDeadLetters))
+		mux.HandleFunc("DELETE /api/deadletter/{id}", s.requireAuth(s.handlePurgeDeadLetter))
+	}
+
 	// Apply middleware chain
 	handler := s.applyMiddleware(mux)
 
@@ -148,6 +223,27 @@ func (s *Server) Start(ctx context.Context) error {
 	return nil
 }
 
+// Storage returns the storage backend the server writes to
+func (s *Server) Storage() storage.Storage {
+	return s.storage
+}
+
+// Blobs returns the attachment blob store, or nil when attachment_extract
+// is off
+func (s *Server) Blobs() *storage.BlobStore {
+	return s.blobs
+}
+
+// Outbound returns the outbound queue, or nil when outbound_enabled is off
+func (s *Server) Outbound() *outbound.Queue {
+	return s.outbound
+}
+
+// Suppressions returns the suppression list
+func (s *Server) Suppressions() *outbound.Suppressions {
+	return s.suppressions
+}
+
 // GetListener returns the server's listener in a thread-safe way
 func (s *Server) GetListener() net.Listener {
 	s.listenerMu.RLock()
@@ -259,6 +355,27 @@ func (s *Server) activeRequestsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// requireInboundAuth accepts a request signed with the inbound secret in
+// place of the bearer token. The signature covers the method and path, so it
+// is only good for delivering mail, never for the rest of the API.
+func (s *Server) requireInboundAuth(next http.HandlerFunc) http.HandlerFunc {
+	withToken := s.requireAuth(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.verifier == nil || r.Header.Get(webhooksig.Header) == "" {
+			withToken(w, r)
+			return
+		}
+
+		if err := s.verifier.VerifyRequestTarget(r); err != nil {
+			requestID := middleware.GetRequestIDFromRequest(r)
+			logging.WithRequestID(requestID).Warnf("Request signature rejected: %v", err)
+			middleware.SendErrorResponse(w, errors.AuthError("Invalid request signature"))
+			return
+		}
+		next(w, r)
+	}
+}
+
 func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
@@ -300,9 +417,6 @@ func (s *Server) handleMailInbound(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Record email size metric
-	metrics.EmailSize.Observe(float64(len(body)))
-
 	// Sanitize headers first
 	headers := validation.SanitizeHeaders(extractHeadersFromRequest(r))
 
@@ -338,113 +452,48 @@ func (s *Server) handleMailInbound(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	logger := logging.WithRequestID(middleware.GetRequestIDFromRequest(r))
+
 	if err != nil {
-		requestID := middleware.GetRequestIDFromRequest(r)
-		logging.WithRequestID(requestID).Errorf("Failed to parse email: %v", err)
+		logger.Errorf("Failed to parse email: %v", err)
+		metrics.EmailSize.Observe(float64(len(body)))
 		metrics.EmailsProcessed.WithLabelValues("error").Inc()
 		metrics.EmailProcessingDuration.Observe(time.Since(start).Seconds())
 		middleware.SendErrorResponse(w, errors.ValidationError("Failed to parse email", map[string]string{"error": err.Error()}))
 		return
 	}
 
-	// Validate email data
-	if err := s.validator.Validate(emailData); err != nil {
-		requestID := middleware.GetRequestIDFromRequest(r)
-		logging.WithRequestID(requestID).Errorf("Email validation failed: %v", err)
-		metrics.EmailsProcessed.WithLabelValues("rejected").Inc()
-		metrics.EmailProcessingDuration.Observe(time.Since(start).Seconds())
-		middleware.SendErrorResponse(w, errors.ValidationError("Email validation failed", map[string]string{"error": err.Error()}))
-		return
-	}
-
-	// Perform email authentication if configured
-	if s.authMiddleware != nil {
-		// Extract source IP for SPF checking
-		sourceIP := net.ParseIP(extractClientIP(r))
-		heloHost := headers["helo"]
-		mailFrom := emailData.Sender
-
-		// Perform authentication checks
-		authResult, err := s.authMiddleware.VerifyInbound(ctx, sourceIP, heloHost, mailFrom, body)
-		if err != nil {
-			requestID := middleware.GetRequestIDFromRequest(r)
-			logging.WithRequestID(requestID).Warnf("Authentication verification error: %v", err)
-		}
-
-		// Add Authentication-Results to email data
-		if authResult != nil {
-			hostname := s.config.MailHostname
-			if hostname == "" {
-				hostname = "localhost"
-			}
-			authResultsHeader := s.authMiddleware.FormatAuthenticationResults(authResult, hostname)
-
-			// Store authentication results in the DMARC metadata
-			emailData.Authentication.DMARC.AuthenticationResults = authResultsHeader
-
-			// Check if email should be rejected based on authentication
-			if authResult.Action == "reject" {
-				requestID := middleware.GetRequestIDFromRequest(r)
-				logging.WithRequestID(requestID).Warnf("Email rejected by authentication policy: from=%s", mailFrom)
-				metrics.EmailsProcessed.WithLabelValues("rejected").Inc()
-				metrics.EmailProcessingDuration.Observe(time.Since(start).Seconds())
-				middleware.SendErrorResponse(w, errors.ValidationError("Email rejected by authentication policy",
-					map[string]string{"reason": "DMARC policy violation"}))
-				return
-			}
-
-			// Add quarantine marker to raw email if needed
-			if authResult.Action == "quarantine" {
-				// Prepend quarantine header to raw email
-				emailData.Raw = "X-Quarantine-Reason: DMARC policy\r\n" + emailData.Raw
-			}
-		}
-	}
-
-	// Store email
-	filename, err := s.storage.Store(emailData)
+	stored, err := s.receive(ctx, inbound{
+		email:    emailData,
+		body:     body,
+		sourceIP: net.ParseIP(extractClientIP(r)),
+		helo:     headers["helo"],
+		logger:   logger,
+		start:    start,
+	})
 	if err != nil {
-		requestID := middleware.GetRequestIDFromRequest(r)
-		logging.WithRequestID(requestID).Errorf("Failed to store email: %v", err)
-		metrics.StorageOperations.WithLabelValues("write", "error").Inc()
-		metrics.EmailsProcessed.WithLabelValues("error").Inc()
-		metrics.EmailProcessingDuration.Observe(time.Since(start).Seconds())
-		middleware.SendErrorResponse(w, errors.StorageError("Failed to store email", err))
+		middleware.SendErrorResponse(w, err)
 		return
 	}
 
-	// Record successful storage
-	metrics.StorageOperations.WithLabelValues("write", "success").Inc()
-
-	// Update metrics
-	s.metrics.TotalEmails.Add(1)
-	s.metrics.TotalBytes.Add(int64(len(body)))
-	s.metrics.LastReceived.Store(time.Now())
-
-	// Record successful email processing
-	metrics.EmailsProcessed.WithLabelValues("success").Inc()
-	metrics.EmailProcessingDuration.Observe(time.Since(start).Seconds())
-
 	// Send response
 	response := map[string]interface{}{
 		"status":     "success",
-		"message_id": filepath.Base(filename),
-		"stored_at":  filename,
+		"id":         stored.id,
+		"message_id": stored.id + ".json",
+		"stored_at":  stored.location,
 		"timestamp":  time.Now().UTC().Format(time.RFC3339),
 	}
+	if stored.duplicate {
+		// Acknowledge so the sender stops retrying, pointing at the original
+		response["status"] = "already_stored"
+		delete(response, "stored_at")
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		requestID := middleware.GetRequestIDFromRequest(r)
-		logging.WithRequestID(requestID).Errorf("Failed to encode response: %v", err)
+		logger.Errorf("Failed to encode response: %v", err)
 	}
-
-	requestID := middleware.GetRequestIDFromRequest(r)
-	logging.WithRequestID(requestID).Infow("Email received",
-		"from", emailData.Sender,
-		"to", emailData.Recipient,
-		"size", len(body),
-		"stored", filename)
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -4,12 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/grumpyguvner/gomail/internal/auth"
 	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/grumpyguvner/gomail/internal/smtp"
+	"github.com/grumpyguvner/gomail/internal/storage"
+	"github.com/grumpyguvner/gomail/pkg/webhooksig"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -85,6 +93,73 @@ func TestServerAuthentication(t *testing.T) {
 	}
 }
 
+func TestServerAuthentication_Signature(t *testing.T) {
+	cfg := &config.Config{
+		BearerToken:           "valid-token",
+		DataDir:               t.TempDir(),
+		WebhookSecret:         "webhook-shared-secret",
+		InboundSecret:         "current-shared-secret",
+		InboundSecretPrevious: "previous-shared-secret",
+	}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	handler := server.requireInboundAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	send := func(body, signature string) int {
+		req := httptest.NewRequest("POST", "/mail/inbound", bytes.NewBufferString(body))
+		req.Header.Set(webhooksig.Header, signature)
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder.Code
+	}
+	sign := func(body string, at time.Time, secret string) string {
+		return webhooksig.SignRequest("POST", "/mail/inbound", []byte(body), at, secret)
+	}
+
+	body := `{"sender":"a@example.com"}`
+	signature := sign(body, time.Now(), "current-shared-secret")
+	assert.Equal(t, http.StatusOK, send(body, signature))
+
+	// Replaying the same signed request is rejected
+	assert.Equal(t, http.StatusUnauthorized, send(body, signature))
+
+	// The previous secret is still accepted during rotation
+	assert.Equal(t, http.StatusOK, send(body, sign(body, time.Now(), "previous-shared-secret")))
+
+	// Wrong secret and tampered body are rejected
+	assert.Equal(t, http.StatusUnauthorized, send(body, sign(body, time.Now(), "some-other-secret-value")))
+	tampered := sign(body, time.Now().Add(time.Second), "current-shared-secret")
+	assert.Equal(t, http.StatusUnauthorized, send(body+" ", tampered))
+
+	// Webhook deliveries are signed with the webhook secret and cannot be replayed here
+	delivery := webhooksig.Sign([]byte(body), time.Now().Add(2*time.Second), "webhook-shared-secret")
+	assert.Equal(t, http.StatusUnauthorized, send(body, delivery))
+
+	// A signature made for another endpoint is rejected
+	other := webhooksig.SignRequest("POST", "/mail/send", []byte(body), time.Now().Add(3*time.Second), "current-shared-secret")
+	assert.Equal(t, http.StatusUnauthorized, send(body, other))
+}
+
+func TestServerAuthentication_SignatureOnlyForInbound(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		BearerToken:   "valid-token",
+		DataDir:       t.TempDir(),
+		InboundSecret: "current-shared-secret",
+	})
+	require.NoError(t, err)
+
+	// A valid signature does not stand in for the bearer token on the rest of the API
+	req := httptest.NewRequest("DELETE", "/api/suppressions/bob@example.net", nil)
+	req.Header.Set(webhooksig.Header, webhooksig.SignRequest("DELETE", "/api/suppressions/bob@example.net", nil, time.Now(), "current-shared-secret"))
+	recorder := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
 func TestHandleHealth(t *testing.T) {
 	cfg := &config.Config{
 		DataDir: t.TempDir(),
@@ -195,6 +270,7 @@ func TestHandleMailInbound_JSONFormat(t *testing.T) {
 
 	assert.Equal(t, "success", response["status"])
 	assert.NotEmpty(t, response["message_id"])
+	assert.Regexp(t, `^msg_[0-9]+_[0-9a-f]+$`, response["id"])
 	assert.NotEmpty(t, response["stored_at"])
 	assert.NotEmpty(t, response["timestamp"])
 
@@ -340,6 +416,120 @@ This is the email body.`
 	assert.NotEmpty(t, response["message_id"])
 }
 
+func TestHandleMailInbound_Duplicate(t *testing.T) {
+	cfg := &config.Config{
+		BearerToken: "test-token",
+		DataDir:     t.TempDir(),
+		DedupWindow: 60,
+	}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	rawEmail := "From: sender@example.com\r\nTo: recipient@example.com\r\nSubject: Retried\r\nMessage-ID: <retry@example.com>\r\n\r\nBody"
+
+	post := func() map[string]interface{} {
+		req := httptest.NewRequest("POST", "/mail/inbound", bytes.NewReader([]byte(rawEmail)))
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Content-Type", "message/rfc822")
+		recorder := httptest.NewRecorder()
+
+		server.handleMailInbound(recorder, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		return response
+	}
+
+	first := post()
+	assert.Equal(t, "success", first["status"])
+
+	// A redelivered copy resolves to the original
+	second := post()
+	assert.Equal(t, "already_stored", second["status"])
+	assert.Equal(t, first["id"], second["id"])
+	assert.Equal(t, first["message_id"], second["message_id"])
+	assert.NotContains(t, second, "stored_at")
+
+	ids, err := server.storage.List(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, ids, 1)
+	assert.Equal(t, int64(1), server.metrics.TotalEmails.Load())
+}
+
+func TestReceive_SMTP(t *testing.T) {
+	server := newEmailServer(t)
+
+	env := &smtp.Envelope{
+		SessionID:  "abc123",
+		ClientIP:   net.ParseIP("192.0.2.1"),
+		Helo:       "mail.sender.example",
+		From:       "bounce@sender.example",
+		Recipients: []string{"bob@example.com", "carol@example.com"},
+		Data: []byte("Received: from mail.sender.example ([192.0.2.1])\r\n" +
+			"Authentication-Results: mx.sender.example; spf=pass; dkim=pass; dmarc=pass\r\n" +
+			"Received-SPF: Pass (sender.example: designates 192.0.2.1)\r\n" +
+			"From: alice@sender.example\r\nTo: list@example.com\r\nSubject: Over SMTP\r\n\r\nBody\r\n"),
+	}
+	require.NoError(t, server.Receive(context.Background(), env))
+
+	ids, err := server.storage.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+
+	// The envelope wins over the headers
+	email, err := storage.LoadEmail(context.Background(), server.storage, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, "bounce@sender.example", email.Sender)
+	assert.Equal(t, []string{"bob@example.com", "carol@example.com"}, email.EnvelopeRecipients())
+	assert.Equal(t, "Over SMTP", email.Subject)
+	assert.Equal(t, "192.0.2.1", email.Connection.ClientAddress)
+	assert.Equal(t, "mail.sender.example", email.Connection.ClientHelo)
+	assert.Equal(t, string(env.Data), email.Raw)
+
+	// Checks are disabled, so the results the message claims are not indexed
+	assert.Equal(t, "localhost; none", email.Authentication.Results)
+	assert.Empty(t, email.Authentication.SPFResult())
+	assert.Empty(t, email.Authentication.DMARCResult())
+
+	// Rejections come back as validation errors for the SMTP reply
+	env.Recipients = []string{"not-an-address"}
+	err = server.Receive(context.Background(), env)
+	appErr, ok := errors.AsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrorTypeValidation, appErr.Type)
+}
+
+func TestReceive_PreVerified(t *testing.T) {
+	server := newEmailServer(t)
+
+	// The milter has already authenticated the message
+	env := &smtp.Envelope{
+		SessionID:  "4ABCD",
+		ClientIP:   net.ParseIP("192.0.2.1"),
+		Helo:       "mail.sender.example",
+		From:       "alice@sender.example",
+		Recipients: []string{"bob@example.com"},
+		Data:       []byte("From: alice@sender.example\r\nTo: bob@example.com\r\nSubject: Via milter\r\n\r\nBody\r\n"),
+		Auth: &auth.AuthenticationResult{
+			SPF:    &auth.SPFResult{Result: authres.ResultFail, Domain: "sender.example", IP: "192.0.2.1"},
+			Action: "quarantine",
+		},
+	}
+	require.NoError(t, server.Receive(context.Background(), env))
+
+	ids, err := server.storage.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+
+	email, err := storage.LoadEmail(context.Background(), server.storage, ids[0])
+	require.NoError(t, err)
+	assert.Contains(t, email.Authentication.DMARC.AuthenticationResults, "spf=fail")
+	assert.Equal(t, "fail", email.Authentication.SPFResult())
+	assert.True(t, strings.HasPrefix(email.Raw, "X-Quarantine-Reason: DMARC policy\r\n"))
+}
+
 func TestHandleMailInbound_AutoDetectFormat(t *testing.T) {
 	cfg := &config.Config{
 		BearerToken: "test-token",
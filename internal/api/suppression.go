@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/middleware"
+	"github.com/grumpyguvner/gomail/internal/outbound"
+	"go.uber.org/zap"
+)
+
+// addSuppressionRequest is the body of POST /api/suppressions
+type addSuppressionRequest struct {
+	Address   string     `json:"address"`
+	Reason    string     `json:"reason"`
+	Detail    string     `json:"detail"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// handleListSuppressions returns every suppressed address, optionally only
+// those with the given reason
+func (s *Server) handleListSuppressions(w http.ResponseWriter, r *http.Request) {
+	list, err := s.suppressions.List()
+	if err != nil {
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to list suppressions", err))
+		return
+	}
+
+	suppressions := []*outbound.Suppression{}
+	reason := r.URL.Query().Get("reason")
+	for _, entry := range list {
+		if reason == "" || entry.Reason == reason {
+			suppressions = append(suppressions, entry)
+		}
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"suppressions": suppressions,
+		"count":        len(suppressions),
+	})
+}
+
+// handleGetSuppression returns the entry for one address
+func (s *Server) handleGetSuppression(w http.ResponseWriter, r *http.Request) {
+	entry, err := s.suppressions.Get(r.PathValue("address"))
+	if err != nil {
+		middleware.SendErrorResponse(w, suppressionError(err))
+		return
+	}
+	writeJSON(w, r, entry)
+}
+
+// handleAddSuppression adds an address, replacing any existing entry for it
+func (s *Server) handleAddSuppression(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 65536))
+	if err != nil {
+		middleware.SendErrorResponse(w, errors.BadRequestError("Failed to read request body"))
+		return
+	}
+
+	var req addSuppressionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		middleware.SendErrorResponse(w, errors.ValidationError("Invalid JSON", map[string]string{"error": err.Error()}))
+		return
+	}
+	if _, err := outbound.NormalizeAddress(req.Address); err != nil {
+		middleware.SendErrorResponse(w, errors.ValidationError("Invalid address", map[string]string{"address": req.Address}))
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		middleware.SendErrorResponse(w, errors.ValidationError("expires_at must be in the future", nil))
+		return
+	}
+
+	entry := &outbound.Suppression{
+		Address:   req.Address,
+		Reason:    strings.TrimSpace(req.Reason),
+		Detail:    req.Detail,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.suppressions.Add(entry); err != nil {
+		middleware.SendErrorResponse(w, errors.StorageError("Failed to add suppression", err))
+		return
+	}
+
+	logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Infow("Address suppressed",
+		"address", entry.Address, "reason", entry.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Errorf("Failed to encode response: %v", err)
+	}
+}
+
+// handleRemoveSuppression takes an address off the list, so it is sent to again
+func (s *Server) handleRemoveSuppression(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	if err := s.suppressions.Remove(address); err != nil {
+		middleware.SendErrorResponse(w, suppressionError(err))
+		return
+	}
+
+	logging.WithRequestID(middleware.GetRequestIDFromRequest(r)).Infof("Suppression removed: %s", address)
+	writeJSON(w, r, map[string]interface{}{
+		"status":  "success",
+		"removed": []string{address},
+	})
+}
+
+// suppressReported adds the addresses a received bounce or spam complaint
+// reports on to the suppression list. Soft bounces and not-spam reports
+// leave the list alone. Anyone can send a report, and passing DMARC only
+// proves who sent it, so an address is only suppressed when the report
+// quotes the Message-ID of a message the outbound queue sent to it.
+func (s *Server) suppressReported(email *mail.EmailData, logger *zap.SugaredLogger) {
+	bounceTTL := time.Duration(s.config.SuppressionBounceExpiry) * time.Hour
+
+	if email.Bounce != nil {
+		sentTo := s.sentTo(email.Bounce.OriginalMessageID)
+		for _, rcpt := range email.Bounce.Recipients {
+			if rcpt.Type != mail.BounceHard {
+				continue
+			}
+			if !containsAddress(sentTo, rcpt.Recipient) {
+				logger.Warnw("Ignoring bounce that matches no sent message", "address", rcpt.Recipient,
+					"original_message_id", email.Bounce.OriginalMessageID, "sender", email.Sender)
+				continue
+			}
+			detail := rcpt.DiagnosticCode
+			if detail == "" {
+				detail = rcpt.Status
+			}
+			s.suppress(logger, rcpt.Recipient, outbound.ReasonHardBounce, detail, bounceTTL)
+		}
+	}
+
+	if email.Complaint != nil && email.Complaint.FeedbackType != mail.FeedbackNotSpam {
+		detail := email.Complaint.FeedbackType
+		if email.Complaint.UserAgent != "" {
+			detail += " report from " + email.Complaint.UserAgent
+		}
+		sentTo := s.sentTo(email.Complaint.OriginalMessageID)
+		for _, address := range email.Complaint.Recipients {
+			if !containsAddress(sentTo, address) {
+				logger.Warnw("Ignoring complaint that matches no sent message", "address", address,
+					"original_message_id", email.Complaint.OriginalMessageID, "sender", email.Sender)
+				continue
+			}
+			s.suppress(logger, address, outbound.ReasonComplaint, detail, 0)
+		}
+	}
+}
+
+// sentTo returns the recipients of the outbound message with messageID, or
+// nil when it is not one GoMail sent
+func (s *Server) sentTo(messageID string) []string {
+	if s.outbound == nil || messageID == "" {
+		return nil
+	}
+	recipients, err := s.outbound.SentTo(messageID)
+	if err != nil {
+		if !stderrors.Is(err, outbound.ErrNotFound) {
+			logging.Get().Errorf("Failed to look up sent message %s: %v", messageID, err)
+		}
+		return nil
+	}
+	return recipients
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, candidate := range addresses {
+		if strings.EqualFold(candidate, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) suppress(logger *zap.SugaredLogger, address, reason, detail string, ttl time.Duration) {
+	added, err := s.suppressions.Suppress(address, reason, detail, ttl)
+	if err != nil {
+		logger.Errorf("Failed to suppress %s: %v", address, err)
+		return
+	}
+	if added {
+		logger.Infow("Address suppressed", "address", address, "reason", reason, "detail", detail)
+	}
+}
+
+// suppressionError maps suppression list errors to API errors
+func suppressionError(err error) *errors.AppError {
+	if stderrors.Is(err, outbound.ErrNotSuppressed) {
+		return errors.NotFoundError("Address not suppressed")
+	}
+	return errors.StorageError("Suppression operation failed", err)
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/grumpyguvner/gomail/internal/auth"
+	"github.com/grumpyguvner/gomail/internal/outbound"
+	"github.com/grumpyguvner/gomail/internal/smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveSuppression(server *Server, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestSuppressions_Endpoints(t *testing.T) {
+	server := newSendServer(t)
+
+	expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	w := serveSuppression(server, "POST", "/api/suppressions",
+		`{"address": "Bob@Example.net", "reason": "unsubscribed", "expires_at": "`+expiresAt.Format(time.RFC3339)+`"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var added outbound.Suppression
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &added))
+	assert.Equal(t, "bob@example.net", added.Address)
+	assert.Equal(t, "unsubscribed", added.Reason)
+	require.NotNil(t, added.ExpiresAt)
+	assert.True(t, expiresAt.Equal(*added.ExpiresAt))
+
+	w = serveSuppression(server, "POST", "/api/suppressions", `{"address": "carol@example.net"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = serveSuppression(server, "GET", "/api/suppressions", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Suppressions []outbound.Suppression `json:"suppressions"`
+		Count        int                    `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Count)
+
+	w = serveSuppression(server, "GET", "/api/suppressions?reason=manual", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, 1, response.Count)
+	assert.Equal(t, "carol@example.net", response.Suppressions[0].Address)
+
+	w = serveSuppression(server, "GET", "/api/suppressions/bob@example.net", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"reason":"unsubscribed"`)
+
+	w = serveSuppression(server, "DELETE", "/api/suppressions/bob@example.net", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveSuppression(server, "GET", "/api/suppressions/bob@example.net", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serveSuppression(server, "DELETE", "/api/suppressions/bob@example.net", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Invalid entries are refused
+	w = serveSuppression(server, "POST", "/api/suppressions", `{"address": "bob"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveSuppression(server, "POST", "/api/suppressions", `{"address": "bob@example.net", "expires_at": "2001-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleMailSend_Suppressed(t *testing.T) {
+	server := newSendServer(t)
+	require.NoError(t, server.suppressions.Add(&outbound.Suppression{Address: "gone@example.net", Reason: outbound.ReasonHardBounce}))
+
+	w := postSend(server, "application/json",
+		`{"from": "alice@example.com", "to": ["bob@example.net", "Gone@example.net"], "text": "Hi"}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []interface{}{"bob@example.net"}, response["recipients"])
+	assert.Equal(t, []interface{}{"Gone@example.net"}, response["suppressed"])
+
+	msg, err := server.outbound.Get(response["id"].(string))
+	require.NoError(t, err)
+	require.Len(t, msg.Recipients, 1)
+	assert.Equal(t, "bob@example.net", msg.Recipients[0].Address)
+
+	// Nothing is queued when every recipient is suppressed
+	w = postSend(server, "application/json", `{"from": "alice@example.com", "to": ["gone@example.net"], "text": "Hi"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "All recipients are suppressed")
+	messages, err := server.outbound.List()
+	require.NoError(t, err)
+	assert.Len(t, messages, 1)
+}
+
+func TestReceive_SuppressesReported(t *testing.T) {
+	server := newSendServer(t)
+
+	receive := func(raw string, result *auth.AuthenticationResult) {
+		require.NoError(t, server.Receive(context.Background(), &smtp.Envelope{
+			SessionID:  "test",
+			Recipients: []string{"alice@example.com"},
+			Data:       []byte(strings.ReplaceAll(raw, "\n", "\r\n")),
+			ClientIP:   net.ParseIP("192.0.2.1"),
+			Auth:       result,
+		}))
+	}
+	dsn := func(messageID string, recipients ...string) string {
+		raw := `From: MAILER-DAEMON@mx.example.net
+To: alice@example.com
+Subject: Undelivered Mail Returned to Sender
+Content-Type: multipart/report; report-type=delivery-status; boundary="dsn"
+
+--dsn
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.net
+`
+		for _, rcpt := range recipients {
+			status := "5.1.1"
+			if strings.HasPrefix(rcpt, "full@") {
+				status = "4.2.2"
+			}
+			raw += "\nFinal-Recipient: rfc822; " + rcpt + "\nAction: failed\nStatus: " + status + "\nDiagnostic-Code: smtp; 550 5.1.1 User unknown\n"
+		}
+		return raw + `
+--dsn
+Content-Type: text/rfc822-headers
+
+From: alice@example.com
+Message-ID: ` + messageID + `
+--dsn--
+`
+	}
+
+	// One message is still being retried, another has been delivered
+	queued := &outbound.Message{ID: "out_1705314600_a1b2c3d4", From: "alice@example.com", MessageID: "<sent-1@example.com>",
+		Recipients: outbound.NewRecipients([]string{"gone@example.net", "full@example.net"}), QueuedAt: time.Now()}
+	require.NoError(t, server.outbound.Enqueue(queued, []byte("Subject: hi\r\n\r\nhi\r\n")))
+	delivered := &outbound.Message{MessageID: "<sent-2@example.com>", Recipients: outbound.NewRecipients([]string{"angry@isp.example"})}
+	delivered.Recipients[0].Status = outbound.StatusDelivered
+	require.NoError(t, server.outbound.RecordSent(delivered))
+
+	receive(dsn("<sent-1@example.com>", "gone@example.net", "full@example.net", "victim@example.org"), nil)
+	receive(dsn("<forged@example.com>", "victim@example.net"), nil)
+	receive(dsn("", "trusted@example.net"), &auth.AuthenticationResult{
+		DMARC:  &auth.DMARCResult{Result: authres.ResultPass, Domain: "example.net"},
+		Action: "accept",
+	})
+
+	receive(`From: abuse@isp.example
+To: alice@example.com
+Subject: Complaint
+Content-Type: multipart/report; report-type=feedback-report; boundary="arf"
+
+--arf
+Content-Type: message/feedback-report
+
+Feedback-Type: abuse
+User-Agent: ISP-FBL/1.0
+Version: 1
+Original-Rcpt-To: <angry@isp.example>
+
+--arf
+Content-Type: message/rfc822
+
+From: alice@example.com
+Message-ID: <sent-2@example.com>
+Subject: hi
+
+hi
+--arf--
+`, nil)
+
+	gone, err := server.suppressions.Get("gone@example.net")
+	require.NoError(t, err)
+	assert.Equal(t, outbound.ReasonHardBounce, gone.Reason)
+	assert.Equal(t, "550 5.1.1 User unknown", gone.Detail)
+
+	angry, err := server.suppressions.Get("angry@isp.example")
+	require.NoError(t, err)
+	assert.Equal(t, outbound.ReasonComplaint, angry.Reason)
+	assert.Equal(t, "abuse report from ISP-FBL/1.0", angry.Detail)
+	assert.Nil(t, angry.ExpiresAt)
+
+	_, err = server.suppressions.Get("trusted@example.net")
+	assert.ErrorIs(t, err, outbound.ErrNotSuppressed, "passing DMARC does not prove we sent to the address")
+
+	_, err = server.suppressions.Get("full@example.net")
+	assert.ErrorIs(t, err, outbound.ErrNotSuppressed, "soft bounces are not suppressed")
+	_, err = server.suppressions.Get("victim@example.org")
+	assert.ErrorIs(t, err, outbound.ErrNotSuppressed, "only recipients of the quoted message")
+	_, err = server.suppressions.Get("victim@example.net")
+	assert.ErrorIs(t, err, outbound.ErrNotSuppressed, "unknown Message-IDs are ignored")
+}
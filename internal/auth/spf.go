@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -95,6 +96,12 @@ func lookupSPF(domain string) (string, error) {
 	// Look up TXT records
 	records, err := net.LookupTXT(domain)
 	if err != nil {
+		// A domain without TXT records has no SPF policy; only lookup
+		// failures are temporary errors
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
 		return "", fmt.Errorf("TXT lookup failed: %w", err)
 	}
 
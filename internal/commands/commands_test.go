@@ -2,8 +2,10 @@ package commands
 
 import (
 	"bytes"
+	"context"
 	"testing"
 
+	"github.com/grumpyguvner/gomail/internal/storage"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -110,6 +112,82 @@ func TestNewServerCommand(t *testing.T) {
 	assert.Equal(t, "Run the mail API server", cmd.Short)
 }
 
+func TestNewDeadLetterCommand(t *testing.T) {
+	cmd := NewDeadLetterCommand()
+	assert.NotNil(t, cmd)
+	assert.Equal(t, "deadletter", cmd.Use)
+
+	// Check subcommands exist
+	subcommands := []string{"list", "replay", "purge"}
+	for _, subcmd := range subcommands {
+		found := false
+		for _, c := range cmd.Commands() {
+			if c.Name() == subcmd {
+				found = true
+				break
+			}
+		}
+		assert.True(t, found, "Subcommand %s not found", subcmd)
+	}
+}
+
+func TestNewSuppressionCommand(t *testing.T) {
+	cmd := NewSuppressionCommand()
+	assert.NotNil(t, cmd)
+	assert.Equal(t, "suppression", cmd.Use)
+
+	// Check subcommands exist
+	subcommands := []string{"list", "add", "remove", "check"}
+	for _, subcmd := range subcommands {
+		found := false
+		for _, c := range cmd.Commands() {
+			if c.Name() == subcmd {
+				found = true
+				break
+			}
+		}
+		assert.True(t, found, "Subcommand %s not found", subcmd)
+	}
+}
+
+func TestNewStorageCommand(t *testing.T) {
+	cmd := NewStorageCommand()
+	assert.NotNil(t, cmd)
+	assert.Equal(t, "storage", cmd.Use)
+
+	// Check subcommands exist
+	subcommands := []string{"migrate", "prune", "rekey"}
+	for _, subcmd := range subcommands {
+		found := false
+		for _, c := range cmd.Commands() {
+			if c.Name() == subcmd {
+				found = true
+				break
+			}
+		}
+		assert.True(t, found, "Subcommand %s not found", subcmd)
+	}
+}
+
+func TestDeadLetterIDs(t *testing.T) {
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	ids, err := deadLetterIDs(context.Background(), store, []string{"msg_1_ab"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"msg_1_ab"}, ids)
+
+	_, err = deadLetterIDs(context.Background(), store, nil, false)
+	assert.Error(t, err)
+
+	_, err = deadLetterIDs(context.Background(), store, []string{"msg_1_ab"}, true)
+	assert.Error(t, err)
+
+	ids, err = deadLetterIDs(context.Background(), store, nil, true)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
 func TestConfigShowCommand(t *testing.T) {
 	cmd := newConfigShowCommand()
 	assert.NotNil(t, cmd)
@@ -230,6 +308,9 @@ func TestCommandStructure(t *testing.T) {
 		NewTestCommand,
 		NewInstallCommand,
 		NewServerCommand,
+		NewDeadLetterCommand,
+		NewSuppressionCommand,
+		NewStorageCommand,
 	}
 
 	for _, cmdFunc := range commands {
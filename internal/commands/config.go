@@ -48,6 +48,30 @@ func newConfigShowCommand() *cobra.Command {
 				if displayCfg.DOAPIToken != "" {
 					displayCfg.DOAPIToken = "***hidden***"
 				}
+				if displayCfg.WebhookBearerToken != "" {
+					displayCfg.WebhookBearerToken = "***hidden***"
+				}
+				if displayCfg.WebhookSecret != "" {
+					displayCfg.WebhookSecret = "***hidden***"
+				}
+				if displayCfg.WebhookSecretPrevious != "" {
+					displayCfg.WebhookSecretPrevious = "***hidden***"
+				}
+				if displayCfg.InboundSecret != "" {
+					displayCfg.InboundSecret = "***hidden***"
+				}
+				if displayCfg.InboundSecretPrevious != "" {
+					displayCfg.InboundSecretPrevious = "***hidden***"
+				}
+				if displayCfg.S3SecretKey != "" {
+					displayCfg.S3SecretKey = "***hidden***"
+				}
+				displayCfg.WebhookRoutes = append([]config.WebhookRoute(nil), cfg.WebhookRoutes...)
+				for i := range displayCfg.WebhookRoutes {
+					if displayCfg.WebhookRoutes[i].BearerToken != "" {
+						displayCfg.WebhookRoutes[i].BearerToken = "***hidden***"
+					}
+				}
 			}
 
 			// Pretty print as JSON
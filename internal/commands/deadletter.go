@@ -0,0 +1,196 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+func NewDeadLetterCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deadletter",
+		Short: "Manage failed webhook deliveries",
+		Long:  `List, replay, and purge messages that exhausted their webhook delivery retries.`,
+	}
+
+	cmd.AddCommand(newDeadLetterListCommand())
+	cmd.AddCommand(newDeadLetterReplayCommand())
+	cmd.AddCommand(newDeadLetterPurgeCommand())
+
+	return cmd
+}
+
+func newDeadLetterListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List dead-lettered messages",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openDeadLetterStorage()
+			if err != nil {
+				return err
+			}
+
+			letters, err := store.ListDeadLetters(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to list dead letters: %w", err)
+			}
+
+			if len(letters) == 0 {
+				fmt.Println("No dead-lettered messages")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tFAILED AT\tATTEMPTS\tSTATUS\tLAST ERROR")
+			for _, letter := range letters {
+				failedAt := "-"
+				if !letter.FailedAt.IsZero() {
+					failedAt = letter.FailedAt.Local().Format(time.RFC3339)
+				}
+				status := "-"
+				if letter.HTTPStatus != 0 {
+					status = fmt.Sprintf("%d", letter.HTTPStatus)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", letter.ID, failedAt, letter.Attempts, status, letter.LastError)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			fmt.Printf("\n%d dead-lettered message(s)\n", len(letters))
+			return nil
+		},
+	}
+}
+
+func newDeadLetterReplayCommand() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "replay [id...]",
+		Short: "Replay dead-lettered messages to the webhook",
+		Long: `Move dead-lettered messages back to the inbox. The webhook dispatcher
+picks them up on its next poll and starts a fresh round of delivery attempts.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openDeadLetterStorage()
+			if err != nil {
+				return err
+			}
+
+			ids, err := deadLetterIDs(cmd.Context(), store, args, all)
+			if err != nil {
+				return err
+			}
+
+			for _, id := range ids {
+				if err := store.ReplayDeadLetter(cmd.Context(), id); err != nil {
+					return fmt.Errorf("failed to replay %s: %w", id, err)
+				}
+				fmt.Printf("✓ Replayed %s\n", id)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "replay every dead-lettered message")
+
+	return cmd
+}
+
+func newDeadLetterPurgeCommand() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "purge [id...]",
+		Short: "Permanently delete dead-lettered messages",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openDeadLetterStorage()
+			if err != nil {
+				return err
+			}
+
+			ids, err := deadLetterIDs(cmd.Context(), store, args, all)
+			if err != nil {
+				return err
+			}
+
+			for _, id := range ids {
+				if err := purgeDeadLetter(cmd.Context(), store, id); err != nil {
+					return fmt.Errorf("failed to purge %s: %w", id, err)
+				}
+				fmt.Printf("✓ Purged %s\n", id)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "purge every dead-lettered message")
+
+	return cmd
+}
+
+// openDeadLetterStorage opens the configured storage backend
+func openDeadLetterStorage() (storage.DeliveryStore, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	store, err := storage.Open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	queue, ok := store.(storage.DeliveryStore)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %q does not track webhook deliveries", cfg.StorageBackend)
+	}
+
+	return queue, nil
+}
+
+// purgeDeadLetter deletes a message, refusing ones that are not dead-lettered
+func purgeDeadLetter(ctx context.Context, store storage.DeliveryStore, id string) error {
+	status, err := store.Status(ctx, id)
+	if err != nil {
+		return err
+	}
+	if status != storage.FolderDeadLetter {
+		return fmt.Errorf("message is %s, not dead-lettered", status)
+	}
+
+	return store.Delete(ctx, id)
+}
+
+// deadLetterIDs resolves the messages a replay or purge applies to
+func deadLetterIDs(ctx context.Context, store storage.DeliveryStore, args []string, all bool) ([]string, error) {
+	if all && len(args) > 0 {
+		return nil, fmt.Errorf("specify message IDs or --all, not both")
+	}
+	if !all {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("specify at least one message ID or --all")
+		}
+		return args, nil
+	}
+
+	letters, err := store.ListDeadLetters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	ids := make([]string, 0, len(letters))
+	for _, letter := range letters {
+		ids = append(ids, letter.ID)
+	}
+	return ids, nil
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,6 +14,12 @@ import (
 	"github.com/grumpyguvner/gomail/internal/config"
 	"github.com/grumpyguvner/gomail/internal/logging"
 	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/grumpyguvner/gomail/internal/milter"
+	"github.com/grumpyguvner/gomail/internal/outbound"
+	"github.com/grumpyguvner/gomail/internal/policy"
+	"github.com/grumpyguvner/gomail/internal/smtp"
+	"github.com/grumpyguvner/gomail/internal/storage"
+	"github.com/grumpyguvner/gomail/internal/webhook"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -29,7 +36,7 @@ func NewServerCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "server",
 		Short: "Run the mail API server",
-		Long:  `Start the mail API server that receives emails from Postfix and forwards them via webhook.`,
+		Long:  `Start the mail API server that receives emails from Postfix (through the pipe script, or over LMTP when lmtp_enabled is set), or directly over SMTP when smtp_enabled is set, and forwards them via webhook. With milter_enabled it also checks mail for an MTA that delivers it itself, and with outbound_enabled it sends mail submitted on POST /mail/send.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// Load configuration from viper first
 			cfg, err := config.Load()
@@ -97,6 +104,120 @@ func NewServerCommand() *cobra.Command {
 				cancel()
 			}()
 
+			// Start webhook dispatcher if a downstream endpoint is configured
+			if cfg.WebhookURL != "" || len(cfg.WebhookRoutes) > 0 {
+				// Share the server's storage so pooled backends are not opened twice
+				if store, ok := server.Storage().(storage.DeliveryStore); ok {
+					go webhook.NewDispatcher(cfg, store).Run(ctx)
+				} else {
+					logging.Get().Errorf("Storage backend %q does not track webhook deliveries, stored emails will not be forwarded", cfg.StorageBackend)
+				}
+			} else {
+				logging.Get().Info("No webhook_url or webhook_routes configured, stored emails will not be forwarded")
+			}
+
+			// Start outbound delivery of messages queued on POST /mail/send
+			if queue := server.Outbound(); queue != nil {
+				dispatcher := outbound.NewDispatcher(cfg, queue)
+				dispatcher.SetSuppressions(server.Suppressions())
+
+				// Delivery events go to the webhook the sender's address routes to
+				if cfg.WebhookURL != "" || len(cfg.WebhookRoutes) > 0 {
+					events, err := webhook.NewEvents(cfg)
+					if err != nil {
+						return fmt.Errorf("failed to create webhook event queue: %w", err)
+					}
+					dispatcher.SetPublisher(events)
+					go events.Run(ctx)
+				}
+				go dispatcher.Run(ctx)
+			}
+
+			// Start the retention janitor if any limit is configured or
+			// attachment blobs need collecting
+			janitor := storage.NewJanitor(cfg, server.Storage())
+			janitor.SetBlobs(server.Blobs())
+			if janitor.Enabled() {
+				go janitor.Run(ctx)
+			}
+
+			// Start the SMTP receiver when GoMail accepts mail itself
+			var smtpServer *smtp.Server
+			if cfg.SMTPEnabled {
+				smtpServer, err = smtp.NewServer(cfg, server)
+				if err != nil {
+					return fmt.Errorf("failed to create SMTP receiver: %w", err)
+				}
+				listener, err := net.Listen("tcp", cfg.SMTPListen)
+				if err != nil {
+					return fmt.Errorf("failed to listen for SMTP on %s: %w", cfg.SMTPListen, err)
+				}
+
+				go func() {
+					logging.Get().Infof("Starting SMTP receiver on %s", cfg.SMTPListen)
+					if err := smtpServer.Serve(listener); err != nil {
+						logging.Get().Errorf("SMTP receiver error: %v", err)
+					}
+				}()
+			}
+
+			// Start the LMTP server Postfix delivers to
+			var lmtpServer *smtp.Server
+			if cfg.LMTPEnabled {
+				lmtpServer = smtp.NewLMTPServer(cfg, server)
+				listener, err := smtp.Listen(cfg.LMTPListen)
+				if err != nil {
+					return fmt.Errorf("failed to listen for LMTP on %s: %w", cfg.LMTPListen, err)
+				}
+
+				go func() {
+					logging.Get().Infof("Starting LMTP server on %s", cfg.LMTPListen)
+					if err := lmtpServer.Serve(listener); err != nil {
+						logging.Get().Errorf("LMTP server error: %v", err)
+					}
+				}()
+			}
+
+			// Start the milter for MTAs that deliver mail themselves
+			var milterServer *milter.Server
+			if cfg.MilterEnabled {
+				milterServer, err = milter.NewServer(cfg, server)
+				if err != nil {
+					return fmt.Errorf("failed to create milter: %w", err)
+				}
+				listener, err := smtp.Listen(cfg.MilterListen)
+				if err != nil {
+					return fmt.Errorf("failed to listen for milter connections on %s: %w", cfg.MilterListen, err)
+				}
+
+				go func() {
+					logging.Get().Infof("Starting milter on %s", cfg.MilterListen)
+					if err := milterServer.Serve(listener); err != nil {
+						logging.Get().Errorf("Milter error: %v", err)
+					}
+				}()
+			}
+
+			// Start the policy service Postfix consults at RCPT time
+			var policyServer *policy.Server
+			if cfg.PolicyServiceEnabled {
+				policyServer, err = policy.NewServer(cfg)
+				if err != nil {
+					return fmt.Errorf("failed to create policy service: %w", err)
+				}
+				listener, err := smtp.Listen(cfg.PolicyServiceListen)
+				if err != nil {
+					return fmt.Errorf("failed to listen for policy requests on %s: %w", cfg.PolicyServiceListen, err)
+				}
+
+				go func() {
+					logging.Get().Infof("Starting policy service on %s", cfg.PolicyServiceListen)
+					if err := policyServer.Serve(listener); err != nil {
+						logging.Get().Errorf("Policy service error: %v", err)
+					}
+				}()
+			}
+
 			// Start server
 			logging.Get().Infof("Starting mail API server on port %d (mode: %s)", cfg.Port, cfg.Mode)
 			if err := server.Start(ctx); err != nil {
@@ -111,6 +232,26 @@ func NewServerCommand() *cobra.Command {
 			defer shutdownCancel()
 
 			logging.Get().Info("Gracefully shutting down server (timeout: 30s)...")
+			if smtpServer != nil {
+				if err := smtpServer.Shutdown(shutdownCtx); err != nil {
+					logging.Get().Warnf("SMTP sessions still open at shutdown were closed: %v", err)
+				}
+			}
+			if lmtpServer != nil {
+				if err := lmtpServer.Shutdown(shutdownCtx); err != nil {
+					logging.Get().Warnf("LMTP sessions still open at shutdown were closed: %v", err)
+				}
+			}
+			if milterServer != nil {
+				if err := milterServer.Shutdown(shutdownCtx); err != nil {
+					logging.Get().Warnf("Milter connections still open at shutdown were closed: %v", err)
+				}
+			}
+			if policyServer != nil {
+				if err := policyServer.Shutdown(shutdownCtx); err != nil {
+					logging.Get().Warnf("Policy service connections still open at shutdown were closed: %v", err)
+				}
+			}
 			if err := server.Shutdown(shutdownCtx); err != nil {
 				if err == context.DeadlineExceeded {
 					logging.Get().Error("Graceful shutdown timed out after 30 seconds, forcing shutdown")
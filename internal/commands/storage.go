@@ -0,0 +1,266 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+func NewStorageCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "storage",
+		Short: "Manage stored emails",
+		Long:  `Maintenance tasks for the email storage backends.`,
+	}
+
+	cmd.AddCommand(newStorageMigrateCommand())
+	cmd.AddCommand(newStoragePruneCommand())
+	cmd.AddCommand(newStorageRekeyCommand())
+
+	return cmd
+}
+
+func newStorageMigrateCommand() *cobra.Command {
+	var dataDir, sqlitePath, target string
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Import a file-based data directory into SQLite or S3",
+		Long: `Copy every message from a file-based data directory into the SQLite
+database, keeping message IDs and webhook delivery state. Messages that
+are already in the database are skipped, so the command can be re-run.
+
+With --to s3 the messages are uploaded to the configured bucket instead,
+and only their metadata is written to the SQLite database.
+
+Set storage_backend to match once the migration has finished. The data
+directory is left untouched.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+			if dataDir == "" {
+				dataDir = cfg.DataDir
+			}
+			if sqlitePath == "" {
+				sqlitePath = storage.SQLitePath(cfg)
+			}
+
+			// Both sides use the configured compression and encryption
+			codec, err := storage.CodecFor(cfg)
+			if err != nil {
+				return fmt.Errorf("failed to load storage encoding: %w", err)
+			}
+
+			src, err := storage.NewFileStorage(dataDir)
+			if err != nil {
+				return fmt.Errorf("failed to open data directory: %w", err)
+			}
+			src.SetCodec(codec)
+
+			var dst interface {
+				storage.Storage
+				SetCodec(*storage.Codec)
+				Close() error
+			}
+			destination := sqlitePath
+			switch target {
+			case storage.BackendSQLite:
+				dst, err = storage.NewSQLiteStorage(sqlitePath)
+				if err != nil {
+					return fmt.Errorf("failed to open SQLite database: %w", err)
+				}
+			case storage.BackendS3:
+				dst, err = storage.NewObjectStorage(storage.S3OptionsFrom(cfg), sqlitePath)
+				if err != nil {
+					return fmt.Errorf("failed to open object storage: %w", err)
+				}
+				destination = "s3://" + cfg.S3Bucket
+			default:
+				return fmt.Errorf("unsupported migration target: %s (use sqlite or s3)", target)
+			}
+			defer dst.Close()
+			dst.SetCodec(codec)
+
+			fmt.Printf("Migrating %s to %s...\n", dataDir, destination)
+			result, err := storage.Migrate(cmd.Context(), src, dst)
+			if err != nil {
+				return fmt.Errorf("migration failed after %d message(s): %w", result.Migrated, err)
+			}
+
+			fmt.Printf("✓ Migrated %d message(s), skipped %d already present\n", result.Migrated, result.Skipped)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&dataDir, "data-dir", "", "file-based data directory to import (default: data_dir)")
+	cmd.Flags().StringVar(&sqlitePath, "sqlite-path", "", "SQLite database to import into (default: sqlite_path)")
+	cmd.Flags().StringVar(&target, "to", storage.BackendSQLite, "backend to import into: sqlite or s3")
+
+	return cmd
+}
+
+func newStoragePruneCommand() *cobra.Command {
+	var dryRun bool
+	var maxAge, maxSizeMB int
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete messages outside the retention policy",
+		Long: `Apply the retention_* settings once: delete inbox and processed messages
+older than their domain's age limit, then the oldest messages until the
+total size is within retention_max_size_mb. Dead-lettered messages are
+never pruned. With attachment_extract on, attachment blobs no remaining
+message refers to are deleted afterwards.
+
+The server runs the same pass every retention_interval minutes. Use
+--dry-run to see what would be deleted without deleting anything.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+			if cmd.Flags().Changed("max-age") {
+				cfg.RetentionMaxAge = maxAge
+			}
+			if cmd.Flags().Changed("max-size-mb") {
+				cfg.RetentionMaxSizeMB = maxSizeMB
+			}
+
+			policy := storage.NewRetentionPolicy(cfg)
+			if !policy.Enabled() && !cfg.AttachmentExtract {
+				fmt.Println("No retention limits configured (set retention_max_age, retention_max_size_mb or retention_domains)")
+				return nil
+			}
+
+			store, err := storage.Open(cfg)
+			if err != nil {
+				return fmt.Errorf("failed to open storage: %w", err)
+			}
+			if closer, ok := store.(io.Closer); ok {
+				defer closer.Close()
+			}
+
+			if policy.Enabled() {
+				if err := pruneMessages(cmd.Context(), store, policy, dryRun); err != nil {
+					return err
+				}
+			}
+
+			// Attachment blobs left behind by deleted messages
+			if cfg.AttachmentExtract && !dryRun {
+				blobs, err := storage.BlobsFor(cfg)
+				if err != nil {
+					return fmt.Errorf("failed to open attachment store: %w", err)
+				}
+				removed, removedBytes, err := storage.SweepBlobs(cmd.Context(), store, blobs, time.Now())
+				if err != nil {
+					return fmt.Errorf("attachment sweep failed after %d blob(s): %w", removed, err)
+				}
+				fmt.Printf("✓ Deleted %d unreferenced attachment blob(s), %d bytes\n", removed, removedBytes)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report what would be deleted without deleting")
+	cmd.Flags().IntVar(&maxAge, "max-age", 0, "override retention_max_age (days)")
+	cmd.Flags().IntVar(&maxSizeMB, "max-size-mb", 0, "override retention_max_size_mb")
+
+	return cmd
+}
+
+// pruneMessages applies a retention policy once and prints the outcome
+func pruneMessages(ctx context.Context, store storage.Storage, policy storage.RetentionPolicy, dryRun bool) error {
+	result, err := storage.Prune(ctx, store, policy, time.Now(), dryRun)
+	if err != nil {
+		return fmt.Errorf("prune failed after %d message(s): %w", len(result.Pruned), err)
+	}
+
+	if dryRun && len(result.Pruned) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTORED AT\tRECIPIENT\tSIZE\tREASON")
+		for _, msg := range result.Pruned {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", msg.ID, msg.StoredAt.Local().Format(time.RFC3339), msg.Recipient, msg.SizeBytes, msg.Reason)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+
+	verb := "Pruned"
+	if dryRun {
+		verb = "Would prune"
+	}
+	fmt.Printf("✓ %s %d message(s), %d bytes; %d message(s), %d bytes kept\n",
+		verb, len(result.Pruned), result.PrunedBytes, result.Kept, result.KeptBytes)
+	return nil
+}
+
+func newStorageRekeyCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rekey",
+		Short: "Re-encode stored messages with the current compression and key",
+		Long: `Rewrite every stored message that is not yet encoded with the current
+storage_compression and encryption key. Run it after adding a new key to
+storage_encryption_key_file (or changing storage_encryption_key_id); once
+it finishes, older keys can be removed from the key file. Extracted
+attachment blobs are re-encoded too.
+
+Messages already encoded with the current settings are skipped, so the
+command can be re-run. Stop the server first if webhook deliveries are
+in flight.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+
+			store, err := storage.Open(cfg)
+			if err != nil {
+				return fmt.Errorf("failed to open storage: %w", err)
+			}
+			if closer, ok := store.(io.Closer); ok {
+				defer closer.Close()
+			}
+
+			rekeyer, ok := store.(storage.Rekeyer)
+			if !ok {
+				return fmt.Errorf("storage backend %q does not encode messages at rest", cfg.StorageBackend)
+			}
+
+			fmt.Println("Re-encoding stored messages...")
+			result, err := rekeyer.Rekey(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("rekey failed after %d message(s): %w", result.Rewritten, err)
+			}
+
+			fmt.Printf("✓ Rewrote %d message(s), skipped %d already current\n", result.Rewritten, result.Skipped)
+
+			blobs, err := storage.BlobsFor(cfg)
+			if err != nil {
+				return fmt.Errorf("failed to open attachment store: %w", err)
+			}
+			if blobs != nil {
+				result, err := blobs.Rekey(cmd.Context())
+				if err != nil {
+					return fmt.Errorf("rekey failed after %d attachment blob(s): %w", result.Rewritten, err)
+				}
+				fmt.Printf("✓ Rewrote %d attachment blob(s), skipped %d already current\n", result.Rewritten, result.Skipped)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,194 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/outbound"
+	"github.com/spf13/cobra"
+)
+
+func NewSuppressionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "suppression",
+		Short: "Manage the outbound suppression list",
+		Long: `List, add, remove, and check addresses that POST /mail/send will not send to.
+Hard bounces and spam complaints are added automatically.`,
+	}
+
+	cmd.AddCommand(newSuppressionListCommand())
+	cmd.AddCommand(newSuppressionAddCommand())
+	cmd.AddCommand(newSuppressionRemoveCommand())
+	cmd.AddCommand(newSuppressionCheckCommand())
+
+	return cmd
+}
+
+func newSuppressionListCommand() *cobra.Command {
+	var reason string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List suppressed addresses",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			suppressions, err := openSuppressions()
+			if err != nil {
+				return err
+			}
+
+			list, err := suppressions.List()
+			if err != nil {
+				return fmt.Errorf("failed to list suppressions: %w", err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ADDRESS\tREASON\tCREATED\tEXPIRES\tDETAIL")
+			count := 0
+			for _, entry := range list {
+				if reason != "" && entry.Reason != reason {
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Address, entry.Reason,
+					entry.CreatedAt.Local().Format(time.RFC3339), formatExpiry(entry), entry.Detail)
+				count++
+			}
+
+			if count == 0 {
+				fmt.Println("No suppressed addresses")
+				return nil
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			fmt.Printf("\n%d suppressed address(es)\n", count)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&reason, "reason", "", "only list addresses suppressed for this reason (e.g. hard_bounce, complaint)")
+
+	return cmd
+}
+
+func newSuppressionAddCommand() *cobra.Command {
+	var reason, detail string
+	var expires time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "add <address>...",
+		Short: "Suppress addresses",
+		Long: `Add addresses to the suppression list, replacing any existing entries for
+them. Without --expires they stay suppressed until removed.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if expires < 0 {
+				return fmt.Errorf("--expires cannot be negative")
+			}
+
+			suppressions, err := openSuppressions()
+			if err != nil {
+				return err
+			}
+
+			for _, address := range args {
+				entry := &outbound.Suppression{Address: address, Reason: reason, Detail: detail}
+				if expires > 0 {
+					expiresAt := time.Now().UTC().Add(expires)
+					entry.ExpiresAt = &expiresAt
+				}
+				if err := suppressions.Add(entry); err != nil {
+					return fmt.Errorf("failed to suppress %s: %w", address, err)
+				}
+				fmt.Printf("✓ Suppressed %s\n", entry.Address)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&reason, "reason", outbound.ReasonManual, "reason for the suppression")
+	cmd.Flags().StringVar(&detail, "detail", "", "free-form note stored with the entry")
+	cmd.Flags().DurationVar(&expires, "expires", 0, "remove the entry after this long (e.g. 720h)")
+
+	return cmd
+}
+
+func newSuppressionRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove <address>...",
+		Short: "Remove addresses from the suppression list",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			suppressions, err := openSuppressions()
+			if err != nil {
+				return err
+			}
+
+			for _, address := range args {
+				if err := suppressions.Remove(address); err != nil {
+					return fmt.Errorf("failed to remove %s: %w", address, err)
+				}
+				fmt.Printf("✓ Removed %s\n", address)
+			}
+
+			return nil
+		},
+	}
+}
+
+func newSuppressionCheckCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "check <address>...",
+		Short: "Show whether addresses are suppressed",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			suppressions, err := openSuppressions()
+			if err != nil {
+				return err
+			}
+
+			for _, address := range args {
+				entry, err := suppressions.Get(address)
+				if errors.Is(err, outbound.ErrNotSuppressed) {
+					fmt.Printf("%s: not suppressed\n", address)
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("failed to check %s: %w", address, err)
+				}
+				fmt.Printf("%s: suppressed (%s) since %s, expires %s\n", entry.Address, entry.Reason,
+					entry.CreatedAt.Local().Format(time.RFC3339), formatExpiry(entry))
+				if entry.Detail != "" {
+					fmt.Printf("  %s\n", entry.Detail)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// openSuppressions opens the suppression list in the configured data directory
+func openSuppressions() (*outbound.Suppressions, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	return outbound.NewSuppressions(filepath.Join(cfg.DataDir, "suppressions"))
+}
+
+// formatExpiry describes when an entry expires
+func formatExpiry(entry *outbound.Suppression) string {
+	if entry.ExpiresAt == nil {
+		return "never"
+	}
+	return entry.ExpiresAt.Local().Format(time.RFC3339)
+}
@@ -24,6 +24,29 @@ type Config struct {
 	// API configuration
 	APIEndpoint string `json:"api_endpoint" mapstructure:"api_endpoint"`
 
+	// Webhook delivery configuration (timeouts and delays in seconds)
+	WebhookURL          string `json:"webhook_url" mapstructure:"webhook_url"`
+	WebhookBearerToken  string `json:"webhook_bearer_token" mapstructure:"webhook_bearer_token"`
+	WebhookTimeout      int    `json:"webhook_timeout" mapstructure:"webhook_timeout"`
+	WebhookMaxRetries   int    `json:"webhook_max_retries" mapstructure:"webhook_max_retries"`
+	WebhookRetryDelay   int    `json:"webhook_retry_delay" mapstructure:"webhook_retry_delay"`
+	WebhookPollInterval int    `json:"webhook_poll_interval" mapstructure:"webhook_poll_interval"`
+
+	// Webhook routing. Recipients matching a route are delivered to its URL;
+	// everything else goes to WebhookURL.
+	WebhookRoutes []WebhookRoute `json:"webhook_routes,omitempty" mapstructure:"webhook_routes"`
+
+	// Webhook signing configuration. Both secrets are active during rotation.
+	WebhookSecret             string `json:"webhook_secret" mapstructure:"webhook_secret"`
+	WebhookSecretPrevious     string `json:"webhook_secret_previous" mapstructure:"webhook_secret_previous"`
+	WebhookSignatureTolerance int    `json:"webhook_signature_tolerance" mapstructure:"webhook_signature_tolerance"`
+
+	// Secrets accepted on signed POST /mail/inbound requests in place of the
+	// bearer token. Kept apart from the webhook secrets, which every webhook
+	// receiver holds.
+	InboundSecret         string `json:"inbound_secret" mapstructure:"inbound_secret"`
+	InboundSecretPrevious string `json:"inbound_secret_previous" mapstructure:"inbound_secret_previous"`
+
 	// Rate limiting configuration
 	RateLimitPerMinute int `json:"rate_limit_per_minute" mapstructure:"rate_limit_per_minute"`
 	RateLimitBurst     int `json:"rate_limit_burst" mapstructure:"rate_limit_burst"`
@@ -42,6 +65,106 @@ type Config struct {
 	IdleTimeout    int `json:"idle_timeout" mapstructure:"idle_timeout"`
 	HandlerTimeout int `json:"handler_timeout" mapstructure:"handler_timeout"`
 
+	// Storage configuration
+	StorageBackend string `json:"storage_backend" mapstructure:"storage_backend"` // "file", "sqlite", "s3" or "maildir"
+	SQLitePath     string `json:"sqlite_path" mapstructure:"sqlite_path"`         // defaults to <data_dir>/gomail.db
+
+	// S3-compatible object storage for the s3 backend. Messages go to the
+	// bucket; their metadata stays in the local sqlite_path database.
+	S3Endpoint  string `json:"s3_endpoint" mapstructure:"s3_endpoint"` // e.g. https://minio.internal:9000
+	S3Region    string `json:"s3_region" mapstructure:"s3_region"`
+	S3Bucket    string `json:"s3_bucket" mapstructure:"s3_bucket"`
+	S3Prefix    string `json:"s3_prefix" mapstructure:"s3_prefix"`
+	S3AccessKey string `json:"s3_access_key" mapstructure:"s3_access_key"`
+	S3SecretKey string `json:"s3_secret_key" mapstructure:"s3_secret_key"`
+	S3PathStyle bool   `json:"s3_path_style" mapstructure:"s3_path_style"` // required by most MinIO deployments
+
+	// At-rest encoding for the file, sqlite and s3 backends. The key file
+	// holds "<id>:<base64 32-byte key>" lines; new messages are encrypted with
+	// StorageEncryptionKeyID, or the last key in the file when it is empty.
+	StorageCompression       string `json:"storage_compression" mapstructure:"storage_compression"` // "none", "gzip" or "zstd"
+	StorageEncryptionKeyFile string `json:"storage_encryption_key_file" mapstructure:"storage_encryption_key_file"`
+	StorageEncryptionKeyID   string `json:"storage_encryption_key_id" mapstructure:"storage_encryption_key_id"`
+
+	// Retention configuration. Zero disables a limit. Dead-lettered messages
+	// are never purged automatically.
+	RetentionMaxAge    int               `json:"retention_max_age" mapstructure:"retention_max_age"`         // days
+	RetentionMaxSizeMB int               `json:"retention_max_size_mb" mapstructure:"retention_max_size_mb"` // total size of stored messages
+	RetentionInterval  int               `json:"retention_interval" mapstructure:"retention_interval"`       // minutes between janitor runs
+	RetentionDomains   []RetentionDomain `json:"retention_domains,omitempty" mapstructure:"retention_domains"`
+
+	// Inbound deduplication. A message with the same Message-ID and recipient
+	// (or, without a Message-ID, the same content) received within the window
+	// is not stored again. Zero disables deduplication.
+	DedupWindow int `json:"dedup_window" mapstructure:"dedup_window"` // minutes
+
+	// Attachment extraction. Attachments of at least AttachmentExtractMinKB
+	// are moved out of stored messages into a content-addressed blob store
+	// under <data_dir>/blobs. When AttachmentBaseURL is set, webhook payloads
+	// carry a download URL for every attachment.
+	AttachmentExtract      bool   `json:"attachment_extract" mapstructure:"attachment_extract"`
+	AttachmentExtractMinKB int    `json:"attachment_extract_min_kb" mapstructure:"attachment_extract_min_kb"`
+	AttachmentBaseURL      string `json:"attachment_base_url" mapstructure:"attachment_base_url"` // e.g. https://mail.example.com
+
+	// Native SMTP receiver. When enabled GoMail accepts mail on SMTPListen
+	// itself, without Postfix and the pipe transport. STARTTLS is offered
+	// when the certificate and key can be loaded.
+	SMTPEnabled             bool   `json:"smtp_enabled" mapstructure:"smtp_enabled"`
+	SMTPListen              string `json:"smtp_listen" mapstructure:"smtp_listen"` // e.g. ":25"
+	SMTPMaxMessageSizeMB    int    `json:"smtp_max_message_size_mb" mapstructure:"smtp_max_message_size_mb"`
+	SMTPMaxRecipients       int    `json:"smtp_max_recipients" mapstructure:"smtp_max_recipients"`
+	SMTPMaxConnections      int    `json:"smtp_max_connections" mapstructure:"smtp_max_connections"`
+	SMTPMaxConnectionsPerIP int    `json:"smtp_max_connections_per_ip" mapstructure:"smtp_max_connections_per_ip"`
+	SMTPTimeout             int    `json:"smtp_timeout" mapstructure:"smtp_timeout"` // seconds
+	SMTPRequireTLS          bool   `json:"smtp_require_tls" mapstructure:"smtp_require_tls"`
+	SMTPTLSCertFile         string `json:"smtp_tls_cert_file" mapstructure:"smtp_tls_cert_file"`
+	SMTPTLSKeyFile          string `json:"smtp_tls_key_file" mapstructure:"smtp_tls_key_file"`
+
+	// Recipient policy, checked at RCPT time by the SMTP receiver and by the
+	// Postfix policy service. Without recipient domains every recipient is
+	// accepted. The policy service answers Postfix check_policy_service
+	// requests on PolicyServiceListen, a host:port or "unix:" socket path,
+	// and also checks SPF at RCPT time when spf_enabled is set.
+	RecipientDomains            []RecipientDomain `json:"recipient_domains,omitempty" mapstructure:"recipient_domains"`
+	PolicyServiceEnabled        bool              `json:"policy_service_enabled" mapstructure:"policy_service_enabled"`
+	PolicyServiceListen         string            `json:"policy_service_listen" mapstructure:"policy_service_listen"` // e.g. "127.0.0.1:10040"
+	PolicyServiceMaxConnections int               `json:"policy_service_max_connections" mapstructure:"policy_service_max_connections"`
+	PolicyRejectSPFFail         bool              `json:"policy_reject_spf_fail" mapstructure:"policy_reject_spf_fail"`
+
+	// LMTP server for Postfix's lmtp transport, replacing the pipe script.
+	// LMTPListen is a host:port or a "unix:" socket path. Message size,
+	// recipient and timeout limits are the smtp_* ones.
+	LMTPEnabled bool   `json:"lmtp_enabled" mapstructure:"lmtp_enabled"`
+	LMTPListen  string `json:"lmtp_listen" mapstructure:"lmtp_listen"` // e.g. "unix:/var/spool/postfix/private/gomail-lmtp"
+
+	// Milter (Sendmail milter protocol v6) for MTAs that keep delivering
+	// mail themselves. Messages are authenticated and annotated with
+	// Authentication-Results; with MilterStoreCopy a copy is also stored and
+	// forwarded to webhooks like mail received over the other transports.
+	MilterEnabled        bool   `json:"milter_enabled" mapstructure:"milter_enabled"`
+	MilterListen         string `json:"milter_listen" mapstructure:"milter_listen"` // e.g. "unix:/var/spool/postfix/private/gomail-milter"
+	MilterMaxConnections int    `json:"milter_max_connections" mapstructure:"milter_max_connections"`
+	MilterStoreCopy      bool   `json:"milter_store_copy" mapstructure:"milter_store_copy"`
+
+	// Outbound mail: POST /mail/send DKIM-signs messages and queues them in
+	// data_dir/outbound for delivery to the recipients' MX hosts. Temporary
+	// failures are retried after each delay in OutboundRetrySchedule
+	// (minutes, the last one repeating) until the message is
+	// OutboundMaxAge hours old. OutboundDomainRate is deliveries per minute
+	// to one destination domain, 0 for no limit.
+	OutboundEnabled           bool  `json:"outbound_enabled" mapstructure:"outbound_enabled"`
+	OutboundRetrySchedule     []int `json:"outbound_retry_schedule" mapstructure:"outbound_retry_schedule"`
+	OutboundMaxAge            int   `json:"outbound_max_age" mapstructure:"outbound_max_age"`
+	OutboundMaxConcurrency    int   `json:"outbound_max_concurrency" mapstructure:"outbound_max_concurrency"`
+	OutboundDomainConcurrency int   `json:"outbound_domain_concurrency" mapstructure:"outbound_domain_concurrency"`
+	OutboundDomainRate        int   `json:"outbound_domain_rate" mapstructure:"outbound_domain_rate"`
+	OutboundTimeout           int   `json:"outbound_timeout" mapstructure:"outbound_timeout"` // seconds per SMTP command
+
+	// Suppression list: addresses in data_dir/suppressions are dropped from
+	// send requests. Hard bounces are added for SuppressionBounceExpiry
+	// hours, 0 for good; spam complaints are always added for good.
+	SuppressionBounceExpiry int `json:"suppression_bounce_expiry" mapstructure:"suppression_bounce_expiry"`
+
 	// Connection pool configuration
 	MaxConnections int `json:"max_connections" mapstructure:"max_connections"`
 	MaxIdleConns   int `json:"max_idle_conns" mapstructure:"max_idle_conns"`
@@ -60,6 +183,41 @@ type Config struct {
 	DMARCEnforcement   string `json:"dmarc_enforcement" mapstructure:"dmarc_enforcement"` // "none", "relaxed", "strict"
 }
 
+// WebhookRoute delivers mail for matching recipients to a dedicated webhook.
+// Match entries containing "@" are recipient patterns ("support@example.com",
+// "*+billing@example.com"); other entries are domains, where "*.example.com"
+// also matches subdomains. Zero timeout and retry values inherit the global
+// webhook settings.
+type WebhookRoute struct {
+	Name        string   `json:"name,omitempty" mapstructure:"name"`
+	Match       []string `json:"match" mapstructure:"match"`
+	URL         string   `json:"url" mapstructure:"url"`
+	BearerToken string   `json:"bearer_token,omitempty" mapstructure:"bearer_token"`
+	Timeout     int      `json:"timeout,omitempty" mapstructure:"timeout"`
+	MaxRetries  int      `json:"max_retries,omitempty" mapstructure:"max_retries"`
+	RetryDelay  int      `json:"retry_delay,omitempty" mapstructure:"retry_delay"`
+}
+
+// RetentionDomain overrides retention_max_age for mail to a recipient
+// domain, where "*.example.com" also matches subdomains. A zero MaxAge keeps
+// the domain's mail until the size limit is reached.
+type RetentionDomain struct {
+	Domain string `json:"domain" mapstructure:"domain"`
+	MaxAge int    `json:"max_age" mapstructure:"max_age"` // days
+}
+
+// RecipientDomain lists the accepted recipients of a domain, where
+// "*.example.com" also matches subdomains. Recipients are local parts
+// ("support") and Patterns are regular expressions matched against the
+// whole local part. CatchAll accepts every address in the domain.
+// postmaster is always accepted.
+type RecipientDomain struct {
+	Domain     string   `json:"domain" mapstructure:"domain"`
+	Recipients []string `json:"recipients,omitempty" mapstructure:"recipients"`
+	Patterns   []string `json:"patterns,omitempty" mapstructure:"patterns"`
+	CatchAll   bool     `json:"catch_all,omitempty" mapstructure:"catch_all"`
+}
+
 func Load() (*Config, error) {
 	cfg := &Config{}
 
@@ -75,6 +233,11 @@ func Load() (*Config, error) {
 	viper.SetDefault("metrics_port", 9090)
 	viper.SetDefault("metrics_path", "/metrics")
 	viper.SetDefault("api_endpoint", "http://localhost:3000/mail/inbound")
+	viper.SetDefault("webhook_timeout", 30)
+	viper.SetDefault("webhook_max_retries", 5)
+	viper.SetDefault("webhook_retry_delay", 5)
+	viper.SetDefault("webhook_poll_interval", 10)
+	viper.SetDefault("webhook_signature_tolerance", 300)
 	viper.SetDefault("postfix_main_cf", "/etc/postfix/main.cf")
 	viper.SetDefault("postfix_virtual_regex", "/etc/postfix/virtual_mailbox_regex")
 	viper.SetDefault("postfix_domains_list", "/etc/postfix/domains.list")
@@ -82,6 +245,33 @@ func Load() (*Config, error) {
 	viper.SetDefault("write_timeout", 30)
 	viper.SetDefault("idle_timeout", 60)
 	viper.SetDefault("handler_timeout", 25)
+	viper.SetDefault("storage_backend", "file")
+	viper.SetDefault("s3_endpoint", "https://s3.amazonaws.com")
+	viper.SetDefault("s3_region", "us-east-1")
+	viper.SetDefault("retention_interval", 60)
+	viper.SetDefault("dedup_window", 1440)
+	viper.SetDefault("attachment_extract_min_kb", 64)
+	viper.SetDefault("smtp_listen", ":25")
+	viper.SetDefault("smtp_max_message_size_mb", 25)
+	viper.SetDefault("smtp_max_recipients", 100)
+	viper.SetDefault("smtp_max_connections", 100)
+	viper.SetDefault("smtp_max_connections_per_ip", 10)
+	viper.SetDefault("smtp_timeout", 300)
+	viper.SetDefault("smtp_tls_cert_file", "/etc/mailserver/certs/cert.pem")
+	viper.SetDefault("smtp_tls_key_file", "/etc/mailserver/certs/key.pem")
+	viper.SetDefault("lmtp_listen", "unix:/var/spool/postfix/private/gomail-lmtp")
+	viper.SetDefault("milter_listen", "unix:/var/spool/postfix/private/gomail-milter")
+	viper.SetDefault("milter_max_connections", 100)
+	viper.SetDefault("outbound_retry_schedule", []int{5, 10, 30, 60, 120, 240})
+	viper.SetDefault("outbound_max_age", 120)
+	viper.SetDefault("outbound_max_concurrency", 20)
+	viper.SetDefault("outbound_domain_concurrency", 2)
+	viper.SetDefault("outbound_domain_rate", 60)
+	viper.SetDefault("outbound_timeout", 300)
+	viper.SetDefault("suppression_bounce_expiry", 0)
+	viper.SetDefault("policy_service_listen", "127.0.0.1:10040")
+	viper.SetDefault("policy_service_max_connections", 100)
+	viper.SetDefault("policy_reject_spf_fail", true)
 	viper.SetDefault("max_connections", 100)
 	viper.SetDefault("max_idle_conns", 10)
 	viper.SetDefault("spf_enabled", true)
@@ -103,6 +293,59 @@ func Load() (*Config, error) {
 	_ = viper.BindEnv("primary_domain", "MAIL_PRIMARY_DOMAIN")
 	_ = viper.BindEnv("mail_hostname", "MAIL_MAIL_HOSTNAME")
 	_ = viper.BindEnv("api_endpoint", "MAIL_API_ENDPOINT")
+	_ = viper.BindEnv("webhook_url", "MAIL_WEBHOOK_URL")
+	_ = viper.BindEnv("webhook_bearer_token", "MAIL_WEBHOOK_BEARER_TOKEN")
+	_ = viper.BindEnv("webhook_secret", "MAIL_WEBHOOK_SECRET")
+	_ = viper.BindEnv("webhook_secret_previous", "MAIL_WEBHOOK_SECRET_PREVIOUS")
+	_ = viper.BindEnv("inbound_secret", "MAIL_INBOUND_SECRET")
+	_ = viper.BindEnv("inbound_secret_previous", "MAIL_INBOUND_SECRET_PREVIOUS")
+	_ = viper.BindEnv("storage_backend", "MAIL_STORAGE_BACKEND")
+	_ = viper.BindEnv("sqlite_path", "MAIL_SQLITE_PATH")
+	_ = viper.BindEnv("s3_endpoint", "MAIL_S3_ENDPOINT")
+	_ = viper.BindEnv("s3_region", "MAIL_S3_REGION")
+	_ = viper.BindEnv("s3_bucket", "MAIL_S3_BUCKET")
+	_ = viper.BindEnv("s3_prefix", "MAIL_S3_PREFIX")
+	_ = viper.BindEnv("s3_access_key", "MAIL_S3_ACCESS_KEY")
+	_ = viper.BindEnv("s3_secret_key", "MAIL_S3_SECRET_KEY")
+	_ = viper.BindEnv("s3_path_style", "MAIL_S3_PATH_STYLE")
+	_ = viper.BindEnv("storage_compression", "MAIL_STORAGE_COMPRESSION")
+	_ = viper.BindEnv("storage_encryption_key_file", "MAIL_STORAGE_ENCRYPTION_KEY_FILE")
+	_ = viper.BindEnv("storage_encryption_key_id", "MAIL_STORAGE_ENCRYPTION_KEY_ID")
+	_ = viper.BindEnv("retention_max_age", "MAIL_RETENTION_MAX_AGE")
+	_ = viper.BindEnv("retention_max_size_mb", "MAIL_RETENTION_MAX_SIZE_MB")
+	_ = viper.BindEnv("retention_interval", "MAIL_RETENTION_INTERVAL")
+	_ = viper.BindEnv("dedup_window", "MAIL_DEDUP_WINDOW")
+	_ = viper.BindEnv("attachment_extract", "MAIL_ATTACHMENT_EXTRACT")
+	_ = viper.BindEnv("attachment_extract_min_kb", "MAIL_ATTACHMENT_EXTRACT_MIN_KB")
+	_ = viper.BindEnv("attachment_base_url", "MAIL_ATTACHMENT_BASE_URL")
+	_ = viper.BindEnv("smtp_enabled", "MAIL_SMTP_ENABLED")
+	_ = viper.BindEnv("smtp_listen", "MAIL_SMTP_LISTEN")
+	_ = viper.BindEnv("smtp_max_message_size_mb", "MAIL_SMTP_MAX_MESSAGE_SIZE_MB")
+	_ = viper.BindEnv("smtp_max_recipients", "MAIL_SMTP_MAX_RECIPIENTS")
+	_ = viper.BindEnv("smtp_max_connections", "MAIL_SMTP_MAX_CONNECTIONS")
+	_ = viper.BindEnv("smtp_max_connections_per_ip", "MAIL_SMTP_MAX_CONNECTIONS_PER_IP")
+	_ = viper.BindEnv("smtp_timeout", "MAIL_SMTP_TIMEOUT")
+	_ = viper.BindEnv("smtp_require_tls", "MAIL_SMTP_REQUIRE_TLS")
+	_ = viper.BindEnv("smtp_tls_cert_file", "MAIL_SMTP_TLS_CERT_FILE")
+	_ = viper.BindEnv("smtp_tls_key_file", "MAIL_SMTP_TLS_KEY_FILE")
+	_ = viper.BindEnv("lmtp_enabled", "MAIL_LMTP_ENABLED")
+	_ = viper.BindEnv("lmtp_listen", "MAIL_LMTP_LISTEN")
+	_ = viper.BindEnv("milter_enabled", "MAIL_MILTER_ENABLED")
+	_ = viper.BindEnv("milter_listen", "MAIL_MILTER_LISTEN")
+	_ = viper.BindEnv("milter_max_connections", "MAIL_MILTER_MAX_CONNECTIONS")
+	_ = viper.BindEnv("milter_store_copy", "MAIL_MILTER_STORE_COPY")
+	_ = viper.BindEnv("outbound_enabled", "MAIL_OUTBOUND_ENABLED")
+	_ = viper.BindEnv("outbound_retry_schedule", "MAIL_OUTBOUND_RETRY_SCHEDULE")
+	_ = viper.BindEnv("outbound_max_age", "MAIL_OUTBOUND_MAX_AGE")
+	_ = viper.BindEnv("outbound_max_concurrency", "MAIL_OUTBOUND_MAX_CONCURRENCY")
+	_ = viper.BindEnv("outbound_domain_concurrency", "MAIL_OUTBOUND_DOMAIN_CONCURRENCY")
+	_ = viper.BindEnv("outbound_domain_rate", "MAIL_OUTBOUND_DOMAIN_RATE")
+	_ = viper.BindEnv("outbound_timeout", "MAIL_OUTBOUND_TIMEOUT")
+	_ = viper.BindEnv("suppression_bounce_expiry", "MAIL_SUPPRESSION_BOUNCE_EXPIRY")
+	_ = viper.BindEnv("policy_service_enabled", "MAIL_POLICY_SERVICE_ENABLED")
+	_ = viper.BindEnv("policy_service_listen", "MAIL_POLICY_SERVICE_LISTEN")
+	_ = viper.BindEnv("policy_service_max_connections", "MAIL_POLICY_SERVICE_MAX_CONNECTIONS")
+	_ = viper.BindEnv("policy_reject_spf_fail", "MAIL_POLICY_REJECT_SPF_FAIL")
 	_ = viper.BindEnv("metrics_enabled", "MAIL_METRICS_ENABLED")
 	_ = viper.BindEnv("metrics_port", "MAIL_METRICS_PORT")
 	_ = viper.BindEnv("metrics_path", "MAIL_METRICS_PATH")
@@ -3,8 +3,11 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/url"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -54,6 +57,12 @@ func (c *Config) ValidateSchema() error {
 	// API configuration validation
 	v.validateAPIEndpoint(c.APIEndpoint)
 
+	// Webhook delivery validation
+	v.validateWebhook(c.WebhookURL, c.WebhookTimeout, c.WebhookMaxRetries, c.WebhookRetryDelay, c.WebhookPollInterval)
+	v.validateWebhookRoutes(c.WebhookRoutes)
+	v.validateWebhookSigning(c.WebhookSecret, c.WebhookSecretPrevious, c.WebhookSignatureTolerance)
+	v.validateInboundSigning(c.InboundSecret, c.InboundSecretPrevious, c.WebhookSecret, c.WebhookSecretPrevious)
+
 	// Rate limiting validation
 	v.validateRateLimiting(c.RateLimitPerMinute, c.RateLimitBurst)
 
@@ -66,6 +75,55 @@ func (c *Config) ValidateSchema() error {
 	// Timeout validation
 	v.validateTimeouts(c.ReadTimeout, c.WriteTimeout, c.IdleTimeout, c.HandlerTimeout)
 
+	// Storage validation
+	v.validateStorageBackend(c.StorageBackend)
+	if c.StorageBackend == "s3" {
+		v.validateS3(c.S3Endpoint, c.S3Bucket, c.S3Prefix)
+	}
+
+	v.validateStorageEncoding(c.StorageBackend, c.StorageCompression, c.StorageEncryptionKeyFile, c.StorageEncryptionKeyID)
+
+	// Retention validation
+	v.validateRetention(c.RetentionMaxAge, c.RetentionMaxSizeMB, c.RetentionInterval, c.RetentionDomains)
+
+	// Deduplication validation
+	v.validateDedup(c.DedupWindow)
+
+	// Attachment extraction validation
+	v.validateAttachments(c.StorageBackend, c.AttachmentExtract, c.AttachmentExtractMinKB, c.AttachmentBaseURL)
+
+	// SMTP receiver validation
+	if c.SMTPEnabled {
+		v.validateSMTP(c.SMTPListen, c.SMTPMaxMessageSizeMB, c.SMTPMaxRecipients, c.SMTPMaxConnections, c.SMTPMaxConnectionsPerIP, c.SMTPTimeout)
+		v.validateSMTPTLS(c.SMTPRequireTLS, c.SMTPTLSCertFile, c.SMTPTLSKeyFile)
+	}
+
+	// LMTP server validation
+	if c.LMTPEnabled {
+		v.validateLMTP(c.LMTPListen, c.SMTPMaxMessageSizeMB, c.SMTPMaxRecipients, c.SMTPMaxConnections, c.SMTPTimeout)
+	}
+
+	// Milter validation
+	if c.MilterEnabled {
+		v.validateMilter(c.MilterListen, c.MilterMaxConnections)
+	}
+
+	// Outbound delivery validation
+	if c.OutboundEnabled {
+		v.validateOutbound(c.OutboundRetrySchedule, c.OutboundMaxAge, c.OutboundMaxConcurrency, c.OutboundDomainConcurrency, c.OutboundDomainRate, c.OutboundTimeout)
+	}
+
+	// Suppression list validation
+	if c.SuppressionBounceExpiry < 0 {
+		v.addError("suppression_bounce_expiry", "cannot be negative")
+	}
+
+	// Recipient policy validation
+	v.validateRecipientPolicy(c.RecipientDomains)
+	if c.PolicyServiceEnabled {
+		v.validatePolicyService(c.PolicyServiceListen, c.PolicyServiceMaxConnections)
+	}
+
 	// Connection pool validation
 	v.validateConnectionPool(c.MaxConnections, c.MaxIdleConns)
 
@@ -192,6 +250,330 @@ func (v *SchemaValidator) validateAPIEndpoint(endpoint string) {
 	}
 }
 
+func (v *SchemaValidator) validateWebhook(endpoint string, timeout, maxRetries, retryDelay, pollInterval int) {
+	if endpoint != "" {
+		v.validateWebhookURL("webhook_url", endpoint)
+	}
+	v.validateWebhookPolicy("webhook_", timeout, maxRetries, retryDelay)
+
+	if pollInterval < 0 {
+		v.addError("webhook_poll_interval", "cannot be negative")
+	}
+}
+
+func (v *SchemaValidator) validateWebhookRoutes(routes []WebhookRoute) {
+	// Delivery state records targets by name, and "default" is the fallback
+	names := map[string]bool{"default": true}
+	for i, route := range routes {
+		field := fmt.Sprintf("webhook_routes[%d]", i)
+
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprintf("route-%d", i+1)
+		}
+		if names[name] {
+			v.addError(field+".name", fmt.Sprintf("must be unique, '%s' is already used", name))
+		}
+		names[name] = true
+
+		if route.URL == "" {
+			v.addError(field+".url", "is required")
+		} else {
+			v.validateWebhookURL(field+".url", route.URL)
+		}
+
+		if len(route.Match) == 0 {
+			v.addError(field+".match", "at least one domain or recipient pattern is required")
+		}
+		for _, pattern := range route.Match {
+			if strings.TrimSpace(pattern) == "" {
+				v.addError(field+".match", "patterns cannot be empty")
+			} else if _, err := path.Match(pattern, ""); err != nil {
+				v.addError(field+".match", fmt.Sprintf("invalid pattern '%s'", pattern))
+			}
+		}
+
+		v.validateWebhookPolicy(field+".", route.Timeout, route.MaxRetries, route.RetryDelay)
+	}
+}
+
+func (v *SchemaValidator) validateStorageEncoding(backend, compression, keyFile, keyID string) {
+	switch compression {
+	case "", "none", "gzip", "zstd":
+	default:
+		v.addError("storage_compression", fmt.Sprintf("must be 'none', 'gzip' or 'zstd', got '%s'", compression))
+	}
+
+	if keyFile != "" && !strings.HasPrefix(keyFile, "/") {
+		v.addError("storage_encryption_key_file", "must be an absolute path")
+	}
+	if keyID != "" && keyFile == "" {
+		v.addError("storage_encryption_key_id", "requires storage_encryption_key_file")
+	}
+
+	if backend == "maildir" && ((compression != "" && compression != "none") || keyFile != "") {
+		v.addError("storage_backend", "maildir does not support storage_compression or storage_encryption_key_file")
+	}
+}
+
+func (v *SchemaValidator) validateRetention(maxAge, maxSizeMB, interval int, domains []RetentionDomain) {
+	if maxAge < 0 {
+		v.addError("retention_max_age", "cannot be negative")
+	}
+	if maxSizeMB < 0 {
+		v.addError("retention_max_size_mb", "cannot be negative")
+	}
+	if interval < 0 {
+		v.addError("retention_interval", "cannot be negative")
+	}
+
+	for i, domain := range domains {
+		field := fmt.Sprintf("retention_domains[%d]", i)
+
+		if strings.TrimSpace(domain.Domain) == "" {
+			v.addError(field+".domain", "is required")
+		} else if strings.Contains(domain.Domain, "@") {
+			v.addError(field+".domain", fmt.Sprintf("must be a domain, got '%s'", domain.Domain))
+		}
+		if domain.MaxAge < 0 {
+			v.addError(field+".max_age", "cannot be negative")
+		}
+	}
+}
+
+func (v *SchemaValidator) validateDedup(window int) {
+	if window < 0 {
+		v.addError("dedup_window", "cannot be negative")
+	}
+}
+
+func (v *SchemaValidator) validateAttachments(backend string, extract bool, minKB int, baseURL string) {
+	if minKB < 0 {
+		v.addError("attachment_extract_min_kb", "cannot be negative")
+	}
+	if extract && backend == "maildir" {
+		v.addError("attachment_extract", "not supported with the maildir backend, whose files must stay complete messages")
+	}
+	if baseURL != "" {
+		v.validateWebhookURL("attachment_base_url", baseURL)
+	}
+}
+
+func (v *SchemaValidator) validateSMTP(listen string, maxSizeMB, maxRecipients, maxConnections, maxConnectionsPerIP, timeout int) {
+	v.validateListen("smtp_listen", listen)
+
+	if maxSizeMB < 1 {
+		v.addError("smtp_max_message_size_mb", "must be at least 1")
+	}
+	if maxRecipients < 1 {
+		v.addError("smtp_max_recipients", "must be at least 1")
+	}
+	if maxConnections < 1 {
+		v.addError("smtp_max_connections", "must be at least 1")
+	}
+	if maxConnectionsPerIP < 1 {
+		v.addError("smtp_max_connections_per_ip", "must be at least 1")
+	}
+	if timeout < 1 {
+		v.addError("smtp_timeout", "must be at least 1")
+	} else if timeout > 3600 {
+		v.addError("smtp_timeout", "unreasonably high timeout (>3600s)")
+	}
+}
+
+func (v *SchemaValidator) validateSMTPTLS(requireTLS bool, certFile, keyFile string) {
+	if certFile != "" && keyFile == "" {
+		v.addError("smtp_tls_key_file", "required when smtp_tls_cert_file is set")
+	}
+	if requireTLS && certFile == "" {
+		v.addError("smtp_require_tls", "requires smtp_tls_cert_file to be set")
+	}
+}
+
+func (v *SchemaValidator) validateRecipientPolicy(domains []RecipientDomain) {
+	for i, domain := range domains {
+		field := fmt.Sprintf("recipient_domains[%d]", i)
+
+		if strings.TrimSpace(domain.Domain) == "" {
+			v.addError(field+".domain", "is required")
+		} else if strings.Contains(domain.Domain, "@") {
+			v.addError(field+".domain", fmt.Sprintf("must be a domain, got '%s'", domain.Domain))
+		}
+		for _, recipient := range domain.Recipients {
+			if strings.TrimSpace(recipient) == "" || strings.Contains(recipient, "@") {
+				v.addError(field+".recipients", fmt.Sprintf("must be local parts, got '%s'", recipient))
+			}
+		}
+		for _, pattern := range domain.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				v.addError(field+".patterns", fmt.Sprintf("invalid pattern '%s': %v", pattern, err))
+			}
+		}
+		if !domain.CatchAll && len(domain.Recipients) == 0 && len(domain.Patterns) == 0 {
+			v.addError(field, "needs recipients, patterns or catch_all")
+		}
+	}
+}
+
+func (v *SchemaValidator) validatePolicyService(listen string, maxConnections int) {
+	v.validateServiceListen("policy_service_listen", listen)
+	if maxConnections < 1 {
+		v.addError("policy_service_max_connections", "must be at least 1")
+	}
+}
+
+func (v *SchemaValidator) validateLMTP(listen string, maxSizeMB, maxRecipients, maxConnections, timeout int) {
+	v.validateServiceListen("lmtp_listen", listen)
+	// XFORWARD is only honoured from local clients, so a TCP listener
+	// reachable from elsewhere would take mail it cannot authenticate
+	if host, _, err := net.SplitHostPort(listen); err == nil && !strings.HasPrefix(listen, "unix:") && host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			v.addError("lmtp_listen", fmt.Sprintf("TCP listener must be on a loopback address, got '%s'", host))
+		}
+	}
+	if maxSizeMB < 1 {
+		v.addError("smtp_max_message_size_mb", "must be at least 1 for LMTP")
+	}
+	if maxRecipients < 1 {
+		v.addError("smtp_max_recipients", "must be at least 1 for LMTP")
+	}
+	if maxConnections < 1 {
+		v.addError("smtp_max_connections", "must be at least 1 for LMTP")
+	}
+	if timeout < 1 {
+		v.addError("smtp_timeout", "must be at least 1 for LMTP")
+	}
+}
+
+func (v *SchemaValidator) validateMilter(listen string, maxConnections int) {
+	v.validateServiceListen("milter_listen", listen)
+	if maxConnections < 1 {
+		v.addError("milter_max_connections", "must be at least 1")
+	}
+}
+
+func (v *SchemaValidator) validateOutbound(retrySchedule []int, maxAge, maxConcurrency, domainConcurrency, domainRate, timeout int) {
+	if len(retrySchedule) == 0 {
+		v.addError("outbound_retry_schedule", "must list at least one retry delay")
+	}
+	for _, delay := range retrySchedule {
+		if delay < 1 {
+			v.addError("outbound_retry_schedule", fmt.Sprintf("retry delays must be at least 1 minute, got %d", delay))
+			break
+		}
+	}
+	if maxAge < 1 {
+		v.addError("outbound_max_age", "must be at least 1 hour")
+	}
+	if maxConcurrency < 1 {
+		v.addError("outbound_max_concurrency", "must be at least 1")
+	}
+	if domainConcurrency < 1 {
+		v.addError("outbound_domain_concurrency", "must be at least 1")
+	} else if domainConcurrency > maxConcurrency && maxConcurrency >= 1 {
+		v.addError("outbound_domain_concurrency", "cannot exceed outbound_max_concurrency")
+	}
+	if domainRate < 0 {
+		v.addError("outbound_domain_rate", "cannot be negative")
+	}
+	if timeout < 1 {
+		v.addError("outbound_timeout", "must be at least 1 second")
+	}
+}
+
+// validateServiceListen accepts a host:port or a "unix:" socket path for
+// services that only Postfix talks to
+func (v *SchemaValidator) validateServiceListen(field, listen string) {
+	if socket, ok := strings.CutPrefix(listen, "unix:"); ok {
+		if !strings.HasPrefix(socket, "/") {
+			v.addError(field, "unix socket path must be absolute")
+		}
+		return
+	}
+	v.validateListen(field, listen)
+}
+
+func (v *SchemaValidator) validateListen(field, listen string) {
+	if _, port, err := net.SplitHostPort(listen); err != nil {
+		v.addError(field, fmt.Sprintf("must be host:port, got '%s'", listen))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		v.addError(field, fmt.Sprintf("port must be between 1 and 65535, got '%s'", port))
+	}
+}
+
+func (v *SchemaValidator) validateWebhookURL(field, endpoint string) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		v.addError(field, fmt.Sprintf("invalid URL: %v", err))
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		v.addError(field, fmt.Sprintf("scheme must be http or https, got '%s'", u.Scheme))
+	}
+	if u.Host == "" {
+		v.addError(field, "missing host in URL")
+	}
+}
+
+// validateWebhookPolicy checks timeout and retry settings; prefix is the
+// field name prefix, e.g. "webhook_" or "webhook_routes[0]."
+func (v *SchemaValidator) validateWebhookPolicy(prefix string, timeout, maxRetries, retryDelay int) {
+	if timeout < 0 {
+		v.addError(prefix+"timeout", "cannot be negative")
+	} else if timeout > 300 {
+		v.addError(prefix+"timeout", "unreasonably high timeout (>300s)")
+	}
+
+	if maxRetries < 0 {
+		v.addError(prefix+"max_retries", "cannot be negative")
+	} else if maxRetries > 100 {
+		v.addError(prefix+"max_retries", "unreasonably high (>100)")
+	}
+
+	if retryDelay < 0 {
+		v.addError(prefix+"retry_delay", "cannot be negative")
+	}
+}
+
+func (v *SchemaValidator) validateWebhookSigning(secret, previous string, tolerance int) {
+	if secret != "" && len(secret) < 16 {
+		v.addError("webhook_secret", "must be at least 16 characters for security")
+	}
+	if previous != "" {
+		if secret == "" {
+			v.addError("webhook_secret_previous", "requires webhook_secret to be set")
+		} else if previous == secret {
+			v.addError("webhook_secret_previous", "must differ from webhook_secret")
+		}
+	}
+	if tolerance < 0 {
+		v.addError("webhook_signature_tolerance", "cannot be negative")
+	} else if tolerance > 3600 {
+		v.addError("webhook_signature_tolerance", "unreasonably high tolerance (>3600s)")
+	}
+}
+
+// validateInboundSigning checks the inbound secrets. Every webhook receiver
+// holds the webhook secrets, so they must not also authenticate requests to GoMail.
+func (v *SchemaValidator) validateInboundSigning(secret, previous, webhookSecret, webhookPrevious string) {
+	if secret != "" && len(secret) < 16 {
+		v.addError("inbound_secret", "must be at least 16 characters for security")
+	}
+	if previous != "" {
+		if secret == "" {
+			v.addError("inbound_secret_previous", "requires inbound_secret to be set")
+		} else if previous == secret {
+			v.addError("inbound_secret_previous", "must differ from inbound_secret")
+		}
+	}
+	if secret != "" && (secret == webhookSecret || secret == webhookPrevious) {
+		v.addError("inbound_secret", "must differ from the webhook secrets")
+	}
+	if previous != "" && (previous == webhookSecret || previous == webhookPrevious) {
+		v.addError("inbound_secret_previous", "must differ from the webhook secrets")
+	}
+}
+
 func (v *SchemaValidator) validateRateLimiting(perMinute, burst int) {
 	if perMinute < 0 {
 		v.addError("rate_limit_per_minute", "cannot be negative")
@@ -269,6 +651,32 @@ func (v *SchemaValidator) validateTimeouts(readTimeout, writeTimeout, idleTimeou
 	}
 }
 
+func (v *SchemaValidator) validateStorageBackend(backend string) {
+	switch backend {
+	case "", "file", "sqlite", "s3", "maildir":
+	default:
+		v.addError("storage_backend", fmt.Sprintf("must be 'file', 'sqlite', 's3' or 'maildir', got '%s'", backend))
+	}
+}
+
+func (v *SchemaValidator) validateS3(endpoint, bucket, prefix string) {
+	if endpoint == "" {
+		v.addError("s3_endpoint", "required when storage_backend is 's3'")
+	} else {
+		v.validateWebhookURL("s3_endpoint", endpoint)
+	}
+
+	if bucket == "" {
+		v.addError("s3_bucket", "required when storage_backend is 's3'")
+	} else if strings.ContainsAny(bucket, "/ ") {
+		v.addError("s3_bucket", fmt.Sprintf("invalid bucket name '%s'", bucket))
+	}
+
+	if strings.HasPrefix(prefix, "/") {
+		v.addError("s3_prefix", "must not start with '/'")
+	}
+}
+
 func (v *SchemaValidator) validateConnectionPool(maxConnections, maxIdleConns int) {
 	if maxConnections < 0 {
 		v.addError("max_connections", "cannot be negative")
@@ -350,6 +758,85 @@ func GetConfigSchema() string {
 				"format":      "uri",
 				"description": "Webhook endpoint for email delivery",
 			},
+			"webhook_url": map[string]interface{}{
+				"type":        "string",
+				"format":      "uri",
+				"description": "Downstream URL that stored emails are delivered to",
+			},
+			"webhook_bearer_token": map[string]interface{}{
+				"type":        "string",
+				"description": "Bearer token sent with webhook deliveries",
+			},
+			"webhook_timeout": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"maximum":     300,
+				"default":     30,
+				"description": "Webhook request timeout in seconds",
+			},
+			"webhook_max_retries": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"maximum":     100,
+				"default":     5,
+				"description": "Delivery attempts before a message is dead-lettered",
+			},
+			"webhook_retry_delay": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"default":     5,
+				"description": "Initial retry delay in seconds, doubled after each failure",
+			},
+			"webhook_poll_interval": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"default":     10,
+				"description": "How often the inbox is scanned for new messages, in seconds",
+			},
+			"webhook_routes": map[string]interface{}{
+				"type":        "array",
+				"description": "Per-domain and per-recipient webhook targets; unmatched mail goes to webhook_url",
+				"items": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"match", "url"},
+					"properties": map[string]interface{}{
+						"name":         map[string]interface{}{"type": "string"},
+						"match":        map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+						"url":          map[string]interface{}{"type": "string", "format": "uri"},
+						"bearer_token": map[string]interface{}{"type": "string"},
+						"timeout":      map[string]interface{}{"type": "integer", "minimum": 0, "maximum": 300},
+						"max_retries":  map[string]interface{}{"type": "integer", "minimum": 0, "maximum": 100},
+						"retry_delay":  map[string]interface{}{"type": "integer", "minimum": 0},
+					},
+				},
+			},
+			"webhook_secret": map[string]interface{}{
+				"type":        "string",
+				"minLength":   16,
+				"description": "Shared secret used to HMAC-sign webhook requests",
+			},
+			"webhook_secret_previous": map[string]interface{}{
+				"type":        "string",
+				"minLength":   16,
+				"description": "Previous signing secret, still accepted and signed with during rotation",
+			},
+			"inbound_secret": map[string]interface{}{
+				"type":        "string",
+				"minLength":   16,
+				"description": "Shared secret accepted on signed POST /mail/inbound requests",
+			},
+			"inbound_secret_previous": map[string]interface{}{
+				"type":        "string",
+				"minLength":   16,
+				"description": "Previous inbound secret, still accepted during rotation",
+			},
+			"webhook_signature_tolerance": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"maximum":     3600,
+				"default":     300,
+				"description": "Maximum age in seconds of a signed request",
+			},
 			"rate_limit_per_minute": map[string]interface{}{
 				"type":        "integer",
 				"minimum":     0,
@@ -428,6 +915,281 @@ func GetConfigSchema() string {
 				"default":     25,
 				"description": "Request handler timeout in seconds",
 			},
+			"storage_backend": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"file", "sqlite", "s3", "maildir"},
+				"default":     "file",
+				"description": "Storage backend for received emails",
+			},
+			"sqlite_path": map[string]interface{}{
+				"type":        "string",
+				"description": "SQLite database file for the sqlite backend and the s3 metadata index (default <data_dir>/gomail.db)",
+			},
+			"s3_endpoint": map[string]interface{}{
+				"type":        "string",
+				"format":      "uri",
+				"default":     "https://s3.amazonaws.com",
+				"description": "S3-compatible endpoint URL for the s3 backend",
+			},
+			"s3_region": map[string]interface{}{
+				"type":    "string",
+				"default": "us-east-1",
+			},
+			"s3_bucket": map[string]interface{}{
+				"type":        "string",
+				"description": "Bucket holding stored messages",
+			},
+			"s3_prefix": map[string]interface{}{
+				"type":        "string",
+				"description": "Key prefix for stored messages",
+			},
+			"s3_access_key": map[string]interface{}{
+				"type": "string",
+			},
+			"s3_secret_key": map[string]interface{}{
+				"type":   "string",
+				"format": "password",
+			},
+			"s3_path_style": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Use path-style bucket addressing (endpoint/bucket/key)",
+			},
+			"storage_compression": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"none", "gzip", "zstd"},
+				"default":     "none",
+				"description": "Compression for stored messages (file, sqlite and s3 backends)",
+			},
+			"storage_encryption_key_file": map[string]interface{}{
+				"type":        "string",
+				"description": "Key file of <id>:<base64 key> lines; enables AES-256-GCM encryption of stored messages",
+			},
+			"storage_encryption_key_id": map[string]interface{}{
+				"type":        "string",
+				"description": "Key used to encrypt new messages (default: the last key in the file)",
+			},
+			"retention_max_age": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"default":     0,
+				"description": "Delete inbox and processed messages older than this many days (0 keeps them forever)",
+			},
+			"retention_max_size_mb": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"default":     0,
+				"description": "Delete the oldest messages once stored messages exceed this many megabytes (0 for no limit)",
+			},
+			"retention_interval": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"default":     60,
+				"description": "Minutes between retention janitor runs",
+			},
+			"retention_domains": map[string]interface{}{
+				"type":        "array",
+				"description": "Per-domain overrides of retention_max_age",
+				"items": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"domain"},
+					"properties": map[string]interface{}{
+						"domain":  map[string]interface{}{"type": "string"},
+						"max_age": map[string]interface{}{"type": "integer", "minimum": 0},
+					},
+				},
+			},
+			"dedup_window": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"default":     1440,
+				"description": "Minutes within which a message with the same Message-ID and recipient is stored only once (0 disables)",
+			},
+			"attachment_extract": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Move attachments out of stored messages into a blob store under data_dir/blobs",
+			},
+			"attachment_extract_min_kb": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"default":     64,
+				"description": "Only extract attachments of at least this many kilobytes",
+			},
+			"attachment_base_url": map[string]interface{}{
+				"type":        "string",
+				"format":      "uri",
+				"description": "Public base URL of the API; webhook payloads then carry attachment download URLs",
+			},
+			"smtp_enabled": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Accept mail with the built-in SMTP receiver instead of the Postfix pipe transport",
+			},
+			"smtp_listen": map[string]interface{}{
+				"type":        "string",
+				"default":     ":25",
+				"description": "Address the SMTP receiver listens on",
+			},
+			"smtp_max_message_size_mb": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     25,
+				"description": "Largest message accepted over SMTP, advertised with SIZE",
+			},
+			"smtp_max_recipients": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     100,
+				"description": "Recipients accepted per SMTP transaction",
+			},
+			"smtp_max_connections": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     100,
+				"description": "Concurrent SMTP sessions",
+			},
+			"smtp_max_connections_per_ip": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     10,
+				"description": "Concurrent SMTP sessions per client IP",
+			},
+			"smtp_timeout": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"maximum":     3600,
+				"default":     300,
+				"description": "SMTP command and data timeout in seconds",
+			},
+			"smtp_require_tls": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Refuse MAIL until the client has issued STARTTLS",
+			},
+			"smtp_tls_cert_file": map[string]interface{}{
+				"type":        "string",
+				"default":     "/etc/mailserver/certs/cert.pem",
+				"description": "Certificate offered with STARTTLS; empty disables STARTTLS",
+			},
+			"smtp_tls_key_file": map[string]interface{}{
+				"type":        "string",
+				"default":     "/etc/mailserver/certs/key.pem",
+				"description": "Private key for smtp_tls_cert_file",
+			},
+			"lmtp_enabled": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Accept mail from Postfix over LMTP instead of the pipe script",
+			},
+			"lmtp_listen": map[string]interface{}{
+				"type":        "string",
+				"default":     "unix:/var/spool/postfix/private/gomail-lmtp",
+				"description": "LMTP listen address, host:port or unix:/path",
+			},
+			"milter_enabled": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Authenticate mail for an MTA that delivers it itself, over the milter protocol",
+			},
+			"milter_listen": map[string]interface{}{
+				"type":        "string",
+				"default":     "unix:/var/spool/postfix/private/gomail-milter",
+				"description": "Milter listen address, host:port or unix:/path",
+			},
+			"milter_max_connections": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     100,
+				"description": "Concurrent milter connections",
+			},
+			"milter_store_copy": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Store a copy of each message passed by the milter and forward it to webhooks",
+			},
+			"outbound_enabled": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Accept messages to send on POST /mail/send and queue them for delivery",
+			},
+			"outbound_retry_schedule": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "integer", "minimum": 1},
+				"default":     []int{5, 10, 30, 60, 120, 240},
+				"description": "Minutes to wait before each retry of a temporary failure; the last delay repeats",
+			},
+			"outbound_max_age": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     120,
+				"description": "Hours a message is retried before its remaining recipients fail",
+			},
+			"outbound_max_concurrency": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     20,
+				"description": "Concurrent outbound SMTP deliveries",
+			},
+			"outbound_domain_concurrency": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     2,
+				"description": "Concurrent deliveries to one destination domain",
+			},
+			"outbound_domain_rate": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"default":     60,
+				"description": "Deliveries per minute to one destination domain (0 = unlimited)",
+			},
+			"outbound_timeout": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     300,
+				"description": "Seconds to wait for each reply from a remote mail server",
+			},
+			"suppression_bounce_expiry": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"default":     0,
+				"description": "Hours a hard-bounced address stays suppressed (0 = until removed)",
+			},
+			"recipient_domains": map[string]interface{}{
+				"type":        "array",
+				"description": "Domains and recipients accepted at RCPT time; empty accepts every recipient",
+				"items": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"domain"},
+					"properties": map[string]interface{}{
+						"domain":     map[string]interface{}{"type": "string"},
+						"recipients": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+						"patterns":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+						"catch_all":  map[string]interface{}{"type": "boolean"},
+					},
+				},
+			},
+			"policy_service_enabled": map[string]interface{}{
+				"type":        "boolean",
+				"default":     false,
+				"description": "Answer Postfix check_policy_service requests",
+			},
+			"policy_service_listen": map[string]interface{}{
+				"type":        "string",
+				"default":     "127.0.0.1:10040",
+				"description": "Address of the Postfix policy service, host:port or unix:/path",
+			},
+			"policy_service_max_connections": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"default":     100,
+				"description": "Concurrent Postfix connections to the policy service",
+			},
+			"policy_reject_spf_fail": map[string]interface{}{
+				"type":        "boolean",
+				"default":     true,
+				"description": "Reject senders failing SPF at RCPT time in the policy service",
+			},
 			"max_connections": map[string]interface{}{
 				"type":        "integer",
 				"minimum":     0,
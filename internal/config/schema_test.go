@@ -61,6 +61,241 @@ func TestSchemaValidator_Mode(t *testing.T) {
 	}
 }
 
+func TestSchemaValidator_StorageBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		wantErr bool
+	}{
+		{"default backend", "", false},
+		{"file backend", "file", false},
+		{"sqlite backend", "sqlite", false},
+		{"maildir backend", "maildir", false},
+		{"unknown backend", "tape", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:           3000,
+				Mode:           "simple",
+				DataDir:        "/opt/test",
+				StorageBackend: tt.backend,
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_S3(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		bucket   string
+		prefix   string
+		wantErr  bool
+	}{
+		{"valid bucket", "https://s3.amazonaws.com", "mail", "", false},
+		{"minio with prefix", "http://localhost:9000", "mail", "gomail/raw", false},
+		{"missing endpoint", "", "mail", "", true},
+		{"invalid endpoint", "ftp://minio.local", "mail", "", true},
+		{"missing bucket", "https://s3.amazonaws.com", "", "", true},
+		{"bucket with slash", "https://s3.amazonaws.com", "mail/raw", "", true},
+		{"absolute prefix", "https://s3.amazonaws.com", "mail", "/raw", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:           3000,
+				Mode:           "simple",
+				DataDir:        "/opt/test",
+				StorageBackend: "s3",
+				S3Endpoint:     tt.endpoint,
+				S3Bucket:       tt.bucket,
+				S3Prefix:       tt.prefix,
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_Retention(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"disabled", Config{}, false},
+		{"limits", Config{RetentionMaxAge: 30, RetentionMaxSizeMB: 5000, RetentionInterval: 15}, false},
+		{"domain override", Config{RetentionDomains: []RetentionDomain{{Domain: "*.example.com", MaxAge: 7}}}, false},
+		{"negative age", Config{RetentionMaxAge: -1}, true},
+		{"negative size", Config{RetentionMaxSizeMB: -1}, true},
+		{"negative interval", Config{RetentionInterval: -1}, true},
+		{"missing domain", Config{RetentionDomains: []RetentionDomain{{MaxAge: 7}}}, true},
+		{"address instead of domain", Config{RetentionDomains: []RetentionDomain{{Domain: "a@example.com", MaxAge: 7}}}, true},
+		{"negative domain age", Config{RetentionDomains: []RetentionDomain{{Domain: "example.com", MaxAge: -1}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Port = 3000
+			cfg.Mode = "simple"
+			cfg.DataDir = "/opt/test"
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_StorageEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"disabled", Config{}, false},
+		{"zstd", Config{StorageCompression: "zstd"}, false},
+		{"encrypted sqlite", Config{StorageBackend: "sqlite", StorageCompression: "gzip", StorageEncryptionKeyFile: "/etc/mailserver/storage.keys"}, false},
+		{"pinned key", Config{StorageEncryptionKeyFile: "/etc/mailserver/storage.keys", StorageEncryptionKeyID: "2026-10"}, false},
+		{"unknown compression", Config{StorageCompression: "brotli"}, true},
+		{"relative key file", Config{StorageEncryptionKeyFile: "storage.keys"}, true},
+		{"key ID without key file", Config{StorageEncryptionKeyID: "2026-10"}, true},
+		{"maildir", Config{StorageBackend: "maildir", StorageCompression: "zstd"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Port = 3000
+			cfg.Mode = "simple"
+			cfg.DataDir = "/opt/test"
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_Dedup(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  int
+		wantErr bool
+	}{
+		{"disabled", 0, false},
+		{"one day", 1440, false},
+		{"negative", -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:        3000,
+				Mode:        "simple",
+				DataDir:     "/opt/test",
+				DedupWindow: tt.window,
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_Attachments(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		extract bool
+		minKB   int
+		baseURL string
+		wantErr bool
+	}{
+		{"disabled", "", false, 0, "", false},
+		{"file backend", "file", true, 64, "https://mail.example.com", false},
+		{"sqlite backend", "sqlite", true, 0, "", false},
+		{"maildir backend", "maildir", true, 64, "", true},
+		{"negative threshold", "file", true, -1, "", true},
+		{"bad base URL", "file", false, 0, "ftp://mail.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:                   3000,
+				Mode:                   "simple",
+				DataDir:                "/opt/test",
+				StorageBackend:         tt.backend,
+				AttachmentExtract:      tt.extract,
+				AttachmentExtractMinKB: tt.minKB,
+				AttachmentBaseURL:      tt.baseURL,
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_SMTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		listen     string
+		maxSizeMB  int
+		timeout    int
+		requireTLS bool
+		certFile   string
+		wantErr    bool
+	}{
+		{"disabled ignores settings", false, "", 0, 0, false, "", false},
+		{"valid", true, ":25", 25, 300, false, "/etc/mailserver/certs/cert.pem", false},
+		{"host and port", true, "127.0.0.1:2525", 25, 300, true, "/etc/mailserver/certs/cert.pem", false},
+		{"missing port", true, "mail.example.com", 25, 300, false, "", true},
+		{"bad port", true, ":99999", 25, 300, false, "", true},
+		{"zero size", true, ":25", 0, 300, false, "", true},
+		{"zero timeout", true, ":25", 25, 0, false, "", true},
+		{"TLS required without certificate", true, ":25", 25, 300, true, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:                    3000,
+				Mode:                    "simple",
+				DataDir:                 "/opt/test",
+				SMTPEnabled:             tt.enabled,
+				SMTPListen:              tt.listen,
+				SMTPMaxMessageSizeMB:    tt.maxSizeMB,
+				SMTPMaxRecipients:       100,
+				SMTPMaxConnections:      100,
+				SMTPMaxConnectionsPerIP: 10,
+				SMTPTimeout:             tt.timeout,
+				SMTPRequireTLS:          tt.requireTLS,
+				SMTPTLSCertFile:         tt.certFile,
+				SMTPTLSKeyFile:          "/etc/mailserver/certs/key.pem",
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestSchemaValidator_DataDir(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -179,6 +414,65 @@ func TestSchemaValidator_APIEndpoint(t *testing.T) {
 	}
 }
 
+func TestSchemaValidator_WebhookRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   WebhookRoute
+		wantErr bool
+	}{
+		{"domain route", WebhookRoute{Match: []string{"example.com"}, URL: "https://hooks.example.com/mail"}, false},
+		{"recipient patterns", WebhookRoute{Match: []string{"support@example.com", "*+billing@*"}, URL: "http://localhost:8080"}, false},
+		{"route policy", WebhookRoute{Match: []string{"*.example.com"}, URL: "https://a.example", Timeout: 10, MaxRetries: 3, RetryDelay: 2}, false},
+		{"missing url", WebhookRoute{Match: []string{"example.com"}}, true},
+		{"invalid scheme", WebhookRoute{Match: []string{"example.com"}, URL: "ftp://example.com"}, true},
+		{"missing match", WebhookRoute{URL: "https://a.example"}, true},
+		{"empty pattern", WebhookRoute{Match: []string{" "}, URL: "https://a.example"}, true},
+		{"bad pattern", WebhookRoute{Match: []string{"[a-@example.com"}, URL: "https://a.example"}, true},
+		{"timeout too high", WebhookRoute{Match: []string{"example.com"}, URL: "https://a.example", Timeout: 301}, true},
+		{"negative retries", WebhookRoute{Match: []string{"example.com"}, URL: "https://a.example", MaxRetries: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:          3000,
+				Mode:          "simple",
+				DataDir:       "/opt/test",
+				WebhookRoutes: []WebhookRoute{tt.route},
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Route names identify targets in saved delivery state
+	names := []struct {
+		name    string
+		routes  []string
+		wantErr bool
+	}{
+		{"distinct names", []string{"billing", "support"}, false},
+		{"unnamed routes", []string{"", ""}, false},
+		{"duplicate name", []string{"billing", "billing"}, true},
+		{"default name", []string{"default"}, true},
+		{"clashes with generated name", []string{"", "route-1"}, true},
+	}
+	for _, tt := range names {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Port: 3000, Mode: "simple", DataDir: "/opt/test"}
+			for _, name := range tt.routes {
+				cfg.WebhookRoutes = append(cfg.WebhookRoutes, WebhookRoute{Name: name, Match: []string{"example.com"}, URL: "https://a.example"})
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestSchemaValidator_RateLimiting(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -291,3 +585,215 @@ func TestConfig_ToJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestSchemaValidator_RecipientPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"disabled", Config{}, false},
+		{"allow list", Config{RecipientDomains: []RecipientDomain{{Domain: "example.com", Recipients: []string{"support", "billing"}}}}, false},
+		{"patterns", Config{RecipientDomains: []RecipientDomain{{Domain: "*.example.com", Patterns: []string{`^ticket-\d+$`}}}}, false},
+		{"catch-all", Config{RecipientDomains: []RecipientDomain{{Domain: "example.org", CatchAll: true}}}, false},
+		{"missing domain", Config{RecipientDomains: []RecipientDomain{{CatchAll: true}}}, true},
+		{"address instead of domain", Config{RecipientDomains: []RecipientDomain{{Domain: "a@example.com", CatchAll: true}}}, true},
+		{"address instead of local part", Config{RecipientDomains: []RecipientDomain{{Domain: "example.com", Recipients: []string{"a@example.com"}}}}, true},
+		{"invalid pattern", Config{RecipientDomains: []RecipientDomain{{Domain: "example.com", Patterns: []string{"ticket-("}}}}, true},
+		{"nothing accepted", Config{RecipientDomains: []RecipientDomain{{Domain: "example.com"}}}, true},
+		{"policy service", Config{PolicyServiceEnabled: true, PolicyServiceListen: "127.0.0.1:10040", PolicyServiceMaxConnections: 100}, false},
+		{"policy service on a socket", Config{PolicyServiceEnabled: true, PolicyServiceListen: "unix:/var/spool/postfix/private/gomail-policy", PolicyServiceMaxConnections: 100}, false},
+		{"policy service without port", Config{PolicyServiceEnabled: true, PolicyServiceListen: "127.0.0.1", PolicyServiceMaxConnections: 100}, true},
+		{"relative socket path", Config{PolicyServiceEnabled: true, PolicyServiceListen: "unix:private/gomail-policy", PolicyServiceMaxConnections: 100}, true},
+		{"no policy connections", Config{PolicyServiceEnabled: true, PolicyServiceListen: "127.0.0.1:10040"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Port = 3000
+			cfg.Mode = "simple"
+			cfg.DataDir = "/opt/test"
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_LMTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		listen  string
+		timeout int
+		wantErr bool
+	}{
+		{"socket", "unix:/var/spool/postfix/private/gomail-lmtp", 300, false},
+		{"loopback", "127.0.0.1:2424", 300, false},
+		{"IPv6 loopback", "[::1]:2424", 300, false},
+		{"localhost", "localhost:2424", 300, false},
+		{"all interfaces", ":2424", 300, true},
+		{"public address", "192.0.2.10:2424", 300, true},
+		{"relative socket", "unix:private/gomail-lmtp", 300, true},
+		{"missing port", "127.0.0.1", 300, true},
+		{"zero timeout", "127.0.0.1:2424", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:                 3000,
+				Mode:                 "simple",
+				DataDir:              "/opt/test",
+				LMTPEnabled:          true,
+				LMTPListen:           tt.listen,
+				SMTPMaxMessageSizeMB: 25,
+				SMTPMaxRecipients:    100,
+				SMTPMaxConnections:   100,
+				SMTPTimeout:          tt.timeout,
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_Milter(t *testing.T) {
+	tests := []struct {
+		name           string
+		listen         string
+		maxConnections int
+		wantErr        bool
+	}{
+		{"socket", "unix:/var/spool/postfix/private/gomail-milter", 100, false},
+		{"loopback", "127.0.0.1:8891", 100, false},
+		{"relative socket", "unix:private/gomail-milter", 100, true},
+		{"missing port", "localhost", 100, true},
+		{"no connections", "127.0.0.1:8891", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:                 3000,
+				Mode:                 "simple",
+				DataDir:              "/opt/test",
+				MilterEnabled:        true,
+				MilterListen:         tt.listen,
+				MilterMaxConnections: tt.maxConnections,
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_Outbound(t *testing.T) {
+	tests := []struct {
+		name              string
+		retrySchedule     []int
+		maxAge            int
+		maxConcurrency    int
+		domainConcurrency int
+		domainRate        int
+		timeout           int
+		wantErr           bool
+	}{
+		{"defaults", []int{5, 10, 30, 60, 120, 240}, 120, 20, 2, 60, 300, false},
+		{"unlimited rate", []int{15}, 48, 10, 10, 0, 60, false},
+		{"empty schedule", nil, 120, 20, 2, 60, 300, true},
+		{"zero delay", []int{5, 0}, 120, 20, 2, 60, 300, true},
+		{"no max age", []int{5}, 0, 20, 2, 60, 300, true},
+		{"no concurrency", []int{5}, 120, 0, 2, 60, 300, true},
+		{"domain above total", []int{5}, 120, 4, 8, 60, 300, true},
+		{"negative rate", []int{5}, 120, 20, 2, -1, 300, true},
+		{"no timeout", []int{5}, 120, 20, 2, 60, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:                      3000,
+				Mode:                      "simple",
+				DataDir:                   "/opt/test",
+				OutboundEnabled:           true,
+				OutboundRetrySchedule:     tt.retrySchedule,
+				OutboundMaxAge:            tt.maxAge,
+				OutboundMaxConcurrency:    tt.maxConcurrency,
+				OutboundDomainConcurrency: tt.domainConcurrency,
+				OutboundDomainRate:        tt.domainRate,
+				OutboundTimeout:           tt.timeout,
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_SuppressionBounceExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		expiry  int
+		wantErr bool
+	}{
+		{"never", 0, false},
+		{"thirty days", 720, false},
+		{"negative", -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:                    3000,
+				Mode:                    "simple",
+				DataDir:                 "/opt/test",
+				SuppressionBounceExpiry: tt.expiry,
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_InboundSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		previous string
+		wantErr  bool
+	}{
+		{"unset", "", "", false},
+		{"set", "inbound-shared-secret", "", false},
+		{"rotating", "inbound-shared-secret", "old-inbound-secret", false},
+		{"too short", "short", "", true},
+		{"previous without current", "", "old-inbound-secret", true},
+		{"reuses webhook secret", "webhook-shared-secret", "", true},
+		{"previous reuses webhook secret", "inbound-shared-secret", "webhook-shared-secret", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:                  3000,
+				Mode:                  "simple",
+				DataDir:               "/opt/test",
+				WebhookSecret:         "webhook-shared-secret",
+				InboundSecret:         tt.secret,
+				InboundSecretPrevious: tt.previous,
+			}
+			err := cfg.ValidateSchema()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
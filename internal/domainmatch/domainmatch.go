@@ -0,0 +1,15 @@
+// Package domainmatch matches domains against the patterns used in webhook
+// routes, retention overrides, and recipient policy, so all three agree on
+// what a pattern covers.
+package domainmatch
+
+import "strings"
+
+// Match reports whether domain matches pattern. A "*." prefix matches the
+// domain itself and any subdomain. Both are expected to be lowercased.
+func Match(pattern, domain string) bool {
+	if base, ok := strings.CutPrefix(pattern, "*."); ok {
+		return domain == base || strings.HasSuffix(domain, "."+base)
+	}
+	return domain == pattern
+}
@@ -0,0 +1,28 @@
+package domainmatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		domain  string
+		want    bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "mail.example.com", false},
+		{"*.example.com", "example.com", true},
+		{"*.example.com", "mail.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "badexample.com", false},
+		{"*.example.com", "example.com.evil", false},
+		{"example.com", "", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Match(tt.pattern, tt.domain), "%s vs %s", tt.pattern, tt.domain)
+	}
+}
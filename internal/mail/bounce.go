@@ -0,0 +1,348 @@
+package mail
+
+import (
+	"bufio"
+	"io"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// Bounce classifications
+const (
+	// BounceHard means the address will not accept mail, e.g. it does not exist
+	BounceHard = "hard"
+	// BounceSoft means delivery may succeed later, e.g. the mailbox is full
+	BounceSoft = "soft"
+)
+
+// Bounce formats
+const (
+	// BounceFormatDSN is an RFC 3464 multipart/report delivery status notification
+	BounceFormatDSN = "dsn"
+	// BounceFormatText is a plain-text notice, as sent by qmail, older Exim
+	// and many appliances, read with heuristics
+	BounceFormatText = "text"
+)
+
+// Bounce describes a delivery failure notice for mail sent from here
+type Bounce struct {
+	// Type is BounceHard if any recipient bounced hard, otherwise BounceSoft
+	Type         string `json:"type"`
+	Format       string `json:"format"`
+	ReportingMTA string `json:"reporting_mta,omitempty"`
+	// OriginalMessageID is the Message-ID of the message that bounced, when
+	// the notice returned its headers
+	OriginalMessageID string             `json:"original_message_id,omitempty"`
+	Recipients        []BouncedRecipient `json:"recipients"`
+}
+
+// BouncedRecipient is one recipient a bounce reports on
+type BouncedRecipient struct {
+	// Recipient is the address the original message was sent to
+	Recipient string `json:"recipient"`
+	Type      string `json:"type"`             // BounceHard or BounceSoft
+	Action    string `json:"action,omitempty"` // "failed" or "delayed"
+	// Status is the RFC 3463 enhanced status code, e.g. "5.1.1"
+	Status string `json:"status,omitempty"`
+	// DiagnosticCode is the remote server's reply, e.g. "550 5.1.1 User unknown"
+	DiagnosticCode string `json:"diagnostic_code,omitempty"`
+	RemoteMTA      string `json:"remote_mta,omitempty"`
+}
+
+var (
+	// statusCode matches an RFC 3463 enhanced status code
+	statusCode = regexp.MustCompile(`\b([245])\.\d{1,3}\.\d{1,3}\b`)
+
+	// smtpDiagnostic matches a failure reply quoted in a plain-text bounce
+	smtpDiagnostic = regexp.MustCompile(`\b[45]\d\d[ -][^\r\n]*`)
+
+	// bounceSubject matches the subjects MTAs give plain-text bounces
+	bounceSubject = regexp.MustCompile(`(?i)(undeliver|undelivered|returned mail|failure notice|` +
+		`delivery (status notification|failure|failed|has failed|problem)|mail delivery (failed|failure|system)|` +
+		`non-?delivery|could not be delivered|delayed mail)`)
+
+	// bounceRecipientLine matches a line naming a failed recipient: a bare
+	// address (Exim) or an address followed by a colon (qmail, Postfix)
+	bounceRecipientLine = regexp.MustCompile(`^\s*<?([^\s<>@:]+@[^\s<>:]+?)>?(:.*)?$`)
+
+	// returnedMessage matches the separator before the returned copy of the
+	// original message in a plain-text bounce
+	returnedMessage = regexp.MustCompile(`(?im)^.*(below this line is a copy|original message|` +
+		`message headers follow|this is a copy of the message|returned message|undelivered message).*$`)
+)
+
+// softStatuses are permanent failure codes that usually clear up on their own
+var softStatuses = map[string]bool{
+	"5.2.2": true, // mailbox full
+	"5.2.3": true, // message too large for the mailbox
+}
+
+// Phrases that classify a plain-text bounce without a status code
+var (
+	hardPhrases = []string{"permanent error", "permanent failure", "failed permanently", "user unknown", "unknown user",
+		"no such user", "does not exist", "address rejected", "mailbox unavailable", "i've given up"}
+	softPhrases = []string{"temporary", "still being retried", "will be retried", "delayed",
+		"mailbox full", "over quota", "quota exceeded"}
+)
+
+// parseBounce returns the bounce a message reports, or nil if it is not one
+func parseBounce(raw string, header textproto.MIMEHeader, content mimeContent) *Bounce {
+	if bounce := parseDSN(raw, content.root); bounce != nil {
+		return bounce
+	}
+	return parseTextBounce(raw, header, content)
+}
+
+// parseDSN reads the failed and delayed recipients of an RFC 3464
+// multipart/report; report-type=delivery-status message
+func parseDSN(raw string, root *MIMEPart) *Bounce {
+	if root == nil || root.ContentType != "multipart/report" {
+		return nil
+	}
+	status := findPart(root, "message/delivery-status", "message/global-delivery-status")
+	if status == nil {
+		return nil
+	}
+	content, err := PartContent(raw, status.Part)
+	if err != nil {
+		return nil
+	}
+
+	// The status is a per-message block followed by one block per recipient
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(string(content))))
+	perMessage, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil
+	}
+
+	bounce := &Bounce{
+		Format:       BounceFormatDSN,
+		ReportingMTA: fieldValue(perMessage.Get("Reporting-Mta")),
+	}
+	for err == nil {
+		var fields textproto.MIMEHeader
+		fields, err = reader.ReadMIMEHeader()
+		if len(fields) == 0 {
+			continue
+		}
+
+		action := strings.ToLower(strings.TrimSpace(fields.Get("Action")))
+		if action != "failed" && action != "delayed" {
+			// delivered, relayed and expanded are not bounces
+			continue
+		}
+		rcpt := BouncedRecipient{
+			Recipient:      fieldValue(fields.Get("Original-Recipient")),
+			Action:         action,
+			Status:         statusCode.FindString(fields.Get("Status")),
+			DiagnosticCode: fieldValue(fields.Get("Diagnostic-Code")),
+			RemoteMTA:      fieldValue(fields.Get("Remote-Mta")),
+		}
+		if rcpt.Recipient == "" {
+			rcpt.Recipient = fieldValue(fields.Get("Final-Recipient"))
+		}
+		if rcpt.Status == "" {
+			rcpt.Status = statusCode.FindString(rcpt.DiagnosticCode)
+		}
+		rcpt.Type = ClassifyBounce(rcpt.Status, rcpt.DiagnosticCode)
+		if action == "delayed" {
+			rcpt.Type = BounceSoft
+		}
+		bounce.Recipients = append(bounce.Recipients, rcpt)
+	}
+	if len(bounce.Recipients) == 0 {
+		return nil
+	}
+
+	bounce.OriginalMessageID = originalMessageID(raw, root)
+	bounce.Type = bounceType(bounce.Recipients)
+	return bounce
+}
+
+// parseTextBounce recognises the plain-text notices of MTAs that do not send
+// DSNs. A message is a bounce if it names its failed recipients in
+// X-Failed-Recipients, or comes from the mailer daemon with a bounce subject.
+func parseTextBounce(raw string, header textproto.MIMEHeader, content mimeContent) *Bounce {
+	failed := SplitRecipients(header.Get("X-Failed-Recipients"))
+	if len(failed) == 0 && !(isDaemon(header.Get("From")) && bounceSubject.MatchString(DecodeHeader(header.Get("Subject")))) {
+		return nil
+	}
+
+	// Only the notice itself, not the returned copy of the message
+	text := content.textBody
+	if loc := returnedMessage.FindStringIndex(text); loc != nil {
+		text = text[:loc[0]]
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	// Each recipient's block runs until the next recipient line
+	type block struct {
+		address string
+		lines   []string
+	}
+	var blocks []*block
+	for _, line := range lines {
+		if m := bounceRecipientLine.FindStringSubmatch(line); m != nil {
+			blocks = append(blocks, &block{address: m[1], lines: []string{m[2]}})
+			continue
+		}
+		if len(blocks) > 0 {
+			blocks[len(blocks)-1].lines = append(blocks[len(blocks)-1].lines, line)
+		}
+	}
+
+	bounce := &Bounce{Format: BounceFormatText}
+	for _, b := range blocks {
+		if len(failed) > 0 && !containsFold(failed, b.address) {
+			continue
+		}
+		details := strings.Join(b.lines, "\n")
+		rcpt := BouncedRecipient{
+			Recipient:      b.address,
+			Action:         "failed",
+			DiagnosticCode: strings.TrimSpace(smtpDiagnostic.FindString(details)),
+		}
+		rcpt.Status = statusCode.FindString(rcpt.DiagnosticCode)
+		if rcpt.Status == "" && rcpt.DiagnosticCode != "" {
+			rcpt.Status = rcpt.DiagnosticCode[:1] + ".0.0"
+		}
+		rcpt.Type = ClassifyBounce(rcpt.Status, details+"\n"+text)
+		bounce.Recipients = append(bounce.Recipients, rcpt)
+	}
+
+	// Recipients named in the header but not described in the text
+	for _, address := range failed {
+		if !bounce.hasRecipient(address) {
+			bounce.Recipients = append(bounce.Recipients, BouncedRecipient{
+				Recipient: address,
+				Action:    "failed",
+				Type:      ClassifyBounce("", text),
+			})
+		}
+	}
+	if len(bounce.Recipients) == 0 {
+		return nil
+	}
+
+	bounce.OriginalMessageID = originalMessageID(raw, content.root)
+	bounce.Type = bounceType(bounce.Recipients)
+	return bounce
+}
+
+// ClassifyBounce decides whether a failure is hard or soft from its status
+// code or, without one, from the wording of the notice. A failure that cannot
+// be classified is soft, so that it is never mistaken for a dead address.
+func ClassifyBounce(status, text string) string {
+	if status != "" {
+		if status[0] == '5' && !softStatuses[status] {
+			return BounceHard
+		}
+		return BounceSoft
+	}
+
+	text = strings.ToLower(text)
+	for _, phrase := range softPhrases {
+		if strings.Contains(text, phrase) {
+			return BounceSoft
+		}
+	}
+	for _, phrase := range hardPhrases {
+		if strings.Contains(text, phrase) {
+			return BounceHard
+		}
+	}
+	return BounceSoft
+}
+
+// bounceType is BounceHard if any recipient bounced hard
+func bounceType(recipients []BouncedRecipient) string {
+	for _, rcpt := range recipients {
+		if rcpt.Type == BounceHard {
+			return BounceHard
+		}
+	}
+	return BounceSoft
+}
+
+func (b *Bounce) hasRecipient(address string) bool {
+	for _, rcpt := range b.Recipients {
+		if strings.EqualFold(rcpt.Recipient, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// originalMessageID returns the Message-ID of the returned message or
+// headers in a bounce
+func originalMessageID(raw string, root *MIMEPart) string {
+	return strings.TrimSpace(returnedHeader(raw, root).Get("Message-Id"))
+}
+
+// returnedHeader returns the header of the original message returned in a
+// bounce or report, or an empty header when there is none
+func returnedHeader(raw string, root *MIMEPart) textproto.MIMEHeader {
+	part := findPart(root, "message/rfc822", "text/rfc822-headers", "message/rfc822-headers", "message/global")
+	if part == nil {
+		return textproto.MIMEHeader{}
+	}
+	content, err := PartContent(raw, part.Part)
+	if err != nil {
+		return textproto.MIMEHeader{}
+	}
+
+	// Returned headers often lack the blank line that ends them
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(string(content) + "\r\n\r\n")))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return textproto.MIMEHeader{}
+	}
+	return header
+}
+
+// findPart returns the first leaf part with one of the content types
+func findPart(part *MIMEPart, contentTypes ...string) *MIMEPart {
+	if part == nil {
+		return nil
+	}
+	for _, contentType := range contentTypes {
+		if part.ContentType == contentType && len(part.Parts) == 0 {
+			return part
+		}
+	}
+	for _, child := range part.Parts {
+		if found := findPart(child, contentTypes...); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// fieldValue strips the type from a DSN field such as "rfc822; bob@example.com"
+func fieldValue(value string) string {
+	if i := strings.Index(value, ";"); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.Trim(strings.TrimSpace(value), "<>")
+}
+
+// isDaemon reports whether a From header belongs to a mail system rather
+// than a person
+func isDaemon(from string) bool {
+	address := from
+	if addr, err := addressParser.Parse(from); err == nil {
+		address = addr.Address
+	}
+	local, _, _ := strings.Cut(strings.ToLower(address), "@")
+	return local == "mailer-daemon" || local == "postmaster" || local == "mail-daemon"
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package mail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dsnTestMessage = `From: Mail Delivery System <MAILER-DAEMON@mx.example.net>
+To: <alice@example.com>
+Subject: Undelivered Mail Returned to Sender
+Message-ID: <dsn@mx.example.net>
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="dsn"
+
+--dsn
+Content-Type: text/plain; charset=utf-8
+
+Your message could not be delivered to one or more recipients.
+
+--dsn
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.net
+Arrival-Date: Mon, 15 Jan 2024 10:00:00 +0000
+
+Original-Recipient: rfc822; Gone@example.net
+Final-Recipient: rfc822; gone@example.net
+Action: failed
+Status: 5.1.1
+Remote-MTA: dns; mail.example.net
+Diagnostic-Code: smtp; 550 5.1.1 <gone@example.net>: Recipient address
+ rejected: User unknown
+
+Final-Recipient: rfc822; full@example.net
+Action: failed
+Status: 5.2.2
+Diagnostic-Code: smtp; 552 5.2.2 Mailbox full
+
+Final-Recipient: rfc822; busy@example.net
+Action: delayed
+Status: 4.4.1
+Diagnostic-Code: smtp; 421 Try again later
+
+Final-Recipient: rfc822; ok@example.net
+Action: delivered
+Status: 2.0.0
+
+--dsn
+Content-Type: text/rfc822-headers
+
+From: alice@example.com
+Subject: Invoice
+Message-ID: <invoice-1@example.com>
+--dsn--
+`
+
+func TestParseRawEmail_DSN(t *testing.T) {
+	email, err := ParseRawEmail(crlf(dsnTestMessage), nil)
+	require.NoError(t, err)
+	require.NotNil(t, email.Bounce)
+
+	bounce := email.Bounce
+	assert.Equal(t, BounceHard, bounce.Type)
+	assert.Equal(t, BounceFormatDSN, bounce.Format)
+	assert.Equal(t, "mx.example.net", bounce.ReportingMTA)
+	assert.Equal(t, "<invoice-1@example.com>", bounce.OriginalMessageID)
+
+	assert.Equal(t, []BouncedRecipient{
+		{
+			Recipient:      "Gone@example.net",
+			Type:           BounceHard,
+			Action:         "failed",
+			Status:         "5.1.1",
+			DiagnosticCode: "550 5.1.1 <gone@example.net>: Recipient address rejected: User unknown",
+			RemoteMTA:      "mail.example.net",
+		},
+		{Recipient: "full@example.net", Type: BounceSoft, Action: "failed", Status: "5.2.2", DiagnosticCode: "552 5.2.2 Mailbox full"},
+		{Recipient: "busy@example.net", Type: BounceSoft, Action: "delayed", Status: "4.4.1", DiagnosticCode: "421 Try again later"},
+	}, bounce.Recipients, "delivered recipients are not bounces")
+}
+
+func TestParseRawEmail_DSNWithoutFailures(t *testing.T) {
+	raw := `From: MAILER-DAEMON@mx.example.net
+Subject: Successful Mail Delivery Report
+Content-Type: multipart/report; report-type=delivery-status; boundary="dsn"
+
+--dsn
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.net
+
+Final-Recipient: rfc822; bob@example.net
+Action: delivered
+Status: 2.0.0
+--dsn--
+`
+	email, err := ParseRawEmail(crlf(raw), nil)
+	require.NoError(t, err)
+	assert.Nil(t, email.Bounce)
+}
+
+func TestParseRawEmail_TextBounces(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want *Bounce
+	}{
+		{
+			name: "exim",
+			raw: `From: Mail Delivery System <Mailer-Daemon@mx.example.net>
+To: alice@example.com
+Subject: Mail delivery failed: returning message to sender
+X-Failed-Recipients: gone@example.net
+
+This message was created automatically by mail delivery software.
+
+A message that you sent could not be delivered to one or more of its
+recipients. This is a permanent error. The following address(es) failed:
+
+  gone@example.net
+    host mail.example.net [192.0.2.1]
+    SMTP error from remote mail server after RCPT TO:<gone@example.net>:
+    550 5.1.1 <gone@example.net>: User unknown
+
+------ This is a copy of the message, including all the headers. ------
+
+From: alice@example.com
+To: gone@example.net
+Message-ID: <x@example.com>
+`,
+			want: &Bounce{
+				Type:   BounceHard,
+				Format: BounceFormatText,
+				Recipients: []BouncedRecipient{{
+					Recipient:      "gone@example.net",
+					Type:           BounceHard,
+					Action:         "failed",
+					Status:         "5.1.1",
+					DiagnosticCode: "550 5.1.1 <gone@example.net>: User unknown",
+				}},
+			},
+		},
+		{
+			name: "qmail",
+			raw: `From: MAILER-DAEMON@mx.example.org
+To: alice@example.com
+Subject: failure notice
+
+Hi. This is the qmail-send program at mx.example.org.
+I'm afraid I wasn't able to deliver your message to the following addresses.
+This is a permanent error; I've given up. Sorry it didn't work out.
+
+<nobody@example.org>:
+192.0.2.7 does not like recipient.
+Remote host said: 550 No such user here
+Giving up on 192.0.2.7.
+
+<full@example.org>:
+Remote host said: 452 4.2.2 Mailbox full
+
+--- Below this line is a copy of the message.
+
+Return-Path: <alice@example.com>
+`,
+			want: &Bounce{
+				Type:   BounceHard,
+				Format: BounceFormatText,
+				Recipients: []BouncedRecipient{
+					{Recipient: "nobody@example.org", Type: BounceHard, Action: "failed", Status: "5.0.0", DiagnosticCode: "550 No such user here"},
+					{Recipient: "full@example.org", Type: BounceSoft, Action: "failed", Status: "4.2.2", DiagnosticCode: "452 4.2.2 Mailbox full"},
+				},
+			},
+		},
+		{
+			name: "header only",
+			raw: `From: postmaster@example.net
+Subject: Delivery has failed
+X-Failed-Recipients: a@example.net, b@example.net
+
+Delivery to these recipients failed permanently.
+`,
+			want: &Bounce{
+				Type:   BounceHard,
+				Format: BounceFormatText,
+				Recipients: []BouncedRecipient{
+					{Recipient: "a@example.net", Type: BounceHard, Action: "failed"},
+					{Recipient: "b@example.net", Type: BounceHard, Action: "failed"},
+				},
+			},
+		},
+		{
+			name: "ordinary mail from postmaster",
+			raw: `From: postmaster@example.net
+Subject: Welcome to example.net
+
+bob@example.net: your account is ready.
+`,
+		},
+		{
+			name: "bounce subject from a person",
+			raw: `From: carol@example.net
+Subject: Re: Undelivered parcel
+
+carol@example.net:
+Where is it?
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := ParseRawEmail(crlf(tt.raw), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, email.Bounce)
+		})
+	}
+}
+
+func TestClassifyBounce(t *testing.T) {
+	tests := []struct {
+		status string
+		text   string
+		want   string
+	}{
+		{"5.1.1", "", BounceHard},
+		{"5.2.2", "", BounceSoft},
+		{"4.7.1", "User unknown", BounceSoft},
+		{"", "This is a permanent error", BounceHard},
+		{"", "Mailbox full; this is a permanent error", BounceSoft},
+		{"", "Something went wrong", BounceSoft},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ClassifyBounce(tt.status, tt.text), "%q %q", tt.status, tt.text)
+	}
+}
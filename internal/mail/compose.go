@@ -0,0 +1,411 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OutgoingEmail is a message to send, as accepted by POST /mail/send
+type OutgoingEmail struct {
+	From        string               `json:"from"`
+	To          []string             `json:"to"`
+	Cc          []string             `json:"cc,omitempty"`
+	Bcc         []string             `json:"bcc,omitempty"`
+	Subject     string               `json:"subject"`
+	Text        string               `json:"text,omitempty"`
+	HTML        string               `json:"html,omitempty"`
+	Attachments []OutgoingAttachment `json:"attachments,omitempty"`
+	Headers     map[string]string    `json:"headers,omitempty"`
+}
+
+// OutgoingAttachment is a file attached to an OutgoingEmail. Attachments
+// with a ContentID are inline parts the HTML body refers to as "cid:".
+type OutgoingAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"` // guessed from the filename when empty
+	Content     string `json:"content"`                // base64
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+// Outgoing is a composed message with its envelope, ready to be queued
+type Outgoing struct {
+	From       string   // envelope sender
+	Recipients []string // To, Cc and Bcc, without duplicates
+	MessageID  string
+	Raw        []byte
+}
+
+// reservedHeaders are set by Compose and cannot be overridden by Headers
+var reservedHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true, "Subject": true,
+	"Date": true, "Message-Id": true, "Mime-Version": true,
+	"Content-Type": true, "Content-Transfer-Encoding": true,
+}
+
+// Compose builds an RFC 5322 message from e. The body is text/plain,
+// text/html or multipart/alternative, wrapped in multipart/related for
+// inline attachments and multipart/mixed for the others. Message-IDs are
+// generated under hostname, or the sender's domain when it is empty.
+func (e *OutgoingEmail) Compose(hostname string, now time.Time) (*Outgoing, error) {
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %q: %w", e.From, err)
+	}
+	to, err := parseOutgoingAddresses("to", e.To)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := parseOutgoingAddresses("cc", e.Cc)
+	if err != nil {
+		return nil, err
+	}
+	bcc, err := parseOutgoingAddresses("bcc", e.Bcc)
+	if err != nil {
+		return nil, err
+	}
+	if len(to)+len(cc)+len(bcc) == 0 {
+		return nil, fmt.Errorf("at least one recipient is required")
+	}
+
+	out := &Outgoing{
+		From:      from.Address,
+		MessageID: newMessageID(hostname, from.Address),
+	}
+	for _, list := range [][]*mail.Address{to, cc, bcc} {
+		for _, addr := range list {
+			out.Recipients = appendRecipient(out.Recipients, addr.Address)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", from.String())
+	if len(to) > 0 {
+		writeHeader(&buf, "To", formatAddressList(to))
+	}
+	if len(cc) > 0 {
+		writeHeader(&buf, "Cc", formatAddressList(cc))
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	writeHeader(&buf, "Date", now.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", out.MessageID)
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	names := make([]string, 0, len(e.Headers))
+	for name := range e.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := e.Headers[name]
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if reservedHeaders[canonical] {
+			return nil, fmt.Errorf("header %s cannot be set directly", name)
+		}
+		if !validHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid header %q", name)
+		}
+		writeHeader(&buf, name, mime.QEncoding.Encode("utf-8", value))
+	}
+
+	var inline, attached []OutgoingAttachment
+	for _, att := range e.Attachments {
+		if att.ContentID != "" {
+			inline = append(inline, att)
+		} else {
+			attached = append(attached, att)
+		}
+	}
+
+	body := e.bodyPart()
+	if len(inline) > 0 {
+		body, err = multipartPart("related", body, inline)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(attached) > 0 {
+		body, err = multipartPart("mixed", body, attached)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range sortedKeys(body.header) {
+		writeHeader(&buf, name, body.header.Get(name))
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body.content)
+
+	out.Raw = buf.Bytes()
+	return out, nil
+}
+
+// PrepareRaw takes a message composed by the caller. The envelope comes
+// from the From, To, Cc and Bcc headers; Bcc is removed, and Date and
+// Message-ID are added when missing.
+func PrepareRaw(raw []byte, hostname string, now time.Time) (*Outgoing, error) {
+	raw = normalizeLineEndings(raw)
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid From header: %w", err)
+	}
+
+	out := &Outgoing{From: from.Address}
+	for _, name := range []string{"To", "Cc", "Bcc"} {
+		if msg.Header.Get(name) == "" {
+			continue
+		}
+		list, err := msg.Header.AddressList(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %w", name, err)
+		}
+		for _, addr := range list {
+			out.Recipients = appendRecipient(out.Recipients, addr.Address)
+		}
+	}
+	if len(out.Recipients) == 0 {
+		return nil, fmt.Errorf("at least one recipient is required")
+	}
+
+	headerEnd := bytes.Index(raw, []byte("\r\n\r\n"))
+	if headerEnd < 0 {
+		headerEnd = len(raw)
+	} else {
+		headerEnd += 2
+	}
+
+	var buf bytes.Buffer
+	if msg.Header.Get("Date") == "" {
+		writeHeader(&buf, "Date", now.Format(time.RFC1123Z))
+	}
+	out.MessageID = msg.Header.Get("Message-ID")
+	if out.MessageID == "" {
+		out.MessageID = newMessageID(hostname, from.Address)
+		writeHeader(&buf, "Message-ID", out.MessageID)
+	}
+	buf.Write(removeHeader(raw[:headerEnd], "Bcc"))
+	buf.Write(raw[headerEnd:])
+
+	out.Raw = buf.Bytes()
+	return out, nil
+}
+
+// part is a MIME entity: its own headers and encoded content
+type part struct {
+	header  textproto.MIMEHeader
+	content []byte
+}
+
+// bodyPart returns the text and HTML bodies as one part
+func (e *OutgoingEmail) bodyPart() part {
+	switch {
+	case e.Text != "" && e.HTML != "":
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		for _, p := range []part{textPart("plain", e.Text), textPart("html", e.HTML)} {
+			pw, _ := w.CreatePart(p.header)
+			_, _ = pw.Write(p.content)
+		}
+		_ = w.Close()
+		return part{
+			header:  textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + w.Boundary()}},
+			content: buf.Bytes(),
+		}
+	case e.HTML != "":
+		return textPart("html", e.HTML)
+	default:
+		return textPart("plain", e.Text)
+	}
+}
+
+func textPart(subtype, text string) part {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	_, _ = w.Write(normalizeLineEndings([]byte(text)))
+	_ = w.Close()
+
+	return part{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {"text/" + subtype + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		content: buf.Bytes(),
+	}
+}
+
+// multipartPart wraps body and attachments in a multipart/subtype part
+func multipartPart(subtype string, body part, attachments []OutgoingAttachment) (part, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	pw, _ := w.CreatePart(body.header)
+	_, _ = pw.Write(body.content)
+
+	for _, att := range attachments {
+		data, err := base64.StdEncoding.DecodeString(att.Content)
+		if err != nil {
+			return part{}, fmt.Errorf("attachment %q is not valid base64: %w", att.Filename, err)
+		}
+
+		contentType := att.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(att.Filename))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return part{}, fmt.Errorf("attachment %q has an invalid content type: %w", att.Filename, err)
+		}
+
+		disposition := "attachment"
+		header := textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		}
+		if att.ContentID != "" {
+			disposition = "inline"
+			header.Set("Content-ID", "<"+strings.Trim(att.ContentID, "<>")+">")
+		}
+		if att.Filename != "" {
+			header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": att.Filename}))
+		} else {
+			header.Set("Content-Disposition", disposition)
+		}
+
+		pw, _ := w.CreatePart(header)
+		_, _ = pw.Write(wrapBase64(data))
+	}
+	_ = w.Close()
+
+	return part{
+		header:  textproto.MIMEHeader{"Content-Type": {"multipart/" + subtype + "; boundary=" + w.Boundary()}},
+		content: buf.Bytes(),
+	}, nil
+}
+
+// wrapBase64 encodes data in lines of 76 characters
+func wrapBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	if encoded != "" {
+		buf.WriteString(encoded + "\r\n")
+	}
+	return buf.Bytes()
+}
+
+func parseOutgoingAddresses(field string, list []string) ([]*mail.Address, error) {
+	addrs := make([]*mail.Address, 0, len(list))
+	for _, s := range list {
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s address %q: %w", field, s, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+func formatAddressList(addrs []*mail.Address) string {
+	strs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		strs[i] = addr.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+// appendRecipient adds addr unless it is already listed, ignoring case
+func appendRecipient(recipients []string, addr string) []string {
+	for _, r := range recipients {
+		if strings.EqualFold(r, addr) {
+			return recipients
+		}
+	}
+	return append(recipients, addr)
+}
+
+// newMessageID returns a random Message-ID under hostname, or under the
+// sender's domain without one
+func newMessageID(hostname, from string) string {
+	if hostname == "" {
+		hostname = from[strings.LastIndex(from, "@")+1:]
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return "<" + hex.EncodeToString(id) + "@" + hostname + ">"
+}
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name + ": " + value + "\r\n")
+}
+
+// validHeaderName reports whether name is a non-empty run of printable
+// ASCII other than colon, as RFC 5322 requires
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' || name[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// removeHeader drops every occurrence of the named header, with its
+// continuation lines, from a CRLF header block
+func removeHeader(header []byte, name string) []byte {
+	var out bytes.Buffer
+	skipping := false
+	for _, line := range bytes.SplitAfter(header, []byte("\r\n")) {
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			if !skipping {
+				out.Write(line)
+			}
+			continue
+		}
+		field, _, _ := bytes.Cut(line, []byte(":"))
+		skipping = strings.EqualFold(strings.TrimSpace(string(field)), name)
+		if !skipping {
+			out.Write(line)
+		}
+	}
+	return out.Bytes()
+}
+
+// normalizeLineEndings converts bare LF line endings to CRLF
+func normalizeLineEndings(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
+
+func sortedKeys(header textproto.MIMEHeader) []string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package mail
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var composeTime = time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+func TestOutgoingEmail_Compose(t *testing.T) {
+	email := &OutgoingEmail{
+		From:    "Alice Example <alice@example.com>",
+		To:      []string{"bob@example.net", "Zoë <zoe@example.net>"},
+		Cc:      []string{"carol@example.net"},
+		Bcc:     []string{"audit@example.com", "BOB@example.net"},
+		Subject: "Café menu",
+		Text:    "Hello\nworld",
+		HTML:    "<p>Hello <img src=\"cid:logo\"></p>",
+		Attachments: []OutgoingAttachment{
+			{Filename: "menu.pdf", Content: base64.StdEncoding.EncodeToString([]byte("%PDF-1.4"))},
+			{Filename: "logo.png", Content: base64.StdEncoding.EncodeToString([]byte("png")), ContentID: "logo"},
+		},
+		Headers: map[string]string{"X-Campaign": "spring"},
+	}
+
+	out, err := email.Compose("mx.example.com", composeTime)
+	require.NoError(t, err)
+
+	assert.Equal(t, "alice@example.com", out.From)
+	assert.Equal(t, []string{"bob@example.net", "zoe@example.net", "carol@example.net", "audit@example.com"}, out.Recipients)
+	assert.Regexp(t, `^<[0-9a-f]{32}@mx\.example\.com>$`, out.MessageID)
+
+	raw := string(out.Raw)
+	assert.NotContains(t, raw, "audit@example.com", "Bcc stays out of the headers")
+	assert.NotContains(t, raw, "\r\n\n")
+	assert.Contains(t, raw, "Date: Mon, 15 Jan 2024 10:30:00 +0000\r\n")
+	assert.Contains(t, raw, "X-Campaign: spring\r\n")
+
+	// The composed message parses back into the same content
+	parsed, err := ParseRawEmail(raw, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Café menu", parsed.Subject)
+	assert.Equal(t, out.MessageID, parsed.MessageID)
+	assert.Equal(t, "Hello\r\nworld", parsed.TextBody)
+	assert.Equal(t, email.HTML, parsed.HTMLBody)
+	require.Len(t, parsed.To, 2)
+	assert.Equal(t, "Zoë", parsed.To[1].Name)
+
+	require.Len(t, parsed.Attachments, 2)
+	byName := map[string]Attachment{}
+	for _, att := range parsed.Attachments {
+		byName[att.Filename] = att
+	}
+	assert.Equal(t, "application/pdf", byName["menu.pdf"].ContentType)
+	assert.Equal(t, "attachment", byName["menu.pdf"].Disposition)
+	assert.Equal(t, 8, byName["menu.pdf"].Size)
+	assert.Equal(t, "inline", byName["logo.png"].Disposition)
+	assert.Equal(t, "logo", byName["logo.png"].ContentID)
+}
+
+func TestOutgoingEmail_ComposeTextOnly(t *testing.T) {
+	out, err := (&OutgoingEmail{From: "alice@example.com", To: []string{"bob@example.net"}, Subject: "Hi", Text: "Plain"}).Compose("", composeTime)
+	require.NoError(t, err)
+
+	assert.Contains(t, out.MessageID, "@example.com>", "sender's domain without a hostname")
+	assert.Contains(t, string(out.Raw), "Content-Type: text/plain; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(string(out.Raw), "\r\n\r\nPlain"))
+}
+
+func TestOutgoingEmail_ComposeErrors(t *testing.T) {
+	valid := func() *OutgoingEmail {
+		return &OutgoingEmail{From: "alice@example.com", To: []string{"bob@example.net"}, Text: "x"}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(e *OutgoingEmail)
+	}{
+		{"bad from", func(e *OutgoingEmail) { e.From = "not an address" }},
+		{"bad recipient", func(e *OutgoingEmail) { e.Cc = []string{"bob@"} }},
+		{"no recipients", func(e *OutgoingEmail) { e.To = nil }},
+		{"reserved header", func(e *OutgoingEmail) { e.Headers = map[string]string{"message-id": "<x@y>"} }},
+		{"header injection", func(e *OutgoingEmail) { e.Headers = map[string]string{"X-Note": "a\r\nBcc: eve@example.org"} }},
+		{"bad header name", func(e *OutgoingEmail) { e.Headers = map[string]string{"X Note": "a"} }},
+		{"bad attachment", func(e *OutgoingEmail) { e.Attachments = []OutgoingAttachment{{Filename: "a.txt", Content: "!!"}} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := valid()
+			tt.modify(email)
+			_, err := email.Compose("mx.example.com", composeTime)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPrepareRaw(t *testing.T) {
+	raw := "From: Alice <alice@example.com>\nTo: bob@example.net\nBcc: audit@example.com,\n bob@example.net\nSubject: Raw\n\nBody\n"
+
+	out, err := PrepareRaw([]byte(raw), "mx.example.com", composeTime)
+	require.NoError(t, err)
+
+	assert.Equal(t, "alice@example.com", out.From)
+	assert.Equal(t, []string{"bob@example.net", "audit@example.com"}, out.Recipients)
+	assert.Equal(t, "Date: Mon, 15 Jan 2024 10:30:00 +0000\r\nMessage-ID: "+out.MessageID+"\r\n"+
+		"From: Alice <alice@example.com>\r\nTo: bob@example.net\r\nSubject: Raw\r\n\r\nBody\r\n", string(out.Raw))
+
+	// An existing Message-ID is kept
+	out, err = PrepareRaw([]byte("From: alice@example.com\r\nTo: bob@example.net\r\nMessage-ID: <1@example.com>\r\nDate: Mon, 15 Jan 2024 10:30:00 +0000\r\n\r\nBody\r\n"), "mx.example.com", composeTime)
+	require.NoError(t, err)
+	assert.Equal(t, "<1@example.com>", out.MessageID)
+	assert.True(t, strings.HasPrefix(string(out.Raw), "From: "))
+
+	_, err = PrepareRaw([]byte("From: alice@example.com\r\nSubject: nobody\r\n\r\nBody\r\n"), "", composeTime)
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package mail
+
+import (
+	"bufio"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// FeedbackNotSpam is the feedback type of a report that mail was wrongly
+// marked as spam. Every other type is a complaint about the mail.
+const FeedbackNotSpam = "not-spam"
+
+// Complaint is an RFC 5965 Abuse Reporting Format report, as sent by mailbox
+// providers' feedback loops when a recipient marks mail as spam
+type Complaint struct {
+	// FeedbackType is "abuse", "fraud", "virus", "other" or FeedbackNotSpam
+	FeedbackType string `json:"feedback_type"`
+	UserAgent    string `json:"user_agent,omitempty"`
+	// Recipients are the addresses that complained: the report's
+	// Original-Rcpt-To fields, or the To header of the returned message
+	Recipients        []string `json:"recipients,omitempty"`
+	OriginalMailFrom  string   `json:"original_mail_from,omitempty"`
+	OriginalMessageID string   `json:"original_message_id,omitempty"`
+	ReportedDomain    string   `json:"reported_domain,omitempty"`
+	SourceIP          string   `json:"source_ip,omitempty"`
+	ArrivalDate       string   `json:"arrival_date,omitempty"`
+}
+
+// parseComplaint returns the report in a multipart/report;
+// report-type=feedback-report message, or nil if it is not one
+func parseComplaint(raw string, root *MIMEPart) *Complaint {
+	if root == nil || root.ContentType != "multipart/report" {
+		return nil
+	}
+	report := findPart(root, "message/feedback-report")
+	if report == nil {
+		return nil
+	}
+	content, err := PartContent(raw, report.Part)
+	if err != nil {
+		return nil
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(string(content) + "\r\n\r\n")))
+	fields, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF && len(fields) == 0 {
+		return nil
+	}
+	feedbackType := strings.ToLower(strings.TrimSpace(fields.Get("Feedback-Type")))
+	if feedbackType == "" {
+		return nil
+	}
+
+	original := returnedHeader(raw, root)
+	complaint := &Complaint{
+		FeedbackType:      feedbackType,
+		UserAgent:         strings.TrimSpace(fields.Get("User-Agent")),
+		OriginalMailFrom:  strings.Trim(strings.TrimSpace(fields.Get("Original-Mail-From")), "<>"),
+		OriginalMessageID: strings.TrimSpace(original.Get("Message-Id")),
+		ReportedDomain:    strings.TrimSpace(fields.Get("Reported-Domain")),
+		SourceIP:          strings.TrimSpace(fields.Get("Source-Ip")),
+		ArrivalDate:       strings.TrimSpace(fields.Get("Arrival-Date")),
+	}
+	for _, value := range fields.Values("Original-Rcpt-To") {
+		if address := strings.Trim(strings.TrimSpace(value), "<>"); address != "" {
+			complaint.Recipients = append(complaint.Recipients, address)
+		}
+	}
+
+	// Many feedback loops leave the recipient out of the report itself
+	if len(complaint.Recipients) == 0 {
+		for _, addr := range parseAddressList(original.Get("To")) {
+			complaint.Recipients = append(complaint.Recipients, addr.Address)
+		}
+	}
+	return complaint
+}
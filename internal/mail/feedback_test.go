@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const arfTestMessage = `From: <abuse@isp.example>
+To: <fbl@example.com>
+Subject: FW: Invoice
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=feedback-report; boundary="arf"
+
+--arf
+Content-Type: text/plain; charset="US-ASCII"
+
+This is an email abuse report for an email message received from IP
+192.0.2.1 on Thu, 8 Mar 2005 14:00:00 EDT.
+
+--arf
+Content-Type: message/feedback-report
+
+Feedback-Type: abuse
+User-Agent: SomeGenerator/1.0
+Version: 1
+Original-Mail-From: <alice@example.com>
+Original-Rcpt-To: <Customer@isp.example>
+Arrival-Date: Thu, 8 Mar 2005 14:00:00 EDT
+Reporting-MTA: dns; mail.isp.example
+Source-IP: 192.0.2.1
+Reported-Domain: example.com
+
+--arf
+Content-Type: message/rfc822
+Content-Disposition: inline
+
+From: <alice@example.com>
+To: <customer@isp.example>
+Subject: Invoice
+Message-ID: <invoice-1@example.com>
+
+Your invoice is attached.
+--arf--
+`
+
+func TestParseRawEmail_Complaint(t *testing.T) {
+	email, err := ParseRawEmail(crlf(arfTestMessage), nil)
+	require.NoError(t, err)
+
+	assert.Nil(t, email.Bounce)
+	assert.Equal(t, &Complaint{
+		FeedbackType:      "abuse",
+		UserAgent:         "SomeGenerator/1.0",
+		Recipients:        []string{"Customer@isp.example"},
+		OriginalMailFrom:  "alice@example.com",
+		OriginalMessageID: "<invoice-1@example.com>",
+		ReportedDomain:    "example.com",
+		SourceIP:          "192.0.2.1",
+		ArrivalDate:       "Thu, 8 Mar 2005 14:00:00 EDT",
+	}, email.Complaint)
+}
+
+func TestParseRawEmail_ComplaintRedacted(t *testing.T) {
+	// Without Original-Rcpt-To the returned message's To header names the
+	// recipient
+	raw := `From: <abuse@isp.example>
+Content-Type: multipart/report; report-type=feedback-report; boundary="arf"
+
+--arf
+Content-Type: message/feedback-report
+
+Feedback-Type: abuse
+User-Agent: SomeGenerator/1.0
+Version: 1
+--arf
+Content-Type: text/rfc822-headers
+
+From: <alice@example.com>
+To: Customer <customer@isp.example>
+--arf--
+`
+	email, err := ParseRawEmail(crlf(raw), nil)
+	require.NoError(t, err)
+	require.NotNil(t, email.Complaint)
+	assert.Equal(t, []string{"customer@isp.example"}, email.Complaint.Recipients)
+
+	// Ordinary mail is not a complaint
+	email, err = ParseRawEmail(crlf(mimeTestMessage), nil)
+	require.NoError(t, err)
+	assert.Nil(t, email.Complaint)
+}
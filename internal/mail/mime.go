@@ -0,0 +1,349 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Limits that keep hostile messages from exhausting the parser
+const (
+	maxMIMEDepth = 32
+	maxMIMEParts = 1000
+)
+
+// Attachment describes a part of a message that is not one of its bodies
+type Attachment struct {
+	Part        string `json:"part"` // MIME part number, see MIMEPart.Part
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type"`
+	Disposition string `json:"disposition,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+	Size        int    `json:"size"` // decoded bytes
+	SHA256      string `json:"sha256"`
+	// Extracted is set when the content was moved out of Raw into the
+	// attachment blob store, leaving the part's body empty
+	Extracted bool `json:"extracted,omitempty"`
+	// URL is where a webhook receiver can download the attachment
+	URL string `json:"url,omitempty"`
+}
+
+// MIMEPart is a node of a message's MIME structure
+type MIMEPart struct {
+	// Part is the IMAP section number: "1", "2.1", ... The root of a
+	// multipart message has no number; a single-part message is part "1".
+	Part        string      `json:"part,omitempty"`
+	ContentType string      `json:"content_type"`
+	Charset     string      `json:"charset,omitempty"`
+	Encoding    string      `json:"encoding,omitempty"`
+	Disposition string      `json:"disposition,omitempty"`
+	Filename    string      `json:"filename,omitempty"`
+	ContentID   string      `json:"content_id,omitempty"`
+	Size        int         `json:"size"` // decoded bytes, excluding children
+	Parts       []*MIMEPart `json:"parts,omitempty"`
+	// Error records why the part could not be read completely, e.g. a
+	// multipart body without its closing boundary
+	Error string `json:"error,omitempty"`
+}
+
+// wordDecoder decodes RFC 2047 encoded-words in any charset htmlindex knows
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// DecodeHeader decodes RFC 2047 encoded-words in a header value. Values
+// that cannot be decoded are returned unchanged.
+func DecodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// mimeContent is the result of walking a message
+type mimeContent struct {
+	root        *MIMEPart
+	textBody    string
+	htmlBody    string
+	attachments []Attachment
+}
+
+// parseMIME walks a message's MIME structure, decoding its text and HTML
+// bodies to UTF-8 and describing everything else as attachments. It never
+// fails: damaged parts are kept as far as they could be read and marked
+// with an Error.
+func parseMIME(header textproto.MIMEHeader, body io.Reader) mimeContent {
+	w := &mimeWalker{}
+	w.content.root = w.walk(header, body, "", 0)
+	return w.content
+}
+
+// PartContent returns the decoded content of the part with the given
+// number in a raw message
+func PartContent(raw, part string) ([]byte, error) {
+	header, body, err := readMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &mimeWalker{want: part}
+	w.walk(header, body, "", 0)
+	if !w.found {
+		return nil, fmt.Errorf("part %s not found", part)
+	}
+	return w.wanted, nil
+}
+
+// readMessage splits a raw message into its header and body
+func readMessage(raw string) (textproto.MIMEHeader, io.Reader, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse email: %w", err)
+	}
+	return textproto.MIMEHeader(msg.Header), msg.Body, nil
+}
+
+type mimeWalker struct {
+	content mimeContent
+	parts   int
+
+	// When want is set the walker only looks for that part's content
+	want   string
+	wanted []byte
+	found  bool
+}
+
+func (w *mimeWalker) walk(header textproto.MIMEHeader, body io.Reader, number string, depth int) *MIMEPart {
+	w.parts++
+
+	mediaType, params := parseContentType(header.Get("Content-Type"))
+
+	node := &MIMEPart{
+		Part:        number,
+		ContentType: mediaType,
+		Charset:     strings.ToLower(params["charset"]),
+		Encoding:    strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))),
+		ContentID:   strings.Trim(strings.TrimSpace(header.Get("Content-Id")), "<>"),
+	}
+
+	if disposition, dispParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		node.Disposition = disposition
+		node.Filename = DecodeHeader(dispParams["filename"])
+	}
+	if node.Filename == "" {
+		node.Filename = DecodeHeader(params["name"])
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		if depth >= maxMIMEDepth {
+			node.Error = "nested too deeply"
+			return node
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			node.Error = err.Error()
+		}
+		w.walkMultipart(node, bytes.NewReader(data), params["boundary"], depth)
+
+		// Without a single recognisable boundary the body is all there is
+		if len(node.Parts) == 0 && w.want == "" && w.content.textBody == "" {
+			w.content.textBody = toUTF8("", data)
+		}
+		return node
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		node.Error = "missing boundary"
+	}
+
+	if number == "" {
+		number = "1"
+		node.Part = number
+	}
+
+	// Only the wanted part needs decoding when looking for one
+	if w.want != "" && w.want != number {
+		return node
+	}
+
+	content, err := decodeTransfer(node.Encoding, body)
+	if err != nil {
+		node.Error = err.Error()
+	}
+	node.Size = len(content)
+
+	if w.want != "" {
+		w.wanted, w.found = content, true
+		return node
+	}
+
+	switch {
+	case isAttachment(node):
+		sum := sha256.Sum256(content)
+		w.content.attachments = append(w.content.attachments, Attachment{
+			Part:        number,
+			Filename:    node.Filename,
+			ContentType: node.ContentType,
+			Disposition: node.Disposition,
+			ContentID:   node.ContentID,
+			Size:        node.Size,
+			SHA256:      hex.EncodeToString(sum[:]),
+		})
+	case node.ContentType == "text/html":
+		if w.content.htmlBody == "" {
+			w.content.htmlBody = toUTF8(node.Charset, content)
+		}
+	default:
+		if w.content.textBody == "" {
+			w.content.textBody = toUTF8(node.Charset, content)
+		}
+	}
+
+	return node
+}
+
+func (w *mimeWalker) walkMultipart(node *MIMEPart, body io.Reader, boundary string, depth int) {
+	mr := multipart.NewReader(body, boundary)
+
+	for i := 1; ; i++ {
+		if w.parts >= maxMIMEParts {
+			node.Error = "too many parts"
+			return
+		}
+
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// Typically a missing or mangled boundary: keep what was read
+			node.Error = fmt.Sprintf("malformed multipart: %v", err)
+			return
+		}
+
+		number := strconv.Itoa(i)
+		if node.Part != "" {
+			number = node.Part + "." + number
+		}
+
+		child := w.walk(textproto.MIMEHeader(p.Header), p, number, depth+1)
+		node.Parts = append(node.Parts, child)
+
+		// Drain the part so a damaged child cannot derail its siblings
+		if _, err := io.Copy(io.Discard, p); err != nil && child.Error == "" {
+			child.Error = err.Error()
+		}
+		if w.found {
+			return
+		}
+	}
+}
+
+// parseContentType parses a Content-Type header, salvaging what it can from
+// malformed ones. RFC 2045 makes anything unusable plain text.
+func parseContentType(contentType string) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		return mediaType, params
+	}
+
+	mediaType = strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	if !strings.Contains(mediaType, "/") {
+		mediaType = "text/plain"
+	}
+	params = map[string]string{}
+	for _, name := range []string{"charset", "boundary", "name"} {
+		if value := mediaParam(contentType, name); value != "" {
+			params[name] = value
+		}
+	}
+	return mediaType, params
+}
+
+// isAttachment reports whether a leaf part is an attachment rather than a
+// message body. A multipart part only ends up here without a boundary, and
+// is read as text.
+func isAttachment(part *MIMEPart) bool {
+	if part.Disposition == "attachment" || part.Filename != "" {
+		return true
+	}
+	return part.ContentType != "text/plain" && part.ContentType != "text/html" &&
+		!strings.HasPrefix(part.ContentType, "multipart/")
+}
+
+// decodeTransfer undoes a Content-Transfer-Encoding. Damaged content is
+// decoded as far as possible and returned along with the error.
+func decodeTransfer(encoding string, body io.Reader) ([]byte, error) {
+	switch encoding {
+	case "base64":
+		raw, err := io.ReadAll(body)
+		// Line breaks and stray characters are common; drop them
+		cleaned := bytes.Map(func(r rune) rune {
+			if r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '+' || r == '/' {
+				return r
+			}
+			return -1
+		}, raw)
+		if len(cleaned)%4 == 1 {
+			// A lone trailing character cannot encode anything
+			cleaned = cleaned[:len(cleaned)-1]
+		}
+		decoded, decodeErr := base64.RawStdEncoding.DecodeString(string(cleaned))
+		return decoded, errors.Join(err, decodeErr)
+	case "quoted-printable":
+		decoded, err := io.ReadAll(quotedprintable.NewReader(body))
+		return decoded, err
+	default:
+		return io.ReadAll(body)
+	}
+}
+
+// toUTF8 converts text in the given charset to UTF-8. Unknown charsets are
+// assumed to be UTF-8; invalid sequences are replaced.
+func toUTF8(charset string, content []byte) string {
+	if charset != "" && charset != "utf-8" && charset != "us-ascii" {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			if decoded, err := enc.NewDecoder().Bytes(content); err == nil {
+				content = decoded
+			}
+		}
+	}
+
+	if utf8.Valid(content) {
+		return string(content)
+	}
+	return strings.ToValidUTF8(string(content), "�")
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// mediaParam extracts a parameter from a Content-Type that
+// mime.ParseMediaType rejected
+func mediaParam(contentType, param string) string {
+	for _, field := range strings.Split(contentType, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(name), param) {
+			return strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return ""
+}
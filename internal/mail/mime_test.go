@@ -0,0 +1,280 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// crlf converts a readable test message to wire format
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+const mimeTestMessage = `From: =?UTF-8?B?SsO8cmdlbg==?= <juergen@example.com>
+To: bob@example.com
+Subject: =?ISO-8859-1?Q?Gr=FC=DFe?= aus =?UTF-8?Q?M=C3=BCnchen?=
+Message-ID: <mime@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+This is a multi-part message in MIME format.
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=iso-8859-1
+Content-Transfer-Encoding: quoted-printable
+
+Gr=FC=DFe, Bob!
+--inner
+Content-Type: text/html; charset="windows-1252"
+Content-Transfer-Encoding: 8bit
+
+<p>Caf` + "\xe9" + `</p>
+--inner--
+--outer
+Content-Type: image/png; name="logo.png"
+Content-Transfer-Encoding: base64
+Content-ID: <logo@example.com>
+Content-Disposition: inline
+
+aGVsbG8g
+d29ybGQ=
+--outer
+Content-Type: application/pdf
+Content-Transfer-Encoding: base64
+Content-Disposition: attachment; filename="=?UTF-8?Q?Rechnung_M=C3=A4rz.pdf?="
+
+JVBERi0xLjQK
+--outer--
+`
+
+func TestParseRawEmail_MIME(t *testing.T) {
+	data, err := ParseRawEmail(crlf(mimeTestMessage), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Grüße aus München", data.Subject)
+	assert.Equal(t, "juergen@example.com", data.Sender)
+	assert.Equal(t, "Grüße, Bob!", data.TextBody)
+	assert.Equal(t, "<p>Café</p>", data.HTMLBody)
+
+	require.Len(t, data.Attachments, 2)
+	assert.Equal(t, Attachment{
+		Part:        "2",
+		Filename:    "logo.png",
+		ContentType: "image/png",
+		Disposition: "inline",
+		ContentID:   "logo@example.com",
+		Size:        len("hello world"),
+		SHA256:      sha256Hex("hello world"),
+	}, data.Attachments[0])
+	assert.Equal(t, "3", data.Attachments[1].Part)
+	assert.Equal(t, "Rechnung März.pdf", data.Attachments[1].Filename)
+	assert.Equal(t, "attachment", data.Attachments[1].Disposition)
+	assert.Equal(t, sha256Hex("%PDF-1.4\n"), data.Attachments[1].SHA256)
+
+	root := data.MIME
+	require.NotNil(t, root)
+	assert.Equal(t, "multipart/mixed", root.ContentType)
+	assert.Empty(t, root.Part)
+	require.Len(t, root.Parts, 3)
+	assert.Equal(t, "multipart/alternative", root.Parts[0].ContentType)
+	require.Len(t, root.Parts[0].Parts, 2)
+	assert.Equal(t, "1.2", root.Parts[0].Parts[1].Part)
+	assert.Equal(t, "windows-1252", root.Parts[0].Parts[1].Charset)
+	assert.Equal(t, "quoted-printable", root.Parts[0].Parts[0].Encoding)
+	assert.Empty(t, root.Error)
+}
+
+func TestParseRawEmail_SinglePart(t *testing.T) {
+	data, err := ParseRawEmail("From: a@example.com\nSubject: Plain\n\nJust text.\n", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Just text.\n", data.TextBody)
+	assert.Empty(t, data.HTMLBody)
+	assert.Empty(t, data.Attachments)
+	assert.Equal(t, &MIMEPart{Part: "1", ContentType: "text/plain", Size: len("Just text.\n")}, data.MIME)
+
+	// A message that is nothing but an attachment
+	data, err = ParseRawEmail(crlf("From: a@example.com\nContent-Type: application/zip\nContent-Transfer-Encoding: base64\n\nUEsDBA==\n"), nil)
+	require.NoError(t, err)
+	assert.Empty(t, data.TextBody)
+	require.Len(t, data.Attachments, 1)
+	assert.Equal(t, "1", data.Attachments[0].Part)
+	assert.Equal(t, 4, data.Attachments[0].Size)
+}
+
+func TestParseRawEmail_MalformedMIME(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		textBody string
+		partErr  bool
+	}{
+		{
+			name: "missing closing boundary",
+			raw: "Content-Type: multipart/mixed; boundary=b\n\n" +
+				"--b\nContent-Type: text/plain\n\nfirst\n" +
+				"--b\nContent-Type: application/octet-stream\n\ntruncated",
+			textBody: "first",
+			partErr:  true,
+		},
+		{
+			name:     "boundary never appears",
+			raw:      "Content-Type: multipart/mixed; boundary=missing\n\nJust a body.\n",
+			textBody: "Just a body.\n",
+		},
+		{
+			name:     "multipart without boundary parameter",
+			raw:      "Content-Type: multipart/mixed\n\nbody\n",
+			textBody: "body\n",
+			partErr:  true,
+		},
+		{
+			name:     "unparseable content type",
+			raw:      "Content-Type: text/plain; charset=\"utf-8; format=flowed\n\nbody\n",
+			textBody: "body\n",
+		},
+		{
+			name:     "truncated base64",
+			raw:      "Content-Type: text/plain\nContent-Transfer-Encoding: base64\n\naGVsbG8g\nd29ybG\n",
+			textBody: "hello worl",
+		},
+		{
+			name:     "unknown charset",
+			raw:      "Content-Type: text/plain; charset=x-unknown\n\ncaf\xe9\n",
+			textBody: "caf�\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ParseRawEmail(crlf(tt.raw), nil)
+			require.NoError(t, err)
+			assert.Equal(t, crlf(tt.textBody), data.TextBody)
+			require.NotNil(t, data.MIME)
+			if tt.partErr {
+				assert.NotEmpty(t, data.MIME.Error)
+			}
+		})
+	}
+}
+
+func TestParseRawEmail_NestingLimit(t *testing.T) {
+	var raw strings.Builder
+	raw.WriteString("Content-Type: multipart/mixed; boundary=b0\r\n\r\n")
+	for i := 1; i <= maxMIMEDepth+5; i++ {
+		raw.WriteString("--b" + strconv.Itoa(i-1) + "\r\nContent-Type: multipart/mixed; boundary=b" + strconv.Itoa(i) + "\r\n\r\n")
+	}
+
+	data, err := ParseRawEmail(raw.String(), nil)
+	require.NoError(t, err)
+
+	depth := 0
+	for part := data.MIME; len(part.Parts) > 0; part = part.Parts[0] {
+		depth++
+	}
+	assert.LessOrEqual(t, depth, maxMIMEDepth)
+}
+
+func TestPartContent(t *testing.T) {
+	raw := crlf(mimeTestMessage)
+
+	content, err := PartContent(raw, "2")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+
+	content, err = PartContent(raw, "1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "Gr\xfc\xdfe, Bob!", string(content), "transfer decoding only, charset untouched")
+
+	_, err = PartContent(raw, "4")
+	assert.Error(t, err)
+
+	content, err = PartContent("Subject: x\n\nbody", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "body", string(content))
+}
+
+func TestDecodeHeader(t *testing.T) {
+	assert.Equal(t, "¡Hola, señor!", DecodeHeader("=?ISO-8859-1?Q?=A1Hola,_se=F1or!?="))
+	assert.Equal(t, "плохо", DecodeHeader("=?koi8-r?B?0MzPyM8=?="))
+	assert.Equal(t, "plain", DecodeHeader("plain"))
+	assert.Equal(t, "=?x-bogus?Q?abc?=", DecodeHeader("=?x-bogus?Q?abc?="))
+}
+
+func TestRewriteParts(t *testing.T) {
+	raw := crlf(mimeTestMessage)
+
+	var seen []string
+	unchanged, err := RewriteParts(raw, func(part string, header textproto.MIMEHeader, body string) (string, bool, error) {
+		seen = append(seen, part+" "+header.Get("Content-Type"))
+		return "", false, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, raw, unchanged)
+	assert.Equal(t, []string{
+		"1.1 text/plain; charset=iso-8859-1",
+		"1.2 text/html; charset=\"windows-1252\"",
+		"2 image/png; name=\"logo.png\"",
+		"3 application/pdf",
+	}, seen)
+
+	// Empty the image, then put it back
+	stripped, err := RewriteParts(raw, func(part string, header textproto.MIMEHeader, body string) (string, bool, error) {
+		if part != "2" {
+			return "", false, nil
+		}
+		assert.Equal(t, "hello world", string(DecodeTransfer(header.Get("Content-Transfer-Encoding"), body)))
+		return "", true, nil
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, stripped, "aGVsbG8g")
+
+	data, err := ParseRawEmail(stripped, nil)
+	require.NoError(t, err)
+	require.Len(t, data.Attachments, 2)
+	assert.Equal(t, 0, data.Attachments[0].Size)
+	assert.Equal(t, "Grüße, Bob!", data.TextBody)
+
+	restored, err := RewriteParts(stripped, func(part string, header textproto.MIMEHeader, body string) (string, bool, error) {
+		if part != "2" {
+			return "", false, nil
+		}
+		return EncodeTransfer(header.Get("Content-Transfer-Encoding"), []byte("hello world"), Newline(stripped)), true, nil
+	})
+	require.NoError(t, err)
+
+	content, err := PartContent(restored, "2")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+}
+
+func TestEncodeTransfer(t *testing.T) {
+	content := []byte(strings.Repeat("Grüße ", 30))
+
+	for _, encoding := range []string{"base64", "quoted-printable", "8bit"} {
+		encoded := EncodeTransfer(encoding, content, "\n")
+		assert.Equal(t, content, DecodeTransfer(encoding, encoded), encoding)
+		for _, line := range strings.Split(encoded, "\n") {
+			if encoding != "8bit" {
+				assert.LessOrEqual(t, len(line), 76, encoding)
+			}
+		}
+	}
+
+	assert.Equal(t, "\r\n", Newline("Subject: x\r\n\r\n"))
+	assert.Equal(t, "\n", Newline("Subject: x\n\n"))
+}
@@ -4,19 +4,89 @@ import (
 	"bufio"
 	"fmt"
 	"net/mail"
+	"net/textproto"
 	"strings"
 	"time"
 )
 
 type EmailData struct {
-	Sender         string                 `json:"sender"`
-	Recipient      string                 `json:"recipient"`
+	Sender string `json:"sender"`
+	// Recipient is the first envelope recipient, kept for clients that
+	// predate Recipients
+	Recipient string `json:"recipient"`
+	// Recipients are the envelope recipients (RCPT TO), which may differ
+	// from the addresses in the To and Cc headers
+	Recipients     []string               `json:"recipients,omitempty"`
 	ReceivedAt     time.Time              `json:"received_at"`
 	Raw            string                 `json:"raw"`
 	Subject        string                 `json:"subject,omitempty"`
 	MessageID      string                 `json:"message_id,omitempty"`
+	To             []Address              `json:"to,omitempty"`
+	Cc             []Address              `json:"cc,omitempty"`
+	ReplyTo        []Address              `json:"reply_to,omitempty"`
+	TextBody       string                 `json:"text_body,omitempty"`
+	HTMLBody       string                 `json:"html_body,omitempty"`
+	Attachments    []Attachment           `json:"attachments,omitempty"`
+	MIME           *MIMEPart              `json:"mime,omitempty"`
 	Connection     ConnectionInfo         `json:"connection"`
 	Authentication AuthenticationMetadata `json:"authentication"`
+	// Bounce is set when the message reports that mail sent from here
+	// could not be delivered
+	Bounce *Bounce `json:"bounce,omitempty"`
+	// Complaint is set when the message is a spam complaint about mail sent
+	// from here
+	Complaint *Complaint `json:"complaint,omitempty"`
+}
+
+// Address is a header address with its decoded display name
+type Address struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+// EnvelopeRecipients returns the envelope recipients, falling back to
+// Recipient for messages stored before Recipients existed
+func (e *EmailData) EnvelopeRecipients() []string {
+	if len(e.Recipients) > 0 {
+		return e.Recipients
+	}
+	if e.Recipient != "" {
+		return []string{e.Recipient}
+	}
+	return nil
+}
+
+// SetRecipients sets the envelope recipients, dropping blanks and
+// duplicates, and Recipient to the first of them
+func (e *EmailData) SetRecipients(recipients []string) {
+	e.Recipients = nil
+	seen := map[string]bool{}
+	for _, recipient := range recipients {
+		recipient = strings.Trim(strings.TrimSpace(recipient), "<>")
+		key := strings.ToLower(recipient)
+		if recipient == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		e.Recipients = append(e.Recipients, recipient)
+	}
+
+	e.Recipient = ""
+	if len(e.Recipients) > 0 {
+		e.Recipient = e.Recipients[0]
+	}
+}
+
+// SplitRecipients splits a comma-separated list of envelope addresses, as
+// passed in the X-Original-Recipient header
+func SplitRecipients(value string) []string {
+	var recipients []string
+	for _, recipient := range strings.Split(value, ",") {
+		if recipient = strings.Trim(strings.TrimSpace(recipient), "<>"); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
 }
 
 type ConnectionInfo struct {
@@ -29,6 +99,11 @@ type AuthenticationMetadata struct {
 	SPF   SPFMetadata   `json:"spf,omitempty"`
 	DKIM  DKIMMetadata  `json:"dkim,omitempty"`
 	DMARC DMARCMetadata `json:"dmarc,omitempty"`
+
+	// Results is the Authentication-Results value GoMail computed, or ""
+	// when it did not check the message. Unlike DMARC.AuthenticationResults
+	// it never comes from the message's own headers.
+	Results string `json:"results,omitempty"`
 }
 
 type SPFMetadata struct {
@@ -50,6 +125,37 @@ type DMARCMetadata struct {
 	AuthenticationResults string `json:"authentication_results,omitempty"`
 }
 
+// SPFResult returns the SPF result GoMail computed, e.g. "pass", or "" if none
+func (a AuthenticationMetadata) SPFResult() string {
+	return authResult(a.Results, "spf")
+}
+
+// DKIMResult returns the first DKIM result GoMail computed, or "" if none
+func (a AuthenticationMetadata) DKIMResult() string {
+	return authResult(a.Results, "dkim")
+}
+
+// DMARCResult returns the DMARC result GoMail computed, or "" if none
+func (a AuthenticationMetadata) DMARCResult() string {
+	return authResult(a.Results, "dmarc")
+}
+
+// authResult extracts the first method=result value from an
+// Authentication-Results header (RFC 8601)
+func authResult(header, method string) string {
+	prefix := method + "="
+	for _, part := range strings.Split(header, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if value := strings.ToLower(fields[0]); strings.HasPrefix(value, prefix) {
+			return strings.TrimPrefix(value, prefix)
+		}
+	}
+	return ""
+}
+
 func ParseRawEmail(rawEmail string, httpHeaders map[string]string) (*EmailData, error) {
 	// Parse email headers
 	msg, err := mail.ReadMessage(strings.NewReader(rawEmail))
@@ -64,23 +170,35 @@ func ParseRawEmail(rawEmail string, httpHeaders map[string]string) (*EmailData,
 
 	// Extract basic headers
 	header := msg.Header
-	data.Subject = header.Get("Subject")
+	data.Subject = DecodeHeader(header.Get("Subject"))
 	data.MessageID = header.Get("Message-ID")
 
-	// Extract From and To
-	if from, err := mail.ParseAddress(header.Get("From")); err == nil {
+	// Decode the bodies and describe the attachments
+	content := parseMIME(textproto.MIMEHeader(header), msg.Body)
+	data.TextBody = content.textBody
+	data.HTMLBody = content.htmlBody
+	data.Attachments = content.attachments
+	data.MIME = content.root
+	data.Bounce = parseBounce(rawEmail, textproto.MIMEHeader(header), content)
+	data.Complaint = parseComplaint(rawEmail, content.root)
+
+	// Extract From and the header recipients
+	if from, err := addressParser.Parse(header.Get("From")); err == nil {
 		data.Sender = from.Address
 	} else {
 		data.Sender = header.Get("From")
 	}
 
-	if to := header.Get("To"); to != "" {
-		if addr, err := mail.ParseAddress(to); err == nil {
-			data.Recipient = addr.Address
-		} else {
-			data.Recipient = to
-		}
+	data.To = parseAddressList(header.Get("To"))
+	data.Cc = parseAddressList(header.Get("Cc"))
+	data.ReplyTo = parseAddressList(header.Get("Reply-To"))
+
+	// Without an envelope the header recipients are the best guess
+	var recipients []string
+	for _, addr := range append(append([]Address{}, data.To...), data.Cc...) {
+		recipients = append(recipients, addr.Address)
 	}
+	data.SetRecipients(recipients)
 
 	// Extract connection info from HTTP headers
 	data.Connection = ConnectionInfo{
@@ -96,13 +214,46 @@ func ParseRawEmail(rawEmail string, httpHeaders map[string]string) (*EmailData,
 	if sender := httpHeaders["X-Original-Sender"]; sender != "" {
 		data.Sender = sender
 	}
-	if recipient := httpHeaders["X-Original-Recipient"]; recipient != "" {
-		data.Recipient = recipient
+	if recipients := SplitRecipients(httpHeaders["X-Original-Recipient"]); len(recipients) > 0 {
+		data.SetRecipients(recipients)
 	}
 
 	return data, nil
 }
 
+// addressParser decodes RFC 2047 display names in any charset htmlindex knows
+var addressParser = &mail.AddressParser{WordDecoder: wordDecoder}
+
+// parseAddressList parses an address header. A list that does not parse as
+// a whole is split on commas and each address salvaged on its own.
+func parseAddressList(value string) []Address {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	if list, err := addressParser.ParseList(value); err == nil {
+		addresses := make([]Address, 0, len(list))
+		for _, addr := range list {
+			addresses = append(addresses, Address{Name: addr.Name, Address: addr.Address})
+		}
+		return addresses
+	}
+
+	var addresses []Address
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if addr, err := addressParser.Parse(field); err == nil {
+			addresses = append(addresses, Address{Name: addr.Name, Address: addr.Address})
+		} else {
+			addresses = append(addresses, Address{Address: strings.Trim(field, "<>")})
+		}
+	}
+	return addresses
+}
+
 func extractAuthenticationMetadata(header mail.Header, httpHeaders map[string]string) AuthenticationMetadata {
 	auth := AuthenticationMetadata{}
 
@@ -185,6 +336,17 @@ func FromJSON(data map[string]interface{}) *EmailData {
 	if v, ok := data["recipient"].(string); ok {
 		email.Recipient = v
 	}
+	if list, ok := data["recipients"].([]interface{}); ok {
+		var recipients []string
+		for _, v := range list {
+			if recipient, ok := v.(string); ok {
+				recipients = append(recipients, recipient)
+			}
+		}
+		if len(recipients) > 0 {
+			email.SetRecipients(recipients)
+		}
+	}
 	if v, ok := data["raw"].(string); ok {
 		email.Raw = v
 	}
@@ -215,6 +377,15 @@ func FromJSON(data map[string]interface{}) *EmailData {
 			if email.MessageID == "" {
 				email.MessageID = parsed.MessageID
 			}
+			email.To = parsed.To
+			email.Cc = parsed.Cc
+			email.ReplyTo = parsed.ReplyTo
+			email.TextBody = parsed.TextBody
+			email.HTMLBody = parsed.HTMLBody
+			email.Attachments = parsed.Attachments
+			email.MIME = parsed.MIME
+			email.Bounce = parsed.Bounce
+			email.Complaint = parsed.Complaint
 		}
 	}
 
@@ -2,6 +2,7 @@ package mail
 
 import (
 	"bufio"
+	"encoding/json"
 	"net/mail"
 	"strings"
 	"testing"
@@ -65,6 +66,51 @@ Body.`,
 				assert.Equal(t, "override-to@example.com", data.Recipient)
 			},
 		},
+		{
+			name: "email with several header recipients",
+			rawEmail: `From: sender@example.com
+To: "Smith, Jane" <jane@example.com>, bob@example.com
+Cc: =?UTF-8?Q?J=C3=BCrgen?= <juergen@example.com>, jane@example.com
+Reply-To: Support <support@example.com>
+
+Body.`,
+			httpHeaders: map[string]string{},
+			validate: func(t *testing.T, data *EmailData) {
+				assert.Equal(t, []Address{{Name: "Smith, Jane", Address: "jane@example.com"}, {Address: "bob@example.com"}}, data.To)
+				assert.Equal(t, []Address{{Name: "Jürgen", Address: "juergen@example.com"}, {Address: "jane@example.com"}}, data.Cc)
+				assert.Equal(t, []Address{{Name: "Support", Address: "support@example.com"}}, data.ReplyTo)
+
+				// Without an envelope the header recipients are used, once each
+				assert.Equal(t, []string{"jane@example.com", "bob@example.com", "juergen@example.com"}, data.Recipients)
+				assert.Equal(t, "jane@example.com", data.Recipient)
+			},
+		},
+		{
+			name: "email with several envelope recipients",
+			rawEmail: `From: sender@example.com
+To: list@example.com
+
+Body.`,
+			httpHeaders: map[string]string{
+				"X-Original-Recipient": "alice@example.com, <bob@example.com>,,",
+			},
+			validate: func(t *testing.T, data *EmailData) {
+				assert.Equal(t, []string{"alice@example.com", "bob@example.com"}, data.Recipients)
+				assert.Equal(t, "alice@example.com", data.Recipient)
+				assert.Equal(t, []Address{{Address: "list@example.com"}}, data.To)
+			},
+		},
+		{
+			name: "email with unparseable To",
+			rawEmail: `From: sender@example.com
+To: good@example.com, not an address
+
+Body.`,
+			httpHeaders: map[string]string{},
+			validate: func(t *testing.T, data *EmailData) {
+				assert.Equal(t, []Address{{Address: "good@example.com"}, {Address: "not an address"}}, data.To)
+			},
+		},
 		{
 			name: "email with connection info",
 			rawEmail: `From: sender@example.com
@@ -265,6 +311,18 @@ func TestFromJSON(t *testing.T) {
 				assert.Equal(t, "raw email content", email.Raw)
 			},
 		},
+		{
+			name: "recipient list",
+			data: map[string]interface{}{
+				"sender":     "sender@example.com",
+				"recipient":  "ignored@example.com",
+				"recipients": []interface{}{"first@example.com", "second@example.com", 42},
+			},
+			validate: func(t *testing.T, email *EmailData) {
+				assert.Equal(t, []string{"first@example.com", "second@example.com"}, email.Recipients)
+				assert.Equal(t, "first@example.com", email.Recipient)
+			},
+		},
 		{
 			name: "with connection info",
 			data: map[string]interface{}{
@@ -320,6 +378,56 @@ Body.`,
 	}
 }
 
+func TestFromJSON_RoundTrip(t *testing.T) {
+	raw := strings.ReplaceAll(`From: Alice <alice@example.com>
+To: bob@example.net
+Subject: Report
+Message-ID: <report-1@example.com>
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/plain; charset=utf-8
+
+Plain body
+--alt
+Content-Type: text/html; charset=utf-8
+
+<p>HTML body</p>
+--alt--
+--b
+Content-Type: application/pdf; name="report.pdf"
+Content-Disposition: attachment; filename="report.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQK
+--b--
+`, "\n", "\r\n")
+
+	parsed, err := ParseRawEmail(raw, map[string]string{"X-Original-Recipient": "bob@example.net"})
+	require.NoError(t, err)
+	require.Len(t, parsed.Attachments, 1)
+
+	encoded, err := json.Marshal(parsed)
+	require.NoError(t, err)
+	var data map[string]interface{}
+	require.NoError(t, json.Unmarshal(encoded, &data))
+
+	email := FromJSON(data)
+	assert.Equal(t, parsed.Sender, email.Sender)
+	assert.Equal(t, parsed.Recipients, email.Recipients)
+	assert.Equal(t, parsed.Subject, email.Subject)
+	assert.Equal(t, parsed.MessageID, email.MessageID)
+	assert.Equal(t, parsed.To, email.To)
+	assert.Equal(t, "Plain body", strings.TrimSpace(email.TextBody))
+	assert.Equal(t, parsed.TextBody, email.TextBody)
+	assert.Equal(t, parsed.HTMLBody, email.HTMLBody)
+	assert.Equal(t, parsed.Attachments, email.Attachments)
+	assert.Equal(t, parsed.MIME, email.MIME)
+}
+
 func TestParseHeaders(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -384,6 +492,24 @@ From: sender@example.com
 	}
 }
 
+func TestAuthenticationResults(t *testing.T) {
+	auth := AuthenticationMetadata{
+		Results: "mx.example.com; spf=pass smtp.mailfrom=example.com; dkim=FAIL header.d=example.com; dkim=pass header.d=other.example; dmarc=none",
+	}
+	assert.Equal(t, "pass", auth.SPFResult())
+	assert.Equal(t, "fail", auth.DKIMResult())
+	assert.Equal(t, "none", auth.DMARCResult())
+
+	// The message's own headers are not results GoMail computed
+	auth = AuthenticationMetadata{
+		SPF:   SPFMetadata{ReceivedSPFHeader: "Pass (example.com: designates 192.0.2.1)"},
+		DMARC: DMARCMetadata{AuthenticationResults: "evil.example; spf=pass; dkim=pass; dmarc=pass"},
+	}
+	assert.Empty(t, auth.SPFResult())
+	assert.Empty(t, auth.DKIMResult())
+	assert.Empty(t, auth.DMARCResult())
+}
+
 func BenchmarkParseRawEmail(b *testing.B) {
 	rawEmail := `From: sender@example.com
 To: recipient@example.com
@@ -0,0 +1,196 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// PartRewriter is called by RewriteParts for every leaf part with its
+// number, header, and transfer-encoded body. It returns the new body and
+// whether to replace the old one.
+type PartRewriter func(part string, header textproto.MIMEHeader, body string) (string, bool, error)
+
+// RewriteParts returns raw with leaf part bodies replaced as rewrite
+// decides. Everything else, including headers and boundaries, is kept byte
+// for byte. Parts are numbered as in MIMEPart.Part; on damaged multipart
+// bodies the numbering may disagree with ParseRawEmail, so callers should
+// check a part's content before replacing it.
+func RewriteParts(raw string, rewrite PartRewriter) (string, error) {
+	headerEnd, bodyStart := splitHeader(raw, 0, len(raw))
+
+	var spans []partSpan
+	collectSpans(raw, parseHeaderBlock(raw[:headerEnd]), bodyStart, len(raw), "", 0, &spans)
+
+	var out strings.Builder
+	last := 0
+	for _, span := range spans {
+		body, replace, err := rewrite(span.number, span.header, raw[span.start:span.end])
+		if err != nil {
+			return "", err
+		}
+		if !replace {
+			continue
+		}
+		out.WriteString(raw[last:span.start])
+		out.WriteString(body)
+		last = span.end
+	}
+	out.WriteString(raw[last:])
+
+	return out.String(), nil
+}
+
+// DecodeTransfer undoes a part's Content-Transfer-Encoding, as far as the
+// content allows
+func DecodeTransfer(encoding, body string) []byte {
+	content, _ := decodeTransfer(strings.ToLower(strings.TrimSpace(encoding)), strings.NewReader(body))
+	return content
+}
+
+// EncodeTransfer applies a Content-Transfer-Encoding to content, breaking
+// lines with newline. It is the inverse of DecodeTransfer.
+func EncodeTransfer(encoding string, content []byte, newline string) string {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		encoded := base64.StdEncoding.EncodeToString(content)
+		lines := make([]string, 0, len(encoded)/76+1)
+		for len(encoded) > 76 {
+			lines = append(lines, encoded[:76])
+			encoded = encoded[76:]
+		}
+		lines = append(lines, encoded)
+		return strings.Join(lines, newline)
+	case "quoted-printable":
+		var buf bytes.Buffer
+		w := quotedprintable.NewWriter(&buf)
+		_, _ = w.Write(content)
+		_ = w.Close()
+		return strings.ReplaceAll(buf.String(), "\r\n", newline)
+	default:
+		return string(content)
+	}
+}
+
+// Newline returns the line ending a raw message uses
+func Newline(raw string) string {
+	if i := strings.Index(raw, "\n"); i > 0 && raw[i-1] == '\r' {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// partSpan locates a leaf part's body in a raw message
+type partSpan struct {
+	number     string
+	header     textproto.MIMEHeader
+	start, end int
+}
+
+func collectSpans(raw string, header textproto.MIMEHeader, start, end int, number string, depth int, spans *[]partSpan) {
+	mediaType, params := parseContentType(header.Get("Content-Type"))
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		if depth >= maxMIMEDepth {
+			return
+		}
+
+		delimiters := findDelimiters(raw, start, end, params["boundary"])
+		for i, d := range delimiters {
+			if d.closing || len(*spans) >= maxMIMEParts {
+				break
+			}
+
+			// A missing closing boundary leaves the last part running to the end
+			partEnd := end
+			if i+1 < len(delimiters) {
+				partEnd = delimiters[i+1].start
+			}
+
+			childNumber := strconv.Itoa(i + 1)
+			if number != "" {
+				childNumber = number + "." + childNumber
+			}
+
+			headerEnd, bodyStart := splitHeader(raw, d.next, partEnd)
+			collectSpans(raw, parseHeaderBlock(raw[d.next:headerEnd]), bodyStart, partEnd, childNumber, depth+1, spans)
+		}
+		return
+	}
+
+	if number == "" {
+		number = "1"
+	}
+	*spans = append(*spans, partSpan{number: number, header: header, start: start, end: end})
+}
+
+// delimiter is a boundary line within a multipart body
+type delimiter struct {
+	start   int // the line break before the boundary belongs to it (RFC 2046)
+	next    int // first byte after the boundary line
+	closing bool
+}
+
+func findDelimiters(raw string, start, end int, boundary string) []delimiter {
+	dash := "--" + boundary
+	var found []delimiter
+
+	for pos := start; pos < end; {
+		lineEnd := strings.IndexByte(raw[pos:end], '\n')
+		next := end
+		if lineEnd >= 0 {
+			next = pos + lineEnd + 1
+		}
+
+		line := strings.TrimRight(raw[pos:next], " \t\r\n")
+		if rest, ok := strings.CutPrefix(line, dash); ok && (rest == "" || rest == "--") {
+			d := delimiter{start: pos, next: next, closing: rest == "--"}
+			if pos > start {
+				d.start--
+				if d.start > start && raw[d.start-1] == '\r' {
+					d.start--
+				}
+			}
+			found = append(found, d)
+		}
+
+		pos = next
+	}
+
+	return found
+}
+
+// splitHeader finds the blank line ending the header block in
+// raw[start:end], returning where the header ends and the body starts
+func splitHeader(raw string, start, end int) (int, int) {
+	s := raw[start:end]
+	switch {
+	case strings.HasPrefix(s, "\r\n"):
+		return start, start + 2
+	case strings.HasPrefix(s, "\n"):
+		return start, start + 1
+	}
+
+	crlf := strings.Index(s, "\n\r\n")
+	lf := strings.Index(s, "\n\n")
+	switch {
+	case crlf >= 0 && (lf < 0 || crlf < lf):
+		return start + crlf + 1, start + crlf + 3
+	case lf >= 0:
+		return start + lf + 1, start + lf + 2
+	default:
+		return end, end
+	}
+}
+
+func parseHeaderBlock(block string) textproto.MIMEHeader {
+	header, _ := textproto.NewReader(bufio.NewReader(strings.NewReader(block + "\r\n"))).ReadMIMEHeader()
+	if header == nil {
+		header = textproto.MIMEHeader{}
+	}
+	return header
+}
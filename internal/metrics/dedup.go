@@ -0,0 +1,14 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// DedupUnreadableMessages counts stored messages skipped while building
+	// the deduplication index because they could not be loaded
+	DedupUnreadableMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gomail_dedup_unreadable_messages_total",
+		Help: "Total number of stored messages left out of the deduplication index because they could not be loaded",
+	})
+)
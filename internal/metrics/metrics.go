@@ -186,6 +186,45 @@ func Init() {
 		_ = prometheus.Register(PlaintextConnections)
 		_ = prometheus.Register(TLSRequiredRejections)
 
+		// Register webhook metrics
+		_ = prometheus.Register(WebhookDeliveries)
+		_ = prometheus.Register(WebhookDeliveryDuration)
+		_ = prometheus.Register(WebhookPending)
+		_ = prometheus.Register(WebhookEvents)
+
+		// Register retention metrics
+		_ = prometheus.Register(RetentionPurgedMessages)
+		_ = prometheus.Register(RetentionPurgedBytes)
+		_ = prometheus.Register(RetentionRuns)
+		_ = prometheus.Register(RetentionStoredBytes)
+		_ = prometheus.Register(RetentionPurgedBlobs)
+
+		// Register deduplication metrics
+		_ = prometheus.Register(DedupUnreadableMessages)
+
+		// Register SMTP receiver metrics
+		_ = prometheus.Register(SMTPSessions)
+		_ = prometheus.Register(SMTPActiveSessions)
+		_ = prometheus.Register(SMTPMessages)
+
+		// Register milter metrics
+		_ = prometheus.Register(MilterSessions)
+		_ = prometheus.Register(MilterMessages)
+
+		// Register outbound metrics
+		_ = prometheus.Register(OutboundSubmissions)
+		_ = prometheus.Register(OutboundQueueMessages)
+		_ = prometheus.Register(OutboundQueueOldestAge)
+		_ = prometheus.Register(OutboundDeliveryAttempts)
+		_ = prometheus.Register(OutboundRecipientAttempts)
+		_ = prometheus.Register(OutboundDSNs)
+		_ = prometheus.Register(OutboundSuppressed)
+		_ = prometheus.Register(SuppressionsAdded)
+
+		// Register recipient policy metrics
+		_ = prometheus.Register(RecipientChecks)
+		_ = prometheus.Register(PolicyRequests)
+
 		// Register authentication metrics
 		initAuthMetrics()
 
@@ -240,6 +279,45 @@ func Reset() {
 	prometheus.Unregister(PlaintextConnections)
 	prometheus.Unregister(TLSRequiredRejections)
 
+	// Unregister webhook metrics
+	prometheus.Unregister(WebhookDeliveries)
+	prometheus.Unregister(WebhookDeliveryDuration)
+	prometheus.Unregister(WebhookPending)
+	prometheus.Unregister(WebhookEvents)
+
+	// Unregister retention metrics
+	prometheus.Unregister(RetentionPurgedMessages)
+	prometheus.Unregister(RetentionPurgedBytes)
+	prometheus.Unregister(RetentionRuns)
+	prometheus.Unregister(RetentionStoredBytes)
+	prometheus.Unregister(RetentionPurgedBlobs)
+
+	// Unregister deduplication metrics
+	prometheus.Unregister(DedupUnreadableMessages)
+
+	// Unregister SMTP receiver metrics
+	prometheus.Unregister(SMTPSessions)
+	prometheus.Unregister(SMTPActiveSessions)
+	prometheus.Unregister(SMTPMessages)
+
+	// Unregister milter metrics
+	prometheus.Unregister(MilterSessions)
+	prometheus.Unregister(MilterMessages)
+
+	// Unregister outbound metrics
+	prometheus.Unregister(OutboundSubmissions)
+	prometheus.Unregister(OutboundQueueMessages)
+	prometheus.Unregister(OutboundQueueOldestAge)
+	prometheus.Unregister(OutboundDeliveryAttempts)
+	prometheus.Unregister(OutboundRecipientAttempts)
+	prometheus.Unregister(OutboundDSNs)
+	prometheus.Unregister(OutboundSuppressed)
+	prometheus.Unregister(SuppressionsAdded)
+
+	// Unregister recipient policy metrics
+	prometheus.Unregister(RecipientChecks)
+	prometheus.Unregister(PolicyRequests)
+
 	// Unregister authentication metrics
 	prometheus.Unregister(SPFPass)
 	prometheus.Unregister(SPFFail)
@@ -0,0 +1,19 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// MilterSessions counts MTA connections handled by the milter
+	MilterSessions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gomail_milter_sessions_total",
+		Help: "Total number of milter connections handled",
+	})
+
+	// MilterMessages counts the milter's verdicts on messages
+	MilterMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_milter_messages_total",
+		Help: "Total number of messages checked by the milter",
+	}, []string{"action"}) // "accept", "quarantine", "reject", "tempfail", "skipped"
+)
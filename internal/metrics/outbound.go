@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// OutboundSubmissions counts messages submitted on POST /mail/send
+	OutboundSubmissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_outbound_submissions_total",
+		Help: "Total number of messages submitted for outbound delivery",
+	}, []string{"result"}) // "queued", "rejected", "error"
+
+	// OutboundQueueMessages tracks messages waiting in the outbound queue
+	OutboundQueueMessages = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gomail_outbound_queue_messages",
+		Help: "Number of messages in the outbound queue",
+	})
+
+	// OutboundQueueOldestAge tracks how long the oldest queued message has waited
+	OutboundQueueOldestAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gomail_outbound_queue_oldest_age_seconds",
+		Help: "Age of the oldest message in the outbound queue in seconds",
+	})
+
+	// OutboundDeliveryAttempts counts delivery attempts per recipient by result
+	OutboundDeliveryAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_outbound_delivery_attempts_total",
+		Help: "Total number of outbound delivery attempts per recipient",
+	}, []string{"result"}) // "delivered", "deferred", "failed", "expired"
+
+	// OutboundRecipientAttempts tracks how many attempts recipients needed
+	// before they were delivered or failed
+	OutboundRecipientAttempts = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gomail_outbound_recipient_attempts",
+		Help:    "Delivery attempts per recipient until delivery or failure",
+		Buckets: []float64{1, 2, 3, 5, 8, 13, 21},
+	})
+
+	// OutboundDSNs counts delivery status notifications sent to senders
+	OutboundDSNs = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gomail_outbound_dsn_total",
+		Help: "Total number of delivery status notifications queued for senders",
+	})
+
+	// OutboundSuppressed counts recipients dropped from send requests because
+	// they are on the suppression list
+	OutboundSuppressed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gomail_outbound_suppressed_recipients_total",
+		Help: "Total number of recipients not sent to because they are suppressed",
+	})
+
+	// SuppressionsAdded counts addresses added to the suppression list by reason
+	SuppressionsAdded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_suppressions_added_total",
+		Help: "Total number of addresses added to the suppression list",
+	}, []string{"reason"}) // "hard_bounce", "complaint", "manual", ...
+)
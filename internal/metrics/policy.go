@@ -0,0 +1,19 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// RecipientChecks counts recipient policy decisions
+	RecipientChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_recipient_checks_total",
+		Help: "Total number of recipient policy checks",
+	}, []string{"result"}) // "accepted", "unknown_user", "unknown_domain"
+
+	// PolicyRequests counts Postfix policy delegation requests by action
+	PolicyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_policy_requests_total",
+		Help: "Total number of Postfix policy service requests",
+	}, []string{"action"}) // "dunno", "defer", "reject"
+)
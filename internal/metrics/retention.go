@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// RetentionPurgedMessages counts messages deleted by the retention janitor
+	RetentionPurgedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_retention_purged_messages_total",
+		Help: "Total number of stored messages deleted by retention policies",
+	}, []string{"reason"}) // "age", "size"
+
+	// RetentionPurgedBytes counts the size of messages deleted by the retention janitor
+	RetentionPurgedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_retention_purged_bytes_total",
+		Help: "Total size in bytes of stored messages deleted by retention policies",
+	}, []string{"reason"})
+
+	// RetentionRuns tracks janitor passes by result
+	RetentionRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_retention_runs_total",
+		Help: "Total number of retention janitor runs",
+	}, []string{"result"}) // "success", "error"
+
+	// RetentionPurgedBlobs counts attachment blobs deleted once no stored
+	// message referred to them
+	RetentionPurgedBlobs = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gomail_retention_purged_blobs_total",
+		Help: "Total number of unreferenced attachment blobs deleted",
+	})
+
+	// RetentionStoredBytes tracks the size of messages kept after the last run
+	RetentionStoredBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gomail_retention_stored_bytes",
+		Help: "Size in bytes of stored messages after the last retention run",
+	})
+)
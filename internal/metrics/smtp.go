@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// SMTPSessions counts connections handled by the SMTP receiver
+	SMTPSessions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gomail_smtp_sessions_total",
+		Help: "Total number of SMTP sessions handled",
+	})
+
+	// SMTPActiveSessions tracks currently open SMTP sessions
+	SMTPActiveSessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gomail_smtp_active_sessions",
+		Help: "Number of open SMTP sessions",
+	})
+
+	// SMTPMessages counts DATA transactions by outcome
+	SMTPMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_smtp_messages_total",
+		Help: "Total number of messages offered over SMTP",
+	}, []string{"result"}) // "accepted", "rejected", "deferred"
+)
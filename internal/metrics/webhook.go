@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// WebhookDeliveries tracks webhook delivery attempts by result
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_webhook_deliveries_total",
+		Help: "Total number of webhook delivery attempts",
+	}, []string{"result"}) // "success", "error", "exhausted"
+
+	// WebhookDeliveryDuration tracks how long webhook requests take
+	WebhookDeliveryDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gomail_webhook_delivery_duration_seconds",
+		Help:    "Webhook delivery request duration in seconds",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// WebhookPending tracks messages waiting in the inbox for delivery
+	WebhookPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gomail_webhook_pending_messages",
+		Help: "Number of stored messages waiting for webhook delivery",
+	})
+
+	// WebhookEvents tracks event deliveries, such as delivery.failed, by result
+	WebhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomail_webhook_events_total",
+		Help: "Total number of webhook event delivery attempts",
+	}, []string{"result"}) // "success", "error", "exhausted"
+)
@@ -0,0 +1,119 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The Sendmail milter protocol, version 6, as spoken by Sendmail 8.14+ and
+// Postfix 2.6+. Every packet is a 32-bit big-endian length, covering the
+// command byte and its data, followed by the command byte and the data.
+const (
+	version = 6
+
+	// maxPacketSize bounds a single packet; MTAs send the body in chunks
+	// of at most 64KB
+	maxPacketSize = 1024 * 1024
+)
+
+// Commands sent by the MTA
+const (
+	cmdAbort   = 'A' // abort the current message
+	cmdBody    = 'B' // body chunk
+	cmdConnect = 'C' // client connection
+	cmdMacro   = 'D' // macros for the following command
+	cmdEOB     = 'E' // end of body
+	cmdHelo    = 'H' // HELO/EHLO name
+	cmdQuitNC  = 'K' // quit, but keep the connection for a new client
+	cmdHeader  = 'L' // one header
+	cmdMail    = 'M' // MAIL FROM
+	cmdEOH     = 'N' // end of headers
+	cmdOptNeg  = 'O' // option negotiation
+	cmdQuit    = 'Q' // close the connection
+	cmdRcpt    = 'R' // RCPT TO
+	cmdData    = 'T' // DATA
+	cmdUnknown = 'U' // unrecognized SMTP command
+)
+
+// Replies sent by the milter
+const (
+	respAccept     = 'a' // accept the message, no more callbacks for it
+	respContinue   = 'c' // carry on; after end of body, accept
+	respInsHeader  = 'i' // insert a header at an index
+	respChgHeader  = 'm' // change or, with an empty value, delete a header
+	respOptNeg     = 'O' // option negotiation
+	respQuarantine = 'q' // put the message on hold
+	respReject     = 'r' // reject with the MTA's default reply
+	respTempFail   = 't' // defer with the MTA's default reply
+	respReplyCode  = 'y' // reject or defer with the given reply
+)
+
+// Actions the milter may take, negotiated with OPTNEG
+const (
+	actionAddHeaders    = 0x01
+	actionChangeHeaders = 0x10
+	actionQuarantine    = 0x20
+)
+
+// Protocol flags, negotiated with OPTNEG
+const (
+	protoNoUnknown  = 0x100    // do not send unrecognized SMTP commands
+	protoHeaderLead = 0x100000 // header values keep their leading space
+)
+
+// packet is one milter protocol message
+type packet struct {
+	cmd  byte
+	data []byte
+}
+
+func readPacket(r io.Reader) (*packet, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size == 0 || size > maxPacketSize {
+		return nil, fmt.Errorf("invalid milter packet size %d", size)
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return &packet{cmd: buf[0], data: buf[1:]}, nil
+}
+
+func writePacket(w io.Writer, cmd byte, data []byte) error {
+	buf := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)+1))
+	buf[4] = cmd
+	_, err := w.Write(append(buf, data...))
+	return err
+}
+
+// splitStrings splits NUL-terminated strings, as used for macros, headers
+// and SMTP command arguments
+func splitStrings(data []byte) []string {
+	data = bytes.TrimSuffix(data, []byte{0})
+	if len(data) == 0 {
+		return nil
+	}
+	parts := bytes.Split(data, []byte{0})
+	strs := make([]string, len(parts))
+	for i, part := range parts {
+		strs[i] = string(part)
+	}
+	return strs
+}
+
+// joinStrings is the inverse of splitStrings
+func joinStrings(strs ...string) []byte {
+	var buf bytes.Buffer
+	for _, s := range strs {
+		buf.WriteString(s)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,185 @@
+package milter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/auth"
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/security"
+	"github.com/grumpyguvner/gomail/internal/smtp"
+	"go.uber.org/zap"
+)
+
+// idleTimeout matches Postfix's milter_content_timeout, after which the MTA
+// gives up on the milter anyway
+const idleTimeout = 300 * time.Second
+
+// verifier is implemented by auth.Middleware
+type verifier interface {
+	VerifyInbound(ctx context.Context, sourceIP net.IP, heloHost string, mailFrom string, message []byte) (*auth.AuthenticationResult, error)
+	FormatAuthenticationResults(result *auth.AuthenticationResult, hostname string) string
+}
+
+// Server is a Sendmail milter (protocol version 6) for MTAs that deliver
+// mail themselves but want GoMail's checks:
+//
+//	smtpd_milters = unix:private/gomail-milter
+//	milter_default_action = tempfail
+//
+// At the end of each message it runs SPF, DKIM and DMARC, replaces any
+// Authentication-Results header claiming its authserv-id with its own, and
+// tells the MTA to accept, quarantine,
+// reject or defer the message. With milter_store_copy a copy of every
+// message that is not rejected is handed to the Handler, which stores it
+// and forwards it to webhooks.
+type Server struct {
+	hostname       string // authserv-id; the MTA's name when empty
+	verifier       verifier
+	handler        smtp.Handler // nil unless milter_store_copy is set
+	maxSize        int
+	handlerTimeout time.Duration
+	limiter        *security.ConnectionLimiter
+	logger         *zap.SugaredLogger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	sessions sync.WaitGroup
+	closing  atomic.Bool
+}
+
+// NewServer creates a milter from the milter_*, spf_*, dkim_* and dmarc_*
+// settings. Copies are handed to handler when milter_store_copy is set.
+func NewServer(cfg *config.Config, handler smtp.Handler) (*Server, error) {
+	middleware, err := auth.NewMiddleware(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	maxConnections := cfg.MilterMaxConnections
+	if maxConnections <= 0 {
+		maxConnections = 100
+	}
+
+	s := &Server{
+		hostname:       cfg.MailHostname,
+		verifier:       middleware,
+		maxSize:        cfg.SMTPMaxMessageSizeMB * 1024 * 1024,
+		handlerTimeout: time.Duration(cfg.HandlerTimeout) * time.Second,
+		limiter:        security.NewConnectionLimiter(maxConnections, maxConnections, time.Minute),
+		logger:         logging.Get(),
+		conns:          make(map[net.Conn]struct{}),
+	}
+	if cfg.MilterStoreCopy {
+		s.handler = handler
+	}
+
+	return s, nil
+}
+
+// Serve accepts connections on listener until Shutdown is called. The MTA
+// opens one connection per SMTP session and may reuse it afterwards.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.closing.Load() {
+				return nil
+			}
+			if isTimeout(err) {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		// A refused connection makes the MTA apply milter_default_action
+		remote := conn.RemoteAddr().String()
+		if !s.limiter.Accept(remote) {
+			_ = conn.Close()
+			continue
+		}
+
+		if !s.track(conn) {
+			s.limiter.Release(remote)
+			_ = conn.Close()
+			continue
+		}
+
+		go func() {
+			defer s.sessions.Done()
+			defer s.limiter.Release(remote)
+			defer s.untrack(conn)
+
+			newSession(s, conn).serve()
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and closes open ones, which makes
+// the MTA apply milter_default_action to messages in progress. Connections
+// still open when ctx expires are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+
+	s.mu.Lock()
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing.Load() {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.sessions.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
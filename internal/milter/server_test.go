@@ -0,0 +1,313 @@
+package milter
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/grumpyguvner/gomail/internal/auth"
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/grumpyguvner/gomail/internal/smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVerifier gives every message action and records what it checked
+type fakeVerifier struct {
+	mu       sync.Mutex
+	action   string
+	messages []string
+}
+
+func (f *fakeVerifier) VerifyInbound(ctx context.Context, sourceIP net.IP, heloHost string, mailFrom string, message []byte) (*auth.AuthenticationResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages = append(f.messages, string(message))
+	return &auth.AuthenticationResult{
+		SPF:    &auth.SPFResult{Result: authres.ResultPass, Domain: "example.net", IP: sourceIP.String()},
+		DMARC:  &auth.DMARCResult{Result: authres.ResultFail, Domain: "example.net"},
+		Action: f.action,
+	}, nil
+}
+
+func (f *fakeVerifier) FormatAuthenticationResults(result *auth.AuthenticationResult, hostname string) string {
+	return hostname + "; spf=" + string(result.SPF.Result)
+}
+
+// recordingHandler stores envelopes, or fails with err
+type recordingHandler struct {
+	mu        sync.Mutex
+	err       error
+	envelopes []*smtp.Envelope
+}
+
+func (h *recordingHandler) Receive(ctx context.Context, env *smtp.Envelope) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.err != nil {
+		return h.err
+	}
+	h.envelopes = append(h.envelopes, env)
+	return nil
+}
+
+// mta plays the MTA's side of a milter connection
+type mta struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func (m *mta) send(cmd byte, data []byte) {
+	require.NoError(m.t, writePacket(m.conn, cmd, data))
+}
+
+func (m *mta) recv() *packet {
+	pkt, err := readPacket(m.conn)
+	require.NoError(m.t, err)
+	return pkt
+}
+
+// command sends cmd and expects a continue reply
+func (m *mta) command(cmd byte, data []byte) {
+	m.send(cmd, data)
+	assert.Equal(m.t, byte(respContinue), m.recv().cmd, "reply to %q", cmd)
+}
+
+// negotiate offers protocol version 6 with every action and protocol flag
+func (m *mta) negotiate() *packet {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:4], 6)
+	binary.BigEndian.PutUint32(data[4:8], 0x1ff)
+	binary.BigEndian.PutUint32(data[8:12], 0x1fffff)
+	m.send(cmdOptNeg, data)
+	return m.recv()
+}
+
+// message sends one message from a TCP client, up to and including EOB
+func (m *mta) message(from string) {
+	m.send(cmdMacro, append([]byte{cmdConnect}, joinStrings("j", "mx.example.com")...))
+	m.command(cmdConnect, append(append([]byte("mail.example.net\x004"), 0, 25), joinStrings("192.0.2.1")...))
+	m.command(cmdHelo, joinStrings("mail.example.net"))
+	m.send(cmdMacro, append([]byte{cmdMail}, joinStrings("{i}", "4ABCD")...))
+	m.command(cmdMail, joinStrings("<"+from+">", "SIZE=100"))
+	m.command(cmdRcpt, joinStrings("<bob@example.com>"))
+	m.command(cmdRcpt, joinStrings("<carol@example.com>"))
+	m.command(cmdData, nil)
+	m.command(cmdHeader, joinStrings("From", " Alice <"+from+">"))
+	m.command(cmdHeader, joinStrings("Subject", " hello\n\tworld"))
+	m.command(cmdEOH, nil)
+	m.command(cmdBody, []byte("body\r\n"))
+	m.send(cmdEOB, nil)
+}
+
+func startServer(t *testing.T, verifier *fakeVerifier, handler smtp.Handler) *mta {
+	t.Helper()
+
+	server, err := NewServer(&config.Config{MilterStoreCopy: handler != nil, SMTPMaxMessageSizeMB: 1}, handler)
+	require.NoError(t, err)
+	server.verifier = verifier
+
+	// MTAs usually talk to milters over a socket
+	socket := filepath.Join(t.TempDir(), "milter.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	return &mta{t: t, conn: conn}
+}
+
+func TestServer_Accept(t *testing.T) {
+	verifier := &fakeVerifier{action: "accept"}
+	handler := &recordingHandler{}
+	m := startServer(t, verifier, handler)
+
+	reply := m.negotiate()
+	require.Equal(t, byte(respOptNeg), reply.cmd)
+	assert.Equal(t, uint32(6), binary.BigEndian.Uint32(reply.data[0:4]))
+	assert.Equal(t, uint32(actionAddHeaders|actionChangeHeaders|actionQuarantine), binary.BigEndian.Uint32(reply.data[4:8]))
+	assert.Equal(t, uint32(protoNoUnknown|protoHeaderLead), binary.BigEndian.Uint32(reply.data[8:12]))
+
+	m.message("alice@example.net")
+
+	// Authentication-Results goes on top, then the message is accepted
+	insert := m.recv()
+	require.Equal(t, byte(respInsHeader), insert.cmd)
+	assert.Equal(t, uint32(0), binary.BigEndian.Uint32(insert.data[0:4]))
+	assert.Equal(t, []string{"Authentication-Results", " mx.example.com; spf=pass"}, splitStrings(insert.data[4:]))
+	assert.Equal(t, byte(respContinue), m.recv().cmd)
+
+	require.Len(t, verifier.messages, 1)
+	assert.Equal(t, "From: Alice <alice@example.net>\r\nSubject: hello\r\n\tworld\r\n\r\nbody\r\n", verifier.messages[0])
+
+	require.Len(t, handler.envelopes, 1)
+	env := handler.envelopes[0]
+	assert.Equal(t, "4ABCD", env.SessionID)
+	assert.Equal(t, "192.0.2.1", env.ClientIP.String())
+	assert.Equal(t, "mail.example.net", env.Helo)
+	assert.Equal(t, "alice@example.net", env.From)
+	assert.Equal(t, []string{"bob@example.com", "carol@example.com"}, env.Recipients)
+	assert.NotNil(t, env.Auth, "the handler does not verify the message again")
+
+	m.send(cmdQuit, nil)
+}
+
+func TestServer_Verdicts(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     string
+		handlerErr error
+		want       []byte // reply commands after EOB
+		reply      string // text of a 'y' reply
+		stored     bool
+	}{
+		{"quarantine", "quarantine", nil, []byte{respInsHeader, respQuarantine, respContinue}, "", true},
+		{"reject", "reject", nil, []byte{respReplyCode}, "550 5.7.1 Message rejected by DMARC policy of example.net", false},
+		{"copy rejected by validation", "accept", errors.ValidationError("Email validation failed", nil), []byte{respInsHeader, respContinue}, "", false},
+		{"copy not stored", "accept", errors.StorageError("Failed to store email", fmt.Errorf("disk full")), []byte{respReplyCode}, "451 4.3.0 Requested action aborted: local error in processing", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &recordingHandler{err: tt.handlerErr}
+			m := startServer(t, &fakeVerifier{action: tt.action}, handler)
+			m.negotiate()
+			m.message("alice@example.net")
+
+			for _, cmd := range tt.want {
+				reply := m.recv()
+				require.Equal(t, cmd, reply.cmd)
+				if cmd == respReplyCode {
+					assert.Equal(t, []string{tt.reply}, splitStrings(reply.data))
+				}
+			}
+			assert.Equal(t, tt.stored, len(handler.envelopes) == 1)
+		})
+	}
+}
+
+func TestServer_LocalSubmission(t *testing.T) {
+	verifier := &fakeVerifier{action: "accept"}
+	handler := &recordingHandler{}
+	m := startServer(t, verifier, handler)
+
+	// Without HDR_LEADSPC header values have no leading space
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:4], 6)
+	binary.BigEndian.PutUint32(data[4:8], actionAddHeaders)
+	m.send(cmdOptNeg, data)
+	m.recv()
+
+	m.command(cmdConnect, append([]byte("localhost\x00L"), joinStrings("/var/run/submit")...))
+	m.command(cmdMail, joinStrings("<root@example.com>"))
+	m.command(cmdRcpt, joinStrings("<bob@example.com>"))
+	m.command(cmdHeader, joinStrings("Subject", "cron"))
+	m.command(cmdBody, []byte("output\r\n"))
+	m.send(cmdEOB, nil)
+
+	// Nothing to check SPF against, so no header is added and the handler
+	// is told the checks were skipped
+	assert.Equal(t, byte(respContinue), m.recv().cmd)
+	assert.Empty(t, verifier.messages)
+	require.Len(t, handler.envelopes, 1)
+	assert.Equal(t, "127.0.0.1", handler.envelopes[0].ClientIP.String())
+	require.NotNil(t, handler.envelopes[0].Auth)
+	assert.Equal(t, "accept", handler.envelopes[0].Auth.Action)
+	assert.Nil(t, handler.envelopes[0].Auth.SPF)
+	assert.Equal(t, "Subject: cron\r\n\r\noutput\r\n", string(handler.envelopes[0].Data))
+
+	// An aborted message is forgotten and the connection can be reused
+	m.command(cmdMail, joinStrings("<root@example.com>"))
+	m.send(cmdAbort, nil)
+	m.send(cmdQuitNC, nil)
+	m.command(cmdConnect, append([]byte("localhost\x00L"), joinStrings("/var/run/submit")...))
+}
+
+func TestServer_ForgedAuthenticationResults(t *testing.T) {
+	verifier := &fakeVerifier{action: "accept"}
+	handler := &recordingHandler{}
+	m := startServer(t, verifier, handler)
+	m.negotiate()
+
+	m.send(cmdMacro, append([]byte{cmdConnect}, joinStrings("j", "mx.example.com")...))
+	m.command(cmdConnect, append(append([]byte("mail.example.net\x004"), 0, 25), joinStrings("192.0.2.1")...))
+	m.command(cmdMail, joinStrings("<alice@example.net>"))
+	m.command(cmdRcpt, joinStrings("<bob@example.com>"))
+	m.command(cmdHeader, joinStrings("Authentication-Results", " MX.example.com; dmarc=pass"))
+	m.command(cmdHeader, joinStrings("Authentication-Results", " relay.example.net; dkim=pass"))
+	m.command(cmdHeader, joinStrings("Authentication-Results", " (forged) mx.example.com 1; spf=pass"))
+	m.command(cmdHeader, joinStrings("Subject", " hi"))
+	m.command(cmdBody, []byte("body\r\n"))
+	m.send(cmdEOB, nil)
+
+	// Ours are deleted last first, then the real results are added
+	for _, index := range []uint32{3, 1} {
+		change := m.recv()
+		require.Equal(t, byte(respChgHeader), change.cmd)
+		assert.Equal(t, index, binary.BigEndian.Uint32(change.data[0:4]))
+		assert.Equal(t, []string{"Authentication-Results", ""}, splitStrings(change.data[4:]))
+	}
+	assert.Equal(t, byte(respInsHeader), m.recv().cmd)
+	assert.Equal(t, byte(respContinue), m.recv().cmd)
+
+	// Neither the checks nor the stored copy see the forged headers
+	want := "Authentication-Results: relay.example.net; dkim=pass\r\nSubject: hi\r\n\r\nbody\r\n"
+	require.Len(t, verifier.messages, 1)
+	assert.Equal(t, want, verifier.messages[0])
+	require.Len(t, handler.envelopes, 1)
+	assert.Equal(t, want, string(handler.envelopes[0].Data))
+}
+
+func TestAuthservIDOf(t *testing.T) {
+	assert.Equal(t, "mx.example.com", authservIDOf(" mx.example.com; spf=pass"))
+	assert.Equal(t, "mx.example.com", authservIDOf("mx.example.com 1; none"))
+	assert.Equal(t, "mx.example.com", authservIDOf(" (comment) mx.example.com;"))
+	assert.Equal(t, "", authservIDOf(" ; spf=pass"))
+}
+
+func TestServer_Oversized(t *testing.T) {
+	verifier := &fakeVerifier{action: "reject"}
+	m := startServer(t, verifier, nil)
+	m.negotiate()
+
+	m.command(cmdConnect, append(append([]byte("mail.example.net\x004"), 0, 25), joinStrings("192.0.2.1")...))
+	m.command(cmdMail, joinStrings("<alice@example.net>"))
+	chunk := make([]byte, 64*1024)
+	for i := 0; i < 17; i++ {
+		m.command(cmdBody, chunk)
+	}
+	m.send(cmdEOB, nil)
+
+	// Messages over smtp_max_message_size_mb pass unchecked
+	assert.Equal(t, byte(respContinue), m.recv().cmd)
+	assert.Empty(t, verifier.messages)
+}
+
+func TestServer_OldProtocol(t *testing.T) {
+	m := startServer(t, &fakeVerifier{}, nil)
+
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:4], 2)
+	m.send(cmdOptNeg, data)
+
+	_, err := readPacket(m.conn)
+	assert.Error(t, err, "connection closed")
+}
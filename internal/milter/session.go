@@ -0,0 +1,442 @@
+package milter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/auth"
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/grumpyguvner/gomail/internal/smtp"
+	"go.uber.org/zap"
+)
+
+// session is one MTA connection. It lasts for one SMTP session, or several
+// when the MTA reuses the connection after QUIT_NC.
+type session struct {
+	server *Server
+	conn   net.Conn
+	reader *bufio.Reader
+	logger *zap.SugaredLogger
+
+	// Negotiated with OPTNEG
+	actions  uint32
+	protocol uint32
+
+	// Set per SMTP session
+	macros   map[string]string
+	clientIP net.IP // nil for local submissions
+	helo     string
+
+	// Set per message
+	from        string
+	recipients  []string
+	header      bytes.Buffer
+	body        bytes.Buffer
+	size        int
+	oversized   bool
+	authResults int   // Authentication-Results headers seen
+	forged      []int // indexes of those claiming our authserv-id
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server: server,
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		logger: server.logger,
+		macros: make(map[string]string),
+	}
+}
+
+func (s *session) serve() {
+	defer func() { _ = s.conn.Close() }()
+
+	metrics.MilterSessions.Inc()
+
+	for {
+		_ = s.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		pkt, err := readPacket(s.reader)
+		if err != nil {
+			if err != io.EOF && !isTimeout(err) && !s.server.closing.Load() {
+				s.logger.Warnf("Milter connection from %s failed: %v", s.conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if !s.handle(pkt) {
+			return
+		}
+	}
+}
+
+// handle answers one command and reports whether the connection stays open
+func (s *session) handle(pkt *packet) bool {
+	switch pkt.cmd {
+	case cmdOptNeg:
+		return s.negotiate(pkt.data)
+	case cmdMacro:
+		// Macros come ahead of the command they belong to and get no reply
+		if len(pkt.data) > 0 {
+			s.setMacros(pkt.data[1:])
+		}
+		return true
+	case cmdConnect:
+		s.connect(pkt.data)
+	case cmdHelo:
+		if args := splitStrings(pkt.data); len(args) > 0 {
+			s.helo = args[0]
+		}
+	case cmdMail:
+		s.resetMessage()
+		if args := splitStrings(pkt.data); len(args) > 0 {
+			s.from = trimAddress(args[0])
+		}
+	case cmdRcpt:
+		if args := splitStrings(pkt.data); len(args) > 0 {
+			s.recipients = append(s.recipients, trimAddress(args[0]))
+		}
+	case cmdHeader:
+		s.addHeader(splitStrings(pkt.data))
+	case cmdBody:
+		s.addBody(pkt.data)
+	case cmdEOB:
+		s.addBody(pkt.data)
+		return s.endOfMessage()
+	case cmdAbort:
+		s.endMessage()
+		return true
+	case cmdQuitNC:
+		s.resetConnection()
+		return true
+	case cmdQuit:
+		return false
+	case cmdData, cmdEOH, cmdUnknown:
+	default:
+		s.logger.Debugf("Milter ignoring unknown command %q", pkt.cmd)
+	}
+
+	return s.reply(respContinue, nil)
+}
+
+// negotiate agrees on the protocol version, the actions the milter may take
+// and the steps the MTA sends
+func (s *session) negotiate(data []byte) bool {
+	if len(data) < 12 {
+		s.logger.Warnf("Milter got a short option negotiation from %s", s.conn.RemoteAddr())
+		return false
+	}
+	mtaVersion := binary.BigEndian.Uint32(data[0:4])
+	mtaActions := binary.BigEndian.Uint32(data[4:8])
+	mtaProtocol := binary.BigEndian.Uint32(data[8:12])
+
+	if mtaVersion < version {
+		s.logger.Warnf("MTA at %s speaks milter protocol version %d, version %d is required", s.conn.RemoteAddr(), mtaVersion, version)
+		return false
+	}
+	s.actions = mtaActions & (actionAddHeaders | actionChangeHeaders | actionQuarantine)
+	s.protocol = mtaProtocol & (protoNoUnknown | protoHeaderLead)
+
+	reply := make([]byte, 12)
+	binary.BigEndian.PutUint32(reply[0:4], version)
+	binary.BigEndian.PutUint32(reply[4:8], s.actions)
+	binary.BigEndian.PutUint32(reply[8:12], s.protocol)
+	return s.reply(respOptNeg, reply)
+}
+
+func (s *session) setMacros(data []byte) {
+	pairs := splitStrings(data)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		s.macros[strings.Trim(pairs[i], "{}")] = pairs[i+1]
+	}
+}
+
+// connect records the client from "hostname\0 family port address\0". The
+// family is '4' or '6' for TCP clients; local submissions have no address.
+func (s *session) connect(data []byte) {
+	s.clientIP = nil
+
+	_, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 4 || (rest[0] != '4' && rest[0] != '6') {
+		return
+	}
+	addr := string(bytes.TrimSuffix(rest[3:], []byte{0}))
+	if len(addr) > 5 && strings.EqualFold(addr[:5], "IPv6:") {
+		addr = addr[5:]
+	}
+	s.clientIP = net.ParseIP(addr)
+
+	s.logger = s.server.logger.With("client", addr)
+}
+
+func (s *session) addHeader(fields []string) {
+	if len(fields) < 2 {
+		return
+	}
+	name, value := fields[0], fields[1]
+
+	// Results under our authserv-id can only be forged by the sender, so
+	// they are removed (RFC 8601 section 5) even from unchecked messages
+	if strings.EqualFold(name, "Authentication-Results") {
+		s.authResults++
+		if strings.EqualFold(authservIDOf(value), s.authservID()) {
+			s.forged = append(s.forged, s.authResults)
+			return
+		}
+	}
+	if s.oversized {
+		return
+	}
+
+	// Folded values come with bare newlines
+	value = strings.ReplaceAll(strings.ReplaceAll(value, "\r\n", "\n"), "\n", "\r\n")
+	if s.protocol&protoHeaderLead == 0 {
+		value = " " + value
+	}
+
+	s.size += len(name) + len(value) + 3
+	if s.checkSize() {
+		s.header.WriteString(name + ":" + value + "\r\n")
+	}
+}
+
+func (s *session) addBody(chunk []byte) {
+	if len(chunk) == 0 || s.oversized {
+		return
+	}
+	s.size += len(chunk)
+	if s.checkSize() {
+		s.body.Write(chunk)
+	}
+}
+
+// checkSize stops collecting messages larger than smtp_max_message_size_mb;
+// they are passed without checks
+func (s *session) checkSize() bool {
+	if s.server.maxSize > 0 && s.size > s.server.maxSize {
+		s.oversized = true
+		s.header.Reset()
+		s.body.Reset()
+		return false
+	}
+	return true
+}
+
+// endOfMessage authenticates the collected message and sends the verdict
+func (s *session) endOfMessage() bool {
+	defer s.endMessage()
+
+	logger := s.logger.With("queue_id", s.queueID(), "from", s.from)
+	if s.oversized {
+		logger.Warnf("Message larger than %d bytes passed without checks", s.server.maxSize)
+		metrics.MilterMessages.WithLabelValues("skipped").Inc()
+		if !s.removeForged(logger) {
+			return false
+		}
+		return s.reply(respContinue, nil)
+	}
+
+	message := make([]byte, 0, s.header.Len()+2+s.body.Len())
+	message = append(message, s.header.Bytes()...)
+	message = append(message, "\r\n"...)
+	message = append(message, s.body.Bytes()...)
+
+	ctx := context.Background()
+	if s.server.handlerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.server.handlerTimeout)
+		defer cancel()
+	}
+
+	// Locally submitted mail has no client to check SPF against
+	var result *auth.AuthenticationResult
+	if s.clientIP != nil {
+		var err error
+		result, err = s.server.verifier.VerifyInbound(ctx, s.clientIP, s.helo, s.from, message)
+		if err != nil {
+			logger.Warnf("Authentication verification error: %v", err)
+		}
+	}
+
+	if result != nil && result.Action == "reject" {
+		domain := ""
+		if result.DMARC != nil {
+			domain = result.DMARC.Domain
+		}
+		logger.Infow("Milter rejected message", "reason", "DMARC policy", "domain", domain)
+		metrics.MilterMessages.WithLabelValues("reject").Inc()
+		return s.reply(respReplyCode, joinStrings(fmt.Sprintf("550 5.7.1 Message rejected by DMARC policy of %s", domain)))
+	}
+
+	if s.server.handler != nil {
+		env := &smtp.Envelope{
+			SessionID:  s.queueID(),
+			ClientIP:   s.clientIP,
+			Helo:       s.helo,
+			From:       s.from,
+			Recipients: s.recipients,
+			Data:       message,
+			Auth:       result,
+		}
+		if env.ClientIP == nil {
+			// Nothing was checked; an empty result stops the handler
+			// checking SPF against the loopback address instead
+			env.ClientIP = net.IPv4(127, 0, 0, 1)
+			env.Auth = &auth.AuthenticationResult{Action: "accept"}
+		}
+		if err := s.server.handler.Receive(ctx, env); err != nil {
+			if appErr, ok := errors.AsAppError(err); ok && (appErr.Type == errors.ErrorTypeValidation || appErr.Type == errors.ErrorTypeBadRequest) {
+				// The MTA delivers the message either way
+				logger.Warnf("Copy of message not stored: %v", err)
+			} else {
+				// Defer so the MTA retries rather than GoMail losing its copy
+				logger.Errorf("Failed to store copy of message: %v", err)
+				metrics.MilterMessages.WithLabelValues("tempfail").Inc()
+				return s.reply(respReplyCode, joinStrings("451 4.3.0 Requested action aborted: local error in processing"))
+			}
+		}
+	}
+
+	if !s.removeForged(logger) {
+		return false
+	}
+	if result != nil && s.actions&actionAddHeaders != 0 {
+		value := s.server.verifier.FormatAuthenticationResults(result, s.authservID())
+		if s.protocol&protoHeaderLead != 0 {
+			value = " " + value
+		}
+		index := make([]byte, 4)
+		if !s.reply(respInsHeader, append(index, joinStrings("Authentication-Results", value)...)) {
+			return false
+		}
+	}
+
+	if result != nil && result.Action == "quarantine" && s.actions&actionQuarantine != 0 {
+		logger.Infow("Milter quarantined message", "reason", "DMARC policy")
+		metrics.MilterMessages.WithLabelValues("quarantine").Inc()
+		if !s.reply(respQuarantine, joinStrings("DMARC policy")) {
+			return false
+		}
+	} else {
+		metrics.MilterMessages.WithLabelValues("accept").Inc()
+	}
+
+	return s.reply(respContinue, nil)
+}
+
+// removeForged asks the MTA to delete the Authentication-Results headers
+// carrying our authserv-id. Indexes count headers of the same name, so the
+// last is deleted first to keep the others in place.
+func (s *session) removeForged(logger *zap.SugaredLogger) bool {
+	if len(s.forged) == 0 {
+		return true
+	}
+	if s.actions&actionChangeHeaders == 0 {
+		logger.Warnf("MTA does not allow header changes, %d forged Authentication-Results header(s) left in place", len(s.forged))
+		return true
+	}
+
+	for i := len(s.forged) - 1; i >= 0; i-- {
+		index := make([]byte, 4)
+		binary.BigEndian.PutUint32(index, uint32(s.forged[i]))
+		if !s.reply(respChgHeader, append(index, joinStrings("Authentication-Results", "")...)) {
+			return false
+		}
+	}
+	logger.Infow("Milter removed forged Authentication-Results", "count", len(s.forged))
+	return true
+}
+
+func (s *session) reply(cmd byte, data []byte) bool {
+	_ = s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := writePacket(s.conn, cmd, data); err != nil {
+		s.logger.Warnf("Milter reply to %s failed: %v", s.conn.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
+
+// queueID is the MTA's queue ID for the message, or a random ID when the
+// MTA does not send the "i" macro
+func (s *session) queueID() string {
+	if id := s.macros["i"]; id != "" {
+		return id
+	}
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	s.macros["i"] = hex.EncodeToString(id)
+	return s.macros["i"]
+}
+
+// authservID names the host in Authentication-Results: mail_hostname, or
+// the MTA's own name from the "j" macro
+func (s *session) authservID() string {
+	if s.server.hostname != "" {
+		return s.server.hostname
+	}
+	if host := s.macros["j"]; host != "" {
+		return host
+	}
+	return "localhost"
+}
+
+func (s *session) resetMessage() {
+	s.from = ""
+	s.recipients = nil
+	s.header.Reset()
+	s.body.Reset()
+	s.size = 0
+	s.oversized = false
+	s.authResults = 0
+	s.forged = nil
+}
+
+// endMessage forgets the message once the MTA is done with it. The queue
+// ID macro arrives ahead of MAIL, so it is not cleared by resetMessage.
+func (s *session) endMessage() {
+	s.resetMessage()
+	delete(s.macros, "i")
+}
+
+func (s *session) resetConnection() {
+	s.resetMessage()
+	s.macros = make(map[string]string)
+	s.clientIP = nil
+	s.helo = ""
+	s.logger = s.server.logger
+}
+
+// authservIDOf returns the authserv-id an Authentication-Results value
+// starts with, skipping comments and the optional version
+func authservIDOf(value string) string {
+	id, _, _ := strings.Cut(value, ";")
+	for {
+		start := strings.IndexByte(id, '(')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(id[start:], ')')
+		if end < 0 {
+			id = id[:start]
+			break
+		}
+		id = id[:start] + " " + id[start+end+1:]
+	}
+	if fields := strings.Fields(id); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// trimAddress strips the angle brackets from a MAIL or RCPT argument
+func trimAddress(addr string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "<"), ">")
+}
@@ -0,0 +1,282 @@
+package outbound
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Resolver looks up where mail for a domain goes. *net.Resolver satisfies it.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// deliveryError is a failure that applies to every recipient of an attempt
+type deliveryError struct {
+	response  string
+	permanent bool
+}
+
+func (e *deliveryError) Error() string {
+	return e.response
+}
+
+// tlsError is a failed STARTTLS, after which the host is tried again
+// without TLS
+type tlsError struct {
+	err error
+}
+
+func (e *tlsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *tlsError) Unwrap() error {
+	return e.err
+}
+
+// lookupHosts returns the hosts accepting mail for domain, most preferred
+// first. A domain without MX records is its own mail host (RFC 5321 section
+// 5.1), and a null MX (RFC 7505) means it accepts no mail at all.
+func lookupHosts(ctx context.Context, resolver Resolver, domain string) ([]string, error) {
+	records, err := resolver.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return nil, &deliveryError{response: fmt.Sprintf("MX lookup for %s failed: %v", domain, err)}
+		}
+		records = nil
+	}
+	if len(records) == 0 {
+		return []string{domain}, nil
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Pref < records[j].Pref
+	})
+
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, mx := range records {
+		host := strings.ToLower(strings.TrimSuffix(mx.Host, "."))
+		if host == "" {
+			if len(records) == 1 {
+				return nil, &deliveryError{response: fmt.Sprintf("domain %s does not accept mail (null MX)", domain), permanent: true}
+			}
+			continue
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// reply is a complete SMTP reply, such as "250 2.0.0 Ok: queued as 4Xk9"
+type reply struct {
+	code int
+	text string
+}
+
+func (r reply) String() string {
+	return fmt.Sprintf("%d %s", r.code, strings.ReplaceAll(r.text, "\n", " "))
+}
+
+func (r reply) ok() bool {
+	return r.code >= 200 && r.code < 400
+}
+
+func (r reply) permanent() bool {
+	return r.code >= 500
+}
+
+// client is one SMTP connection to a remote mail host
+type client struct {
+	conn       net.Conn
+	text       *textproto.Conn
+	host       string
+	timeout    time.Duration
+	extensions map[string]string
+	tlsVersion string
+}
+
+// dialClient connects to host, says EHLO and starts TLS when the server
+// offers it. TLS is opportunistic (RFC 7435): certificates are not checked,
+// since most MX hosts do not present one matching their name, and a host
+// whose STARTTLS fails is connected to again in plaintext, as it would
+// have been had it not offered TLS at all.
+func dialClient(ctx context.Context, dial DialFunc, host, port, hostname string, timeout time.Duration) (*client, error) {
+	c, err := connect(ctx, dial, host, port, hostname, timeout, true)
+	var tlsErr *tlsError
+	if errors.As(err, &tlsErr) {
+		return connect(ctx, dial, host, port, hostname, timeout, false)
+	}
+	return c, err
+}
+
+// connect opens one session with host, starting TLS when useTLS is set and
+// the server offers it
+func connect(ctx context.Context, dial DialFunc, host, port, hostname string, timeout time.Duration, useTLS bool) (*client, error) {
+	conn, err := dial(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		conn:    conn,
+		text:    textproto.NewConn(conn),
+		host:    host,
+		timeout: timeout,
+	}
+
+	greeting, err := c.read()
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	if !greeting.ok() {
+		c.close()
+		return nil, fmt.Errorf("greeting: %s", greeting)
+	}
+
+	if err := c.hello(hostname); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	if _, ok := c.extensions["STARTTLS"]; ok && useTLS {
+		if err := c.startTLS(hostname); err != nil {
+			c.close()
+			return nil, &tlsError{err: err}
+		}
+	}
+	return c, nil
+}
+
+// hello sends EHLO, falling back to HELO for servers without ESMTP
+func (c *client) hello(hostname string) error {
+	r, err := c.cmd("EHLO %s", hostname)
+	if err != nil {
+		return err
+	}
+	if r.ok() {
+		c.extensions = make(map[string]string)
+		lines := strings.Split(r.text, "\n")
+		for _, line := range lines[1:] {
+			keyword, params, _ := strings.Cut(line, " ")
+			c.extensions[strings.ToUpper(keyword)] = params
+		}
+		return nil
+	}
+
+	r, err = c.cmd("HELO %s", hostname)
+	if err != nil {
+		return err
+	}
+	if !r.ok() {
+		return fmt.Errorf("HELO: %s", r)
+	}
+	c.extensions = map[string]string{}
+	return nil
+}
+
+func (c *client) startTLS(hostname string) error {
+	r, err := c.cmd("STARTTLS")
+	if err != nil {
+		return err
+	}
+	if !r.ok() {
+		return fmt.Errorf("STARTTLS: %s", r)
+	}
+
+	tlsConn := tls.Client(c.conn, &tls.Config{
+		ServerName:         c.host,
+		InsecureSkipVerify: true, // opportunistic, see dialClient
+		MinVersion:         tls.VersionTLS12,
+	})
+	if err := c.deadline(); err != nil {
+		return err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake: %w", err)
+	}
+	c.conn = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	c.tlsVersion = tls.VersionName(tlsConn.ConnectionState().Version)
+
+	// The server forgets everything it said before TLS
+	return c.hello(hostname)
+}
+
+// mail sends MAIL FROM, with the message size when the server takes SIZE
+func (c *client) mail(from string, size int) (reply, error) {
+	if _, ok := c.extensions["SIZE"]; ok {
+		return c.cmd("MAIL FROM:<%s> SIZE=%d", from, size)
+	}
+	return c.cmd("MAIL FROM:<%s>", from)
+}
+
+func (c *client) rcpt(to string) (reply, error) {
+	return c.cmd("RCPT TO:<%s>", to)
+}
+
+// data sends the message and returns the server's final reply
+func (c *client) data(raw []byte) (reply, error) {
+	r, err := c.cmd("DATA")
+	if err != nil || r.code != 354 {
+		return r, err
+	}
+
+	if err := c.deadline(); err != nil {
+		return reply{}, err
+	}
+	w := c.text.DotWriter()
+	if _, err := w.Write(raw); err != nil {
+		return reply{}, err
+	}
+	if err := w.Close(); err != nil {
+		return reply{}, err
+	}
+	return c.read()
+}
+
+// quit ends the session politely and closes the connection
+func (c *client) quit() {
+	_, _ = c.cmd("QUIT")
+	c.close()
+}
+
+func (c *client) close() {
+	_ = c.conn.Close()
+}
+
+func (c *client) cmd(format string, args ...interface{}) (reply, error) {
+	if err := c.deadline(); err != nil {
+		return reply{}, err
+	}
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return reply{}, err
+	}
+	return c.read()
+}
+
+func (c *client) read() (reply, error) {
+	if err := c.deadline(); err != nil {
+		return reply{}, err
+	}
+	code, text, err := c.text.ReadResponse(0)
+	if err != nil {
+		return reply{}, err
+	}
+	return reply{code: code, text: text}, nil
+}
+
+func (c *client) deadline() error {
+	return c.conn.SetDeadline(time.Now().Add(c.timeout))
+}
@@ -0,0 +1,646 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultPollInterval = 10 * time.Second
+	connectTimeout      = 30 * time.Second
+)
+
+// Defaults for settings left at zero, matching the config defaults
+var defaultRetrySchedule = []int{5, 10, 30, 60, 120, 240}
+
+const (
+	defaultMaxAge            = 120 // hours
+	defaultMaxConcurrency    = 20
+	defaultDomainConcurrency = 2
+	defaultTimeout           = 300 // seconds
+)
+
+// Delivery event types, published for every recipient of a message from a
+// sender once the recipient is delivered or has failed
+const (
+	EventDelivered = "delivery.delivered"
+	EventFailed    = "delivery.failed"
+)
+
+// Publisher queues events for the sender's webhook; webhook.Events
+// implements it
+type Publisher interface {
+	Publish(eventType, requestID, address string, data interface{}) error
+}
+
+// DeliveryEvent is the data of delivery.delivered and delivery.failed events
+type DeliveryEvent struct {
+	ID         string `json:"id"` // outbound queue ID returned by POST /mail/send
+	MessageID  string `json:"message_id"`
+	From       string `json:"from"`
+	Recipient  string `json:"recipient"`
+	Status     string `json:"status"` // RFC 3463 status code
+	Response   string `json:"response"`
+	RemoteMX   string `json:"remote_mx,omitempty"`
+	TLSVersion string `json:"tls_version,omitempty"`
+	Attempts   int    `json:"attempts"`
+	Expired    bool   `json:"expired,omitempty"`
+	DSNID      string `json:"dsn_id,omitempty"` // queue ID of the bounce sent to the sender
+}
+
+// DialFunc opens a connection to a mail host, like (*net.Dialer).DialContext
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// domainState tracks deliveries to one destination domain
+type domainState struct {
+	active  int
+	limiter *rate.Limiter // nil without a rate limit
+}
+
+// result is the outcome of one attempt for one recipient
+type result struct {
+	status     string // StatusDelivered, StatusFailed, or StatusQueued to retry
+	response   string
+	remoteMX   string
+	tlsVersion string
+}
+
+// Dispatcher delivers queued messages to the recipients' mail hosts. Each
+// destination domain of a message is delivered on its own connection, within
+// the per-domain concurrency and rate limits.
+type Dispatcher struct {
+	queue             *Queue
+	hostname          string
+	resolver          Resolver
+	dial              DialFunc
+	port              string
+	timeout           time.Duration
+	retrySchedule     []time.Duration
+	maxAge            time.Duration
+	maxConcurrency    int
+	domainConcurrency int
+	domainRate        int
+	pollInterval      time.Duration
+	publisher         Publisher     // nil when no webhook is configured
+	suppressions      *Suppressions // nil to leave hard bounces unsuppressed
+	bounceTTL         time.Duration // how long hard bounces stay suppressed, 0 for good
+	logger            *zap.SugaredLogger
+
+	// mu guards the delivery state and every read-modify-write of a queued
+	// message, since several domains of one message may finish together
+	mu       sync.Mutex
+	active   int
+	inFlight map[string]bool // "<id> <domain>"
+	domains  map[string]*domainState
+	wg       sync.WaitGroup
+	wake     chan struct{}
+	now      func() time.Time
+
+	sentPruned time.Time // last pass over the queue's sent messages
+}
+
+// NewDispatcher creates an outbound dispatcher for the given queue
+func NewDispatcher(cfg *config.Config, queue *Queue) *Dispatcher {
+	schedule := cfg.OutboundRetrySchedule
+	if len(schedule) == 0 {
+		schedule = defaultRetrySchedule
+	}
+	retrySchedule := make([]time.Duration, len(schedule))
+	for i, minutes := range schedule {
+		retrySchedule[i] = time.Duration(minutes) * time.Minute
+	}
+
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	return &Dispatcher{
+		queue:             queue,
+		hostname:          cfg.MailHostname,
+		resolver:          net.DefaultResolver,
+		dial:              dialer.DialContext,
+		port:              "25",
+		timeout:           time.Duration(orDefault(cfg.OutboundTimeout, defaultTimeout)) * time.Second,
+		retrySchedule:     retrySchedule,
+		maxAge:            time.Duration(orDefault(cfg.OutboundMaxAge, defaultMaxAge)) * time.Hour,
+		maxConcurrency:    orDefault(cfg.OutboundMaxConcurrency, defaultMaxConcurrency),
+		domainConcurrency: orDefault(cfg.OutboundDomainConcurrency, defaultDomainConcurrency),
+		domainRate:        cfg.OutboundDomainRate,
+		pollInterval:      defaultPollInterval,
+		bounceTTL:         time.Duration(cfg.SuppressionBounceExpiry) * time.Hour,
+		logger:            logging.Get(),
+		inFlight:          make(map[string]bool),
+		domains:           make(map[string]*domainState),
+		wake:              make(chan struct{}, 1),
+		now:               time.Now,
+	}
+}
+
+// SetPublisher sends delivery events to p
+func (d *Dispatcher) SetPublisher(p Publisher) {
+	d.publisher = p
+}
+
+// SetSuppressions adds recipients that bounce hard to s
+func (d *Dispatcher) SetSuppressions(s *Suppressions) {
+	d.suppressions = s
+}
+
+// suppress adds a permanently failed recipient to the suppression list if
+// the failure was a hard bounce
+func (d *Dispatcher) suppress(logger *zap.SugaredLogger, rcpt *Recipient) {
+	if d.suppressions == nil {
+		return
+	}
+	status := dsnStatus(rcpt)
+	if mail.ClassifyBounce(status, rcpt.Response) != mail.BounceHard {
+		return
+	}
+
+	detail := rcpt.Response
+	if detail == "" {
+		detail = status
+	}
+	added, err := d.suppressions.Suppress(rcpt.Address, ReasonHardBounce, detail, d.bounceTTL)
+	if err != nil {
+		logger.Errorf("Failed to suppress %s: %v", rcpt.Address, err)
+	} else if added {
+		logger.Infow("Address suppressed after hard bounce", "address", rcpt.Address, "status", status)
+	}
+}
+
+func orDefault(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Run delivers queued messages until the context is cancelled, then waits
+// for deliveries in progress
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.logger.Infof("Outbound dispatcher started: max_concurrency=%d, domain_concurrency=%d, domain_rate=%d/min, max_age=%v",
+		d.maxConcurrency, d.domainConcurrency, d.domainRate, d.maxAge)
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.ProcessPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			d.wg.Wait()
+			d.logger.Info("Outbound dispatcher stopped")
+			return
+		case <-ticker.C:
+		case <-d.wake:
+			// A delivery finished, so its domain may take the next one
+		}
+	}
+}
+
+// ProcessPending makes one pass over the queue, starting a delivery for
+// every destination domain of every message that is due
+func (d *Dispatcher) ProcessPending(ctx context.Context) {
+	messages, err := d.queue.List()
+	if err != nil {
+		d.logger.Errorf("Failed to list outbound queue: %v", err)
+		return
+	}
+
+	now := d.now()
+	metrics.OutboundQueueMessages.Set(float64(len(messages)))
+	if len(messages) > 0 {
+		metrics.OutboundQueueOldestAge.Set(now.Sub(messages[0].QueuedAt).Seconds())
+	} else {
+		metrics.OutboundQueueOldestAge.Set(0)
+	}
+	d.pruneDomains(now)
+	if now.Sub(d.sentPruned) >= time.Hour {
+		d.sentPruned = now
+		if err := d.queue.PruneSent(); err != nil {
+			d.logger.Errorf("Failed to prune sent messages: %v", err)
+		}
+	}
+
+	for _, msg := range messages {
+		if ctx.Err() != nil {
+			return
+		}
+		for _, domain := range dueDomains(msg, now) {
+			if !d.acquire(msg.ID, domain) {
+				continue
+			}
+			d.wg.Add(1)
+			go func(id, domain string) {
+				defer d.wg.Done()
+				defer d.release(id, domain)
+				d.attempt(ctx, id, domain)
+			}(msg.ID, domain)
+		}
+	}
+}
+
+// dueDomains returns the domains of msg with recipients due for an attempt
+func dueDomains(msg *Message, now time.Time) []string {
+	var domains []string
+	seen := make(map[string]bool)
+	for _, rcpt := range msg.Recipients {
+		if rcpt.Status != StatusQueued || now.Before(rcpt.NextAttempt) {
+			continue
+		}
+		if domain := rcpt.Domain(); !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// acquire reserves a delivery slot for one domain of a message, or reports
+// that the limits do not allow one yet
+func (d *Dispatcher) acquire(id, domain string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := id + " " + domain
+	if d.inFlight[key] || d.active >= d.maxConcurrency {
+		return false
+	}
+
+	state, ok := d.domains[domain]
+	if !ok {
+		state = &domainState{}
+		if d.domainRate > 0 {
+			state.limiter = rate.NewLimiter(rate.Limit(float64(d.domainRate)/60), d.domainConcurrency)
+		}
+		d.domains[domain] = state
+	}
+	if state.active >= d.domainConcurrency {
+		return false
+	}
+	if state.limiter != nil && !state.limiter.AllowN(d.now(), 1) {
+		return false
+	}
+
+	d.inFlight[key] = true
+	d.active++
+	state.active++
+	return true
+}
+
+func (d *Dispatcher) release(id, domain string) {
+	d.mu.Lock()
+	delete(d.inFlight, id+" "+domain)
+	d.active--
+	d.domains[domain].active--
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pruneDomains forgets domains with nothing in flight and a full rate limit
+func (d *Dispatcher) pruneDomains(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for domain, state := range d.domains {
+		if state.active == 0 && (state.limiter == nil || state.limiter.TokensAt(now) >= float64(state.limiter.Burst())) {
+			delete(d.domains, domain)
+		}
+	}
+}
+
+// attempt delivers one message to the due recipients in one domain
+func (d *Dispatcher) attempt(ctx context.Context, id, domain string) {
+	// Recipients come from the current state rather than the listing, which
+	// may predate a delivery that has finished since
+	msg, err := d.queue.Get(id)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			d.logger.Errorf("Failed to load outbound message %s: %v", id, err)
+		}
+		return
+	}
+
+	now := d.now()
+	var recipients []string
+	for _, rcpt := range msg.Recipients {
+		if rcpt.Status == StatusQueued && rcpt.Domain() == domain && !now.Before(rcpt.NextAttempt) {
+			recipients = append(recipients, rcpt.Address)
+		}
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	raw, err := d.queue.Raw(id)
+	if err != nil {
+		d.logger.Errorf("Failed to load outbound message %s: %v", id, err)
+		return
+	}
+
+	results := d.deliver(ctx, msg.From, raw, domain, recipients)
+	if ctx.Err() != nil {
+		// Interrupted by shutdown, which is not the remote server's fault
+		return
+	}
+	d.record(id, results)
+}
+
+// deliver tries the domain's mail hosts in order of preference until one
+// takes part in a transaction, and returns the result for each recipient.
+// A host that cannot be reached or turns the whole transaction away with a
+// temporary error is passed over for the next one (RFC 5321 section 5.1).
+func (d *Dispatcher) deliver(ctx context.Context, from string, raw []byte, domain string, recipients []string) map[string]result {
+	hosts, err := lookupHosts(ctx, d.resolver, domain)
+	if err != nil {
+		status := StatusQueued
+		var deliveryErr *deliveryError
+		if errors.As(err, &deliveryErr) && deliveryErr.permanent {
+			status = StatusFailed
+		}
+		return allResults(recipients, result{status: status, response: err.Error()})
+	}
+
+	var lastErr error
+	var lastResults map[string]result
+	for _, host := range hosts {
+		dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+		c, err := dialClient(dialCtx, d.dial, host, d.port, d.hostname, d.timeout)
+		cancel()
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", host, err)
+			d.logger.Debugw("Mail host unavailable", "domain", domain, "host", host, "error", err)
+			continue
+		}
+
+		stop := context.AfterFunc(ctx, c.close)
+		results, tryNext := transaction(c, from, raw, recipients)
+		stop()
+		c.quit()
+		if !tryNext || ctx.Err() != nil {
+			return results
+		}
+		lastResults = results
+		d.logger.Debugw("Mail host deferred the transaction", "domain", domain, "host", host, "response", results[recipients[0]].response)
+	}
+	if lastResults != nil {
+		return lastResults
+	}
+	return allResults(recipients, result{status: StatusQueued, response: lastErr.Error()})
+}
+
+// transaction sends the message to the recipients over an open connection.
+// It reports whether the next mail host should be tried instead, when the
+// host failed temporarily before accepting any recipient: on MAIL FROM, or
+// by closing the connection or the service (421).
+func transaction(c *client, from string, raw []byte, recipients []string) (map[string]result, bool) {
+	newResult := func(r reply) result {
+		status := StatusQueued
+		if r.ok() {
+			status = StatusDelivered
+		} else if r.permanent() {
+			status = StatusFailed
+		}
+		return result{status: status, response: r.String(), remoteMX: c.host, tlsVersion: c.tlsVersion}
+	}
+	failed := func(err error) result {
+		return result{status: StatusQueued, response: err.Error(), remoteMX: c.host, tlsVersion: c.tlsVersion}
+	}
+
+	r, err := c.mail(from, len(raw))
+	if err != nil {
+		return allResults(recipients, failed(err)), true
+	}
+	if !r.ok() {
+		return allResults(recipients, newResult(r)), !r.permanent()
+	}
+
+	results := make(map[string]result, len(recipients))
+	var accepted []string
+	for i, rcpt := range recipients {
+		r, err := c.rcpt(rcpt)
+		if err != nil || r.code == 421 {
+			// The connection is gone; recipients accepted so far are
+			// retried along with the rest
+			res := newResult(r)
+			if err != nil {
+				res = failed(err)
+			}
+			if i == 0 {
+				return allResults(recipients, res), true
+			}
+			for _, rest := range append(accepted, recipients[i:]...) {
+				results[rest] = res
+			}
+			return results, false
+		}
+		if r.ok() {
+			accepted = append(accepted, rcpt)
+		} else {
+			results[rcpt] = newResult(r)
+		}
+	}
+	if len(accepted) == 0 {
+		return results, false
+	}
+
+	var res result
+	if r, err := c.data(raw); err != nil {
+		res = failed(err)
+	} else {
+		res = newResult(r)
+	}
+	for _, rcpt := range accepted {
+		results[rcpt] = res
+	}
+	return results, false
+}
+
+func allResults(recipients []string, res result) map[string]result {
+	results := make(map[string]result, len(recipients))
+	for _, rcpt := range recipients {
+		results[rcpt] = res
+	}
+	return results
+}
+
+// record saves the results of an attempt, scheduling retries for temporary
+// failures, and removes the message once no recipient is left to deliver.
+// Recipients that failed for good are reported to the sender in a DSN, and
+// every finished recipient in a delivery event.
+func (d *Dispatcher) record(id string, results map[string]result) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	msg, err := d.queue.Get(id)
+	if err != nil {
+		d.logger.Errorf("Failed to update outbound message %s: %v", id, err)
+		return
+	}
+	logger := logging.WithRequestID(msg.RequestID).With("id", id, "message_id", msg.MessageID)
+
+	now := d.now()
+	var finished, failed []*Recipient
+	for _, rcpt := range msg.Recipients {
+		res, ok := results[rcpt.Address]
+		if !ok || rcpt.Status != StatusQueued {
+			continue
+		}
+		rcpt.Attempts++
+		rcpt.Response = res.response
+		rcpt.RemoteMX = res.remoteMX
+		rcpt.TLSVersion = res.tlsVersion
+
+		switch {
+		case res.status == StatusDelivered:
+			rcpt.Status = StatusDelivered
+			metrics.OutboundDeliveryAttempts.WithLabelValues("delivered").Inc()
+			metrics.OutboundRecipientAttempts.Observe(float64(rcpt.Attempts))
+			logger.Infow("Outbound message delivered",
+				"to", rcpt.Address, "mx", rcpt.RemoteMX, "tls", rcpt.TLSVersion, "attempt", rcpt.Attempts, "response", rcpt.Response)
+
+		case res.status == StatusFailed:
+			rcpt.Status = StatusFailed
+			metrics.OutboundDeliveryAttempts.WithLabelValues("failed").Inc()
+			metrics.OutboundRecipientAttempts.Observe(float64(rcpt.Attempts))
+			logger.Warnw("Outbound delivery failed permanently",
+				"to", rcpt.Address, "mx", rcpt.RemoteMX, "attempt", rcpt.Attempts, "response", rcpt.Response)
+			d.suppress(logger, rcpt)
+
+		case now.Sub(msg.QueuedAt) >= d.maxAge:
+			rcpt.Status = StatusFailed
+			rcpt.Expired = true
+			metrics.OutboundDeliveryAttempts.WithLabelValues("expired").Inc()
+			metrics.OutboundRecipientAttempts.Observe(float64(rcpt.Attempts))
+			logger.Warnw("Outbound delivery expired, giving up",
+				"to", rcpt.Address, "mx", rcpt.RemoteMX, "attempts", rcpt.Attempts, "response", rcpt.Response)
+
+		default:
+			rcpt.NextAttempt = now.Add(d.retryDelay(rcpt.Attempts))
+			metrics.OutboundDeliveryAttempts.WithLabelValues("deferred").Inc()
+			logger.Infow("Outbound delivery deferred",
+				"to", rcpt.Address, "mx", rcpt.RemoteMX, "attempt", rcpt.Attempts, "next_attempt", rcpt.NextAttempt, "response", rcpt.Response)
+			continue
+		}
+
+		finished = append(finished, rcpt)
+		if rcpt.Status == StatusFailed {
+			failed = append(failed, rcpt)
+		}
+	}
+
+	// Bounces have a null sender and are never bounced or reported themselves
+	if msg.From != "" {
+		var dsnID string
+		if len(failed) > 0 {
+			if dsnID, err = d.bounce(msg, failed, now); err != nil {
+				logger.Errorf("Failed to queue delivery status notification: %v", err)
+			} else {
+				logger.Infow("Delivery status notification queued", "dsn_id", dsnID, "to", msg.From, "failed", len(failed))
+			}
+		}
+		d.publish(logger, msg, finished, dsnID)
+	}
+
+	if msg.Pending() {
+		if err := d.queue.Save(msg); err != nil {
+			logger.Errorf("Failed to save outbound message state: %v", err)
+		}
+		return
+	}
+	// Bounces and complaints about the message are only believed when they
+	// can be matched to it
+	if err := d.queue.RecordSent(msg); err != nil {
+		logger.Errorf("Failed to record sent message: %v", err)
+	}
+	if err := d.queue.Remove(id); err != nil {
+		logger.Errorf("Failed to remove finished outbound message: %v", err)
+	}
+}
+
+// bounce queues a DSN for the failed recipients to the sender of msg and
+// returns its queue ID
+func (d *Dispatcher) bounce(msg *Message, failed []*Recipient, now time.Time) (string, error) {
+	original, err := d.queue.Raw(msg.ID)
+	if err != nil {
+		return "", err
+	}
+
+	hostname := d.hostname
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	id, err := NewID(now)
+	if err != nil {
+		return "", err
+	}
+	dsn := &Message{
+		ID:         id,
+		RequestID:  msg.RequestID,
+		Recipients: NewRecipients([]string{msg.From}),
+		MessageID:  fmt.Sprintf("<%s@%s>", id, hostname),
+		QueuedAt:   now,
+	}
+	if err := d.queue.Enqueue(dsn, buildDSN(hostname, dsn.MessageID, msg, failed, original, now)); err != nil {
+		return "", err
+	}
+	metrics.OutboundDSNs.Inc()
+	return id, nil
+}
+
+// publish sends a delivery event for each finished recipient to the sender's
+// webhook, correlated by the request ID of the send request
+func (d *Dispatcher) publish(logger *zap.SugaredLogger, msg *Message, finished []*Recipient, dsnID string) {
+	if d.publisher == nil {
+		return
+	}
+
+	for _, rcpt := range finished {
+		event := DeliveryEvent{
+			ID:         msg.ID,
+			MessageID:  msg.MessageID,
+			From:       msg.From,
+			Recipient:  rcpt.Address,
+			Response:   rcpt.Response,
+			RemoteMX:   rcpt.RemoteMX,
+			TLSVersion: rcpt.TLSVersion,
+			Attempts:   rcpt.Attempts,
+		}
+		eventType := EventDelivered
+		if rcpt.Status == StatusFailed {
+			eventType = EventFailed
+			event.Status = dsnStatus(rcpt)
+			event.Expired = rcpt.Expired
+			event.DSNID = dsnID
+		} else if m := enhancedStatus.FindStringSubmatch(rcpt.Response); m != nil {
+			event.Status = m[1]
+		} else {
+			event.Status = "2.0.0"
+		}
+
+		if err := d.publisher.Publish(eventType, msg.RequestID, msg.From, event); err != nil {
+			logger.Errorw("Failed to queue delivery event", "event", eventType, "to", rcpt.Address, "error", err)
+		}
+	}
+}
+
+// retryDelay returns the wait after the given number of attempts; the last
+// delay in the schedule repeats
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	i := min(attempts, len(d.retrySchedule)) - 1
+	return d.retrySchedule[max(i, 0)]
+}
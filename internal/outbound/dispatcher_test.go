@@ -0,0 +1,593 @@
+package outbound
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDelivery is a message the fake mail host accepted
+type fakeDelivery struct {
+	from       string
+	recipients []string
+	data       string
+	tls        bool
+}
+
+// fakeMX is an in-process SMTP server that replies as scripted
+type fakeMX struct {
+	listener    net.Listener
+	tlsConfig   *tls.Config // STARTTLS is offered when set
+	brokenTLS   bool        // STARTTLS is offered but the handshake fails
+	mailReply   string
+	rcptReplies map[string]string // reply per recipient, "250 2.1.5 Ok" otherwise
+	dataReply   string
+	hold        chan struct{} // when set, the DATA reply waits for it to close
+
+	mu         sync.Mutex
+	sessions   int
+	deliveries []fakeDelivery
+}
+
+func newFakeMX(t *testing.T) *fakeMX {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	mx := &fakeMX{
+		listener:    listener,
+		mailReply:   "250 2.1.0 Ok",
+		rcptReplies: map[string]string{},
+		dataReply:   "250 2.0.0 Ok: queued as 1",
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go mx.serve(conn)
+		}
+	}()
+	return mx
+}
+
+func (mx *fakeMX) serve(conn net.Conn) {
+	defer conn.Close()
+	mx.mu.Lock()
+	mx.sessions++
+	mx.mu.Unlock()
+
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 mx.example.net ESMTP")
+
+	var delivery fakeDelivery
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			_ = text.PrintfLine("250-mx.example.net")
+			if (mx.tlsConfig != nil || mx.brokenTLS) && !delivery.tls {
+				_ = text.PrintfLine("250-STARTTLS")
+			}
+			_ = text.PrintfLine("250 SIZE 10240000")
+		case "STARTTLS":
+			_ = text.PrintfLine("220 2.0.0 Ready to start TLS")
+			if mx.brokenTLS {
+				_ = text.PrintfLine("garbage")
+				return
+			}
+			tlsConn := tls.Server(conn, mx.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			delivery.tls = true
+		case "MAIL":
+			delivery.from = strings.Trim(strings.Fields(strings.TrimPrefix(arg, "FROM:"))[0], "<>")
+			_ = text.PrintfLine("%s", mx.mailReply)
+		case "RCPT":
+			rcpt := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			reply, ok := mx.rcptReplies[rcpt]
+			if !ok {
+				reply = "250 2.1.5 Ok"
+			}
+			if strings.HasPrefix(reply, "2") {
+				delivery.recipients = append(delivery.recipients, rcpt)
+			}
+			_ = text.PrintfLine("%s", reply)
+		case "DATA":
+			_ = text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			if mx.hold != nil {
+				<-mx.hold
+			}
+			delivery.data = string(data)
+			if strings.HasPrefix(mx.dataReply, "2") {
+				mx.mu.Lock()
+				mx.deliveries = append(mx.deliveries, delivery)
+				mx.mu.Unlock()
+			}
+			_ = text.PrintfLine("%s", mx.dataReply)
+		case "QUIT":
+			_ = text.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			_ = text.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func (mx *fakeMX) received() []fakeDelivery {
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	return append([]fakeDelivery(nil), mx.deliveries...)
+}
+
+func (mx *fakeMX) sessionCount() int {
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	return mx.sessions
+}
+
+// fakeResolver answers MX lookups from a map; unknown names do not exist
+type fakeResolver map[string][]*net.MX
+
+func (r fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if name == "timeout.example" {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true, IsTemporary: true}
+	}
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+// publishedEvent is an event the dispatcher handed to its publisher
+type publishedEvent struct {
+	eventType string
+	requestID string
+	address   string
+	data      DeliveryEvent
+}
+
+type fakePublisher struct {
+	mu     sync.Mutex
+	events []publishedEvent
+}
+
+func (p *fakePublisher) Publish(eventType, requestID, address string, data interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, publishedEvent{eventType, requestID, address, data.(DeliveryEvent)})
+	return nil
+}
+
+func (p *fakePublisher) published() []publishedEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]publishedEvent(nil), p.events...)
+}
+
+// newTestDispatcher returns a dispatcher resolving through resolver and
+// reaching the fake hosts in hosts by name; every other host refuses
+func newTestDispatcher(t *testing.T, cfg *config.Config, resolver fakeResolver, hosts map[string]*fakeMX) (*Dispatcher, *Queue) {
+	t.Helper()
+
+	queue, err := NewQueue(t.TempDir())
+	require.NoError(t, err)
+
+	cfg.MailHostname = "mail.example.com"
+	d := NewDispatcher(cfg, queue)
+	d.resolver = resolver
+	d.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		mx, ok := hosts[host]
+		if !ok {
+			return nil, errors.New("connection refused")
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, mx.listener.Addr().String())
+	}
+	return d, queue
+}
+
+func enqueue(t *testing.T, q *Queue, queuedAt time.Time, raw string, recipients ...string) *Message {
+	t.Helper()
+
+	id, err := NewID(queuedAt)
+	require.NoError(t, err)
+	msg := &Message{
+		ID:         id,
+		RequestID:  "req-1",
+		From:       "alice@example.com",
+		Recipients: NewRecipients(recipients),
+		MessageID:  "<1@example.com>",
+		QueuedAt:   queuedAt,
+	}
+	require.NoError(t, q.Enqueue(msg, []byte(raw)))
+	return msg
+}
+
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mx.example.net"},
+		DNSNames:     []string{"mx.example.net"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestDispatcher_Delivers(t *testing.T) {
+	netMX := newFakeMX(t)
+	netMX.tlsConfig = testTLSConfig(t)
+	orgMX := newFakeMX(t)
+
+	resolver := fakeResolver{
+		"example.net": {{Host: "mx2.example.net.", Pref: 20}, {Host: "mx1.example.net.", Pref: 10}},
+	}
+	// mx1 is down, so example.net goes to its backup; example.org has no MX
+	// records and is delivered to the domain itself
+	d, queue := newTestDispatcher(t, &config.Config{}, resolver, map[string]*fakeMX{
+		"mx2.example.net": netMX,
+		"example.org":     orgMX,
+	})
+
+	raw := "From: alice@example.com\r\nSubject: Hi\r\n\r\n.leading dot\r\nBye\r\n"
+	enqueue(t, queue, time.Now(), raw, "bob@example.net", "carol@Example.NET", "dave@example.org")
+
+	d.ProcessPending(context.Background())
+	d.wg.Wait()
+
+	netDeliveries := netMX.received()
+	require.Len(t, netDeliveries, 1, "one transaction for both example.net recipients")
+	assert.Equal(t, "alice@example.com", netDeliveries[0].from)
+	assert.Equal(t, []string{"bob@example.net", "carol@Example.NET"}, netDeliveries[0].recipients)
+	assert.True(t, netDeliveries[0].tls)
+	assert.Equal(t, strings.ReplaceAll(raw, "\r\n", "\n"), netDeliveries[0].data)
+
+	orgDeliveries := orgMX.received()
+	require.Len(t, orgDeliveries, 1)
+	assert.Equal(t, []string{"dave@example.org"}, orgDeliveries[0].recipients)
+	assert.False(t, orgDeliveries[0].tls)
+
+	// Delivered messages leave the queue
+	messages, err := queue.List()
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestDispatcher_RetrySchedule(t *testing.T) {
+	mx := newFakeMX(t)
+	mx.tlsConfig = testTLSConfig(t)
+	mx.rcptReplies["busy@example.net"] = "451 4.2.1 Mailbox busy, try later"
+	mx.rcptReplies["gone@example.net"] = "550 5.1.1 No such user"
+
+	// The sender's domain takes no mail, so bounces to it fail as well
+	d, queue := newTestDispatcher(t, &config.Config{OutboundRetrySchedule: []int{5, 30}, OutboundMaxAge: 1},
+		fakeResolver{"example.net": {{Host: "mx.example.net", Pref: 10}}, "example.com": {{Host: ".", Pref: 0}}},
+		map[string]*fakeMX{"mx.example.net": mx})
+
+	publisher := &fakePublisher{}
+	d.SetPublisher(publisher)
+
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	now := start
+	d.now = func() time.Time { return now }
+	msg := enqueue(t, queue, start, "Subject: Hi\r\n\r\nHi\r\n", "bob@example.net", "busy@example.net", "gone@example.net")
+
+	pass := func() *Message {
+		d.ProcessPending(context.Background())
+		d.wg.Wait()
+		got, err := queue.Get(msg.ID)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		require.NoError(t, err)
+		return got
+	}
+
+	got := pass()
+	require.NotNil(t, got)
+	bob, busy, gone := got.Recipients[0], got.Recipients[1], got.Recipients[2]
+
+	assert.Equal(t, StatusDelivered, bob.Status)
+	assert.Equal(t, "250 2.0.0 Ok: queued as 1", bob.Response)
+	assert.Equal(t, "mx.example.net", bob.RemoteMX)
+	assert.Equal(t, "TLS 1.3", bob.TLSVersion)
+
+	assert.Equal(t, StatusFailed, gone.Status)
+	assert.Equal(t, "550 5.1.1 No such user", gone.Response)
+	assert.False(t, gone.Expired)
+
+	assert.Equal(t, StatusQueued, busy.Status)
+	assert.Equal(t, 1, busy.Attempts)
+	assert.Equal(t, "451 4.2.1 Mailbox busy, try later", busy.Response)
+	assert.Equal(t, start.Add(5*time.Minute), busy.NextAttempt)
+
+	// Finished recipients are reported, and the failed one bounced
+	events := publisher.published()
+	require.Len(t, events, 2)
+	assert.Equal(t, publishedEvent{EventDelivered, "req-1", "alice@example.com", DeliveryEvent{
+		ID:         msg.ID,
+		MessageID:  "<1@example.com>",
+		From:       "alice@example.com",
+		Recipient:  "bob@example.net",
+		Status:     "2.0.0",
+		Response:   "250 2.0.0 Ok: queued as 1",
+		RemoteMX:   "mx.example.net",
+		TLSVersion: "TLS 1.3",
+		Attempts:   1,
+	}}, events[0])
+	assert.Equal(t, EventFailed, events[1].eventType)
+	assert.Equal(t, "gone@example.net", events[1].data.Recipient)
+	assert.Equal(t, "5.1.1", events[1].data.Status)
+	require.NotEmpty(t, events[1].data.DSNID)
+
+	dsn, err := queue.Get(events[1].data.DSNID)
+	require.NoError(t, err)
+	assert.Empty(t, dsn.From, "bounces have a null sender")
+	assert.Equal(t, "req-1", dsn.RequestID)
+	require.Len(t, dsn.Recipients, 1)
+	assert.Equal(t, "alice@example.com", dsn.Recipients[0].Address)
+	dsnRaw, err := queue.Raw(dsn.ID)
+	require.NoError(t, err)
+	assert.Contains(t, string(dsnRaw), "Final-Recipient: rfc822; gone@example.net\r\n")
+	assert.NotContains(t, string(dsnRaw), "bob@example.net")
+
+	// Nothing is due until the retry delay has passed
+	sessions := mx.sessionCount()
+	got = pass()
+	assert.Equal(t, sessions, mx.sessionCount())
+	assert.Equal(t, 1, got.Recipients[1].Attempts)
+
+	// Retries follow the schedule, repeating its last delay
+	now = start.Add(5 * time.Minute)
+	got = pass()
+	assert.Equal(t, 2, got.Recipients[1].Attempts)
+	assert.Equal(t, now.Add(30*time.Minute), got.Recipients[1].NextAttempt)
+	assert.Equal(t, StatusDelivered, got.Recipients[0].Status, "delivered recipients are not retried")
+	assert.Len(t, mx.received(), 1)
+
+	now = now.Add(30 * time.Minute)
+	got = pass()
+	assert.Equal(t, 3, got.Recipients[1].Attempts)
+	assert.Equal(t, now.Add(30*time.Minute), got.Recipients[1].NextAttempt)
+
+	// Past outbound_max_age the last temporary failure is final
+	now = now.Add(30 * time.Minute)
+	assert.Nil(t, pass(), "finished messages leave the queue")
+
+	// Bounces that cannot be delivered are not reported or bounced again
+	d.ProcessPending(context.Background())
+	d.wg.Wait()
+	messages, err := queue.List()
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+	events = publisher.published()
+	require.Len(t, events, 3)
+	assert.Equal(t, EventFailed, events[2].eventType)
+	assert.Equal(t, "busy@example.net", events[2].data.Recipient)
+	assert.True(t, events[2].data.Expired)
+	assert.Equal(t, "4.4.7", events[2].data.Status)
+	assert.Equal(t, 4, events[2].data.Attempts)
+	assert.NotEqual(t, events[1].data.DSNID, events[2].data.DSNID)
+}
+
+func TestDispatcher_DestinationFailures(t *testing.T) {
+	d, queue := newTestDispatcher(t, &config.Config{OutboundRetrySchedule: []int{15}},
+		fakeResolver{
+			"down.example":   {{Host: "mx1.down.example", Pref: 10}, {Host: "mx2.down.example", Pref: 20}},
+			"nullmx.example": {{Host: ".", Pref: 0}},
+		}, nil)
+
+	msg := enqueue(t, queue, time.Now(), "Subject: Hi\r\n\r\nHi\r\n",
+		"a@down.example", "b@nullmx.example", "c@timeout.example")
+
+	d.ProcessPending(context.Background())
+	d.wg.Wait()
+
+	got, err := queue.Get(msg.ID)
+	require.NoError(t, err)
+
+	down, nullMX, timeout := got.Recipients[0], got.Recipients[1], got.Recipients[2]
+	assert.Equal(t, StatusQueued, down.Status, "unreachable hosts are retried")
+	assert.Contains(t, down.Response, "mx2.down.example: connection refused")
+	assert.Equal(t, StatusFailed, nullMX.Status)
+	assert.Contains(t, nullMX.Response, "null MX")
+	assert.Equal(t, StatusQueued, timeout.Status, "DNS failures are retried")
+	assert.Contains(t, timeout.Response, "MX lookup for timeout.example failed")
+}
+
+func TestDispatcher_BrokenSTARTTLS(t *testing.T) {
+	mx := newFakeMX(t)
+	mx.brokenTLS = true
+	d, queue := newTestDispatcher(t, &config.Config{},
+		fakeResolver{"example.net": {{Host: "mx.example.net", Pref: 10}}},
+		map[string]*fakeMX{"mx.example.net": mx})
+
+	enqueue(t, queue, time.Now(), "Subject: Hi\r\n\r\nHi\r\n", "bob@example.net")
+	d.ProcessPending(context.Background())
+	d.wg.Wait()
+
+	// The failed handshake is followed by a plaintext session
+	deliveries := mx.received()
+	require.Len(t, deliveries, 1)
+	assert.False(t, deliveries[0].tls)
+	assert.Equal(t, 2, mx.sessionCount())
+
+	messages, err := queue.List()
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestDispatcher_NextHostOnTemporaryFailure(t *testing.T) {
+	busyMX := newFakeMX(t)
+	busyMX.mailReply = "451 4.3.2 System not accepting network messages"
+	backupMX := newFakeMX(t)
+	closingMX := newFakeMX(t)
+	closingMX.rcptReplies["bob@example.org"] = "421 4.3.2 Service shutting down"
+	rejectingMX := newFakeMX(t)
+	rejectingMX.mailReply = "550 5.7.1 Sender rejected"
+
+	d, queue := newTestDispatcher(t, &config.Config{OutboundRetrySchedule: []int{15}},
+		fakeResolver{
+			"example.net": {{Host: "mx1.example.net", Pref: 10}, {Host: "mx2.example.net", Pref: 20}},
+			"example.org": {{Host: "mx1.example.org", Pref: 10}, {Host: "mx2.example.org", Pref: 20}},
+			"example.com": {{Host: "mx1.example.com", Pref: 10}, {Host: "mx2.example.com", Pref: 20}},
+		},
+		map[string]*fakeMX{
+			"mx1.example.net": busyMX,
+			"mx2.example.net": backupMX,
+			"mx1.example.org": closingMX,
+			"mx2.example.org": backupMX,
+			"mx1.example.com": rejectingMX,
+			"mx2.example.com": backupMX,
+		})
+
+	enqueue(t, queue, time.Now(), "Subject: Hi\r\n\r\nHi\r\n", "bob@example.net", "bob@example.org", "carol@example.com")
+	d.ProcessPending(context.Background())
+	d.wg.Wait()
+
+	// Temporary failures before any recipient is accepted move on to the
+	// backup; a permanent one is final
+	var delivered []string
+	for _, delivery := range backupMX.received() {
+		delivered = append(delivered, delivery.recipients...)
+	}
+	assert.ElementsMatch(t, []string{"bob@example.net", "bob@example.org"}, delivered)
+	assert.Equal(t, 1, rejectingMX.sessionCount())
+	assert.Empty(t, rejectingMX.received())
+}
+
+func TestDispatcher_SuppressesHardBounces(t *testing.T) {
+	mx := newFakeMX(t)
+	mx.rcptReplies["gone@example.net"] = "550 5.1.1 No such user"
+	mx.rcptReplies["full@example.net"] = "552 5.2.2 Mailbox full"
+
+	d, queue := newTestDispatcher(t, &config.Config{SuppressionBounceExpiry: 24},
+		fakeResolver{"example.net": {{Host: "mx.example.net", Pref: 10}}, "nullmx.example": {{Host: ".", Pref: 0}}},
+		map[string]*fakeMX{"mx.example.net": mx})
+
+	suppressions, err := NewSuppressions(t.TempDir())
+	require.NoError(t, err)
+	d.SetSuppressions(suppressions)
+
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	suppressions.now = d.now
+
+	enqueue(t, queue, now, "Subject: Hi\r\n\r\nHi\r\n", "gone@example.net", "full@example.net", "a@nullmx.example")
+	d.ProcessPending(context.Background())
+	d.wg.Wait()
+
+	gone, err := suppressions.Get("gone@example.net")
+	require.NoError(t, err)
+	assert.Equal(t, ReasonHardBounce, gone.Reason)
+	assert.Equal(t, "550 5.1.1 No such user", gone.Detail)
+	require.NotNil(t, gone.ExpiresAt)
+	assert.Equal(t, now.Add(24*time.Hour), *gone.ExpiresAt)
+
+	nullMX, err := suppressions.Get("a@nullmx.example")
+	require.NoError(t, err)
+	assert.Equal(t, ReasonHardBounce, nullMX.Reason)
+
+	_, err = suppressions.Get("full@example.net")
+	assert.ErrorIs(t, err, ErrNotSuppressed, "a full mailbox is a soft bounce")
+}
+
+func TestDispatcher_DomainLimits(t *testing.T) {
+	mx := newFakeMX(t)
+	mx.hold = make(chan struct{})
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			close(mx.hold)
+		}
+	}
+	t.Cleanup(release)
+
+	d, queue := newTestDispatcher(t, &config.Config{OutboundDomainConcurrency: 1, OutboundDomainRate: 1},
+		fakeResolver{"example.net": {{Host: "mx.example.net", Pref: 10}}},
+		map[string]*fakeMX{"mx.example.net": mx, "example.org": mx})
+
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		enqueue(t, queue, now.Add(time.Duration(i)*time.Second), "Subject: Hi\r\n\r\nHi\r\n", "bob@example.net")
+	}
+	enqueue(t, queue, now.Add(5*time.Second), "Subject: Hi\r\n\r\nHi\r\n", "dave@example.org")
+
+	inFlight := func(domain string) int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if state, ok := d.domains[domain]; ok {
+			return state.active
+		}
+		return 0
+	}
+
+	// One delivery at a time to example.net; other domains are not held up
+	d.ProcessPending(context.Background())
+	assert.Equal(t, 1, inFlight("example.net"))
+	assert.Equal(t, 1, inFlight("example.org"))
+
+	d.ProcessPending(context.Background())
+	assert.Equal(t, 1, inFlight("example.net"), "the concurrency limit holds across passes")
+
+	release()
+	d.wg.Wait()
+	assert.Len(t, mx.received(), 2)
+
+	// The rate limit allows one example.net delivery a minute
+	d.ProcessPending(context.Background())
+	assert.Equal(t, 0, inFlight("example.net"))
+
+	now = now.Add(time.Minute)
+	d.ProcessPending(context.Background())
+	d.wg.Wait()
+	assert.Len(t, mx.received(), 3)
+
+	messages, err := queue.List()
+	require.NoError(t, err)
+	assert.Len(t, messages, 1)
+}
@@ -0,0 +1,97 @@
+package outbound
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// smtpReply matches a response that came from a remote server
+	smtpReply = regexp.MustCompile(`^[2-5]\d\d\b`)
+
+	// enhancedStatus matches the RFC 3463 status code after the reply code
+	enhancedStatus = regexp.MustCompile(`^[2-5]\d\d[ -]([245]\.\d{1,3}\.\d{1,3})\b`)
+)
+
+// dsnStatus returns the RFC 3463 status code reported for a failed recipient
+func dsnStatus(rcpt *Recipient) string {
+	switch {
+	case rcpt.Expired:
+		return "4.4.7" // delivery time expired
+	case enhancedStatus.MatchString(rcpt.Response):
+		return enhancedStatus.FindStringSubmatch(rcpt.Response)[1]
+	case smtpReply.MatchString(rcpt.Response):
+		return rcpt.Response[:1] + ".0.0"
+	default:
+		// The only permanent failure without a reply is a null MX
+		return "5.1.10"
+	}
+}
+
+// buildDSN returns an RFC 3464 delivery status notification telling the
+// sender of msg that the failed recipients will not receive it. The original
+// headers are returned, but not the body.
+func buildDSN(hostname, messageID string, msg *Message, failed []*Recipient, original []byte, now time.Time) []byte {
+	boundary := "dsn-" + msg.ID
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: Mail Delivery System <MAILER-DAEMON@%s>\r\n", hostname)
+	fmt.Fprintf(&b, "To: <%s>\r\n", msg.From)
+	b.WriteString("Subject: Undelivered Mail Returned to Sender\r\n")
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: %s\r\n", messageID)
+	b.WriteString("Auto-Submitted: auto-replied\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/report; report-type=delivery-status; boundary=\"%s\"\r\n", boundary)
+	b.WriteString("\r\nThis is a MIME-encapsulated message.\r\n")
+
+	// Human-readable part
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "This is the mail system at host %s.\r\n\r\n", hostname)
+	b.WriteString("Your message could not be delivered to one or more recipients.\r\n\r\n")
+	for _, rcpt := range failed {
+		fmt.Fprintf(&b, "<%s>: ", rcpt.Address)
+		if rcpt.Expired {
+			fmt.Fprintf(&b, "delivery gave up after %d attempts; last error: ", rcpt.Attempts)
+		}
+		b.WriteString(rcpt.Response)
+		if rcpt.RemoteMX != "" {
+			fmt.Fprintf(&b, " (from %s)", rcpt.RemoteMX)
+		}
+		b.WriteString("\r\n")
+	}
+
+	// Machine-readable part
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	b.WriteString("Content-Type: message/delivery-status\r\n\r\n")
+	fmt.Fprintf(&b, "Reporting-MTA: dns; %s\r\n", hostname)
+	fmt.Fprintf(&b, "Arrival-Date: %s\r\n", msg.QueuedAt.Format(time.RFC1123Z))
+	for _, rcpt := range failed {
+		fmt.Fprintf(&b, "\r\nFinal-Recipient: rfc822; %s\r\n", rcpt.Address)
+		b.WriteString("Action: failed\r\n")
+		fmt.Fprintf(&b, "Status: %s\r\n", dsnStatus(rcpt))
+		if rcpt.RemoteMX != "" {
+			fmt.Fprintf(&b, "Remote-MTA: dns; %s\r\n", rcpt.RemoteMX)
+		}
+		if smtpReply.MatchString(rcpt.Response) {
+			fmt.Fprintf(&b, "Diagnostic-Code: smtp; %s\r\n", rcpt.Response)
+		}
+		fmt.Fprintf(&b, "Last-Attempt-Date: %s\r\n", now.Format(time.RFC1123Z))
+	}
+
+	// Original headers
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/rfc822-headers\r\n\r\n")
+	headers := original
+	if i := bytes.Index(original, []byte("\r\n\r\n")); i >= 0 {
+		headers = original[:i+2]
+	}
+	b.Write(headers)
+
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	return []byte(b.String())
+}
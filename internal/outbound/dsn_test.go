@@ -0,0 +1,84 @@
+package outbound
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDSNStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		recipient Recipient
+		want      string
+	}{
+		{"enhanced status", Recipient{Response: "550 5.1.1 No such user"}, "5.1.1"},
+		{"multi-line reply", Recipient{Response: "554-5.7.1 Rejected 554 5.7.1 Spam"}, "5.7.1"},
+		{"plain reply", Recipient{Response: "550 Mailbox unavailable"}, "5.0.0"},
+		{"expired", Recipient{Response: "451 4.2.1 Mailbox busy", Expired: true}, "4.4.7"},
+		{"null MX", Recipient{Response: "domain example.org does not accept mail (null MX)"}, "5.1.10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, dsnStatus(&tt.recipient))
+		})
+	}
+}
+
+func TestBuildDSN(t *testing.T) {
+	queuedAt := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	msg := &Message{ID: "out_1705312800_a1b2c3d4", From: "alice@example.com", QueuedAt: queuedAt}
+	failed := []*Recipient{
+		{Address: "gone@example.net", Status: StatusFailed, Attempts: 1, Response: "550 5.1.1 No such user", RemoteMX: "mx.example.net"},
+		{Address: "busy@example.org", Status: StatusFailed, Attempts: 7, Response: "connection refused", Expired: true},
+	}
+	original := []byte("From: alice@example.com\r\nSubject: Invoice\r\nMessage-ID: <1@example.com>\r\n\r\nSecret body\r\n")
+
+	raw := buildDSN("mail.example.com", "<dsn@mail.example.com>", msg, failed, original, queuedAt.Add(5*24*time.Hour))
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	require.NoError(t, err)
+	assert.Equal(t, "<alice@example.com>", parsed.Header.Get("To"))
+	assert.Equal(t, "Mail Delivery System <MAILER-DAEMON@mail.example.com>", parsed.Header.Get("From"))
+	assert.Equal(t, "auto-replied", parsed.Header.Get("Auto-Submitted"))
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/report", mediaType)
+	assert.Equal(t, "delivery-status", params["report-type"])
+
+	var parts []string
+	var types []string
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		types = append(types, part.Header.Get("Content-Type"))
+		parts = append(parts, string(body))
+	}
+	require.Equal(t, []string{"text/plain; charset=utf-8", "message/delivery-status", "text/rfc822-headers"}, types)
+
+	assert.Contains(t, parts[0], "<gone@example.net>: 550 5.1.1 No such user (from mx.example.net)")
+	assert.Contains(t, parts[0], "<busy@example.org>: delivery gave up after 7 attempts; last error: connection refused")
+
+	assert.Contains(t, parts[1], "Reporting-MTA: dns; mail.example.com\r\nArrival-Date: Mon, 15 Jan 2024 10:00:00 +0000\r\n")
+	assert.Contains(t, parts[1], "Final-Recipient: rfc822; gone@example.net\r\nAction: failed\r\nStatus: 5.1.1\r\n"+
+		"Remote-MTA: dns; mx.example.net\r\nDiagnostic-Code: smtp; 550 5.1.1 No such user\r\n")
+	assert.Contains(t, parts[1], "Final-Recipient: rfc822; busy@example.org\r\nAction: failed\r\nStatus: 4.4.7\r\nLast-Attempt-Date: ")
+
+	assert.Contains(t, parts[2], "Message-ID: <1@example.com>")
+	assert.NotContains(t, string(raw), "Secret body", "the original body is not returned")
+}
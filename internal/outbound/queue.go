@@ -0,0 +1,224 @@
+package outbound
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for IDs that are not in the queue
+var ErrNotFound = errors.New("message not in outbound queue")
+
+var idPattern = regexp.MustCompile(`^out_\d+_[0-9a-f]{8}$`)
+
+// Recipient delivery states
+const (
+	StatusQueued    = "queued"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Message is a message waiting in the outbound queue. The signed message
+// itself is kept next to it in a ".eml" file.
+type Message struct {
+	ID         string       `json:"id"`
+	RequestID  string       `json:"request_id,omitempty"` // X-Request-ID of the send request
+	From       string       `json:"from"`                 // envelope sender
+	Recipients []*Recipient `json:"recipients"`
+	MessageID  string       `json:"message_id"`
+	Size       int          `json:"size"`
+	QueuedAt   time.Time    `json:"queued_at"`
+}
+
+// Recipient is one envelope recipient and the state of its delivery
+type Recipient struct {
+	Address     string    `json:"address"`
+	Status      string    `json:"status"` // StatusQueued, StatusDelivered or StatusFailed
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Response    string    `json:"response,omitempty"` // last reply from the remote server, or the error
+	RemoteMX    string    `json:"remote_mx,omitempty"`
+	TLSVersion  string    `json:"tls_version,omitempty"`
+	Expired     bool      `json:"expired,omitempty"` // failed because outbound_max_age passed
+}
+
+// NewRecipients returns queued recipients for the given addresses
+func NewRecipients(addresses []string) []*Recipient {
+	recipients := make([]*Recipient, len(addresses))
+	for i, address := range addresses {
+		recipients[i] = &Recipient{Address: address, Status: StatusQueued}
+	}
+	return recipients
+}
+
+// Domain returns the recipient's domain, lowercased
+func (r *Recipient) Domain() string {
+	return strings.ToLower(r.Address[strings.LastIndex(r.Address, "@")+1:])
+}
+
+// Pending reports whether any recipient is still waiting for delivery
+func (m *Message) Pending() bool {
+	for _, rcpt := range m.Recipients {
+		if rcpt.Status == StatusQueued {
+			return true
+		}
+	}
+	return false
+}
+
+// Queue is the persistent outbound queue under data_dir/outbound. Each
+// message is written as "<id>.eml" and "<id>.json"; the metadata is written
+// last, so a message is only queued once both files are complete. Delivered
+// messages are remembered in the "sent" subdirectory.
+type Queue struct {
+	dir string
+	now func() time.Time
+}
+
+// NewQueue opens the queue in dir, creating it if needed
+func NewQueue(dir string) (*Queue, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "sent"), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create outbound queue directory: %w", err)
+	}
+	return &Queue{dir: dir, now: time.Now}, nil
+}
+
+// NewID returns a queue ID such as "out_1705314600_a1b2c3d4"
+func NewID(t time.Time) (string, error) {
+	randomBytes := make([]byte, 4)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to generate random ID: %w", err)
+	}
+	return fmt.Sprintf("out_%d_%s", t.Unix(), hex.EncodeToString(randomBytes)), nil
+}
+
+// Enqueue adds msg with its raw message to the queue
+func (q *Queue) Enqueue(msg *Message, raw []byte) error {
+	if !idPattern.MatchString(msg.ID) {
+		return fmt.Errorf("invalid outbound message ID %q", msg.ID)
+	}
+	msg.Size = len(raw)
+
+	if err := writeAtomic(q.rawPath(msg.ID), raw); err != nil {
+		return err
+	}
+	return q.Save(msg)
+}
+
+// Save writes msg's metadata
+func (q *Queue) Save(msg *Message) error {
+	data, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode outbound message: %w", err)
+	}
+	return writeAtomic(q.metaPath(msg.ID), data)
+}
+
+// Get returns the queued message with id
+func (q *Queue) Get(id string) (*Message, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(q.metaPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read outbound message: %w", err)
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to decode outbound message %s: %w", id, err)
+	}
+	return &msg, nil
+}
+
+// Raw returns the signed message for id
+func (q *Queue) Raw(id string) ([]byte, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(q.rawPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read outbound message: %w", err)
+	}
+	return data, nil
+}
+
+// List returns every queued message, oldest first
+func (q *Queue) List() ([]*Message, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbound queue: %w", err)
+	}
+
+	var messages []*Message
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !idPattern.MatchString(id) {
+			continue
+		}
+		msg, err := q.Get(id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue // removed while listing
+			}
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].QueuedAt.Before(messages[j].QueuedAt)
+	})
+	return messages, nil
+}
+
+// Remove deletes a message from the queue
+func (q *Queue) Remove(id string) error {
+	if !idPattern.MatchString(id) {
+		return ErrNotFound
+	}
+	// Metadata first, so a half-removed message is no longer queued
+	if err := os.Remove(q.metaPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove outbound message: %w", err)
+	}
+	if err := os.Remove(q.rawPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove outbound message: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue) metaPath(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+func (q *Queue) rawPath(id string) string {
+	return filepath.Join(q.dir, id+".eml")
+}
+
+// writeAtomic writes data to a temporary file first and renames it into
+// place, so the queue never holds a partial message
+func writeAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
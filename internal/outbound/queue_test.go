@@ -0,0 +1,116 @@
+package outbound
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queueMessage(t *testing.T, q *Queue, queuedAt time.Time) *Message {
+	t.Helper()
+
+	id, err := NewID(queuedAt)
+	require.NoError(t, err)
+	msg := &Message{
+		ID:         id,
+		RequestID:  "req-1",
+		From:       "alice@example.com",
+		Recipients: NewRecipients([]string{"bob@example.net"}),
+		MessageID:  "<1@example.com>",
+		QueuedAt:   queuedAt,
+	}
+	require.NoError(t, q.Enqueue(msg, []byte("From: alice@example.com\r\n\r\nHi\r\n")))
+	return msg
+}
+
+func TestQueue_Enqueue(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbound")
+	q, err := NewQueue(dir)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	later := queueMessage(t, q, now)
+	earlier := queueMessage(t, q, now.Add(-time.Minute))
+	assert.Regexp(t, `^out_\d+_[0-9a-f]{8}$`, later.ID)
+
+	got, err := q.Get(later.ID)
+	require.NoError(t, err)
+	assert.Equal(t, later, got)
+	assert.Equal(t, 31, got.Size)
+
+	raw, err := q.Raw(later.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "From: alice@example.com\r\n\r\nHi\r\n", string(raw))
+
+	// Leftovers of an interrupted write are not queued
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "out_1_00000000.json.tmp"), []byte("{"), 0640))
+
+	messages, err := q.List()
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, earlier.ID, messages[0].ID)
+	assert.Equal(t, later.ID, messages[1].ID)
+
+	require.NoError(t, q.Remove(earlier.ID))
+	_, err = q.Get(earlier.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = q.Raw(earlier.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestQueue_InvalidID(t *testing.T) {
+	q, err := NewQueue(t.TempDir())
+	require.NoError(t, err)
+
+	for _, id := range []string{"", "../../etc/passwd", "msg_1_abcdef01", "out_1_ab/../x"} {
+		_, err := q.Get(id)
+		assert.ErrorIs(t, err, ErrNotFound, id)
+		_, err = q.Raw(id)
+		assert.ErrorIs(t, err, ErrNotFound, id)
+		assert.ErrorIs(t, q.Remove(id), ErrNotFound, id)
+	}
+	assert.Error(t, q.Enqueue(&Message{ID: "../x"}, nil))
+}
+
+func TestQueue_SentTo(t *testing.T) {
+	q, err := NewQueue(t.TempDir())
+	require.NoError(t, err)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	// Queued messages are found by their Message-ID, with or without brackets
+	msg := queueMessage(t, q, now)
+	recipients, err := q.SentTo("1@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob@example.net"}, recipients)
+
+	// Once delivered, only the recipients that were accepted are remembered
+	msg.MessageID = "<2@example.com>"
+	msg.Recipients = NewRecipients([]string{"bob@example.net", "gone@example.net"})
+	msg.Recipients[0].Status = StatusDelivered
+	msg.Recipients[1].Status = StatusFailed
+	require.NoError(t, q.RecordSent(msg))
+	require.NoError(t, q.Remove(msg.ID))
+
+	recipients, err = q.SentTo(" <2@example.com>")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob@example.net"}, recipients)
+
+	_, err = q.SentTo("<3@example.com>")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = q.SentTo("")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Sent messages are forgotten after sentTTL
+	now = now.Add(sentTTL + time.Hour)
+	require.NoError(t, q.PruneSent())
+	entries, err := os.ReadDir(q.sentDir())
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	_, err = q.SentTo("<2@example.com>")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
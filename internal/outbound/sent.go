@@ -0,0 +1,138 @@
+package outbound
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sentTTL is how long delivered messages are remembered. Most bounces and
+// complaints arrive within days; some feedback loops take a few weeks.
+const sentTTL = 30 * 24 * time.Hour
+
+// SentMessage is a message the queue delivered, kept in
+// data_dir/outbound/sent so bounces and complaints that arrive after it
+// has left the queue can be matched to it
+type SentMessage struct {
+	MessageID  string    `json:"message_id"`
+	Recipients []string  `json:"recipients"` // those a remote server accepted
+	SentAt     time.Time `json:"sent_at"`
+}
+
+// RecordSent remembers the recipients of msg that were delivered. Messages
+// without a Message-ID or delivered recipients are not recorded.
+func (q *Queue) RecordSent(msg *Message) error {
+	key := messageIDKey(msg.MessageID)
+	if key == "" {
+		return nil
+	}
+	sent := &SentMessage{MessageID: msg.MessageID, SentAt: q.now().UTC()}
+	for _, rcpt := range msg.Recipients {
+		if rcpt.Status == StatusDelivered {
+			sent.Recipients = append(sent.Recipients, rcpt.Address)
+		}
+	}
+	if len(sent.Recipients) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sent message: %w", err)
+	}
+	return writeAtomic(q.sentPath(key), data)
+}
+
+// SentTo returns the recipients of the message with messageID, while it is
+// queued or for sentTTL after it was delivered, or ErrNotFound
+func (q *Queue) SentTo(messageID string) ([]string, error) {
+	key := messageIDKey(messageID)
+	if key == "" {
+		return nil, ErrNotFound
+	}
+
+	sent, err := q.loadSent(q.sentPath(key))
+	if err == nil {
+		return sent.Recipients, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	// Recipients still being retried may already bounce from a backup MX
+	messages, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range messages {
+		if messageIDKey(msg.MessageID) == key {
+			recipients := make([]string, len(msg.Recipients))
+			for i, rcpt := range msg.Recipients {
+				recipients[i] = rcpt.Address
+			}
+			return recipients, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// PruneSent removes sent messages older than sentTTL
+func (q *Queue) PruneSent() error {
+	entries, err := os.ReadDir(q.sentDir())
+	if err != nil {
+		return fmt.Errorf("failed to read sent messages: %w", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if _, err := q.loadSent(filepath.Join(q.sentDir(), entry.Name())); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSent reads one sent message, removing it if it is older than sentTTL
+func (q *Queue) loadSent(path string) (*SentMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read sent message: %w", err)
+	}
+
+	var sent SentMessage
+	if err := json.Unmarshal(data, &sent); err != nil {
+		return nil, fmt.Errorf("failed to decode sent message %s: %w", filepath.Base(path), err)
+	}
+	if q.now().Sub(sent.SentAt) > sentTTL {
+		_ = os.Remove(path)
+		return nil, ErrNotFound
+	}
+	return &sent, nil
+}
+
+func (q *Queue) sentDir() string {
+	return filepath.Join(q.dir, "sent")
+}
+
+// sentPath returns the file for a Message-ID key. Message-IDs may contain
+// characters that are not safe in file names, so the name is a hash.
+func (q *Queue) sentPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(q.sentDir(), hex.EncodeToString(sum[:16])+".json")
+}
+
+// messageIDKey returns a Message-ID without its angle brackets, as reports
+// quote it either way
+func messageIDKey(messageID string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(messageID), "<"), ">")
+}
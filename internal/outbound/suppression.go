@@ -0,0 +1,197 @@
+package outbound
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/metrics"
+)
+
+// Suppression reasons added by GoMail itself. Entries added through the API
+// or CLI may carry any reason and default to ReasonManual.
+const (
+	ReasonHardBounce = "hard_bounce"
+	ReasonComplaint  = "complaint"
+	ReasonManual     = "manual"
+)
+
+// ErrNotSuppressed is returned for addresses that are not on the suppression list
+var ErrNotSuppressed = errors.New("address not suppressed")
+
+// Suppression is an address that mail is no longer sent to
+type Suppression struct {
+	Address   string     `json:"address"` // lowercased
+	Reason    string     `json:"reason"`
+	Detail    string     `json:"detail,omitempty"` // e.g. the bounce's diagnostic code
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil for entries that never expire
+}
+
+// Expired reports whether the entry no longer applies at now
+func (s *Suppression) Expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
+// Suppressions is the suppression list under data_dir/suppressions. Each
+// address is kept in its own file, named after a hash of the address, so the
+// server and `gomail suppression` can both change the list while the other
+// is running. Expired entries are removed when they are next read.
+type Suppressions struct {
+	dir string
+	now func() time.Time
+}
+
+// NewSuppressions opens the suppression list in dir, creating it if needed
+func NewSuppressions(dir string) (*Suppressions, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create suppression directory: %w", err)
+	}
+	return &Suppressions{dir: dir, now: time.Now}, nil
+}
+
+// NormalizeAddress returns address lowercased and without angle brackets or
+// a display name, or an error if it is not an email address
+func NormalizeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q", address)
+	}
+	return strings.ToLower(parsed.Address), nil
+}
+
+// Add puts entry on the list, replacing any existing entry for its address.
+// CreatedAt defaults to now and Reason to ReasonManual.
+func (s *Suppressions) Add(entry *Suppression) error {
+	address, err := NormalizeAddress(entry.Address)
+	if err != nil {
+		return err
+	}
+	entry.Address = address
+	if entry.Reason == "" {
+		entry.Reason = ReasonManual
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = s.now().UTC()
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode suppression: %w", err)
+	}
+	if err := writeAtomic(s.path(address), data); err != nil {
+		return err
+	}
+	metrics.SuppressionsAdded.WithLabelValues(entry.Reason).Inc()
+	return nil
+}
+
+// Suppress adds address for reason unless it is already suppressed, so an
+// automatic entry never replaces one made by hand. A ttl of zero never
+// expires. It reports whether the address was added.
+func (s *Suppressions) Suppress(address, reason, detail string, ttl time.Duration) (bool, error) {
+	if _, err := s.Get(address); err == nil {
+		return false, nil
+	} else if !errors.Is(err, ErrNotSuppressed) {
+		return false, err
+	}
+
+	entry := &Suppression{Address: address, Reason: reason, Detail: detail, CreatedAt: s.now().UTC()}
+	if ttl > 0 {
+		expiresAt := entry.CreatedAt.Add(ttl)
+		entry.ExpiresAt = &expiresAt
+	}
+	if err := s.Add(entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Get returns the entry for address, or ErrNotSuppressed if there is none or
+// it has expired
+func (s *Suppressions) Get(address string) (*Suppression, error) {
+	normalized, err := NormalizeAddress(address)
+	if err != nil {
+		return nil, ErrNotSuppressed
+	}
+	return s.load(s.path(normalized))
+}
+
+// Remove takes address off the list
+func (s *Suppressions) Remove(address string) error {
+	normalized, err := NormalizeAddress(address)
+	if err != nil {
+		return ErrNotSuppressed
+	}
+	if err := os.Remove(s.path(normalized)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotSuppressed
+		}
+		return fmt.Errorf("failed to remove suppression: %w", err)
+	}
+	return nil
+}
+
+// List returns every entry that has not expired, ordered by address
+func (s *Suppressions) List() ([]*Suppression, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read suppression list: %w", err)
+	}
+
+	var list []*Suppression
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		suppression, err := s.load(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			if errors.Is(err, ErrNotSuppressed) {
+				continue // expired, or removed while listing
+			}
+			return nil, err
+		}
+		list = append(list, suppression)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Address < list[j].Address
+	})
+	return list, nil
+}
+
+// load reads one entry, removing it if it has expired
+func (s *Suppressions) load(path string) (*Suppression, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotSuppressed
+		}
+		return nil, fmt.Errorf("failed to read suppression: %w", err)
+	}
+
+	var entry Suppression
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode suppression %s: %w", filepath.Base(path), err)
+	}
+	if entry.Expired(s.now()) {
+		_ = os.Remove(path)
+		return nil, ErrNotSuppressed
+	}
+	return &entry, nil
+}
+
+// path returns the file for a normalized address. Addresses may contain
+// characters that are not safe in file names, so the name is a hash.
+func (s *Suppressions) path(address string) string {
+	sum := sha256.Sum256([]byte(address))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16])+".json")
+}
@@ -0,0 +1,98 @@
+package outbound
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuppressions_AddGetRemove(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSuppressions(dir)
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.Add(&Suppression{Address: "Bob <Bob@Example.NET>", Detail: "asked to be removed"}))
+
+	entry, err := s.Get("bob@example.net")
+	require.NoError(t, err)
+	assert.Equal(t, &Suppression{
+		Address:   "bob@example.net",
+		Reason:    ReasonManual,
+		Detail:    "asked to be removed",
+		CreatedAt: now,
+	}, entry)
+
+	// Lookups ignore case and brackets
+	_, err = s.Get("<BOB@example.net>")
+	assert.NoError(t, err)
+
+	// A second process sees the same list
+	other, err := NewSuppressions(dir)
+	require.NoError(t, err)
+	list, err := other.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "bob@example.net", list[0].Address)
+
+	require.NoError(t, s.Remove("bob@example.net"))
+	_, err = s.Get("bob@example.net")
+	assert.ErrorIs(t, err, ErrNotSuppressed)
+	assert.ErrorIs(t, s.Remove("bob@example.net"), ErrNotSuppressed)
+
+	assert.Error(t, s.Add(&Suppression{Address: "not an address"}))
+}
+
+func TestSuppressions_Expiry(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSuppressions(dir)
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	added, err := s.Suppress("gone@example.net", ReasonHardBounce, "550 5.1.1 No such user", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, added)
+	added, err = s.Suppress("spam@example.net", ReasonComplaint, "abuse", 0)
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	list, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "gone@example.net", list[0].Address)
+	assert.Equal(t, now.Add(time.Hour), *list[0].ExpiresAt)
+	assert.Nil(t, list[1].ExpiresAt)
+
+	// Expired entries disappear, and their files with them
+	now = now.Add(time.Hour)
+	_, err = s.Get("gone@example.net")
+	assert.ErrorIs(t, err, ErrNotSuppressed)
+	list, err = s.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "spam@example.net", list[0].Address)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestSuppressions_SuppressKeepsExisting(t *testing.T) {
+	s, err := NewSuppressions(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, s.Add(&Suppression{Address: "bob@example.net", Reason: "unsubscribed"}))
+
+	added, err := s.Suppress("bob@example.net", ReasonHardBounce, "550 5.1.1 No such user", time.Hour)
+	require.NoError(t, err)
+	assert.False(t, added)
+
+	entry, err := s.Get("bob@example.net")
+	require.NoError(t, err)
+	assert.Equal(t, "unsubscribed", entry.Reason)
+	assert.Nil(t, entry.ExpiresAt, "an automatic entry never replaces one made by hand")
+}
@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/domainmatch"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+)
+
+// Verdict is the outcome of a recipient check
+type Verdict int
+
+const (
+	// Accepted recipients are delivered
+	Accepted Verdict = iota
+	// UnknownUser is an address in a served domain that is not accepted
+	UnknownUser
+	// UnknownDomain is an address in a domain the policy does not serve
+	UnknownDomain
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Accepted:
+		return "accepted"
+	case UnknownUser:
+		return "unknown_user"
+	default:
+		return "unknown_domain"
+	}
+}
+
+// recipientDomain is a compiled config.RecipientDomain
+type recipientDomain struct {
+	domain     string          // lowercased, "*." prefix matches subdomains
+	recipients map[string]bool // lowercased local parts
+	patterns   []*regexp.Regexp
+	catchAll   bool
+}
+
+// Recipients decides which envelope recipients are accepted
+type Recipients struct {
+	domains []recipientDomain
+}
+
+// NewRecipients compiles the recipient_domains configuration. Patterns are
+// anchored, so "ticket-\d+" does not accept "old-ticket-1".
+func NewRecipients(domains []config.RecipientDomain) (*Recipients, error) {
+	r := &Recipients{}
+
+	for _, dc := range domains {
+		rd := recipientDomain{
+			domain:     strings.ToLower(strings.TrimSpace(dc.Domain)),
+			recipients: make(map[string]bool),
+			catchAll:   dc.CatchAll,
+		}
+		for _, local := range dc.Recipients {
+			rd.recipients[strings.ToLower(strings.TrimSpace(local))] = true
+		}
+		for _, pattern := range dc.Patterns {
+			re, err := regexp.Compile("(?i)^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient pattern %q for %s: %w", pattern, dc.Domain, err)
+			}
+			rd.patterns = append(rd.patterns, re)
+		}
+		r.domains = append(r.domains, rd)
+	}
+
+	return r, nil
+}
+
+// Enabled reports whether any recipient domains are configured. Without
+// them every recipient is accepted.
+func (r *Recipients) Enabled() bool {
+	return r != nil && len(r.domains) > 0
+}
+
+// Check decides whether mail to address is accepted. The first configured
+// domain matching the address decides; postmaster is accepted in every
+// served domain.
+func (r *Recipients) Check(address string) Verdict {
+	if !r.Enabled() {
+		return Accepted
+	}
+
+	verdict := r.check(address)
+	metrics.RecipientChecks.WithLabelValues(verdict.String()).Inc()
+	return verdict
+}
+
+func (r *Recipients) check(address string) Verdict {
+	address = strings.ToLower(strings.TrimSpace(address))
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return UnknownDomain
+	}
+	local, domain := address[:at], address[at+1:]
+
+	for _, rd := range r.domains {
+		if !domainmatch.Match(rd.domain, domain) {
+			continue
+		}
+		if rd.catchAll || rd.recipients[local] || local == "postmaster" {
+			return Accepted
+		}
+		for _, re := range rd.patterns {
+			if re.MatchString(local) {
+				return Accepted
+			}
+		}
+		return UnknownUser
+	}
+
+	return UnknownDomain
+}
@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecipients_Check(t *testing.T) {
+	recipients, err := NewRecipients([]config.RecipientDomain{
+		{Domain: "example.com", Recipients: []string{"Support", "billing"}, Patterns: []string{`ticket-\d+`}},
+		{Domain: "*.example.org", CatchAll: true},
+	})
+	require.NoError(t, err)
+	assert.True(t, recipients.Enabled())
+
+	tests := []struct {
+		address string
+		want    Verdict
+	}{
+		{"support@example.com", Accepted},
+		{"SUPPORT@Example.COM", Accepted},
+		{"billing@example.com", Accepted},
+		{"ticket-42@example.com", Accepted},
+		{"postmaster@example.com", Accepted},
+		{"old-ticket-42@example.com", UnknownUser},
+		{"ticket-42x@example.com", UnknownUser},
+		{"sales@example.com", UnknownUser},
+		{"anyone@example.org", Accepted},
+		{"anyone@eu.example.org", Accepted},
+		{"support@example.net", UnknownDomain},
+		{"support@sub.example.com", UnknownDomain},
+		{"support", UnknownDomain},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, recipients.Check(tt.address), tt.address)
+	}
+}
+
+func TestRecipients_Disabled(t *testing.T) {
+	recipients, err := NewRecipients(nil)
+	require.NoError(t, err)
+	assert.False(t, recipients.Enabled())
+	assert.Equal(t, Accepted, recipients.Check("anyone@anywhere.example"))
+
+	var none *Recipients
+	assert.Equal(t, Accepted, none.Check("anyone@anywhere.example"))
+}
+
+func TestNewRecipients_InvalidPattern(t *testing.T) {
+	_, err := NewRecipients([]config.RecipientDomain{{Domain: "example.com", Patterns: []string{"ticket-("}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,353 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/grumpyguvner/gomail/internal/auth"
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/grumpyguvner/gomail/internal/security"
+	"go.uber.org/zap"
+)
+
+const (
+	// idleTimeout matches Postfix's smtpd_policy_service_max_idle, after
+	// which the client drops the connection anyway
+	idleTimeout = 300 * time.Second
+
+	// maxRequestSize bounds the attributes of one request
+	maxRequestSize = 64 * 1024
+
+	// transactionTTL bounds how long SPF results are kept for a Postfix
+	// transaction, whose recipients share one SPF check
+	transactionTTL = 10 * time.Minute
+
+	dunno = "DUNNO"
+)
+
+// Request holds the attributes of one Postfix policy delegation request,
+// such as "protocol_state", "recipient" and "client_address"
+type Request map[string]string
+
+// spfChecker is implemented by auth.SPFVerifier
+type spfChecker interface {
+	Verify(ctx context.Context, ip net.IP, heloHost, mailFrom string) (*auth.SPFResult, error)
+}
+
+// transaction is what the service remembers about one Postfix transaction
+// between its RCPT requests
+type transaction struct {
+	spf  *auth.SPFResult
+	seen time.Time
+}
+
+// Server answers Postfix check_policy_service requests over the policy
+// delegation protocol:
+//
+//	smtpd_recipient_restrictions = ..., check_policy_service inet:127.0.0.1:10040
+//
+// At RCPT time it rejects unknown recipients and senders failing SPF, and
+// defers when SPF cannot be checked. DMARC is not judged here: Postfix does
+// not pass message headers or DKIM signatures to policy services, so an
+// SPF failure says nothing about whether an aligned DKIM signature would
+// still pass. DMARC is left to the milter or to the full check when the
+// message is received.
+type Server struct {
+	recipients    *Recipients
+	spf           spfChecker // nil when spf_enabled is off
+	rejectSPFFail bool
+	limiter       *security.ConnectionLimiter
+	logger        *zap.SugaredLogger
+
+	txMu         sync.Mutex
+	transactions map[string]*transaction // by Postfix "instance"
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	sessions sync.WaitGroup
+	closing  atomic.Bool
+}
+
+// NewServer creates a policy service from the recipient_domains, policy_*
+// and spf_enabled settings
+func NewServer(cfg *config.Config) (*Server, error) {
+	recipients, err := NewRecipients(cfg.RecipientDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	maxConnections := cfg.PolicyServiceMaxConnections
+	if maxConnections <= 0 {
+		maxConnections = 100
+	}
+
+	s := &Server{
+		recipients:    recipients,
+		rejectSPFFail: cfg.PolicyRejectSPFFail,
+		limiter:       security.NewConnectionLimiter(maxConnections, maxConnections, time.Minute),
+		logger:        logging.Get(),
+		transactions:  make(map[string]*transaction),
+		conns:         make(map[net.Conn]struct{}),
+	}
+	if cfg.SPFEnabled {
+		s.spf = auth.NewSPFVerifier()
+	}
+
+	return s, nil
+}
+
+// Serve accepts connections on listener until Shutdown is called. Postfix
+// keeps connections open and sends one request after another.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.closing.Load() {
+				return nil
+			}
+			if isTimeout(err) {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		// Postfix treats a refused connection as a temporary failure, so
+		// senders are deferred rather than accepted unchecked
+		remote := conn.RemoteAddr().String()
+		if !s.limiter.Accept(remote) {
+			_ = conn.Close()
+			continue
+		}
+
+		if !s.track(conn) {
+			s.limiter.Release(remote)
+			_ = conn.Close()
+			continue
+		}
+
+		go func() {
+			defer s.sessions.Done()
+			defer s.limiter.Release(remote)
+			defer s.untrack(conn)
+
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and closes idle ones. Postfix
+// reconnects on its next request, so nothing is lost. Connections still
+// open when ctx expires are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+
+	s.mu.Lock()
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Decide returns the action for a request. Anything the policy has no
+// opinion about gets DUNNO, leaving the decision to the restrictions that
+// follow in Postfix.
+func (s *Server) Decide(ctx context.Context, req Request) string {
+	if req["request"] != "smtpd_access_policy" {
+		return dunno
+	}
+
+	switch req["protocol_state"] {
+	case "RCPT":
+		return s.decideRcpt(ctx, req)
+	}
+	return dunno
+}
+
+func (s *Server) decideRcpt(ctx context.Context, req Request) string {
+	if recipient := req["recipient"]; recipient != "" {
+		if s.recipients.Check(recipient) == UnknownUser {
+			return fmt.Sprintf("550 5.1.1 <%s>: Recipient address rejected: User unknown", recipient)
+		}
+	}
+
+	spf := s.checkSPF(ctx, req)
+	if spf == nil {
+		return dunno
+	}
+	switch spf.Result {
+	case authres.ResultFail:
+		if s.rejectSPFFail {
+			return fmt.Sprintf("550 5.7.23 <%s>: Sender address rejected: SPF check failed for %s", req["sender"], req["client_address"])
+		}
+	case authres.ResultTempError:
+		return fmt.Sprintf("451 4.7.24 <%s>: Sender address rejected: SPF lookup failed, try again later", req["sender"])
+	}
+	return dunno
+}
+
+// checkSPF returns the SPF result for the request's transaction, checking
+// the client once per transaction. It is nil when SPF checks are disabled
+// or the request has no client address.
+func (s *Server) checkSPF(ctx context.Context, req Request) *auth.SPFResult {
+	if s.spf == nil {
+		return nil
+	}
+	ip := net.ParseIP(req["client_address"])
+	if ip == nil {
+		return nil
+	}
+
+	instance := req["instance"]
+	s.txMu.Lock()
+	tx, ok := s.transactions[instance]
+	s.txMu.Unlock()
+	if ok && instance != "" {
+		return tx.spf
+	}
+
+	spf, err := s.spf.Verify(ctx, ip, req["helo_name"], req["sender"])
+	if err != nil {
+		s.logger.Warnf("SPF check for %s from %s failed: %v", req["sender"], ip, err)
+	}
+	if instance != "" && spf != nil {
+		s.remember(instance, spf)
+	}
+	return spf
+}
+
+func (s *Server) remember(instance string, spf *auth.SPFResult) {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	now := time.Now()
+	for id, tx := range s.transactions {
+		if now.Sub(tx.seen) > transactionTTL {
+			delete(s.transactions, id)
+		}
+	}
+	s.transactions[instance] = &transaction{spf: spf, seen: now}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxRequestSize)
+
+	req := Request{}
+	for {
+		if s.closing.Load() && len(req) == 0 {
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil && !isTimeout(err) {
+				s.logger.Warnf("Policy service connection from %s failed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		line := scanner.Text()
+		if line != "" {
+			name, value, ok := strings.Cut(line, "=")
+			if !ok {
+				s.logger.Warnf("Policy service got a malformed attribute from %s: %q", conn.RemoteAddr(), line)
+				return
+			}
+			req[name] = value
+			continue
+		}
+
+		// An empty line ends the request
+		action := s.Decide(context.Background(), req)
+		metrics.PolicyRequests.WithLabelValues(actionLabel(action)).Inc()
+		if action != dunno {
+			s.logger.Infow("Policy service refused mail",
+				"state", req["protocol_state"],
+				"action", action,
+				"sender", req["sender"],
+				"recipient", req["recipient"],
+				"client", req["client_address"],
+				"queue_id", req["queue_id"])
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := fmt.Fprintf(conn, "action=%s\n\n", action); err != nil {
+			return
+		}
+		req = Request{}
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing.Load() {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.sessions.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+// actionLabel reduces an action to its metric label
+func actionLabel(action string) string {
+	switch {
+	case action == dunno:
+		return "dunno"
+	case strings.HasPrefix(action, "4"):
+		return "defer"
+	default:
+		return "reject"
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,207 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/grumpyguvner/gomail/internal/auth"
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		RecipientDomains: []config.RecipientDomain{
+			{Domain: "example.com", Recipients: []string{"support"}},
+		},
+	}
+}
+
+// fakeSPF answers every check with result and counts the lookups
+type fakeSPF struct {
+	mu     sync.Mutex
+	result authres.ResultValue
+	checks int
+}
+
+func (f *fakeSPF) Verify(ctx context.Context, ip net.IP, heloHost, mailFrom string) (*auth.SPFResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.checks++
+	domain := mailFrom[strings.LastIndex(mailFrom, "@")+1:]
+	return &auth.SPFResult{Result: f.result, Domain: domain, IP: ip.String()}, nil
+}
+
+func request(state, instance, recipient string) Request {
+	return Request{
+		"request":        "smtpd_access_policy",
+		"protocol_state": state,
+		"instance":       instance,
+		"client_address": "192.0.2.1",
+		"helo_name":      "mx.example.net",
+		"sender":         "alice@example.net",
+		"recipient":      recipient,
+	}
+}
+
+func TestServer_Decide(t *testing.T) {
+	server, err := NewServer(testConfig())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{
+			name: "known recipient",
+			req:  Request{"request": "smtpd_access_policy", "protocol_state": "RCPT", "recipient": "support@example.com"},
+			want: "DUNNO",
+		},
+		{
+			name: "unknown recipient",
+			req:  Request{"request": "smtpd_access_policy", "protocol_state": "RCPT", "recipient": "nobody@example.com"},
+			want: "550 5.1.1 <nobody@example.com>: Recipient address rejected: User unknown",
+		},
+		{
+			name: "domain left to Postfix",
+			req:  Request{"request": "smtpd_access_policy", "protocol_state": "RCPT", "recipient": "nobody@example.net"},
+			want: "DUNNO",
+		},
+		{
+			name: "other protocol state",
+			req:  Request{"request": "smtpd_access_policy", "protocol_state": "DATA", "recipient": "nobody@example.com"},
+			want: "DUNNO",
+		},
+		{
+			name: "unknown request type",
+			req:  Request{"request": "something_else", "protocol_state": "RCPT", "recipient": "nobody@example.com"},
+			want: "DUNNO",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, server.Decide(context.Background(), tt.req))
+		})
+	}
+}
+
+func TestServer_SPF(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		result authres.ResultValue
+		reject bool
+		want   string
+	}{
+		{"pass", authres.ResultPass, true, "DUNNO"},
+		{"softfail", authres.ResultSoftFail, true, "DUNNO"},
+		{"fail", authres.ResultFail, true, "550 5.7.23 <alice@example.net>: Sender address rejected: SPF check failed for 192.0.2.1"},
+		{"fail without rejection", authres.ResultFail, false, "DUNNO"},
+		{"temporary error", authres.ResultTempError, false, "451 4.7.24 <alice@example.net>: Sender address rejected: SPF lookup failed, try again later"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewServer(testConfig())
+			require.NoError(t, err)
+			spf := &fakeSPF{result: tt.result}
+			server.spf = spf
+			server.rejectSPFFail = tt.reject
+
+			assert.Equal(t, tt.want, server.Decide(ctx, request("RCPT", "A1", "support@example.com")))
+			server.Decide(ctx, request("RCPT", "A1", "support@example.com"))
+			assert.Equal(t, 1, spf.checks, "one lookup per transaction")
+		})
+	}
+
+	// Unknown users are refused before SPF is looked at
+	server, err := NewServer(testConfig())
+	require.NoError(t, err)
+	spf := &fakeSPF{result: authres.ResultFail}
+	server.spf = spf
+	assert.Contains(t, server.Decide(ctx, request("RCPT", "B1", "nobody@example.com")), "5.1.1")
+	assert.Zero(t, spf.checks)
+}
+
+func TestServer_EndOfData(t *testing.T) {
+	ctx := context.Background()
+
+	// Without the headers and DKIM signatures an SPF failure cannot decide
+	// DMARC, so END-OF-DATA is left to the full check even with strict
+	// enforcement
+	cfg := testConfig()
+	cfg.DMARCEnabled = true
+	cfg.DMARCEnforcement = "strict"
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+	server.spf = &fakeSPF{result: authres.ResultFail}
+
+	assert.Equal(t, "DUNNO", server.Decide(ctx, request("RCPT", "C1", "support@example.com")))
+	assert.Equal(t, "DUNNO", server.Decide(ctx, request("END-OF-DATA", "C1", "")))
+}
+
+func TestNewServer_Checks(t *testing.T) {
+	cfg := testConfig()
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, server.spf)
+
+	cfg.SPFEnabled = true
+	server, err = NewServer(cfg)
+	require.NoError(t, err)
+	assert.NotNil(t, server.spf)
+}
+
+func TestServer_Protocol(t *testing.T) {
+	server, err := NewServer(testConfig())
+	require.NoError(t, err)
+
+	// Postfix usually talks to the service over a socket
+	socket := filepath.Join(t.TempDir(), "policy.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	go func() { _ = server.Serve(listener) }()
+
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	ask := func(recipient string) string {
+		_, err := conn.Write([]byte("request=smtpd_access_policy\nprotocol_state=RCPT\nprotocol_name=ESMTP\n" +
+			"client_address=192.0.2.1\nsender=alice@example.net\nrecipient=" + recipient + "\n\n"))
+		require.NoError(t, err)
+
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		blank, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "\n", blank)
+		return strings.TrimSuffix(line, "\n")
+	}
+
+	// Requests follow one another on the same connection
+	assert.Equal(t, "action=DUNNO", ask("support@example.com"))
+	assert.Equal(t, "action=550 5.1.1 <nobody@example.com>: Recipient address rejected: User unknown", ask("nobody@example.com"))
+	assert.Equal(t, "action=DUNNO", ask("Support@Example.com"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, server.Shutdown(ctx))
+
+	_, err = reader.ReadString('\n')
+	assert.Error(t, err, "idle connection closed on shutdown")
+}
@@ -2,6 +2,7 @@ package postfix
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"strings"
@@ -28,9 +29,11 @@ func (i *Installer) Install() error {
 		return fmt.Errorf("failed to configure Postfix: %w", err)
 	}
 
-	// Create pipe script
-	if err := i.createPipeScript(); err != nil {
-		return fmt.Errorf("failed to create pipe script: %w", err)
+	// Create pipe script, unless Postfix delivers over LMTP
+	if !i.config.LMTPEnabled {
+		if err := i.createPipeScript(); err != nil {
+			return fmt.Errorf("failed to create pipe script: %w", err)
+		}
 	}
 
 	// Configure master.cf
@@ -64,9 +67,16 @@ func (i *Installer) configurePostfix() error {
 	// Check if Postfix is already configured for our mail server
 	cmd := exec.Command("postconf", "virtual_transport")
 	output, _ := cmd.Output()
-	if strings.Contains(string(output), "mailapi") {
-		// Already configured, just update domains if needed
-		return i.updateDomains()
+	if strings.Contains(string(output), "mailapi") || strings.Contains(string(output), "lmtp:") {
+		// Already configured, just update domains, the transport and the
+		// policy service if needed
+		if err := i.updateDomains(); err != nil {
+			return err
+		}
+		if err := i.configureTransport(); err != nil {
+			return err
+		}
+		return i.configurePolicyService()
 	}
 
 	// Set Postfix configuration parameters
@@ -80,13 +90,14 @@ func (i *Installer) configurePostfix() error {
 		"local_recipient_maps":                "",
 		"virtual_mailbox_domains":             i.config.PrimaryDomain,
 		"virtual_mailbox_maps":                "regexp:/etc/postfix/virtual_mailbox_regex",
-		"virtual_transport":                   "mailapi:",
-		"mailapi_destination_recipient_limit": "1",
+		"virtual_transport":                   i.virtualTransport(),
+		"lmtp_send_xforward_command":          "yes",
+		"mailapi_destination_recipient_limit": "50",
 		"message_size_limit":                  "26214400",
 		"mailbox_size_limit":                  "0",
 		"smtpd_banner":                        "$myhostname ESMTP",
 		"smtpd_relay_restrictions":            "permit_mynetworks,reject_unauth_destination",
-		"smtpd_recipient_restrictions":        "permit_mynetworks,reject_unauth_destination",
+		"smtpd_recipient_restrictions":        i.recipientRestrictions(),
 		"smtpd_client_restrictions":           "permit_mynetworks,reject_unknown_reverse_client_hostname",
 	}
 
@@ -114,6 +125,81 @@ func (i *Installer) configurePostfix() error {
 	return nil
 }
 
+// configureTransport switches virtual_transport between the pipe script
+// and LMTP
+func (i *Installer) configureTransport() error {
+	settings := map[string]string{
+		"virtual_transport":          i.virtualTransport(),
+		"lmtp_send_xforward_command": "yes",
+	}
+	for key, value := range settings {
+		cmd := exec.Command("postconf", "-e", fmt.Sprintf("%s=%s", key, value))
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// virtualTransport returns the transport for virtual domains: the lmtp
+// client pointed at GoMail's LMTP server, or the mailapi pipe
+func (i *Installer) virtualTransport() string {
+	if i.config.LMTPEnabled {
+		if endpoint := postfixEndpoint(i.config.LMTPListen); endpoint != "" {
+			return "lmtp:" + endpoint
+		}
+	}
+	return "mailapi:"
+}
+
+// configurePolicyService points Postfix's restrictions at the policy
+// service, or removes it when the service is disabled
+func (i *Installer) configurePolicyService() error {
+	cmd := exec.Command("postconf", "-e", "smtpd_recipient_restrictions="+i.recipientRestrictions())
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to set smtpd_recipient_restrictions: %w", err)
+	}
+	return nil
+}
+
+// recipientRestrictions returns smtpd_recipient_restrictions, consulting
+// GoMail's policy service after the relay check when it is enabled
+func (i *Installer) recipientRestrictions() string {
+	restrictions := "permit_mynetworks,reject_unauth_destination"
+	if service := i.policyService(); service != "" {
+		restrictions += ",check_policy_service " + service
+	}
+	return restrictions
+}
+
+// policyService returns the check_policy_service endpoint for
+// policy_service_listen, or "" when the policy service is disabled
+func (i *Installer) policyService() string {
+	if !i.config.PolicyServiceEnabled {
+		return ""
+	}
+	return postfixEndpoint(i.config.PolicyServiceListen)
+}
+
+// postfixEndpoint turns a GoMail listen address into a Postfix endpoint,
+// "inet:host:port" or "unix:path". Sockets under the Postfix queue
+// directory are given relative to it so they also work from chrooted
+// Postfix daemons.
+func postfixEndpoint(listen string) string {
+	if socket, ok := strings.CutPrefix(listen, "unix:"); ok {
+		return "unix:" + strings.TrimPrefix(socket, "/var/spool/postfix/")
+	}
+
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return ""
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "inet:" + net.JoinHostPort(host, port)
+}
+
 func (i *Installer) updateDomains() error {
 	// Ensure primary domain is in domains list
 	domains := []string{}
@@ -177,22 +263,26 @@ API_BEARER_TOKEN="${API_BEARER_TOKEN:-%s}"
 # Read the email from stdin
 EMAIL_DATA=$(cat)
 
-# Extract sender and recipient from Postfix environment
-SENDER="${2:-unknown}"
-RECIPIENT="${3:-unknown}"
+# Postfix passes the sender followed by one argument per recipient
+SENDER="${1:-unknown}"
+if [ $# -gt 0 ]; then
+    shift
+fi
+RECIPIENTS=$(IFS=,; echo "$*")
+RECIPIENTS="${RECIPIENTS:-unknown}"
 CLIENT_ADDRESS="${CLIENT_ADDRESS:-}"
 CLIENT_HOSTNAME="${CLIENT_NAME:-}"
 CLIENT_HELO="${CLIENT_HELO:-}"
 
 # Log for debugging
-logger -t postfix-to-api "Processing email from $SENDER to $RECIPIENT"
+logger -t postfix-to-api "Processing email from $SENDER to $RECIPIENTS"
 
 # Send to API with authentication metadata in headers
 response=$(curl -s -w "\n%%{http_code}" -X POST "$API_ENDPOINT" \
   -H "Authorization: Bearer $API_BEARER_TOKEN" \
   -H "Content-Type: message/rfc822" \
   -H "X-Original-Sender: $SENDER" \
-  -H "X-Original-Recipient: $RECIPIENT" \
+  -H "X-Original-Recipient: $RECIPIENTS" \
   -H "X-Original-Client-Address: $CLIENT_ADDRESS" \
   -H "X-Original-Client-Hostname: $CLIENT_HOSTNAME" \
   -H "X-Original-Helo: $CLIENT_HELO" \
@@ -237,6 +327,22 @@ func (i *Installer) configureMasterCF() error {
 		return fmt.Errorf("failed to read master.cf: %w", err)
 	}
 
+	// LMTP delivery uses Postfix's own lmtp client, which stock master.cf
+	// files define; only put it back if it was removed
+	if i.config.LMTPEnabled {
+		if hasService(string(content), "lmtp") {
+			return nil
+		}
+		lmtpConfig := `
+# LMTP client for delivery to GoMail
+lmtp      unix  -       -       n       -       -       lmtp
+`
+		if err := os.WriteFile(masterCFPath, append(content, []byte(lmtpConfig)...), 0644); err != nil {
+			return fmt.Errorf("failed to update master.cf: %w", err)
+		}
+		return nil
+	}
+
 	// Check if mailapi transport already exists
 	if strings.Contains(string(content), "mailapi") {
 		// Check if it needs updating (e.g., if the path changed)
@@ -279,6 +385,20 @@ mailapi   unix  -       n       n       -       -       pipe
 	return nil
 }
 
+// hasService reports whether master.cf content defines the named service
+func hasService(content, name string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		// Indented lines continue the previous service's arguments
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if fields := strings.Fields(line); fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (i *Installer) enablePostfix() error {
 	// Enable Postfix service
 	cmd := exec.Command("systemctl", "enable", "postfix")
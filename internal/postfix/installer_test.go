@@ -169,3 +169,58 @@ func TestReverseClientHostnameRejection(t *testing.T) {
 		t.Skip("Requires full SMTP test environment")
 	})
 }
+
+func TestPolicyServiceRestrictions(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		listen    string
+		recipient string
+	}{
+		{"policy service disabled", false, "127.0.0.1:10040", "permit_mynetworks,reject_unauth_destination"},
+		{"loopback", true, "127.0.0.1:10040", "permit_mynetworks,reject_unauth_destination,check_policy_service inet:127.0.0.1:10040"},
+		{"all interfaces", true, ":10040", "permit_mynetworks,reject_unauth_destination,check_policy_service inet:127.0.0.1:10040"},
+		{"queue directory socket", true, "unix:/var/spool/postfix/private/gomail-policy", "permit_mynetworks,reject_unauth_destination,check_policy_service unix:private/gomail-policy"},
+		{"other socket", true, "unix:/run/gomail/policy.sock", "permit_mynetworks,reject_unauth_destination,check_policy_service unix:/run/gomail/policy.sock"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installer := NewInstaller(&config.Config{PolicyServiceEnabled: tt.enabled, PolicyServiceListen: tt.listen})
+			assert.Equal(t, tt.recipient, installer.recipientRestrictions())
+		})
+	}
+}
+
+func TestVirtualTransport(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		listen  string
+		want    string
+	}{
+		{"pipe script", false, "unix:/var/spool/postfix/private/gomail-lmtp", "mailapi:"},
+		{"queue directory socket", true, "unix:/var/spool/postfix/private/gomail-lmtp", "lmtp:unix:private/gomail-lmtp"},
+		{"loopback", true, "127.0.0.1:2424", "lmtp:inet:127.0.0.1:2424"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installer := NewInstaller(&config.Config{LMTPEnabled: tt.enabled, LMTPListen: tt.listen})
+			assert.Equal(t, tt.want, installer.virtualTransport())
+		})
+	}
+}
+
+func TestHasService(t *testing.T) {
+	masterCF := "# service type  private unpriv  chroot  wakeup  maxproc command\n" +
+		"smtp      inet  n       -       n       -       -       smtpd\n" +
+		"#lmtp     unix  -       -       n       -       -       lmtp\n" +
+		"mailapi   unix  -       n       n       -       -       pipe\n" +
+		"  flags=FR user=nobody argv=/usr/local/bin/postfix-to-api\n"
+
+	assert.True(t, hasService(masterCF, "smtp"))
+	assert.True(t, hasService(masterCF, "mailapi"))
+	assert.False(t, hasService(masterCF, "lmtp"), "commented out")
+	assert.False(t, hasService(masterCF, "flags=FR"))
+}
@@ -0,0 +1,267 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/auth"
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/grumpyguvner/gomail/internal/policy"
+	"github.com/grumpyguvner/gomail/internal/security"
+	gomailtls "github.com/grumpyguvner/gomail/internal/tls"
+	"go.uber.org/zap"
+)
+
+// Envelope is a message accepted in one SMTP transaction
+type Envelope struct {
+	SessionID  string
+	ClientIP   net.IP
+	Helo       string
+	TLS        bool
+	From       string // empty for the null reverse-path of bounces
+	Recipients []string
+	Data       []byte // the message with GoMail's Received header prepended
+
+	// Auth holds checks the caller has already run on the message, as the
+	// milter does; when nil the handler authenticates the message itself
+	Auth *auth.AuthenticationResult
+}
+
+// Handler takes responsibility for accepted messages. Returning nil
+// acknowledges the message to the client; errors are turned into SMTP
+// replies by replyForError.
+type Handler interface {
+	Receive(ctx context.Context, env *Envelope) error
+}
+
+// Server is an RFC 5321 receiver that hands every message accepted with
+// DATA to a Handler. It supports SIZE, 8BITMIME, PIPELINING,
+// ENHANCEDSTATUSCODES and STARTTLS, and does not relay: with a recipient
+// policy configured, unknown recipients are refused at RCPT time. The same
+// server speaks LMTP when created with NewLMTPServer.
+type Server struct {
+	hostname       string
+	handler        Handler
+	lmtp           bool
+	tlsConfig      *tls.Config
+	requireTLS     bool
+	maxSize        int64
+	maxRecipients  int
+	recipients     *policy.Recipients
+	timeout        time.Duration
+	handlerTimeout time.Duration
+	limiter        *security.ConnectionLimiter
+	logger         *zap.SugaredLogger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]*atomic.Bool // whether the session is idle
+	sessions sync.WaitGroup
+	closing  atomic.Bool
+}
+
+// NewServer creates an SMTP receiver from the smtp_* and recipient_domains
+// settings. STARTTLS is offered when the configured certificate and key can
+// be loaded.
+func NewServer(cfg *config.Config, handler Handler) (*Server, error) {
+	s := newServer(cfg, handler)
+	s.requireTLS = cfg.SMTPRequireTLS
+	s.limiter = security.NewConnectionLimiter(cfg.SMTPMaxConnectionsPerIP, cfg.SMTPMaxConnections, time.Hour)
+
+	recipients, err := policy.NewRecipients(cfg.RecipientDomains)
+	if err != nil {
+		return nil, err
+	}
+	s.recipients = recipients
+
+	if cfg.SMTPTLSCertFile != "" {
+		tlsCfg := gomailtls.NewConfig(cfg)
+		if err := tlsCfg.LoadCertificatesFrom(cfg.SMTPTLSCertFile, cfg.SMTPTLSKeyFile); err != nil {
+			if s.requireTLS {
+				return nil, fmt.Errorf("smtp_require_tls is set but no certificate is available: %w", err)
+			}
+			s.logger.Warnf("STARTTLS will not be offered: %v", err)
+		} else {
+			tlsConfig, err := tlsCfg.GetTLSConfig()
+			if err != nil {
+				return nil, err
+			}
+			s.tlsConfig = tlsConfig
+		}
+	} else if s.requireTLS {
+		return nil, fmt.Errorf("smtp_require_tls is set but smtp_tls_cert_file is empty")
+	}
+
+	return s, nil
+}
+
+// NewLMTPServer creates an RFC 2033 LMTP server for Postfix's lmtp
+// transport. Postfix has already accepted the message and applied its
+// restrictions, so there is no recipient policy or STARTTLS; the original
+// client is taken from the XFORWARD command. Size, recipient and timeout
+// limits are shared with the SMTP receiver.
+func NewLMTPServer(cfg *config.Config, handler Handler) *Server {
+	s := newServer(cfg, handler)
+	s.lmtp = true
+	// All connections come from the local Postfix
+	s.limiter = security.NewConnectionLimiter(cfg.SMTPMaxConnections, cfg.SMTPMaxConnections, time.Minute)
+	return s
+}
+
+func newServer(cfg *config.Config, handler Handler) *Server {
+	s := &Server{
+		hostname:       cfg.MailHostname,
+		handler:        handler,
+		maxSize:        int64(cfg.SMTPMaxMessageSizeMB) * 1024 * 1024,
+		maxRecipients:  cfg.SMTPMaxRecipients,
+		timeout:        time.Duration(cfg.SMTPTimeout) * time.Second,
+		handlerTimeout: time.Duration(cfg.HandlerTimeout) * time.Second,
+		logger:         logging.Get(),
+		conns:          make(map[net.Conn]*atomic.Bool),
+	}
+	if s.hostname == "" {
+		s.hostname = "localhost"
+	}
+	return s
+}
+
+// Listen opens a listen address: a host:port, or a unix socket for
+// "unix:/path". A socket left behind by a previous run is replaced.
+func Listen(address string) (net.Listener, error) {
+	socket, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(socket)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	// Postfix connects as the postfix user
+	if err := os.Chmod(socket, 0666); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// Serve accepts connections on listener until Shutdown is called
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.closing.Load() {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		remote := conn.RemoteAddr().String()
+		if !s.limiter.Accept(remote) {
+			_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			_, _ = conn.Write([]byte("421 4.7.0 Too many connections, try again later\r\n"))
+			_ = conn.Close()
+			continue
+		}
+
+		idle := s.track(conn)
+		if idle == nil {
+			s.limiter.Release(remote)
+			_ = conn.Close()
+			continue
+		}
+
+		go func() {
+			defer s.sessions.Done()
+			defer s.limiter.Release(remote)
+			defer s.untrack(conn)
+
+			newSession(s, conn, idle).serve()
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and ends idle sessions with a 421
+// reply. Messages already received are handed to the handler before their
+// session closes. Sessions still open when ctx expires are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+
+	s.mu.Lock()
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	// Wake sessions waiting for the client's next command; sessions busy
+	// with a message see the shutdown once they are done with it
+	for conn, idle := range s.conns {
+		if idle.Load() {
+			_ = conn.SetReadDeadline(time.Now())
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// track registers a session and returns its idle flag, or nil once
+// shutdown has started
+func (s *Server) track(conn net.Conn) *atomic.Bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing.Load() {
+		return nil
+	}
+	idle := &atomic.Bool{}
+	s.conns[conn] = idle
+	s.sessions.Add(1)
+	metrics.SMTPSessions.Inc()
+	metrics.SMTPActiveSessions.Inc()
+	return idle
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+	metrics.SMTPActiveSessions.Dec()
+}
@@ -0,0 +1,582 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	netsmtp "net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHandler keeps every envelope it receives and answers with err
+type recordingHandler struct {
+	mu        sync.Mutex
+	envelopes []*Envelope
+	err       error
+}
+
+func (h *recordingHandler) Receive(ctx context.Context, env *Envelope) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.envelopes = append(h.envelopes, env)
+	return h.err
+}
+
+func (h *recordingHandler) received() []*Envelope {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]*Envelope(nil), h.envelopes...)
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		MailHostname:            "mx.example.com",
+		HandlerTimeout:          5,
+		SMTPMaxMessageSizeMB:    1,
+		SMTPMaxRecipients:       3,
+		SMTPMaxConnections:      10,
+		SMTPMaxConnectionsPerIP: 10,
+		SMTPTimeout:             5,
+	}
+}
+
+// startServer runs a receiver on a loopback port and returns its address
+func startServer(t *testing.T, cfg *config.Config, handler Handler) (*Server, string) {
+	t.Helper()
+
+	server, err := NewServer(cfg, handler)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+
+	return server, listener.Addr().String()
+}
+
+// rawClient speaks SMTP line by line for tests net/smtp cannot express
+type rawClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialRaw(t *testing.T, addr string) *rawClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c := &rawClient{conn: conn, reader: bufio.NewReader(conn)}
+	assert.True(t, strings.HasPrefix(c.reply(t), "220 mx.example.com"))
+	return c
+}
+
+func (c *rawClient) send(t *testing.T, data string) {
+	t.Helper()
+
+	_, err := c.conn.Write([]byte(data))
+	require.NoError(t, err)
+}
+
+// reply reads one complete, possibly multiline, reply
+func (c *rawClient) reply(t *testing.T) string {
+	t.Helper()
+
+	var lines []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		require.NoError(t, err)
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+		if len(line) < 4 || line[3] != '-' {
+			return strings.Join(lines, "\n")
+		}
+	}
+}
+
+func TestServer_ReceivesMessage(t *testing.T) {
+	handler := &recordingHandler{}
+	_, addr := startServer(t, testConfig(), handler)
+
+	body := "From: alice@example.com\r\nSubject: Hello\r\n\r\nHi Bob\r\n.leading dot\r\n"
+	err := netsmtp.SendMail(addr, nil, "alice@example.com",
+		[]string{"bob@example.com", "carol@example.com"}, []byte(body))
+	require.NoError(t, err)
+
+	envelopes := handler.received()
+	require.Len(t, envelopes, 1)
+	env := envelopes[0]
+	assert.Equal(t, "alice@example.com", env.From)
+	assert.Equal(t, []string{"bob@example.com", "carol@example.com"}, env.Recipients)
+	assert.Equal(t, "127.0.0.1", env.ClientIP.String())
+	assert.Equal(t, "localhost", env.Helo)
+	assert.False(t, env.TLS)
+
+	data := string(env.Data)
+	assert.True(t, strings.HasPrefix(data, "Received: from localhost ([127.0.0.1])\r\n\tby mx.example.com (GoMail) with ESMTP id "+env.SessionID+";"))
+	assert.True(t, strings.HasSuffix(data, "\r\n"+body), "dot-stuffing is undone")
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	handler := &recordingHandler{}
+	_, addr := startServer(t, testConfig(), handler)
+	c := dialRaw(t, addr)
+
+	c.send(t, "EHLO client.example\r\n")
+	ehlo := c.reply(t)
+	assert.Contains(t, ehlo, "250-PIPELINING")
+	assert.Contains(t, ehlo, "250-SIZE 1048576")
+	assert.Contains(t, ehlo, "250-8BITMIME")
+	assert.NotContains(t, ehlo, "STARTTLS", "no certificate configured")
+
+	// The whole transaction in one write, with bare LF line endings
+	c.send(t, "MAIL FROM:<> BODY=8BITMIME\r\nRCPT TO:<bob@example.com>\r\nRCPT TO:bob@example.com\r\nDATA\r\n")
+	assert.Equal(t, "250 2.1.0 Ok", c.reply(t))
+	assert.Equal(t, "250 2.1.5 Ok", c.reply(t))
+	assert.Equal(t, "250 2.1.5 Ok", c.reply(t))
+	assert.True(t, strings.HasPrefix(c.reply(t), "354 "))
+
+	c.send(t, "Subject: bounce\n\n..\nbody\n.\n")
+	assert.True(t, strings.HasPrefix(c.reply(t), "250 2.0.0 Ok"))
+
+	c.send(t, "QUIT\r\n")
+	assert.Equal(t, "221 2.0.0 Bye", c.reply(t))
+
+	envelopes := handler.received()
+	require.Len(t, envelopes, 1)
+	assert.Empty(t, envelopes[0].From, "null reverse-path")
+	assert.True(t, strings.HasSuffix(string(envelopes[0].Data), "Subject: bounce\r\n\r\n.\r\nbody\r\n"))
+}
+
+func TestServer_CommandSequence(t *testing.T) {
+	_, addr := startServer(t, testConfig(), &recordingHandler{})
+	c := dialRaw(t, addr)
+
+	steps := []struct {
+		command string
+		reply   string
+	}{
+		{"MAIL FROM:<alice@example.com>", "503 5.5.1 Send HELO/EHLO first"},
+		{"HELO client.example", "250 mx.example.com"},
+		{"RCPT TO:<bob@example.com>", "503 5.5.1 Need MAIL command"},
+		{"DATA", "503 5.5.1 Need MAIL command"},
+		{"MAIL FROM:<alice@example.com> SIZE=2000000", "552 5.3.4 Message size exceeds fixed maximum message size"},
+		{"MAIL FROM:<alice@example.com> AUTH=<>", "555 5.5.4 Unsupported parameter AUTH"},
+		{"MAIL FROM:<alice@example.com>", "250 2.1.0 Ok"},
+		{"MAIL FROM:<alice@example.com>", "503 5.5.1 Sender already specified"},
+		{"DATA", "503 5.5.1 Need RCPT command"},
+		{"RCPT TO:<bob>", "501 5.1.3 Bad recipient address syntax"},
+		{"RCPT TO:<@relay.example:bob@example.com>", "250 2.1.5 Ok"},
+		{"RCPT TO:<carol@example.com>", "250 2.1.5 Ok"},
+		{"RCPT TO:<dave@example.com>", "250 2.1.5 Ok"},
+		{"RCPT TO:<erin@example.com>", "452 4.5.3 Too many recipients"},
+		{"RSET", "250 2.0.0 Ok"},
+		{"RCPT TO:<bob@example.com>", "503 5.5.1 Need MAIL command"},
+		{"BDAT 10 LAST", "500 5.5.2 Command not recognized"},
+		{"NOOP", "250 2.0.0 Ok"},
+		{"STARTTLS", "502 5.5.1 STARTTLS not available"},
+	}
+	for _, step := range steps {
+		c.send(t, step.command+"\r\n")
+		assert.Equal(t, step.reply, c.reply(t), step.command)
+	}
+}
+
+func TestServer_RecipientPolicy(t *testing.T) {
+	cfg := testConfig()
+	cfg.RecipientDomains = []config.RecipientDomain{
+		{Domain: "example.com", Recipients: []string{"bob"}},
+	}
+	handler := &recordingHandler{}
+	_, addr := startServer(t, cfg, handler)
+	c := dialRaw(t, addr)
+
+	steps := []struct {
+		command string
+		reply   string
+	}{
+		{"EHLO client.example", ""},
+		{"MAIL FROM:<alice@example.net>", "250 2.1.0 Ok"},
+		{"RCPT TO:<nobody@example.com>", "550 5.1.1 <nobody@example.com>: Recipient address rejected: User unknown"},
+		{"RCPT TO:<bob@example.net>", "550 5.7.1 <bob@example.net>: Relay access denied"},
+		{"RCPT TO:<Bob@Example.com>", "250 2.1.5 Ok"},
+		{"RCPT TO:<postmaster@example.com>", "250 2.1.5 Ok"},
+		{"DATA", "354 End data with <CR><LF>.<CR><LF>"},
+	}
+	for _, step := range steps {
+		c.send(t, step.command+"\r\n")
+		reply := c.reply(t)
+		if step.reply != "" {
+			assert.Equal(t, step.reply, reply, step.command)
+		}
+	}
+
+	c.send(t, "Subject: hi\r\n\r\nbody\r\n.\r\n")
+	assert.True(t, strings.HasPrefix(c.reply(t), "250 2.0.0 Ok"))
+
+	envelopes := handler.received()
+	require.Len(t, envelopes, 1)
+	assert.Equal(t, []string{"Bob@Example.com", "postmaster@example.com"}, envelopes[0].Recipients)
+}
+
+func TestServer_MessageTooBig(t *testing.T) {
+	handler := &recordingHandler{}
+	_, addr := startServer(t, testConfig(), handler)
+	c := dialRaw(t, addr)
+
+	c.send(t, "EHLO client.example\r\n")
+	c.reply(t)
+	c.send(t, "MAIL FROM:<alice@example.com>\r\nRCPT TO:<bob@example.com>\r\nDATA\r\n")
+	c.reply(t)
+	c.reply(t)
+	c.reply(t)
+
+	line := strings.Repeat("x", 998) + "\r\n"
+	c.send(t, "Subject: big\r\n\r\n"+strings.Repeat(line, 1100)+".\r\n")
+	assert.Equal(t, "552 5.3.4 Message size exceeds fixed maximum message size", c.reply(t))
+	assert.Empty(t, handler.received())
+
+	// The session carries on
+	c.send(t, "NOOP\r\n")
+	assert.Equal(t, "250 2.0.0 Ok", c.reply(t))
+}
+
+func TestServer_HandlerErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		reply string
+	}{
+		{
+			name:  "rejected",
+			err:   errors.ValidationError("Email rejected by authentication policy", nil),
+			reply: "550 5.7.1 Email rejected by authentication policy",
+		},
+		{
+			name:  "storage failure",
+			err:   errors.StorageError("Failed to store email", fmt.Errorf("disk full")),
+			reply: "451 4.3.0 Requested action aborted: local error in processing",
+		},
+		{
+			name:  "plain error",
+			err:   fmt.Errorf("boom"),
+			reply: "451 4.3.0 Requested action aborted: local error in processing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := startServer(t, testConfig(), &recordingHandler{err: tt.err})
+			c := dialRaw(t, addr)
+
+			c.send(t, "EHLO client.example\r\n")
+			c.reply(t)
+			c.send(t, "MAIL FROM:<alice@example.com>\r\nRCPT TO:<bob@example.com>\r\nDATA\r\n")
+			c.reply(t)
+			c.reply(t)
+			c.reply(t)
+			c.send(t, "Subject: x\r\n\r\nbody\r\n.\r\n")
+			assert.Equal(t, tt.reply, c.reply(t))
+		})
+	}
+}
+
+// startLMTPServer runs an LMTP server on a unix socket and returns a
+// client connected to it
+func startLMTPServer(t *testing.T, handler Handler) *rawClient {
+	t.Helper()
+
+	server := NewLMTPServer(testConfig(), handler)
+	socket := filepath.Join(t.TempDir(), "lmtp.sock")
+	listener, err := Listen("unix:" + socket)
+	require.NoError(t, err)
+
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c := &rawClient{conn: conn, reader: bufio.NewReader(conn)}
+	assert.Equal(t, "220 mx.example.com LMTP GoMail", c.reply(t))
+	return c
+}
+
+func TestLMTPServer_ReceivesMessage(t *testing.T) {
+	handler := &recordingHandler{}
+	c := startLMTPServer(t, handler)
+
+	c.send(t, "EHLO postfix.example\r\n")
+	assert.Equal(t, "500 5.5.1 Use LHLO", c.reply(t))
+	c.send(t, "MAIL FROM:<alice@example.net>\r\n")
+	assert.Equal(t, "503 5.5.1 Send LHLO first", c.reply(t))
+
+	c.send(t, "LHLO mx.example.com\r\n")
+	lhlo := c.reply(t)
+	assert.Contains(t, lhlo, "250-PIPELINING")
+	assert.Contains(t, lhlo, "XFORWARD NAME ADDR PROTO HELO SOURCE")
+	assert.NotContains(t, lhlo, "STARTTLS")
+
+	// Postfix forwards the original client before each transaction
+	c.send(t, "XFORWARD NAME=mail.example.net ADDR=IPV6:2001:db8::25 PROTO=ESMTP HELO=mail+2Eexample.net SOURCE=REMOTE\r\n")
+	assert.Equal(t, "250 2.0.0 Ok", c.reply(t))
+
+	// Recipient policy is Postfix's business with LMTP
+	c.send(t, "MAIL FROM:<alice@example.net>\r\nRCPT TO:<bob@example.com>\r\nRCPT TO:<nobody@elsewhere.example>\r\nDATA\r\n")
+	assert.Equal(t, "250 2.1.0 Ok", c.reply(t))
+	assert.Equal(t, "250 2.1.5 Ok", c.reply(t))
+	assert.Equal(t, "250 2.1.5 Ok", c.reply(t))
+	assert.True(t, strings.HasPrefix(c.reply(t), "354 "))
+
+	c.send(t, "Subject: hi\r\n\r\nbody\r\n.\r\n")
+	first, second := c.reply(t), c.reply(t)
+	assert.Regexp(t, `^250 2\.0\.0 Ok: queued as \w+ <bob@example\.com>$`, first)
+	assert.Regexp(t, `^250 2\.0\.0 Ok: queued as \w+ <nobody@elsewhere\.example>$`, second)
+
+	envelopes := handler.received()
+	require.Len(t, envelopes, 1)
+	env := envelopes[0]
+	assert.Equal(t, "2001:db8::25", env.ClientIP.String())
+	assert.Equal(t, "mail.example.net", env.Helo)
+	assert.Contains(t, string(env.Data), "with LMTP id "+env.SessionID)
+
+	// XFORWARD values last for one transaction only
+	c.send(t, "MAIL FROM:<alice@example.net>\r\nRCPT TO:<bob@example.com>\r\nDATA\r\n")
+	c.reply(t)
+	c.reply(t)
+	c.reply(t)
+	c.send(t, "Subject: again\r\n\r\nbody\r\n.\r\n")
+	c.reply(t)
+
+	envelopes = handler.received()
+	require.Len(t, envelopes, 2)
+	assert.Equal(t, "127.0.0.1", envelopes[1].ClientIP.String())
+	assert.Equal(t, "mx.example.com", envelopes[1].Helo)
+}
+
+func TestLMTPServer_XForwardOnlyFromLocalClients(t *testing.T) {
+	handler := &recordingHandler{}
+	server := NewLMTPServer(testConfig(), handler)
+
+	// A pipe has neither a unix nor a loopback address, like a remote client
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { _ = peer.Close() })
+	_ = peer.SetDeadline(time.Now().Add(10 * time.Second))
+	go newSession(server, conn, &atomic.Bool{}).serve()
+
+	c := &rawClient{conn: peer, reader: bufio.NewReader(peer)}
+	assert.Equal(t, "220 mx.example.com LMTP GoMail", c.reply(t))
+	c.send(t, "LHLO mx.example.com\r\n")
+	assert.NotContains(t, c.reply(t), "XFORWARD")
+	c.send(t, "XFORWARD ADDR=192.0.2.25 HELO=mail.example.net\r\n")
+	assert.Equal(t, "550 5.7.0 Error: insufficient authorization", c.reply(t))
+
+	c.send(t, "MAIL FROM:<alice@example.net>\r\nRCPT TO:<bob@example.com>\r\nDATA\r\n")
+	c.reply(t)
+	c.reply(t)
+	c.reply(t)
+	c.send(t, "Subject: hi\r\n\r\nbody\r\n.\r\n")
+	c.reply(t)
+
+	envelopes := handler.received()
+	require.Len(t, envelopes, 1)
+	assert.NotEqual(t, "192.0.2.25", envelopes[0].ClientIP.String())
+	assert.Equal(t, "mx.example.com", envelopes[0].Helo)
+}
+
+func TestLMTPServer_PerRecipientErrors(t *testing.T) {
+	handler := &recordingHandler{err: errors.StorageError("Failed to store email", fmt.Errorf("disk full"))}
+	c := startLMTPServer(t, handler)
+
+	c.send(t, "LHLO mx.example.com\r\n")
+	c.reply(t)
+	c.send(t, "XFORWARD ADDR=bogus\r\n")
+	assert.Equal(t, "501 5.5.4 Bad XFORWARD address bogus", c.reply(t))
+
+	c.send(t, "MAIL FROM:<alice@example.net>\r\nRCPT TO:<bob@example.com>\r\nRCPT TO:<carol@example.com>\r\nDATA\r\n")
+	c.reply(t)
+	c.reply(t)
+	c.reply(t)
+	c.reply(t)
+	c.send(t, "Subject: hi\r\n\r\nbody\r\n.\r\n")
+
+	// Each recipient is deferred so Postfix keeps the message queued
+	assert.Equal(t, "451 4.3.0 Requested action aborted: local error in processing <bob@example.com>", c.reply(t))
+	assert.Equal(t, "451 4.3.0 Requested action aborted: local error in processing <carol@example.com>", c.reply(t))
+}
+
+func TestDecodeXText(t *testing.T) {
+	assert.Equal(t, "mail.example.net", decodeXText("mail.example.net"))
+	assert.Equal(t, "a=b c", decodeXText("a+3Db+20c"))
+	assert.Equal(t, "trailing+2", decodeXText("trailing+2"))
+}
+
+// writeTestCertificate writes a self-signed certificate for mx.example.com
+func writeTestCertificate(t *testing.T, cfg *config.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mx.example.com"},
+		DNSNames:     []string{"mx.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	cfg.SMTPTLSCertFile = filepath.Join(dir, "cert.pem")
+	cfg.SMTPTLSKeyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(cfg.SMTPTLSCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(cfg.SMTPTLSKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func TestServer_STARTTLS(t *testing.T) {
+	cfg := testConfig()
+	cfg.SMTPRequireTLS = true
+	writeTestCertificate(t, cfg)
+
+	handler := &recordingHandler{}
+	_, addr := startServer(t, cfg, handler)
+
+	// Plaintext transactions are refused
+	c := dialRaw(t, addr)
+	c.send(t, "EHLO client.example\r\n")
+	assert.Contains(t, c.reply(t), "STARTTLS")
+	c.send(t, "MAIL FROM:<alice@example.com>\r\n")
+	assert.Equal(t, "530 5.7.0 Must issue a STARTTLS command first", c.reply(t))
+
+	client, err := netsmtp.Dial(addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.Hello("client.example"))
+	require.NoError(t, client.StartTLS(&tls.Config{ServerName: "mx.example.com", InsecureSkipVerify: true}))
+	ok, _ := client.Extension("STARTTLS")
+	assert.False(t, ok, "not offered twice")
+
+	require.NoError(t, client.Mail("alice@example.com"))
+	require.NoError(t, client.Rcpt("bob@example.com"))
+	w, err := client.Data()
+	require.NoError(t, err)
+	_, err = w.Write([]byte("Subject: secret\r\n\r\nbody\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, client.Quit())
+
+	envelopes := handler.received()
+	require.Len(t, envelopes, 1)
+	assert.True(t, envelopes[0].TLS)
+	assert.Contains(t, string(envelopes[0].Data), "with ESMTPS id")
+}
+
+func TestNewServer_RequireTLSWithoutCertificate(t *testing.T) {
+	cfg := testConfig()
+	cfg.SMTPRequireTLS = true
+	cfg.SMTPTLSCertFile = filepath.Join(t.TempDir(), "missing.pem")
+	cfg.SMTPTLSKeyFile = filepath.Join(t.TempDir(), "missing.key")
+
+	_, err := NewServer(cfg, &recordingHandler{})
+	assert.Error(t, err)
+
+	// Without the requirement the receiver runs without STARTTLS
+	cfg.SMTPRequireTLS = false
+	server, err := NewServer(cfg, &recordingHandler{})
+	require.NoError(t, err)
+	assert.Nil(t, server.tlsConfig)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	server, addr := startServer(t, testConfig(), &recordingHandler{})
+	c := dialRaw(t, addr)
+
+	c.send(t, "EHLO client.example\r\n")
+	c.reply(t)
+
+	// Wait until the session is idle again
+	require.Eventually(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		for _, idle := range server.conns {
+			if idle.Load() {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, server.Shutdown(ctx))
+
+	assert.Equal(t, "421 4.3.2 Service shutting down", c.reply(t))
+	_, err := net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		arg     string
+		address string
+		params  []string
+		ok      bool
+	}{
+		{"FROM:<alice@example.com>", "alice@example.com", nil, true},
+		{"from: <alice@example.com> SIZE=10 BODY=8BITMIME", "alice@example.com", []string{"SIZE=10", "BODY=8BITMIME"}, true},
+		{"FROM:<>", "", nil, true},
+		{"FROM:alice@example.com SIZE=10", "alice@example.com", []string{"SIZE=10"}, true},
+		{"FROM:<@a.example,@b.example:alice@example.com>", "alice@example.com", nil, true},
+		{"FROM:<alice@example.com", "", nil, false},
+		{"TO:<bob@example.com>", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		address, params, ok := parsePath(tt.arg, "FROM:")
+		assert.Equal(t, tt.ok, ok, tt.arg)
+		assert.Equal(t, tt.address, address, tt.arg)
+		assert.ElementsMatch(t, tt.params, params, tt.arg)
+	}
+}
@@ -0,0 +1,679 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/errors"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/grumpyguvner/gomail/internal/policy"
+	gomailtls "github.com/grumpyguvner/gomail/internal/tls"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxLineLength bounds command lines; RFC 5321 allows 512 octets, the
+	// rest is headroom for extension parameters
+	maxLineLength = 2048
+
+	// maxErrors ends sessions that keep sending bad commands
+	maxErrors = 20
+)
+
+// errLineTooLong is returned by readLine for oversized command lines
+var errLineTooLong = fmt.Errorf("line too long")
+
+// session is one SMTP connection
+type session struct {
+	server   *Server
+	conn     net.Conn // the accepted connection, wrapped by TLS after STARTTLS
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	id       string
+	clientIP net.IP
+	local    bool         // connected over a unix socket or loopback
+	idle     *atomic.Bool // waiting for a command, safe to interrupt
+	logger   *zap.SugaredLogger
+
+	helo     string
+	esmtp    bool
+	tls      bool
+	failures int
+
+	// Current transaction
+	from       string
+	hasFrom    bool
+	recipients []string
+
+	// Original client passed on by Postfix with XFORWARD (LMTP only)
+	forwardedIP   net.IP
+	forwardedHelo string
+}
+
+func newSession(server *Server, conn net.Conn, idle *atomic.Bool) *session {
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+
+	clientIP := net.IPv4zero
+	local := false
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		clientIP = addr.IP
+		local = addr.IP.IsLoopback()
+	case *net.UnixAddr:
+		clientIP = net.IPv4(127, 0, 0, 1)
+		local = true
+	}
+
+	s := &session{
+		server:   server,
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		writer:   bufio.NewWriter(conn),
+		id:       hex.EncodeToString(id),
+		clientIP: clientIP,
+		local:    local,
+		idle:     idle,
+	}
+	s.logger = server.logger.With("session", s.id, "client", clientIP.String())
+	return s
+}
+
+// serve runs the command loop until the client quits or the connection fails
+func (s *session) serve() {
+	defer func() { _ = s.conn.Close() }()
+
+	s.logger.Debug("SMTP session started")
+	if s.server.lmtp {
+		s.reply(220, fmt.Sprintf("%s LMTP GoMail", s.server.hostname))
+	} else {
+		s.reply(220, fmt.Sprintf("%s ESMTP GoMail", s.server.hostname))
+	}
+
+	for {
+		// Shutdown interrupts reads of idle sessions; checking after the
+		// deadline is set means a session never misses it
+		s.idle.Store(true)
+		_ = s.conn.SetReadDeadline(time.Now().Add(s.server.timeout))
+		if s.server.closing.Load() {
+			s.replyNow(421, "4.3.2 Service shutting down")
+			break
+		}
+
+		line, err := s.readLine()
+		s.idle.Store(false)
+		if err == errLineTooLong {
+			if !s.fail(500, "5.5.2 Line too long") {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			if s.server.closing.Load() {
+				s.replyNow(421, "4.3.2 Service shutting down")
+			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				s.replyNow(421, "4.4.2 Timeout exceeded")
+			}
+			break
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		if !s.handle(strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			break
+		}
+	}
+
+	if !s.tls && !s.server.lmtp {
+		metrics.PlaintextConnections.Inc()
+	}
+	s.logger.Debug("SMTP session ended")
+}
+
+// handle runs one command and reports whether the session continues
+func (s *session) handle(verb, arg string) bool {
+	switch verb {
+	case "EHLO", "HELO":
+		if s.server.lmtp {
+			return s.fail(500, "5.5.1 Use LHLO")
+		}
+		return s.handleHelo(verb, arg)
+	case "LHLO":
+		if !s.server.lmtp {
+			return s.fail(500, "5.5.2 Command not recognized")
+		}
+		return s.handleHelo(verb, arg)
+	case "XFORWARD":
+		if !s.server.lmtp {
+			return s.fail(500, "5.5.2 Command not recognized")
+		}
+		return s.handleXForward(arg)
+	case "STARTTLS":
+		return s.handleStartTLS(arg)
+	case "MAIL":
+		return s.handleMail(arg)
+	case "RCPT":
+		return s.handleRcpt(arg)
+	case "DATA":
+		return s.handleData(arg)
+	case "RSET":
+		s.reset()
+		s.reply(250, "2.0.0 Ok")
+	case "NOOP":
+		s.reply(250, "2.0.0 Ok")
+	case "VRFY":
+		s.reply(252, "2.5.0 Cannot VRFY user, but will accept message and attempt delivery")
+	case "HELP":
+		s.reply(214, "2.0.0 See RFC 5321")
+	case "QUIT":
+		s.replyNow(221, "2.0.0 Bye")
+		return false
+	default:
+		return s.fail(500, "5.5.2 Command not recognized")
+	}
+	return true
+}
+
+func (s *session) handleHelo(verb, arg string) bool {
+	if arg == "" {
+		return s.fail(501, "5.5.4 Syntax: "+verb+" hostname")
+	}
+
+	s.reset()
+	s.helo = arg
+	s.esmtp = verb != "HELO"
+
+	if !s.esmtp {
+		s.reply(250, s.server.hostname)
+		return true
+	}
+
+	lines := []string{
+		s.server.hostname,
+		"PIPELINING",
+		fmt.Sprintf("SIZE %d", s.server.maxSize),
+		"8BITMIME",
+		"ENHANCEDSTATUSCODES",
+	}
+	if s.server.tlsConfig != nil && !s.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	if s.server.lmtp && s.local {
+		lines = append(lines, "XFORWARD NAME ADDR PROTO HELO SOURCE")
+	}
+	s.replyLines(250, lines)
+	return true
+}
+
+// handleXForward takes the original client's address and HELO name from
+// Postfix (XFORWARD_README), so SPF and DMARC see the real client rather
+// than the local Postfix. The values last until the transaction ends.
+// Only local clients may use it: anyone else could claim an address that
+// passes the sender's SPF record.
+func (s *session) handleXForward(arg string) bool {
+	switch {
+	case !s.local:
+		return s.fail(550, "5.7.0 Error: insufficient authorization")
+	case s.helo == "":
+		return s.fail(503, "5.5.1 Send LHLO first")
+	case s.hasFrom:
+		return s.fail(503, "5.5.1 Mail transaction in progress")
+	case arg == "":
+		return s.fail(501, "5.5.4 Syntax: XFORWARD attribute=value...")
+	}
+
+	for _, attr := range strings.Fields(arg) {
+		name, value, ok := strings.Cut(attr, "=")
+		if !ok {
+			return s.fail(501, "5.5.4 Bad XFORWARD attribute "+attr)
+		}
+		value = decodeXText(value)
+		if value == "[UNAVAILABLE]" || value == "[TEMPUNAVAIL]" {
+			continue
+		}
+
+		switch strings.ToUpper(name) {
+		case "ADDR":
+			ip := net.ParseIP(strings.TrimPrefix(strings.ToUpper(value), "IPV6:"))
+			if ip == nil {
+				return s.fail(501, "5.5.4 Bad XFORWARD address "+value)
+			}
+			s.forwardedIP = ip
+		case "HELO":
+			s.forwardedHelo = value
+		case "NAME", "PROTO", "SOURCE", "PORT", "IDENT":
+		default:
+			return s.fail(501, "5.5.4 Bad XFORWARD attribute "+name)
+		}
+	}
+
+	s.reply(250, "2.0.0 Ok")
+	return true
+}
+
+func (s *session) handleStartTLS(arg string) bool {
+	switch {
+	case arg != "":
+		return s.fail(501, "5.5.4 Syntax: STARTTLS")
+	case s.server.tlsConfig == nil:
+		return s.fail(502, "5.5.1 STARTTLS not available")
+	case s.tls:
+		return s.fail(503, "5.5.1 TLS already active")
+	}
+
+	metrics.STARTTLSCommands.Inc()
+	s.replyNow(220, "2.0.0 Ready to start TLS")
+
+	tlsConn, err := gomailtls.UpgradeConnection(s.conn, s.server.tlsConfig)
+	if err != nil {
+		s.logger.Warnf("STARTTLS failed: %v", err)
+		return false
+	}
+
+	// Anything the client sent before the handshake is discarded, and the
+	// session starts over as if newly connected (RFC 3207 section 4.2)
+	s.conn = tlsConn
+	s.reader = bufio.NewReader(tlsConn)
+	s.writer = bufio.NewWriter(tlsConn)
+	s.tls = true
+	s.helo = ""
+	s.reset()
+
+	state := tlsConn.ConnectionState()
+	s.logger.Debugf("TLS established: version=%s, cipher=%s",
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	return true
+}
+
+func (s *session) handleMail(arg string) bool {
+	switch {
+	case s.helo == "" && s.server.lmtp:
+		return s.fail(503, "5.5.1 Send LHLO first")
+	case s.helo == "":
+		return s.fail(503, "5.5.1 Send HELO/EHLO first")
+	case s.server.requireTLS && !s.tls:
+		metrics.TLSRequiredRejections.Inc()
+		return s.fail(530, "5.7.0 Must issue a STARTTLS command first")
+	case s.hasFrom:
+		return s.fail(503, "5.5.1 Sender already specified")
+	}
+
+	address, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		return s.fail(501, "5.5.4 Syntax: MAIL FROM:<address>")
+	}
+	if address != "" && !strings.Contains(address, "@") {
+		return s.fail(501, "5.1.7 Bad sender address syntax")
+	}
+
+	for _, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		switch strings.ToUpper(key) {
+		case "SIZE":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return s.fail(501, "5.5.4 Invalid SIZE parameter")
+			}
+			if size > s.server.maxSize {
+				s.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+				return true
+			}
+		case "BODY":
+			switch strings.ToUpper(value) {
+			case "7BIT", "8BITMIME":
+			default:
+				return s.fail(501, "5.5.4 Unsupported BODY parameter")
+			}
+		default:
+			return s.fail(555, "5.5.4 Unsupported parameter "+key)
+		}
+	}
+
+	s.from = address
+	s.hasFrom = true
+	s.reply(250, "2.1.0 Ok")
+	return true
+}
+
+func (s *session) handleRcpt(arg string) bool {
+	if !s.hasFrom {
+		return s.fail(503, "5.5.1 Need MAIL command")
+	}
+
+	address, params, ok := parsePath(arg, "TO:")
+	if !ok {
+		return s.fail(501, "5.5.4 Syntax: RCPT TO:<address>")
+	}
+	if len(params) > 0 {
+		return s.fail(555, "5.5.4 Unsupported parameter "+params[0])
+	}
+	if !strings.Contains(address, "@") {
+		return s.fail(501, "5.1.3 Bad recipient address syntax")
+	}
+	switch s.server.recipients.Check(address) {
+	case policy.UnknownUser:
+		return s.fail(550, "5.1.1 <"+address+">: Recipient address rejected: User unknown")
+	case policy.UnknownDomain:
+		return s.fail(550, "5.7.1 <"+address+">: Relay access denied")
+	}
+	if s.server.maxRecipients > 0 && len(s.recipients) >= s.server.maxRecipients {
+		s.reply(452, "4.5.3 Too many recipients")
+		return true
+	}
+
+	s.recipients = append(s.recipients, address)
+	s.reply(250, "2.1.5 Ok")
+	return true
+}
+
+func (s *session) handleData(arg string) bool {
+	switch {
+	case arg != "":
+		return s.fail(501, "5.5.4 Syntax: DATA")
+	case !s.hasFrom:
+		return s.fail(503, "5.5.1 Need MAIL command")
+	case len(s.recipients) == 0:
+		return s.fail(503, "5.5.1 Need RCPT command")
+	}
+
+	s.replyNow(354, "End data with <CR><LF>.<CR><LF>")
+
+	_ = s.conn.SetReadDeadline(time.Now().Add(s.server.timeout))
+	data, err := s.readData()
+	if err == errTooBig {
+		metrics.SMTPMessages.WithLabelValues("rejected").Inc()
+		recipients := s.recipients
+		s.reset()
+		s.replyData(recipients, 552, "5.3.4 Message size exceeds fixed maximum message size")
+		return true
+	}
+	if err != nil {
+		s.logger.Warnf("Failed to read message data: %v", err)
+		return false
+	}
+
+	clientIP, helo := s.clientIP, s.helo
+	if s.forwardedIP != nil {
+		clientIP = s.forwardedIP
+	}
+	if s.forwardedHelo != "" {
+		helo = s.forwardedHelo
+	}
+
+	env := &Envelope{
+		SessionID:  s.id,
+		ClientIP:   clientIP,
+		Helo:       helo,
+		TLS:        s.tls,
+		From:       s.from,
+		Recipients: s.recipients,
+		Data:       append([]byte(s.receivedHeader()), data...),
+	}
+	s.reset()
+
+	ctx := context.Background()
+	if s.server.handlerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.server.handlerTimeout)
+		defer cancel()
+	}
+
+	if err := s.server.handler.Receive(ctx, env); err != nil {
+		code, message := replyForError(err)
+		if code >= 500 {
+			metrics.SMTPMessages.WithLabelValues("rejected").Inc()
+		} else {
+			metrics.SMTPMessages.WithLabelValues("deferred").Inc()
+		}
+		s.logger.Infow("Message not accepted", "from", env.From, "to", strings.Join(env.Recipients, ","), "reply", code, "error", err)
+		s.replyData(env.Recipients, code, message)
+		return true
+	}
+
+	metrics.SMTPMessages.WithLabelValues("accepted").Inc()
+	s.replyData(env.Recipients, 250, "2.0.0 Ok: queued as "+s.id)
+	return true
+}
+
+// replyData sends the reply to the end of DATA. LMTP answers once for
+// every recipient (RFC 2033 section 4.2); the message is stored once, so
+// they all share the outcome.
+func (s *session) replyData(recipients []string, code int, message string) {
+	if !s.server.lmtp {
+		s.replyNow(code, message)
+		return
+	}
+	for _, recipient := range recipients {
+		fmt.Fprintf(s.writer, "%d %s <%s>\r\n", code, message, recipient)
+	}
+	s.flush()
+}
+
+// receivedHeader is the trace header GoMail adds to accepted messages.
+// storage.DedupKey recognises it by "(GoMail) with" and leaves it out of
+// the content hash, so retries of a message without a Message-ID match.
+func (s *session) receivedHeader() string {
+	protocol := "SMTP"
+	if s.server.lmtp {
+		protocol = "LMTP"
+	} else if s.esmtp {
+		protocol = "ESMTP"
+		if s.tls {
+			protocol = "ESMTPS"
+		}
+	}
+	return fmt.Sprintf("Received: from %s ([%s])\r\n\tby %s (GoMail) with %s id %s;\r\n\t%s\r\n",
+		s.helo, s.clientIP, s.server.hostname, protocol, s.id, time.Now().Format(time.RFC1123Z))
+}
+
+// reset clears the current transaction
+func (s *session) reset() {
+	s.from = ""
+	s.hasFrom = false
+	s.recipients = nil
+	s.forwardedIP = nil
+	s.forwardedHelo = ""
+}
+
+// fail replies with an error and reports whether the session may continue.
+// Clients that keep sending bad commands are disconnected.
+func (s *session) fail(code int, message string) bool {
+	s.failures++
+	if s.failures >= maxErrors {
+		s.replyNow(421, "4.7.0 Too many errors")
+		return false
+	}
+	s.reply(code, message)
+	return true
+}
+
+// reply queues a reply. Replies are sent once the client has no more
+// pipelined commands waiting, so a PIPELINING batch gets one write.
+func (s *session) reply(code int, message string) {
+	fmt.Fprintf(s.writer, "%d %s\r\n", code, message)
+	if s.reader.Buffered() == 0 {
+		s.flush()
+	}
+}
+
+// replyNow sends a reply straight away
+func (s *session) replyNow(code int, message string) {
+	fmt.Fprintf(s.writer, "%d %s\r\n", code, message)
+	s.flush()
+}
+
+// replyLines sends a multiline reply
+func (s *session) replyLines(code int, lines []string) {
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		fmt.Fprintf(s.writer, "%d%s%s\r\n", code, separator, line)
+	}
+	if s.reader.Buffered() == 0 {
+		s.flush()
+	}
+}
+
+func (s *session) flush() {
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.server.timeout))
+	if err := s.writer.Flush(); err != nil {
+		s.logger.Debugf("Failed to write reply: %v", err)
+	}
+}
+
+// readLine reads a command line without its line ending
+func (s *session) readLine() (string, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := s.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineLength {
+			tooLong = true
+		} else {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	if tooLong {
+		return "", errLineTooLong
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// errTooBig is returned by readData when a message exceeds the size limit
+var errTooBig = fmt.Errorf("message too big")
+
+// readData reads a message up to the terminating "." line, undoing dot
+// stuffing and normalising bare LF line endings to CRLF. An oversized
+// message is read to the end and discarded.
+func (s *session) readData() ([]byte, error) {
+	var data bytes.Buffer
+	tooBig := false
+	lineStart := true
+	pendingCR := false // a CR that ended a partial read, maybe half of a CRLF
+	for {
+		chunk, err := s.reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		complete := err == nil
+
+		if lineStart && complete && (string(chunk) == ".\r\n" || string(chunk) == ".\n") {
+			break
+		}
+		if lineStart && len(chunk) > 0 && chunk[0] == '.' {
+			chunk = chunk[1:]
+		}
+		lineStart = complete
+
+		var prefix []byte
+		if pendingCR && !(complete && len(chunk) == 1) {
+			prefix = []byte("\r")
+		}
+		pendingCR = false
+
+		var suffix []byte
+		if complete {
+			chunk = bytes.TrimSuffix(bytes.TrimSuffix(chunk, []byte("\n")), []byte("\r"))
+			suffix = []byte("\r\n")
+		} else if bytes.HasSuffix(chunk, []byte("\r")) {
+			chunk = chunk[:len(chunk)-1]
+			pendingCR = true
+		}
+
+		if int64(data.Len()+len(prefix)+len(chunk)+len(suffix)) > s.server.maxSize {
+			tooBig = true
+		}
+		if tooBig {
+			continue
+		}
+		data.Write(prefix)
+		data.Write(chunk)
+		data.Write(suffix)
+	}
+
+	if tooBig {
+		return nil, errTooBig
+	}
+	return data.Bytes(), nil
+}
+
+// parsePath parses "FROM:<address> PARAM=value ..." arguments. The angle
+// brackets are required by RFC 5321 but commonly left out, so they are
+// optional here; source routes are dropped.
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+
+	var path string
+	if strings.HasPrefix(rest, "<") {
+		end := strings.IndexByte(rest, '>')
+		if end < 0 {
+			return "", nil, false
+		}
+		path, rest = rest[1:end], rest[end+1:]
+	} else {
+		path, rest, _ = strings.Cut(rest, " ")
+	}
+
+	if i := strings.LastIndexByte(path, ':'); i >= 0 && strings.HasPrefix(path, "@") {
+		path = path[i+1:]
+	}
+	return path, strings.Fields(rest), true
+}
+
+// decodeXText undoes the xtext encoding of XFORWARD values, where "+XX"
+// stands for the byte with hex value XX (RFC 3461 section 4)
+func decodeXText(value string) string {
+	if !strings.Contains(value, "+") {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '+' && i+2 < len(value) {
+			if n, err := strconv.ParseUint(value[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// replyForError maps a handler error to an SMTP reply. Messages the
+// pipeline refused are rejected permanently; anything else is deferred so
+// the client retries.
+func replyForError(err error) (int, string) {
+	if appErr, ok := errors.AsAppError(err); ok {
+		switch appErr.Type {
+		case errors.ErrorTypeValidation, errors.ErrorTypeBadRequest:
+			return 550, "5.7.1 " + appErr.Message
+		case errors.ErrorTypeRateLimit, errors.ErrorTypeUnavailable:
+			return 451, "4.7.1 " + appErr.Message
+		}
+	}
+	return 451, "4.3.0 Requested action aborted: local error in processing"
+}
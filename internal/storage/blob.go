@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/mail"
+)
+
+// blobNamePattern matches the SHA-256 names blobs are stored under
+var blobNamePattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// BlobStore is a content-addressed store for extracted attachments. Blobs
+// are named by the SHA-256 of their content and sharded by its first two
+// hex digits, so identical attachments are stored once.
+type BlobStore struct {
+	dir   string
+	codec *Codec
+}
+
+// NewBlobStore creates a blob store rooted at dir
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &BlobStore{dir: dir}, nil
+}
+
+// BlobsFor returns the attachment blob store when attachment_extract is
+// set, or nil. Blobs are encoded with the same codec as messages.
+func BlobsFor(cfg *config.Config) (*BlobStore, error) {
+	if !cfg.AttachmentExtract {
+		return nil, nil
+	}
+
+	codec, err := CodecFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := NewBlobStore(BlobPath(cfg))
+	if err != nil {
+		return nil, err
+	}
+	blobs.SetCodec(codec)
+	return blobs, nil
+}
+
+// BlobPath returns the attachment blob directory, <data_dir>/blobs
+func BlobPath(cfg *config.Config) string {
+	return filepath.Join(cfg.DataDir, "blobs")
+}
+
+// SetCodec sets the encoding applied to blobs at rest
+func (b *BlobStore) SetCodec(codec *Codec) {
+	b.codec = codec
+}
+
+// Put stores content and returns its SHA-256, the name it is stored under
+func (b *BlobStore) Put(content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	name := hex.EncodeToString(sum[:])
+	path := b.path(name)
+
+	// Reusing a blob makes it new again, so a sweep that listed messages
+	// before this one was stored leaves it alone. If a sweep removed it
+	// first, it is written again below.
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return name, nil
+	}
+
+	data, err := b.codec.Encode(name, content)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode blob: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	// Concurrent puts of the same content each write their own temp file
+	tmp, err := os.CreateTemp(filepath.Dir(path), name+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	return name, nil
+}
+
+// Get returns the content stored under a SHA-256
+func (b *BlobStore) Get(name string) ([]byte, error) {
+	if !blobNamePattern.MatchString(name) {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(b.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	return b.codec.Decode(name, data)
+}
+
+// Rekey rewrites every blob not encoded with the current codec
+func (b *BlobStore) Rekey(ctx context.Context) (RekeyResult, error) {
+	var result RekeyResult
+
+	err := b.walk(func(name, path string, _ fs.FileInfo) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read blob %s: %w", name, err)
+		}
+		if b.codec.Current(data) {
+			result.Skipped++
+			return nil
+		}
+
+		content, err := b.codec.Decode(name, data)
+		if err != nil {
+			return err
+		}
+		encoded, err := b.codec.Encode(name, content)
+		if err != nil {
+			return fmt.Errorf("failed to encode blob %s: %w", name, err)
+		}
+		if err := writeAtomic(path, encoded); err != nil {
+			return err
+		}
+		result.Rewritten++
+		return nil
+	})
+
+	return result, err
+}
+
+// Sweep deletes blobs no stored message refers to. Blobs written after
+// cutoff are kept, since their message may not have been stored yet.
+func (b *BlobStore) Sweep(ctx context.Context, referenced map[string]bool, cutoff time.Time) (int, int64, error) {
+	removed, removedBytes := 0, int64(0)
+
+	err := b.walk(func(name, path string, info fs.FileInfo) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if referenced[name] || info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete blob %s: %w", name, err)
+		}
+		removed++
+		removedBytes += info.Size()
+		return nil
+	})
+
+	return removed, removedBytes, err
+}
+
+// walk calls fn for every stored blob
+func (b *BlobStore) walk(fn func(name, path string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(b.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || !blobNamePattern.MatchString(entry.Name()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return fn(entry.Name(), path, info)
+	})
+}
+
+func (b *BlobStore) path(name string) string {
+	return filepath.Join(b.dir, name[:2], name)
+}
+
+// ExtractAttachments moves every attachment of at least minSize bytes out
+// of email.Raw into blobs, leaving the part's headers in place with an
+// empty body and marking the attachment Extracted. Parts whose content
+// cannot be located exactly are left alone.
+func ExtractAttachments(email *mail.EmailData, blobs *BlobStore, minSize int) error {
+	wanted := map[string]*mail.Attachment{}
+	for i := range email.Attachments {
+		att := &email.Attachments[i]
+		if !att.Extracted && att.Size > 0 && att.Size >= minSize {
+			wanted[att.Part] = att
+		}
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	raw, err := mail.RewriteParts(email.Raw, func(part string, header textproto.MIMEHeader, body string) (string, bool, error) {
+		att := wanted[part]
+		if att == nil {
+			return "", false, nil
+		}
+
+		content := mail.DecodeTransfer(header.Get("Content-Transfer-Encoding"), body)
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != att.SHA256 {
+			return "", false, nil
+		}
+
+		if _, err := blobs.Put(content); err != nil {
+			return "", false, err
+		}
+		att.Extracted = true
+		return "", true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to extract attachments: %w", err)
+	}
+
+	email.Raw = raw
+	return nil
+}
+
+// AttachmentContent returns the decoded content of an attachment, from the
+// blob store if it was extracted
+func AttachmentContent(email *mail.EmailData, att mail.Attachment, blobs *BlobStore) ([]byte, error) {
+	if !att.Extracted {
+		return mail.PartContent(email.Raw, att.Part)
+	}
+	if blobs == nil {
+		return nil, fmt.Errorf("attachment %s was extracted but attachment_extract is off: %w", att.SHA256, ErrNotFound)
+	}
+	return blobs.Get(att.SHA256)
+}
+
+// RestoreAttachments returns email.Raw with extracted attachments put back,
+// re-encoded with each part's Content-Transfer-Encoding
+func RestoreAttachments(email *mail.EmailData, blobs *BlobStore) (string, error) {
+	extracted := map[string]string{}
+	for _, att := range email.Attachments {
+		if att.Extracted {
+			extracted[att.Part] = att.SHA256
+		}
+	}
+	if len(extracted) == 0 {
+		return email.Raw, nil
+	}
+	if blobs == nil {
+		return "", fmt.Errorf("message has extracted attachments but attachment_extract is off")
+	}
+
+	newline := mail.Newline(email.Raw)
+	return mail.RewriteParts(email.Raw, func(part string, header textproto.MIMEHeader, body string) (string, bool, error) {
+		name, ok := extracted[part]
+		if !ok {
+			return "", false, nil
+		}
+		content, err := blobs.Get(name)
+		if err != nil {
+			return "", false, fmt.Errorf("attachment %s: %w", name, err)
+		}
+		return mail.EncodeTransfer(header.Get("Content-Transfer-Encoding"), content, newline), true, nil
+	})
+}
+
+// SweepBlobs deletes the blobs no stored message refers to any more.
+// Messages are read in full, so this runs alongside retention rather than
+// on every delete.
+func SweepBlobs(ctx context.Context, s Storage, blobs *BlobStore, now time.Time) (int, int64, error) {
+	ids, err := s.List(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	// Take the cutoff before reading, so blobs for messages stored while
+	// the sweep runs are never considered
+	cutoff := now.Add(-time.Hour)
+
+	referenced := map[string]bool{}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+		email, err := LoadEmail(ctx, s, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, att := range email.Attachments {
+			if att.Extracted {
+				referenced[strings.ToLower(att.SHA256)] = true
+			}
+		}
+	}
+
+	return blobs.Sweep(ctx, referenced, cutoff)
+}
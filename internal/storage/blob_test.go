@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBlobStore(t *testing.T) *BlobStore {
+	t.Helper()
+
+	blobs, err := NewBlobStore(filepath.Join(t.TempDir(), "blobs"))
+	require.NoError(t, err)
+	return blobs
+}
+
+// attachmentMessage builds a message with a text body and one base64
+// attachment per content
+func attachmentMessage(contents ...string) string {
+	var raw strings.Builder
+	raw.WriteString("From: alice@example.com\r\nTo: bob@example.com\r\nSubject: Files\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n")
+	for i, content := range contents {
+		raw.WriteString("--b\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n" +
+			"Content-Disposition: attachment; filename=\"file" + string(rune('a'+i)) + ".bin\"\r\n\r\n")
+		raw.WriteString(mail.EncodeTransfer("base64", []byte(content), "\r\n") + "\r\n")
+	}
+	raw.WriteString("--b--\r\n")
+	return raw.String()
+}
+
+func TestBlobStore_PutGet(t *testing.T) {
+	blobs := newTestBlobStore(t)
+	content := []byte("attachment content")
+
+	name, err := blobs.Put(content)
+	require.NoError(t, err)
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), name)
+	assert.FileExists(t, filepath.Join(blobs.dir, name[:2], name))
+
+	// Identical content is stored once
+	again, err := blobs.Put(content)
+	require.NoError(t, err)
+	assert.Equal(t, name, again)
+
+	got, err := blobs.Get(name)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	_, err = blobs.Get(strings.Repeat("0", 64))
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = blobs.Get("../../etc/passwd")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestBlobStore_Encoded(t *testing.T) {
+	ctx := context.Background()
+	blobs := newTestBlobStore(t)
+	content := []byte(strings.Repeat("compressible ", 100))
+
+	plainName, err := blobs.Put(content)
+	require.NoError(t, err)
+
+	oldKey := newTestKey(t)
+	codec, err := NewCodec(CompressionZstd, "old", map[string][]byte{"old": oldKey})
+	require.NoError(t, err)
+	blobs.SetCodec(codec)
+
+	result, err := blobs.Rekey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RekeyResult{Rewritten: 1}, result)
+
+	data, err := os.ReadFile(blobs.path(plainName))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "compressible")
+
+	rotated, err := NewCodec(CompressionZstd, "new", map[string][]byte{"old": oldKey, "new": newTestKey(t)})
+	require.NoError(t, err)
+	blobs.SetCodec(rotated)
+
+	got, err := blobs.Get(plainName)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	result, err = blobs.Rekey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RekeyResult{Rewritten: 1}, result)
+	result, err = blobs.Rekey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RekeyResult{Skipped: 1}, result)
+}
+
+func TestExtractAttachments(t *testing.T) {
+	blobs := newTestBlobStore(t)
+	large := strings.Repeat("large attachment ", 100)
+	raw := attachmentMessage("small", large)
+
+	email, err := mail.ParseRawEmail(raw, nil)
+	require.NoError(t, err)
+	require.Len(t, email.Attachments, 2)
+
+	require.NoError(t, ExtractAttachments(email, blobs, 1024))
+
+	assert.False(t, email.Attachments[0].Extracted, "below the threshold")
+	assert.True(t, email.Attachments[1].Extracted)
+	assert.Less(t, len(email.Raw), len(raw))
+	assert.Contains(t, email.Raw, base64.StdEncoding.EncodeToString([]byte("small")))
+	assert.Contains(t, email.Raw, `filename="fileb.bin"`, "headers are kept")
+
+	// Content is served from wherever it lives
+	content, err := AttachmentContent(email, email.Attachments[0], blobs)
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(content))
+	content, err = AttachmentContent(email, email.Attachments[1], blobs)
+	require.NoError(t, err)
+	assert.Equal(t, large, string(content))
+	_, err = AttachmentContent(email, email.Attachments[1], nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	restored, err := RestoreAttachments(email, blobs)
+	require.NoError(t, err)
+	assert.Equal(t, raw, restored)
+}
+
+func TestExtractAttachments_Mismatch(t *testing.T) {
+	blobs := newTestBlobStore(t)
+
+	email, err := mail.ParseRawEmail(attachmentMessage("content"), nil)
+	require.NoError(t, err)
+
+	// Parsed metadata that does not match the part is left alone
+	email.Attachments[0].SHA256 = strings.Repeat("0", 64)
+	raw := email.Raw
+	require.NoError(t, ExtractAttachments(email, blobs, 0))
+	assert.False(t, email.Attachments[0].Extracted)
+	assert.Equal(t, raw, email.Raw)
+}
+
+func TestSweepBlobs(t *testing.T) {
+	ctx := context.Background()
+	blobs := newTestBlobStore(t)
+	store, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	keep, err := mail.ParseRawEmail(attachmentMessage("kept"), nil)
+	require.NoError(t, err)
+	require.NoError(t, ExtractAttachments(keep, blobs, 0))
+	storeAt(t, store, time.Now(), keep)
+
+	drop, err := mail.ParseRawEmail(attachmentMessage("dropped"), nil)
+	require.NoError(t, err)
+	require.NoError(t, ExtractAttachments(drop, blobs, 0))
+	dropID := storeAt(t, store, time.Now(), drop)
+	require.NoError(t, store.Delete(ctx, dropID))
+
+	// Freshly written blobs survive, their message may still be on its way
+	removed, _, err := SweepBlobs(ctx, store, blobs, time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+
+	removed, removedBytes, err := SweepBlobs(ctx, store, blobs, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, int64(len("dropped")), removedBytes)
+
+	_, err = blobs.Get(drop.Attachments[0].SHA256)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = blobs.Get(keep.Attachments[0].SHA256)
+	assert.NoError(t, err)
+}
+
+// listedStorage returns a fixed message list, as seen by a sweep that
+// listed messages before others were stored
+type listedStorage struct {
+	Storage
+	ids []string
+}
+
+func (l *listedStorage) List(ctx context.Context) ([]string, error) {
+	return l.ids, nil
+}
+
+func TestSweepBlobs_ReusedBlob(t *testing.T) {
+	ctx := context.Background()
+	blobs := newTestBlobStore(t)
+	store, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	// A logo last sent long ago, whose messages are all gone
+	first, err := mail.ParseRawEmail(attachmentMessage("logo"), nil)
+	require.NoError(t, err)
+	require.NoError(t, ExtractAttachments(first, blobs, 0))
+	logo := first.Attachments[0].SHA256
+	old := time.Now().Add(-24 * time.Hour)
+	require.NoError(t, os.Chtimes(blobs.path(logo), old, old))
+
+	// The sweep lists messages, then a new message with the same logo is stored
+	listed, err := store.List(ctx)
+	require.NoError(t, err)
+	second, err := mail.ParseRawEmail(attachmentMessage("logo"), nil)
+	require.NoError(t, err)
+	require.NoError(t, ExtractAttachments(second, blobs, 0))
+	storeAt(t, store, time.Now(), second)
+
+	removed, _, err := SweepBlobs(ctx, &listedStorage{Storage: store, ids: listed}, blobs, time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+	content, err := blobs.Get(logo)
+	require.NoError(t, err)
+	assert.Equal(t, "logo", string(content))
+}
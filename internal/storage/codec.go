@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms accepted by the storage_compression setting
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// ErrUnknownKey is returned when a message was encrypted with a key that is
+// not in the key file
+var ErrUnknownKey = errors.New("encryption key not available")
+
+// codecMagic starts every encoded message. Anything else is read as the
+// plain JSON written before encoding was enabled.
+var codecMagic = []byte("GMS1")
+
+// compression identifiers stored in the header
+var compressionIDs = map[string]byte{CompressionNone: 0, CompressionGzip: 1, CompressionZstd: 2}
+
+const (
+	dataKeySize = 32 // AES-256
+	nonceSize   = 12 // standard GCM nonce
+	// wrappedKeySize is an AES-GCM sealed data key, including its tag
+	wrappedKeySize = dataKeySize + 16
+)
+
+// Codec compresses and encrypts messages at rest. Each message is sealed
+// with its own random data key, which is itself sealed with the current key
+// from the key file (envelope encryption); the key ID is recorded so older
+// keys keep working after rotation. A nil Codec stores messages as-is.
+//
+// Encoded layout:
+//
+//	"GMS1" | compression (1) | key ID length (1) | key ID
+//	[ wrap nonce (12) | wrapped data key (48) | data nonce (12) ]  if encrypted
+//	payload
+type Codec struct {
+	compression string
+	keyID       string                 // key new messages are sealed with; "" disables encryption
+	keys        map[string]cipher.AEAD // key encryption keys by ID
+}
+
+// NewCodec creates a codec. keys maps key IDs to 32-byte AES keys and may
+// be nil when encryption is off; keyID selects the key used for new
+// messages and must be present in keys.
+func NewCodec(compression, keyID string, keys map[string][]byte) (*Codec, error) {
+	if compression == "" {
+		compression = CompressionNone
+	}
+	if _, ok := compressionIDs[compression]; !ok {
+		return nil, fmt.Errorf("unknown compression: %s", compression)
+	}
+
+	c := &Codec{compression: compression, keyID: keyID, keys: map[string]cipher.AEAD{}}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		c.keys[id] = aead
+	}
+
+	if keyID != "" {
+		if len(keyID) > 255 {
+			return nil, fmt.Errorf("key ID %s is too long", keyID)
+		}
+		if _, ok := c.keys[keyID]; !ok {
+			return nil, fmt.Errorf("key %s: %w", keyID, ErrUnknownKey)
+		}
+	}
+
+	return c, nil
+}
+
+// LoadKeyFile reads a key file of "<id>:<base64 32-byte key>" lines. Blank
+// lines and lines starting with # are ignored. The IDs are returned in file
+// order, so the last one is the newest key.
+func LoadKeyFile(path string) (map[string][]byte, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer f.Close()
+
+	keys := map[string][]byte{}
+	var order []string
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(text, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, nil, fmt.Errorf("key file line %d: expected <id>:<base64 key>", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != dataKeySize {
+			return nil, nil, fmt.Errorf("key file line %d: key %s must be %d base64-encoded bytes", line, id, dataKeySize)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, nil, fmt.Errorf("key file line %d: duplicate key ID %s", line, id)
+		}
+
+		keys[id] = key
+		order = append(order, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(order) == 0 {
+		return nil, nil, fmt.Errorf("key file %s contains no keys", path)
+	}
+
+	return keys, order, nil
+}
+
+// Enabled reports whether Encode changes messages at all
+func (c *Codec) Enabled() bool {
+	return c != nil && (c.compression != CompressionNone || c.keyID != "")
+}
+
+// Encode compresses and encrypts a message for storage. The message ID is
+// authenticated along with the header, so sealed data cannot be swapped
+// between messages.
+func (c *Codec) Encode(emailID string, data []byte) ([]byte, error) {
+	if !c.Enabled() {
+		return data, nil
+	}
+
+	payload, err := compress(c.compression, data)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{}, codecMagic...)
+	header = append(header, compressionIDs[c.compression], byte(len(c.keyID)))
+	header = append(header, c.keyID...)
+
+	if c.keyID == "" {
+		return append(header, payload...), nil
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	wrapNonce := make([]byte, nonceSize)
+	dataNonce := make([]byte, nonceSize)
+	for _, b := range [][]byte{dataKey, wrapNonce, dataNonce} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate key material: %w", err)
+		}
+	}
+
+	aad := append(append([]byte{}, header...), emailID...)
+	wrapped := c.keys[c.keyID].Seal(nil, wrapNonce, dataKey, aad)
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append(header, wrapNonce...)
+	out = append(out, wrapped...)
+	out = append(out, dataNonce...)
+	return dataAEAD.Seal(out, dataNonce, payload, aad), nil
+}
+
+// Decode reverses Encode. Data without the codec header is returned
+// unchanged, so messages stored before encoding was enabled stay readable.
+func (c *Codec) Decode(emailID string, data []byte) ([]byte, error) {
+	h, err := parseCodecHeader(data)
+	if err != nil || h == nil {
+		return data, err
+	}
+
+	payload := data[h.size:]
+	if h.keyID != "" {
+		var kek cipher.AEAD
+		if c != nil {
+			kek = c.keys[h.keyID]
+		}
+		if kek == nil {
+			return nil, fmt.Errorf("message %s is encrypted with key %s: %w", emailID, h.keyID, ErrUnknownKey)
+		}
+		if len(payload) < nonceSize+wrappedKeySize+nonceSize {
+			return nil, fmt.Errorf("message %s: truncated encryption header", emailID)
+		}
+
+		aad := append(append([]byte{}, data[:h.size]...), emailID...)
+		wrapNonce := payload[:nonceSize]
+		wrapped := payload[nonceSize : nonceSize+wrappedKeySize]
+		dataNonce := payload[nonceSize+wrappedKeySize : nonceSize+wrappedKeySize+nonceSize]
+
+		dataKey, err := kek.Open(nil, wrapNonce, wrapped, aad)
+		if err != nil {
+			return nil, fmt.Errorf("message %s: failed to unwrap data key: %w", emailID, err)
+		}
+		dataAEAD, err := newAEAD(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		payload, err = dataAEAD.Open(nil, dataNonce, payload[nonceSize+wrappedKeySize+nonceSize:], aad)
+		if err != nil {
+			return nil, fmt.Errorf("message %s: failed to decrypt: %w", emailID, err)
+		}
+	}
+
+	return decompress(h.compression, payload)
+}
+
+// Current reports whether data is already encoded the way Encode would
+// encode it now, i.e. with the same compression and key
+func (c *Codec) Current(data []byte) bool {
+	compression, keyID := CompressionNone, ""
+	if c != nil {
+		compression, keyID = c.compression, c.keyID
+	}
+
+	h, err := parseCodecHeader(data)
+	if err != nil {
+		return false
+	}
+	if h == nil {
+		return compression == CompressionNone && keyID == ""
+	}
+	return h.compression == compression && h.keyID == keyID
+}
+
+// codecHeader is the parsed fixed part of an encoded message
+type codecHeader struct {
+	compression string
+	keyID       string
+	size        int
+}
+
+// parseCodecHeader returns nil for data that was stored without encoding
+func parseCodecHeader(data []byte) (*codecHeader, error) {
+	if !bytes.HasPrefix(data, codecMagic) {
+		return nil, nil
+	}
+	if len(data) < len(codecMagic)+2 {
+		return nil, fmt.Errorf("truncated storage header")
+	}
+
+	h := &codecHeader{compression: ""}
+	for name, id := range compressionIDs {
+		if id == data[len(codecMagic)] {
+			h.compression = name
+		}
+	}
+	if h.compression == "" {
+		return nil, fmt.Errorf("unknown compression ID %d", data[len(codecMagic)])
+	}
+
+	keyIDLen := int(data[len(codecMagic)+1])
+	h.size = len(codecMagic) + 2 + keyIDLen
+	if len(data) < h.size {
+		return nil, fmt.Errorf("truncated storage header")
+	}
+	h.keyID = string(data[len(codecMagic)+2 : h.size])
+
+	return h, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// zstd encoders and decoders are safe for concurrent EncodeAll/DecodeAll
+// calls and expensive to create, so they are shared
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+)
+
+func compress(compression string, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to compress: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress: %w", err)
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress: %w", err)
+		}
+		return encoder.EncodeAll(data, nil), nil
+	default:
+		return data, nil
+	}
+}
+
+func decompress(compression string, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		defer r.Close()
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		return out, nil
+	case CompressionZstd:
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		out, err := decoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		return out, nil
+	default:
+		return data, nil
+	}
+}
+
+// RekeyResult counts the messages handled by a Rekeyer
+type RekeyResult struct {
+	Rewritten int
+	Skipped   int // already encoded with the current settings
+}
+
+// Rekeyer is implemented by backends that encode messages at rest. Rekey
+// rewrites every message not yet encoded with the current compression and
+// key, so an old key can be retired once it completes.
+type Rekeyer interface {
+	Rekey(ctx context.Context) (RekeyResult, error)
+}
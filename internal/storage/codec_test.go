@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func writeKeyFile(t *testing.T, keys ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "storage.keys")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(keys, "\n")+"\n"), 0600))
+	return path
+}
+
+func keyLine(id string, key []byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	keys := map[string][]byte{"2026-01": newTestKey(t)}
+	data := []byte(`{"raw":"` + strings.Repeat("Subject: hello\r\n", 200) + `"}`)
+	id := "msg_1760612345_9f86d081"
+
+	for _, compression := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		for _, keyID := range []string{"", "2026-01"} {
+			t.Run(compression+"/"+keyID, func(t *testing.T) {
+				codec, err := NewCodec(compression, keyID, keys)
+				require.NoError(t, err)
+
+				encoded, err := codec.Encode(id, data)
+				require.NoError(t, err)
+				assert.True(t, codec.Current(encoded))
+
+				if compression != CompressionNone {
+					assert.Less(t, len(encoded), len(data))
+				}
+				if keyID != "" {
+					assert.NotContains(t, string(encoded), "Subject: hello")
+				}
+
+				decoded, err := codec.Decode(id, encoded)
+				require.NoError(t, err)
+				assert.Equal(t, data, decoded)
+			})
+		}
+	}
+}
+
+func TestCodec_PlainPassthrough(t *testing.T) {
+	data := []byte(`{"sender":"alice@example.com"}`)
+
+	var none *Codec
+	assert.False(t, none.Enabled())
+	encoded, err := none.Encode("msg_1_00", data)
+	require.NoError(t, err)
+	assert.Equal(t, data, encoded)
+	assert.True(t, none.Current(data))
+
+	// Messages written before encoding was enabled stay readable
+	codec, err := NewCodec(CompressionZstd, "", nil)
+	require.NoError(t, err)
+	decoded, err := codec.Decode("msg_1_00", data)
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+	assert.False(t, codec.Current(data))
+}
+
+func TestCodec_KeyRotation(t *testing.T) {
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+	id := "msg_1760612345_9f86d081"
+	data := []byte(`{"raw":"secret"}`)
+
+	old, err := NewCodec(CompressionNone, "old", map[string][]byte{"old": oldKey})
+	require.NoError(t, err)
+	sealed, err := old.Encode(id, data)
+	require.NoError(t, err)
+
+	rotated, err := NewCodec(CompressionNone, "new", map[string][]byte{"old": oldKey, "new": newKey})
+	require.NoError(t, err)
+	assert.False(t, rotated.Current(sealed))
+
+	decoded, err := rotated.Decode(id, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+
+	// Once the old key is gone the message can no longer be read
+	retired, err := NewCodec(CompressionNone, "new", map[string][]byte{"new": newKey})
+	require.NoError(t, err)
+	_, err = retired.Decode(id, sealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	_, err = NewCodec(CompressionNone, "missing", map[string][]byte{"new": newKey})
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestCodec_Tampering(t *testing.T) {
+	codec, err := NewCodec(CompressionGzip, "k1", map[string][]byte{"k1": newTestKey(t)})
+	require.NoError(t, err)
+
+	sealed, err := codec.Encode("msg_1760612345_9f86d081", []byte(`{"raw":"secret"}`))
+	require.NoError(t, err)
+
+	// Sealed data is bound to its message ID
+	_, err = codec.Decode("msg_1760612345_00000000", sealed)
+	assert.Error(t, err)
+
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 0xff
+	_, err = codec.Decode("msg_1760612345_9f86d081", flipped)
+	assert.Error(t, err)
+
+	_, err = codec.Decode("msg_1760612345_9f86d081", sealed[:20])
+	assert.Error(t, err)
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	first, second := newTestKey(t), newTestKey(t)
+
+	path := writeKeyFile(t, "# rotated quarterly", keyLine("2026-q1", first), "", keyLine("2026-q2", second))
+	keys, order, err := LoadKeyFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2026-q1", "2026-q2"}, order)
+	assert.Equal(t, second, keys["2026-q2"])
+
+	for name, contents := range map[string]string{
+		"empty":      "# nothing yet",
+		"no id":      ":" + base64.StdEncoding.EncodeToString(first),
+		"short key":  "k1:" + base64.StdEncoding.EncodeToString(first[:16]),
+		"not base64": "k1:not-base64!",
+		"duplicate":  keyLine("k1", first) + "\n" + keyLine("k1", second),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := LoadKeyFile(writeKeyFile(t, contents))
+			assert.Error(t, err)
+		})
+	}
+
+	_, _, err = LoadKeyFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestCodecFor(t *testing.T) {
+	codec, err := CodecFor(&config.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, codec)
+
+	codec, err = CodecFor(&config.Config{StorageCompression: CompressionZstd})
+	require.NoError(t, err)
+	assert.True(t, codec.Enabled())
+	assert.Empty(t, codec.keyID)
+
+	// The last key in the file encrypts new messages unless one is chosen
+	path := writeKeyFile(t, keyLine("a", newTestKey(t)), keyLine("b", newTestKey(t)))
+	codec, err = CodecFor(&config.Config{StorageEncryptionKeyFile: path})
+	require.NoError(t, err)
+	assert.Equal(t, "b", codec.keyID)
+
+	codec, err = CodecFor(&config.Config{StorageEncryptionKeyFile: path, StorageEncryptionKeyID: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "a", codec.keyID)
+
+	_, err = CodecFor(&config.Config{StorageEncryptionKeyFile: path, StorageEncryptionKeyID: "c"})
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	_, err = Open(&config.Config{StorageBackend: BackendMaildir, DataDir: t.TempDir(), StorageCompression: CompressionGzip})
+	assert.Error(t, err)
+}
+
+func TestFileStorage_Encoded(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+
+	store, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	plain := storeAt(t, store, time.Now().Add(-time.Hour), &mail.EmailData{Subject: "Plain", Raw: "Subject: Plain\r\n\r\nhi"})
+
+	old, err := NewCodec(CompressionGzip, "old", map[string][]byte{"old": oldKey})
+	require.NoError(t, err)
+	store.SetCodec(old)
+
+	id, path, err := StoreEmail(ctx, store, &mail.EmailData{Subject: "Sealed", Raw: "Subject: Sealed\r\n\r\nsecret body"})
+	require.NoError(t, err)
+
+	onDisk, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(onDisk), "secret body")
+
+	// Load and the Storage interface both decode transparently
+	email, err := store.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Sealed", email.Subject)
+
+	email, err = LoadEmail(ctx, store, plain)
+	require.NoError(t, err)
+	assert.Equal(t, "Plain", email.Subject)
+
+	// Rotate to a new key and re-encode everything
+	rotated, err := NewCodec(CompressionZstd, "new", map[string][]byte{"old": oldKey, "new": newKey})
+	require.NoError(t, err)
+	store.SetCodec(rotated)
+	require.NoError(t, store.MarkProcessed(ctx, id))
+
+	result, err := store.Rekey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RekeyResult{Rewritten: 2}, result)
+
+	result, err = store.Rekey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RekeyResult{Skipped: 2}, result)
+
+	// The old key is no longer needed
+	retired, err := NewCodec(CompressionZstd, "new", map[string][]byte{"new": newKey})
+	require.NoError(t, err)
+	store.SetCodec(retired)
+
+	for subject, id := range map[string]string{"Plain": plain, "Sealed": id} {
+		email, err := LoadEmail(ctx, store, id)
+		require.NoError(t, err)
+		assert.Equal(t, subject, email.Subject)
+	}
+
+	status, err := store.Status(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, FolderProcessed, status)
+}
+
+func TestSQLiteStorage_Encoded(t *testing.T) {
+	ctx := context.Background()
+	store := newTestSQLiteStorage(t)
+	key := newTestKey(t)
+
+	plain := storeAt(t, store, time.Now().Add(-time.Hour), &mail.EmailData{Sender: "alice@example.com", Raw: "plain body"})
+
+	codec, err := NewCodec(CompressionZstd, "k1", map[string][]byte{"k1": key})
+	require.NoError(t, err)
+	store.SetCodec(codec)
+
+	id := storeAt(t, store, time.Now(), &mail.EmailData{Sender: "bob@example.com", Raw: "secret body"})
+
+	blob, err := store.blob(ctx, id)
+	require.NoError(t, err)
+	assert.NotContains(t, string(blob), "secret body")
+
+	// Metadata stays searchable
+	summaries, _, err := store.Search(ctx, MessageQuery{Sender: "bob"})
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, id, summaries[0].ID)
+
+	email, err := LoadEmail(ctx, store, id)
+	require.NoError(t, err)
+	assert.Equal(t, "secret body", email.Raw)
+
+	result, err := store.Rekey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RekeyResult{Rewritten: 1, Skipped: 1}, result)
+
+	blob, err = store.blob(ctx, plain)
+	require.NoError(t, err)
+	assert.True(t, codec.Current(blob))
+}
@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+)
+
+// DedupKey identifies a delivery for deduplication: the Message-ID and
+// envelope recipients, or a hash of the raw message and recipients when
+// there is no Message-ID. The hash leaves out GoMail's own Received
+// header, which differs on every delivery. It returns "" when there is
+// nothing to key on.
+func DedupKey(email *mail.EmailData) string {
+	var recipients []string
+	for _, recipient := range email.EnvelopeRecipients() {
+		recipients = append(recipients, strings.ToLower(strings.TrimSpace(recipient)))
+	}
+	sort.Strings(recipients)
+	recipient := strings.Join(recipients, ",")
+
+	if messageID := strings.Trim(strings.TrimSpace(email.MessageID), "<>"); messageID != "" {
+		sum := sha256.Sum256([]byte(messageID + "\n" + recipient))
+		return "mid:" + hex.EncodeToString(sum[:])
+	}
+	if email.Raw != "" {
+		sum := sha256.Sum256([]byte(withoutTrace(email.Raw) + "\n" + recipient))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+// withoutTrace removes the Received header the SMTP and LMTP receivers
+// add. It names the session and the time, so a client's retry of the same
+// message would otherwise never hash the same.
+func withoutTrace(raw string) string {
+	start := 0
+	for start < len(raw) {
+		end := strings.IndexByte(raw[start:], '\n')
+		if end < 0 || strings.TrimRight(raw[start:start+end], "\r") == "" {
+			// End of the headers
+			return raw
+		}
+		end += start + 1
+
+		// Folded continuation lines belong to the same field
+		for end < len(raw) && (raw[end] == ' ' || raw[end] == '\t') {
+			next := strings.IndexByte(raw[end:], '\n')
+			if next < 0 {
+				end = len(raw)
+				break
+			}
+			end += next + 1
+		}
+
+		field := raw[start:end]
+		if len(field) > 9 && strings.EqualFold(field[:9], "Received:") && strings.Contains(field, " (GoMail) with ") {
+			return raw[:start] + raw[end:]
+		}
+		start = end
+	}
+	return raw
+}
+
+// Deduplicator stores inbound messages at most once per window. Postfix
+// retries and the pipe script's own retries can hand over the same message
+// several times; later copies resolve to the ID of the first.
+//
+// The index is kept in memory and rebuilt from the messages stored within
+// the window when the Deduplicator is created, so it survives restarts
+// without any state of its own.
+type Deduplicator struct {
+	storage Storage
+	window  time.Duration
+	now     func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]dedupEntry
+	locks     map[string]*dedupLock
+	lastSweep time.Time
+}
+
+type dedupEntry struct {
+	id       string
+	storedAt time.Time
+}
+
+// dedupLock serialises concurrent deliveries of the same message
+type dedupLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// NewDeduplicator creates a deduplicator for s and indexes the messages
+// stored within the last window
+func NewDeduplicator(ctx context.Context, s Storage, window time.Duration) (*Deduplicator, error) {
+	d := &Deduplicator{
+		storage: s,
+		window:  window,
+		now:     time.Now,
+		entries: map[string]dedupEntry{},
+		locks:   map[string]*dedupLock{},
+	}
+	d.lastSweep = d.now()
+
+	if err := d.load(ctx); err != nil {
+		return nil, fmt.Errorf("failed to build dedup index: %w", err)
+	}
+	return d, nil
+}
+
+// StoreEmail stores email unless a copy was stored within the window, in
+// which case the original's ID is returned with duplicate set and location
+// empty
+func (d *Deduplicator) StoreEmail(ctx context.Context, email *mail.EmailData) (id, location string, duplicate bool, err error) {
+	key := DedupKey(email)
+	if key == "" {
+		id, location, err = StoreEmail(ctx, d.storage, email)
+		return id, location, false, err
+	}
+
+	unlock := d.lock(key)
+	defer unlock()
+
+	now := d.now()
+	if entry, ok := d.lookup(key, now); ok {
+		exists, err := d.exists(ctx, entry.id)
+		if err != nil {
+			return "", "", false, err
+		}
+		if exists {
+			return entry.id, "", true, nil
+		}
+	}
+
+	id, location, err = StoreEmail(ctx, d.storage, email)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	d.mu.Lock()
+	d.entries[key] = dedupEntry{id: id, storedAt: now}
+	d.mu.Unlock()
+
+	return id, location, false, nil
+}
+
+// lock takes the per-key lock and returns its release function
+func (d *Deduplicator) lock(key string) func() {
+	d.mu.Lock()
+	l, ok := d.locks[key]
+	if !ok {
+		l = &dedupLock{}
+		d.locks[key] = l
+	}
+	l.refs++
+	d.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		d.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(d.locks, key)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// lookup returns the unexpired entry for key, dropping expired entries at
+// most once per minute
+func (d *Deduplicator) lookup(key string, now time.Time) (dedupEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastSweep) >= time.Minute {
+		for k, entry := range d.entries {
+			if now.Sub(entry.storedAt) > d.window {
+				delete(d.entries, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	entry, ok := d.entries[key]
+	if !ok || now.Sub(entry.storedAt) > d.window {
+		return dedupEntry{}, false
+	}
+	return entry, true
+}
+
+// exists reports whether the original is still stored; a copy of a deleted
+// message is stored again
+func (d *Deduplicator) exists(ctx context.Context, id string) (bool, error) {
+	var err error
+	if queue, ok := d.storage.(DeliveryStore); ok {
+		_, err = queue.Status(ctx, id)
+	} else {
+		_, err = d.storage.Retrieve(ctx, id)
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check for original message %s: %w", id, err)
+	}
+	return true, nil
+}
+
+// load indexes the messages stored within the window. Message IDs encode
+// the storage time, so older messages are skipped without being read.
+func (d *Deduplicator) load(ctx context.Context) error {
+	since := d.now().Add(-d.window)
+
+	if searcher, ok := d.storage.(Searcher); ok {
+		summaries, _, err := searcher.Search(ctx, MessageQuery{Since: since})
+		if err != nil {
+			return err
+		}
+		for _, summary := range summaries {
+			if summary.MessageID != "" {
+				d.add(&mail.EmailData{MessageID: summary.MessageID, Recipient: summary.Recipient, Recipients: summary.Recipients}, summary.ID)
+			} else {
+				d.loadMessage(ctx, summary.ID)
+			}
+		}
+		return nil
+	}
+
+	ids, err := d.storage.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if storedAt, ok := MessageTime(id); !ok || storedAt.Before(since) {
+			continue
+		}
+		d.loadMessage(ctx, id)
+	}
+	return nil
+}
+
+// loadMessage indexes one stored message. A message that cannot be loaded,
+// such as a truncated file or one encrypted with a retired key, is left out
+// of the index rather than keeping the server from starting.
+func (d *Deduplicator) loadMessage(ctx context.Context, id string) {
+	email, err := LoadEmail(ctx, d.storage, id)
+	if errors.Is(err, ErrNotFound) {
+		// Deleted since it was listed
+		return
+	}
+	if err != nil {
+		logging.Get().Errorw("Skipping unreadable message in dedup index", "message", id, "error", err)
+		metrics.DedupUnreadableMessages.Inc()
+		return
+	}
+	d.add(email, id)
+}
+
+// add indexes a stored message, keeping the oldest copy of each key
+func (d *Deduplicator) add(email *mail.EmailData, id string) {
+	key := DedupKey(email)
+	storedAt, ok := MessageTime(id)
+	if key == "" || !ok {
+		return
+	}
+
+	if existing, ok := d.entries[key]; ok && !storedAt.Before(existing.storedAt) {
+		return
+	}
+	d.entries[key] = dedupEntry{id: id, storedAt: storedAt}
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupKey(t *testing.T) {
+	email := &mail.EmailData{MessageID: "<abc@example.com>", Recipient: "Bob@Example.com", Raw: "one"}
+
+	key := DedupKey(email)
+	assert.Regexp(t, `^mid:[0-9a-f]{64}$`, key)
+	assert.Equal(t, key, DedupKey(&mail.EmailData{MessageID: "abc@example.com", Recipient: " bob@example.com", Raw: "two"}))
+	assert.NotEqual(t, key, DedupKey(&mail.EmailData{MessageID: "<abc@example.com>", Recipient: "carol@example.com"}))
+
+	// The recipient set counts, not its order
+	both := DedupKey(&mail.EmailData{MessageID: "<abc@example.com>", Recipients: []string{"bob@example.com", "carol@example.com"}})
+	assert.NotEqual(t, key, both)
+	assert.Equal(t, both, DedupKey(&mail.EmailData{MessageID: "<abc@example.com>", Recipients: []string{"Carol@example.com", "bob@example.com"}}))
+
+	// Without a Message-ID the content decides
+	hashed := DedupKey(&mail.EmailData{Recipient: "bob@example.com", Raw: "Subject: hi\r\n\r\nbody"})
+	assert.Regexp(t, `^sha256:[0-9a-f]{64}$`, hashed)
+	assert.NotEqual(t, hashed, DedupKey(&mail.EmailData{Recipient: "bob@example.com", Raw: "Subject: hi\r\n\r\nother"}))
+
+	assert.Empty(t, DedupKey(&mail.EmailData{Recipient: "bob@example.com", Subject: "legacy JSON"}))
+}
+
+func TestDedupKey_IgnoresGoMailTrace(t *testing.T) {
+	received := func(session, date string) string {
+		return "Received: from mail.sender.example ([192.0.2.1])\r\n\tby mx.example.com (GoMail) with ESMTP id " +
+			session + ";\r\n\t" + date + "\r\n"
+	}
+	body := "From: alice@sender.example\r\nSubject: hi\r\n\r\nbody\r\n"
+
+	// A client's retry arrives in a new session at a new time
+	first := DedupKey(&mail.EmailData{Recipient: "bob@example.com", Raw: received("abc123", "Fri, 16 Oct 2026 11:05:45 +0000") + body})
+	retry := DedupKey(&mail.EmailData{Recipient: "bob@example.com", Raw: received("def456", "Fri, 16 Oct 2026 11:10:45 +0000") + body})
+	assert.Equal(t, first, retry)
+	assert.Equal(t, first, DedupKey(&mail.EmailData{Recipient: "bob@example.com", Raw: body}))
+
+	// Quarantined copies have a header above the trace
+	quarantined := "X-Quarantine-Reason: DMARC policy\r\n"
+	assert.Equal(t,
+		DedupKey(&mail.EmailData{Recipient: "bob@example.com", Raw: quarantined + received("abc123", "Fri, 16 Oct 2026 11:05:45 +0000") + body}),
+		DedupKey(&mail.EmailData{Recipient: "bob@example.com", Raw: quarantined + received("def456", "Fri, 16 Oct 2026 11:10:45 +0000") + body}))
+
+	// Other servers' trace headers and the body still count
+	other := "Received: from relay.example ([198.51.100.1]) by mx.example.com; Fri, 16 Oct 2026 11:10:45 +0000\r\n"
+	assert.NotEqual(t, first, DedupKey(&mail.EmailData{Recipient: "bob@example.com", Raw: other + body}))
+	assert.NotEqual(t, first, DedupKey(&mail.EmailData{Recipient: "bob@example.com", Raw: strings.Replace(body, "body", "other", 1)}))
+}
+
+func TestDeduplicator_StoreEmail(t *testing.T) {
+	for name, newStore := range map[string]func(t *testing.T) Storage{
+		"file": func(t *testing.T) Storage {
+			store, err := NewFileStorage(t.TempDir())
+			require.NoError(t, err)
+			return store
+		},
+		"sqlite": func(t *testing.T) Storage { return newTestSQLiteStorage(t) },
+		"maildir": func(t *testing.T) Storage {
+			store, err := NewMaildirStorage(t.TempDir())
+			require.NoError(t, err)
+			return store
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+
+			dedup, err := NewDeduplicator(ctx, store, time.Hour)
+			require.NoError(t, err)
+
+			email := &mail.EmailData{MessageID: "<a@example.com>", Recipient: "bob@example.com", Raw: "Message-ID: <a@example.com>\r\n\r\nhi"}
+			id, location, duplicate, err := dedup.StoreEmail(ctx, email)
+			require.NoError(t, err)
+			assert.False(t, duplicate)
+			assert.NotEmpty(t, location)
+
+			again, location, duplicate, err := dedup.StoreEmail(ctx, email)
+			require.NoError(t, err)
+			assert.True(t, duplicate)
+			assert.Equal(t, id, again)
+			assert.Empty(t, location)
+
+			// The same message to another recipient is a separate delivery
+			other := *email
+			other.Recipient = "carol@example.com"
+			otherID, _, duplicate, err := dedup.StoreEmail(ctx, &other)
+			require.NoError(t, err)
+			assert.False(t, duplicate)
+
+			ids, err := store.List(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{id, otherID}, ids)
+
+			// A fresh index finds messages already stored within the window
+			restarted, err := NewDeduplicator(ctx, store, time.Hour)
+			require.NoError(t, err)
+			again, _, duplicate, err = restarted.StoreEmail(ctx, email)
+			require.NoError(t, err)
+			assert.True(t, duplicate)
+			assert.Equal(t, id, again)
+
+			// Once the original is deleted a copy is stored again
+			require.NoError(t, store.Delete(ctx, id))
+			again, _, duplicate, err = restarted.StoreEmail(ctx, email)
+			require.NoError(t, err)
+			assert.False(t, duplicate)
+			assert.NotEqual(t, id, again)
+		})
+	}
+}
+
+func TestDeduplicator_Window(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	email := &mail.EmailData{Recipient: "bob@example.com", Raw: "Subject: hi\r\n\r\nhi"}
+
+	// Stored two hours ago, outside a one-hour window
+	storeAt(t, store, time.Now().Add(-2*time.Hour), email)
+
+	dedup, err := NewDeduplicator(ctx, store, time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, dedup.entries)
+
+	id, _, duplicate, err := dedup.StoreEmail(ctx, email)
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+
+	now := time.Now()
+	dedup.now = func() time.Time { return now.Add(61 * time.Minute) }
+
+	again, _, duplicate, err := dedup.StoreEmail(ctx, email)
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+	assert.NotEqual(t, id, again)
+}
+
+func TestDeduplicator_UnreadableMessage(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	email := &mail.EmailData{MessageID: "<abc@example.com>", Recipient: "bob@example.com"}
+	original := storeAt(t, store, time.Now().Add(-time.Minute), email)
+
+	// A truncated message inside the window
+	corrupt, err := NewMessageID(time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	_, err = store.Store(ctx, corrupt, []byte(`{"message_id": "<trunc`))
+	require.NoError(t, err)
+
+	before := testutil.ToFloat64(metrics.DedupUnreadableMessages)
+	dedup, err := NewDeduplicator(ctx, store, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.DedupUnreadableMessages))
+
+	// The readable message is still indexed
+	id, _, duplicate, err := dedup.StoreEmail(ctx, email)
+	require.NoError(t, err)
+	assert.True(t, duplicate)
+	assert.Equal(t, original, id)
+}
+
+func TestDeduplicator_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	dedup, err := NewDeduplicator(ctx, store, time.Hour)
+	require.NoError(t, err)
+
+	email := &mail.EmailData{MessageID: "<race@example.com>", Recipient: "bob@example.com", Raw: "Subject: race\r\n\r\nhi"}
+
+	var wg sync.WaitGroup
+	ids := make([]string, 10)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, _, _, err := dedup.StoreEmail(ctx, email)
+			assert.NoError(t, err)
+			ids[i] = id
+		}(i)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
+
+	stored, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, stored, 1)
+	assert.Empty(t, dedup.locks)
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/mail"
+)
+
+// messageIDPattern matches the msg_<unix>_<hex> IDs generated by NewMessageID
+var messageIDPattern = regexp.MustCompile(`^msg_[0-9]+_[0-9a-f]+$`)
+
+// NewMessageID returns a unique message ID of the form msg_<unix>_<hex>.
+// IDs sort chronologically and encode the time the message was stored.
+func NewMessageID(t time.Time) (string, error) {
+	randomBytes := make([]byte, 4)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to generate random ID: %w", err)
+	}
+
+	return fmt.Sprintf("msg_%d_%s", t.Unix(), hex.EncodeToString(randomBytes)), nil
+}
+
+// ValidMessageID reports whether id has the form generated by NewMessageID
+func ValidMessageID(id string) bool {
+	return messageIDPattern.MatchString(id)
+}
+
+// MessageTime returns the storage time encoded in a message ID
+func MessageTime(id string) (time.Time, bool) {
+	if !ValidMessageID(id) {
+		return time.Time{}, false
+	}
+
+	parts := strings.Split(id, "_")
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(unix, 0), true
+}
+
+// StoreEmail assigns a new message ID to email and stores it as JSON,
+// returning the ID and the backend-specific location
+func StoreEmail(ctx context.Context, s Storage, email *mail.EmailData) (string, string, error) {
+	id, err := NewMessageID(time.Now())
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := json.MarshalIndent(email, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal email data: %w", err)
+	}
+
+	location, err := s.Store(ctx, id, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return id, location, nil
+}
+
+// LoadEmail retrieves and decodes a stored email
+func LoadEmail(ctx context.Context, s Storage, id string) (*mail.EmailData, error) {
+	data, err := s.Retrieve(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var email mail.EmailData
+	if err := json.Unmarshal(data, &email); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal email data: %w", err)
+	}
+
+	return &email, nil
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/logging"
+)
+
+// Storage backend names accepted by the storage_backend setting
+const (
+	BackendFile    = "file"
+	BackendSQLite  = "sqlite"
+	BackendS3      = "s3"
+	BackendMaildir = "maildir"
+)
+
+// connectionLimiter is implemented by backends that pool their own connections
+type connectionLimiter interface {
+	SetConnectionLimits(maxOpen, maxIdle int)
+}
+
+// Open creates the storage backend selected by cfg.StorageBackend
+func Open(cfg *config.Config) (Storage, error) {
+	codec, err := CodecFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return open(cfg, codec)
+}
+
+// open creates the configured backend with messages encoded by codec
+func open(cfg *config.Config, codec *Codec) (Storage, error) {
+	switch cfg.StorageBackend {
+	case "", BackendFile:
+		store, err := NewFileStorage(cfg.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		store.SetCodec(codec)
+		return store, nil
+	case BackendSQLite:
+		store, err := NewSQLiteStorage(SQLitePath(cfg))
+		if err != nil {
+			return nil, err
+		}
+		store.SetCodec(codec)
+		warnPlaintextIndex(cfg)
+		return store, nil
+	case BackendS3:
+		store, err := NewObjectStorage(S3OptionsFrom(cfg), SQLitePath(cfg))
+		if err != nil {
+			return nil, err
+		}
+		store.SetCodec(codec)
+		warnPlaintextIndex(cfg)
+		return store, nil
+	case BackendMaildir:
+		// Maildir files are read directly by mail clients
+		if codec.Enabled() {
+			return nil, fmt.Errorf("the maildir backend does not support compression or encryption")
+		}
+		return NewMaildirStorage(MaildirPath(cfg))
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.StorageBackend)
+	}
+}
+
+// warnPlaintextIndex notes that encryption does not cover the SQLite
+// metadata index, which has to stay searchable
+func warnPlaintextIndex(cfg *config.Config) {
+	if cfg.StorageEncryptionKeyFile != "" {
+		logging.Get().Warnf("storage_encryption_key_file encrypts stored messages only; sender, recipients, subject and Message-ID stay in plaintext in the index at %s", SQLitePath(cfg))
+	}
+}
+
+// New creates the configured storage backend, wrapped in a ConnectionPool
+// when max_connections or max_idle_conns is set
+func New(cfg *config.Config) (Storage, error) {
+	codec, err := CodecFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// database/sql already pools connections, so SQLite takes the limits
+	// directly; the same goes for the S3 backend's metadata index
+	if cfg.StorageBackend == BackendSQLite || cfg.StorageBackend == BackendS3 {
+		store, err := open(cfg, codec)
+		if err != nil {
+			return nil, err
+		}
+		store.(connectionLimiter).SetConnectionLimits(cfg.MaxConnections, cfg.MaxIdleConns)
+		return store, nil
+	}
+
+	if cfg.MaxConnections <= 0 && cfg.MaxIdleConns <= 0 {
+		return open(cfg, codec)
+	}
+
+	// Fail fast on configuration errors rather than inside the pool
+	if _, err := open(cfg, codec); err != nil {
+		return nil, err
+	}
+
+	factory := func() (Storage, error) { return open(cfg, codec) }
+	pool, err := NewConnectionPool(factory, cfg.MaxConnections, cfg.MaxIdleConns, time.Duration(cfg.HandlerTimeout)*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage pool: %w", err)
+	}
+
+	return NewPooledStorage(pool), nil
+}
+
+// CodecFor returns the at-rest encoding configured by storage_compression
+// and storage_encryption_key_file, or nil when neither is set. Without
+// storage_encryption_key_id the last key in the file encrypts new messages.
+func CodecFor(cfg *config.Config) (*Codec, error) {
+	var keys map[string][]byte
+	keyID := ""
+
+	if cfg.StorageEncryptionKeyFile != "" {
+		var order []string
+		var err error
+		keys, order, err = LoadKeyFile(cfg.StorageEncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keyID = cfg.StorageEncryptionKeyID
+		if keyID == "" {
+			keyID = order[len(order)-1]
+		}
+	}
+
+	if keys == nil && (cfg.StorageCompression == "" || cfg.StorageCompression == CompressionNone) {
+		return nil, nil
+	}
+
+	return NewCodec(cfg.StorageCompression, keyID, keys)
+}
+
+// SQLitePath returns the configured SQLite database file, defaulting to
+// gomail.db in the data directory
+func SQLitePath(cfg *config.Config) string {
+	if cfg.SQLitePath != "" {
+		return cfg.SQLitePath
+	}
+	return filepath.Join(cfg.DataDir, "gomail.db")
+}
+
+// MaildirPath returns the directory holding the per-recipient Maildirs
+func MaildirPath(cfg *config.Config) string {
+	return filepath.Join(cfg.DataDir, "maildir")
+}
+
+// S3OptionsFrom returns the object storage settings from cfg
+func S3OptionsFrom(cfg *config.Config) S3Options {
+	return S3Options{
+		Endpoint:  cfg.S3Endpoint,
+		Region:    cfg.S3Region,
+		Bucket:    cfg.S3Bucket,
+		Prefix:    cfg.S3Prefix,
+		AccessKey: cfg.S3AccessKey,
+		SecretKey: cfg.S3SecretKey,
+		PathStyle: cfg.S3PathStyle,
+	}
+}
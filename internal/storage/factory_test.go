@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		pooled bool
+	}{
+		{"default backend", config.Config{}, false},
+		{"file backend", config.Config{StorageBackend: BackendFile}, false},
+		{"pooled", config.Config{MaxConnections: 5, MaxIdleConns: 2}, true},
+		{"idle only", config.Config{MaxIdleConns: 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.DataDir = t.TempDir()
+
+			store, err := New(&cfg)
+			require.NoError(t, err)
+
+			if tt.pooled {
+				assert.IsType(t, &pooledDeliveryStore{}, store)
+			} else {
+				assert.IsType(t, &FileStorage{}, store)
+			}
+
+			_, ok := store.(DeliveryStore)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestNew_SQLite(t *testing.T) {
+	cfg := &config.Config{
+		StorageBackend: BackendSQLite,
+		DataDir:        t.TempDir(),
+		MaxConnections: 5,
+		MaxIdleConns:   2,
+	}
+
+	store, err := New(cfg)
+	require.NoError(t, err)
+	defer store.(*SQLiteStorage).Close()
+
+	// Pooling is left to database/sql rather than ConnectionPool
+	assert.IsType(t, &SQLiteStorage{}, store)
+	assert.FileExists(t, filepath.Join(cfg.DataDir, "gomail.db"))
+
+	cfg.SQLitePath = filepath.Join(t.TempDir(), "mail", "custom.db")
+	assert.Equal(t, cfg.SQLitePath, SQLitePath(cfg))
+}
+
+func TestNew_UnknownBackend(t *testing.T) {
+	_, err := New(&config.Config{StorageBackend: "tape", DataDir: t.TempDir()})
+	assert.Error(t, err)
+
+	_, err = New(&config.Config{StorageBackend: "tape", DataDir: t.TempDir(), MaxConnections: 5})
+	assert.Error(t, err)
+}
@@ -1,27 +1,49 @@
 package storage
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/grumpyguvner/gomail/internal/mail"
 )
 
+// FileStorage implements DeliveryStore and Rekeyer
+var (
+	_ DeliveryStore = (*FileStorage)(nil)
+	_ Rekeyer       = (*FileStorage)(nil)
+)
+
+// folders lists every folder a message can live in
+var folders = []string{FolderInbox, FolderProcessed, FolderDeadLetter}
+
+// MessageInfo locates a stored message without loading it
+type MessageInfo struct {
+	ID       string
+	Path     string
+	Folder   string
+	StoredAt time.Time
+}
+
+// FileStorage stores each message as JSON under
+// <folder>/YYYY/MM/DD/<id>.json, where the date comes from the message ID.
+// With a Codec set the files are compressed and/or encrypted.
 type FileStorage struct {
 	baseDir string
+	codec   *Codec
 }
 
 func NewFileStorage(baseDir string) (*FileStorage, error) {
 	// Ensure base directories exist
-	dirs := []string{
-		baseDir,
-		filepath.Join(baseDir, "inbox"),
-		filepath.Join(baseDir, "processed"),
+	dirs := []string{baseDir}
+	for _, folder := range folders {
+		dirs = append(dirs, filepath.Join(baseDir, folder))
 	}
 
 	for _, dir := range dirs {
@@ -35,47 +57,243 @@ func NewFileStorage(baseDir string) (*FileStorage, error) {
 	}, nil
 }
 
-func (fs *FileStorage) Store(email *mail.EmailData) (string, error) {
-	// Create date-based directory structure
-	now := time.Now()
-	year := now.Format("2006")
-	month := now.Format("01")
-	day := now.Format("02")
+// SetCodec sets how messages are encoded at rest. Existing messages stay
+// readable whatever they were written with, as long as their key is known.
+func (fs *FileStorage) SetCodec(codec *Codec) {
+	fs.codec = codec
+}
 
-	dir := filepath.Join(fs.baseDir, "inbox", year, month, day)
+// Store writes a new message to the inbox and returns its path
+func (fs *FileStorage) Store(ctx context.Context, emailID string, data []byte) (string, error) {
+	storedAt, ok := MessageTime(emailID)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	data, err := fs.codec.Encode(emailID, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	// Create date-based directory structure
+	dir := filepath.Join(fs.baseDir, FolderInbox, storedAt.Format("2006"), storedAt.Format("01"), storedAt.Format("02"))
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	// Generate unique filename
-	randomBytes := make([]byte, 4)
-	if _, err := rand.Read(randomBytes); err != nil {
-		return "", fmt.Errorf("failed to generate random ID: %w", err)
+	fullPath := filepath.Join(dir, emailID+".json")
+	if err := writeAtomic(fullPath, data); err != nil {
+		return "", err
+	}
+
+	return fullPath, nil
+}
+
+// Retrieve returns the stored JSON for a message in any folder
+func (fs *FileStorage) Retrieve(ctx context.Context, emailID string) ([]byte, error) {
+	info, err := fs.Find(emailID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(info.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Moved between Find and ReadFile
+			return nil, fmt.Errorf("message %s: %w", emailID, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	filename := fmt.Sprintf("msg_%d_%s.json", now.Unix(), hex.EncodeToString(randomBytes))
-	fullPath := filepath.Join(dir, filename)
+	return fs.codec.Decode(emailID, data)
+}
 
-	// Marshal email data to JSON
-	data, err := json.MarshalIndent(email, "", "  ")
+// List returns the IDs of all stored messages across every folder, oldest first
+func (fs *FileStorage) List(ctx context.Context) ([]string, error) {
+	var ids []string
+	for _, folder := range folders {
+		messages, err := fs.scan(folder)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range messages {
+			ids = append(ids, info.ID)
+		}
+	}
+
+	// msg_<unix>_ IDs sort chronologically
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Delete permanently deletes a message from whichever folder holds it
+func (fs *FileStorage) Delete(ctx context.Context, emailID string) error {
+	info, err := fs.Find(emailID)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal email data: %w", err)
+		return err
 	}
 
-	// Write to file
-	if err := os.WriteFile(fullPath, data, 0644); err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+	if err := fs.Remove(info.Path); err != nil {
+		return err
 	}
+	if info.Folder == FolderDeadLetter {
+		_ = os.Remove(deadLetterMetaPath(info.Path))
+	}
+	_ = os.Remove(deliveryStatePath(info.Path))
 
-	return fullPath, nil
+	return nil
 }
 
-func (fs *FileStorage) List(date time.Time) ([]string, error) {
+// Status returns the folder currently holding a message
+func (fs *FileStorage) Status(ctx context.Context, emailID string) (string, error) {
+	info, err := fs.Find(emailID)
+	if err != nil {
+		return "", err
+	}
+	return info.Folder, nil
+}
+
+// Pending returns the IDs of all messages still waiting in the inbox, oldest first
+func (fs *FileStorage) Pending(ctx context.Context) ([]string, error) {
+	messages, err := fs.scan(FolderInbox)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, info := range messages {
+		ids = append(ids, info.ID)
+	}
+	return ids, nil
+}
+
+// MarkProcessed moves a message from the inbox to the processed tree,
+// keeping its date-based path
+func (fs *FileStorage) MarkProcessed(ctx context.Context, emailID string) error {
+	_, err := fs.moveFolder(emailID, FolderInbox, FolderProcessed)
+	return err
+}
+
+// MarkDeadLetter moves an inbox message to the dead-letter tree and records
+// why delivery failed in a .meta file alongside it
+func (fs *FileStorage) MarkDeadLetter(ctx context.Context, emailID string, info DeadLetter) error {
+	destination, err := fs.moveFolder(emailID, FolderInbox, FolderDeadLetter)
+	if err != nil {
+		return err
+	}
+
+	info.ID = emailID
+	info.Path = destination
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead-letter metadata: %w", err)
+	}
+	if err := os.WriteFile(deadLetterMetaPath(destination), data, 0644); err != nil {
+		return fmt.Errorf("failed to write dead-letter metadata: %w", err)
+	}
+
+	return nil
+}
+
+// ListDeadLetters returns all dead-lettered messages, oldest first
+func (fs *FileStorage) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	messages, err := fs.scan(FolderDeadLetter)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]DeadLetter, 0, len(messages))
+	for _, info := range messages {
+		letter := DeadLetter{ID: info.ID}
+		// Metadata is best effort; a message without it is still listed
+		if data, err := os.ReadFile(deadLetterMetaPath(info.Path)); err == nil {
+			_ = json.Unmarshal(data, &letter)
+		}
+		letter.Path = info.Path
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+// ReplayDeadLetter moves a dead-lettered message back to the inbox so the
+// dispatcher picks it up again
+func (fs *FileStorage) ReplayDeadLetter(ctx context.Context, emailID string) error {
+	source, err := fs.findIn(FolderDeadLetter, emailID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fs.moveFolder(emailID, FolderDeadLetter, FolderInbox); err != nil {
+		return err
+	}
+	_ = os.Remove(deadLetterMetaPath(source))
+
+	return nil
+}
+
+// DeliveryState returns the retry state saved for an inbox message, or a
+// zero state if none has been saved
+func (fs *FileStorage) DeliveryState(ctx context.Context, emailID string) (DeliveryState, error) {
+	path, err := fs.findIn(FolderInbox, emailID)
+	if err != nil {
+		return DeliveryState{}, err
+	}
+
+	var state DeliveryState
+	data, err := os.ReadFile(deliveryStatePath(path))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read delivery state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return DeliveryState{}, fmt.Errorf("failed to decode delivery state: %w", err)
+	}
+
+	return state, nil
+}
+
+// SaveDeliveryState records the retry state of an inbox message in a .state
+// file alongside it
+func (fs *FileStorage) SaveDeliveryState(ctx context.Context, emailID string, state DeliveryState) error {
+	path, err := fs.findIn(FolderInbox, emailID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery state: %w", err)
+	}
+	return writeAtomic(deliveryStatePath(path), data)
+}
+
+// Find resolves a message ID to its location in any folder
+func (fs *FileStorage) Find(id string) (MessageInfo, error) {
+	for _, folder := range folders {
+		path, err := fs.findIn(folder, id)
+		if err == nil {
+			info, _ := messageInfo(path, folder)
+			return info, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return MessageInfo{}, err
+		}
+	}
+
+	return MessageInfo{}, fmt.Errorf("message %s: %w", id, ErrNotFound)
+}
+
+// ListDate returns the paths of inbox messages stored on the given date
+func (fs *FileStorage) ListDate(date time.Time) ([]string, error) {
 	year := date.Format("2006")
 	month := date.Format("01")
 	day := date.Format("02")
 
-	dir := filepath.Join(fs.baseDir, "inbox", year, month, day)
+	dir := filepath.Join(fs.baseDir, FolderInbox, year, month, day)
 
 	entries, err := os.ReadDir(dir)
 	if err != nil {
@@ -95,12 +313,18 @@ func (fs *FileStorage) List(date time.Time) ([]string, error) {
 	return files, nil
 }
 
+// Load reads and decodes the message at path
 func (fs *FileStorage) Load(path string) (*mail.EmailData, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
+	data, err = fs.codec.Decode(strings.TrimSuffix(filepath.Base(path), ".json"), data)
+	if err != nil {
+		return nil, err
+	}
+
 	var email mail.EmailData
 	if err := json.Unmarshal(data, &email); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal email data: %w", err)
@@ -124,9 +348,154 @@ func (fs *FileStorage) Move(source, destination string) error {
 	return nil
 }
 
-func (fs *FileStorage) Delete(path string) error {
+// Remove deletes the file at path
+func (fs *FileStorage) Remove(path string) error {
 	if err := os.Remove(path); err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
 	return nil
 }
+
+// Rekey rewrites every message not encoded with the current codec
+func (fs *FileStorage) Rekey(ctx context.Context) (RekeyResult, error) {
+	var result RekeyResult
+
+	for _, folder := range folders {
+		messages, err := fs.scan(folder)
+		if err != nil {
+			return result, err
+		}
+
+		for _, info := range messages {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+
+			data, err := os.ReadFile(info.Path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					// Moved or deleted since the scan
+					continue
+				}
+				return result, fmt.Errorf("failed to read %s: %w", info.ID, err)
+			}
+			if fs.codec.Current(data) {
+				result.Skipped++
+				continue
+			}
+
+			plain, err := fs.codec.Decode(info.ID, data)
+			if err != nil {
+				return result, err
+			}
+			encoded, err := fs.codec.Encode(info.ID, plain)
+			if err != nil {
+				return result, fmt.Errorf("failed to encode %s: %w", info.ID, err)
+			}
+			// Don't resurrect a message the dispatcher moved since it was read
+			if _, err := os.Stat(info.Path); os.IsNotExist(err) {
+				continue
+			}
+			if err := writeAtomic(info.Path, encoded); err != nil {
+				return result, err
+			}
+			result.Rewritten++
+		}
+	}
+
+	return result, nil
+}
+
+// moveFolder moves a message between folders, keeping its date-based
+// path, and returns the new location
+func (fs *FileStorage) moveFolder(id, from, to string) (string, error) {
+	source, err := fs.findIn(from, id)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(filepath.Join(fs.baseDir, from), source)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", id, err)
+	}
+
+	destination := filepath.Join(fs.baseDir, to, rel)
+	if err := fs.Move(source, destination); err != nil {
+		return "", err
+	}
+	// Retry state only applies while a message waits in the inbox
+	_ = os.Remove(deliveryStatePath(source))
+
+	return destination, nil
+}
+
+// scan returns every message in a folder, oldest first
+func (fs *FileStorage) scan(folder string) ([]MessageInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(fs.baseDir, folder, "*", "*", "*", "msg_*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", folder, err)
+	}
+
+	messages := make([]MessageInfo, 0, len(matches))
+	for _, path := range matches {
+		if info, ok := messageInfo(path, folder); ok {
+			messages = append(messages, info)
+		}
+	}
+
+	// Date directories and msg_<unix>_ IDs sort chronologically
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Path < messages[j].Path })
+	return messages, nil
+}
+
+// findIn resolves a message ID to its path within a single folder
+func (fs *FileStorage) findIn(folder, id string) (string, error) {
+	if !ValidMessageID(id) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidMessageID, id)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(fs.baseDir, folder, "*", "*", "*", id+".json"))
+	if err != nil {
+		return "", fmt.Errorf("failed to search %s: %w", folder, err)
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("message %s not in %s: %w", id, folder, ErrNotFound)
+	}
+
+	return matches[0], nil
+}
+
+// messageInfo derives the ID and storage time from a message path
+func messageInfo(path, folder string) (MessageInfo, bool) {
+	id := strings.TrimSuffix(filepath.Base(path), ".json")
+	storedAt, ok := MessageTime(id)
+	if !ok {
+		return MessageInfo{}, false
+	}
+
+	return MessageInfo{ID: id, Path: path, Folder: folder, StoredAt: storedAt}, true
+}
+
+// deadLetterMetaPath returns the metadata file path for a dead-lettered message
+func deadLetterMetaPath(path string) string {
+	return strings.TrimSuffix(path, ".json") + ".meta"
+}
+
+// deliveryStatePath returns the retry state file path for an inbox message
+func deliveryStatePath(path string) string {
+	return strings.TrimSuffix(path, ".json") + ".state"
+}
+
+// writeAtomic writes data to a temporary file first and renames it into
+// place, so readers never see a partial message
+func writeAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -25,6 +27,7 @@ func TestNewFileStorage(t *testing.T) {
 	assert.DirExists(t, baseDir)
 	assert.DirExists(t, filepath.Join(baseDir, "inbox"))
 	assert.DirExists(t, filepath.Join(baseDir, "processed"))
+	assert.DirExists(t, filepath.Join(baseDir, "deadletter"))
 }
 
 func TestNewFileStorage_CreateDirectoryError(t *testing.T) {
@@ -47,8 +50,7 @@ func TestFileStorage_Store(t *testing.T) {
 		ReceivedAt: time.Now(),
 	}
 
-	path, err := storage.Store(email)
-	require.NoError(t, err)
+	path := storeEmail(t, storage, email)
 	assert.NotEmpty(t, path)
 	assert.True(t, strings.HasPrefix(path, baseDir))
 	assert.True(t, strings.HasSuffix(path, ".json"))
@@ -76,8 +78,7 @@ func TestFileStorage_Store_DateBasedDirectory(t *testing.T) {
 		Recipient: "dest@example.com",
 	}
 
-	path, err := storage.Store(email)
-	require.NoError(t, err)
+	path := storeEmail(t, storage, email)
 
 	// Verify date-based directory structure
 	now := time.Now()
@@ -102,14 +103,13 @@ func TestFileStorage_Store_UniqueFilenames(t *testing.T) {
 	// Store multiple emails and ensure unique filenames
 	paths := make(map[string]bool)
 	for i := 0; i < 10; i++ {
-		path, err := storage.Store(email)
-		require.NoError(t, err)
+		path := storeEmail(t, storage, email)
 		assert.False(t, paths[path], "Duplicate path generated: %s", path)
 		paths[path] = true
 	}
 }
 
-func TestFileStorage_List(t *testing.T) {
+func TestFileStorage_ListDate(t *testing.T) {
 	baseDir := t.TempDir()
 	storage, err := NewFileStorage(baseDir)
 	require.NoError(t, err)
@@ -122,13 +122,12 @@ func TestFileStorage_List(t *testing.T) {
 
 	var expectedPaths []string
 	for i := 0; i < 3; i++ {
-		path, err := storage.Store(email)
-		require.NoError(t, err)
+		path := storeEmail(t, storage, email)
 		expectedPaths = append(expectedPaths, path)
 	}
 
 	// List files for today
-	files, err := storage.List(today)
+	files, err := storage.ListDate(today)
 	require.NoError(t, err)
 	assert.Len(t, files, 3)
 
@@ -138,24 +137,24 @@ func TestFileStorage_List(t *testing.T) {
 	}
 }
 
-func TestFileStorage_List_EmptyDirectory(t *testing.T) {
+func TestFileStorage_ListDate_EmptyDirectory(t *testing.T) {
 	baseDir := t.TempDir()
 	storage, err := NewFileStorage(baseDir)
 	require.NoError(t, err)
 
 	// List files for a date with no emails
-	files, err := storage.List(time.Now().AddDate(0, 0, 1)) // Tomorrow
+	files, err := storage.ListDate(time.Now().AddDate(0, 0, 1)) // Tomorrow
 	require.NoError(t, err)
 	assert.Empty(t, files)
 }
 
-func TestFileStorage_List_NonexistentDirectory(t *testing.T) {
+func TestFileStorage_ListDate_NonexistentDirectory(t *testing.T) {
 	baseDir := t.TempDir()
 	storage, err := NewFileStorage(baseDir)
 	require.NoError(t, err)
 
 	// List files for a far future date
-	files, err := storage.List(time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC))
+	files, err := storage.ListDate(time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC))
 	require.NoError(t, err)
 	assert.Empty(t, files)
 }
@@ -178,8 +177,7 @@ func TestFileStorage_Load(t *testing.T) {
 	}
 
 	// Store the email
-	path, err := storage.Store(original)
-	require.NoError(t, err)
+	path := storeEmail(t, storage, original)
 
 	// Load it back
 	loaded, err := storage.Load(path)
@@ -226,8 +224,7 @@ func TestFileStorage_Move(t *testing.T) {
 	email := &mail.EmailData{
 		Sender: "test@example.com",
 	}
-	sourcePath, err := storage.Store(email)
-	require.NoError(t, err)
+	sourcePath := storeEmail(t, storage, email)
 
 	// Move it to processed directory
 	destPath := filepath.Join(baseDir, "processed", filepath.Base(sourcePath))
@@ -255,8 +252,7 @@ func TestFileStorage_Move_CreateDestinationDirectory(t *testing.T) {
 	email := &mail.EmailData{
 		Sender: "test@example.com",
 	}
-	sourcePath, err := storage.Store(email)
-	require.NoError(t, err)
+	sourcePath := storeEmail(t, storage, email)
 
 	// Move to a new directory that doesn't exist yet
 	destPath := filepath.Join(baseDir, "new", "directory", "structure", filepath.Base(sourcePath))
@@ -267,7 +263,7 @@ func TestFileStorage_Move_CreateDestinationDirectory(t *testing.T) {
 	assert.NoFileExists(t, sourcePath)
 }
 
-func TestFileStorage_Delete(t *testing.T) {
+func TestFileStorage_Remove(t *testing.T) {
 	baseDir := t.TempDir()
 	storage, err := NewFileStorage(baseDir)
 	require.NoError(t, err)
@@ -276,22 +272,21 @@ func TestFileStorage_Delete(t *testing.T) {
 	email := &mail.EmailData{
 		Sender: "test@example.com",
 	}
-	path, err := storage.Store(email)
-	require.NoError(t, err)
+	path := storeEmail(t, storage, email)
 	assert.FileExists(t, path)
 
 	// Delete it
-	err = storage.Delete(path)
+	err = storage.Remove(path)
 	require.NoError(t, err)
 	assert.NoFileExists(t, path)
 }
 
-func TestFileStorage_Delete_NonexistentFile(t *testing.T) {
+func TestFileStorage_Remove_NonexistentFile(t *testing.T) {
 	baseDir := t.TempDir()
 	storage, err := NewFileStorage(baseDir)
 	require.NoError(t, err)
 
-	err = storage.Delete("/nonexistent/file.json")
+	err = storage.Remove("/nonexistent/file.json")
 	assert.Error(t, err)
 }
 
@@ -310,7 +305,7 @@ func BenchmarkFileStorage_Store(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = storage.Store(email)
+		_, _, _ = StoreEmail(context.Background(), storage, email)
 	}
 }
 
@@ -327,11 +322,254 @@ func BenchmarkFileStorage_Load(b *testing.B) {
 		ReceivedAt: time.Now(),
 	}
 
-	path, err := storage.Store(email)
-	require.NoError(b, err)
+	path := storeEmail(b, storage, email)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, _ = storage.Load(path)
 	}
 }
+
+// storeEmail stores email through the Storage interface and returns its path
+func storeEmail(tb testing.TB, storage *FileStorage, email *mail.EmailData) string {
+	tb.Helper()
+
+	_, path, err := StoreEmail(context.Background(), storage, email)
+	require.NoError(tb, err)
+	return path
+}
+
+// writeMessage stores a message with a fixed ID timestamp in the given folder
+func writeMessage(t *testing.T, baseDir, folder string, storedAt time.Time, suffix string) string {
+	t.Helper()
+
+	dir := filepath.Join(baseDir, folder, storedAt.Format("2006"), storedAt.Format("01"), storedAt.Format("02"))
+	require.NoError(t, os.MkdirAll(dir, 0755))
+
+	id := "msg_" + strconv.FormatInt(storedAt.Unix(), 10) + "_" + suffix
+	data, err := json.Marshal(&mail.EmailData{Sender: "a@example.com", Recipient: "b@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, id+".json"), data, 0644))
+
+	return id
+}
+
+func TestFileStorage_StoreRetrieveDelete(t *testing.T) {
+	ctx := context.Background()
+	storage, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	id, err := NewMessageID(time.Now())
+	require.NoError(t, err)
+
+	path, err := storage.Store(ctx, id, []byte(`{"sender":"a@example.com"}`))
+	require.NoError(t, err)
+	assert.Equal(t, id+".json", filepath.Base(path))
+	assert.NoFileExists(t, path+".tmp")
+
+	data, err := storage.Retrieve(ctx, id)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"sender":"a@example.com"}`, string(data))
+
+	email, err := LoadEmail(ctx, storage, id)
+	require.NoError(t, err)
+	assert.Equal(t, "a@example.com", email.Sender)
+
+	require.NoError(t, storage.Delete(ctx, id))
+	assert.NoFileExists(t, path)
+
+	_, err = storage.Retrieve(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, storage.Delete(ctx, id), ErrNotFound)
+
+	_, err = storage.Store(ctx, "../escape", []byte("{}"))
+	assert.ErrorIs(t, err, ErrInvalidMessageID)
+}
+
+func TestFileStorage_List(t *testing.T) {
+	baseDir := t.TempDir()
+	storage, err := NewFileStorage(baseDir)
+	require.NoError(t, err)
+
+	day := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	oldest := writeMessage(t, baseDir, FolderProcessed, day.Add(-48*time.Hour), "aaaa0001")
+	middle := writeMessage(t, baseDir, FolderDeadLetter, day, "aaaa0002")
+	newest := writeMessage(t, baseDir, FolderInbox, day.Add(48*time.Hour), "aaaa0003")
+
+	// Non-message files are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "inbox", "stray.json"), []byte("{}"), 0644))
+
+	ids, err := storage.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldest, middle, newest}, ids)
+}
+
+func TestFileStorage_Pending(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	storage, err := NewFileStorage(baseDir)
+	require.NoError(t, err)
+
+	// Empty inbox
+	pending, err := storage.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Old message in an earlier date directory
+	oldID := writeMessage(t, baseDir, FolderInbox, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), "aabbccdd")
+
+	newID, _, err := StoreEmail(ctx, storage, &mail.EmailData{Sender: "test@example.com"})
+	require.NoError(t, err)
+
+	pending, err = storage.Pending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldID, newID}, pending)
+}
+
+func TestFileStorage_MarkProcessed(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	storage, err := NewFileStorage(baseDir)
+	require.NoError(t, err)
+
+	id, path, err := StoreEmail(ctx, storage, &mail.EmailData{Sender: "test@example.com"})
+	require.NoError(t, err)
+
+	require.NoError(t, storage.MarkProcessed(ctx, id))
+
+	rel, err := filepath.Rel(filepath.Join(baseDir, "inbox"), path)
+	require.NoError(t, err)
+	assert.NoFileExists(t, path)
+	assert.FileExists(t, filepath.Join(baseDir, "processed", rel))
+
+	status, err := storage.Status(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, FolderProcessed, status)
+
+	// Only inbox messages can be marked processed
+	assert.ErrorIs(t, storage.MarkProcessed(ctx, id), ErrNotFound)
+}
+
+func TestFileStorage_DeliveryState(t *testing.T) {
+	ctx := context.Background()
+	storage, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	id, path, err := StoreEmail(ctx, storage, &mail.EmailData{Sender: "test@example.com"})
+	require.NoError(t, err)
+
+	state, err := storage.DeliveryState(ctx, id)
+	require.NoError(t, err)
+	assert.Zero(t, state)
+
+	saved := DeliveryState{Attempts: 2, NextAttempt: time.Now().UTC(), Delivered: []string{"billing"}}
+	require.NoError(t, storage.SaveDeliveryState(ctx, id, saved))
+	assert.FileExists(t, strings.TrimSuffix(path, ".json")+".state")
+
+	state, err = storage.DeliveryState(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, saved.Attempts, state.Attempts)
+	assert.True(t, saved.NextAttempt.Equal(state.NextAttempt))
+	assert.Equal(t, saved.Delivered, state.Delivered)
+
+	// The state file does not outlive the message's time in the inbox
+	require.NoError(t, storage.MarkProcessed(ctx, id))
+	assert.NoFileExists(t, strings.TrimSuffix(path, ".json")+".state")
+	_, err = storage.DeliveryState(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, storage.SaveDeliveryState(ctx, id, saved), ErrNotFound)
+}
+
+func TestFileStorage_DeadLetterLifecycle(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	storage, err := NewFileStorage(baseDir)
+	require.NoError(t, err)
+
+	id, path, err := StoreEmail(ctx, storage, &mail.EmailData{Sender: "test@example.com"})
+	require.NoError(t, err)
+
+	failedAt := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, storage.MarkDeadLetter(ctx, id, DeadLetter{
+		LastError:  "webhook returned HTTP 503",
+		HTTPStatus: 503,
+		Attempts:   5,
+		FailedAt:   failedAt,
+	}))
+
+	rel, err := filepath.Rel(filepath.Join(baseDir, "inbox"), path)
+	require.NoError(t, err)
+	dead := filepath.Join(baseDir, "deadletter", rel)
+	assert.NoFileExists(t, path)
+	assert.FileExists(t, dead)
+	assert.FileExists(t, strings.TrimSuffix(dead, ".json")+".meta")
+
+	// Dead letters are no longer pending
+	pending, err := storage.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	letters, err := storage.ListDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, id, letters[0].ID)
+	assert.Equal(t, dead, letters[0].Path)
+	assert.Equal(t, "webhook returned HTTP 503", letters[0].LastError)
+	assert.Equal(t, 503, letters[0].HTTPStatus)
+	assert.Equal(t, 5, letters[0].Attempts)
+	assert.True(t, failedAt.Equal(letters[0].FailedAt))
+
+	// Replay puts it back in the inbox at the same date path
+	require.NoError(t, storage.ReplayDeadLetter(ctx, id))
+	assert.FileExists(t, path)
+	assert.NoFileExists(t, strings.TrimSuffix(dead, ".json")+".meta")
+
+	letters, err = storage.ListDeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+
+	// Dead-letter it again and delete it along with its metadata
+	require.NoError(t, storage.MarkDeadLetter(ctx, id, DeadLetter{LastError: "timeout"}))
+	require.NoError(t, storage.Delete(ctx, id))
+	assert.NoFileExists(t, dead)
+	assert.NoFileExists(t, strings.TrimSuffix(dead, ".json")+".meta")
+
+	assert.ErrorIs(t, storage.ReplayDeadLetter(ctx, id), ErrNotFound)
+}
+
+func TestFileStorage_InvalidID(t *testing.T) {
+	ctx := context.Background()
+	storage, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	for _, id := range []string{"", "../../etc/passwd", "msg_1_zz", "msg_1_ab/../x"} {
+		_, err := storage.Retrieve(ctx, id)
+		assert.ErrorIs(t, err, ErrInvalidMessageID, id)
+		assert.ErrorIs(t, storage.Delete(ctx, id), ErrInvalidMessageID, id)
+		assert.ErrorIs(t, storage.ReplayDeadLetter(ctx, id), ErrInvalidMessageID, id)
+	}
+}
+
+func TestFileStorage_Find(t *testing.T) {
+	ctx := context.Background()
+	storage, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	id, path, err := StoreEmail(ctx, storage, &mail.EmailData{Sender: "a@example.com"})
+	require.NoError(t, err)
+
+	info, err := storage.Find(id)
+	require.NoError(t, err)
+	assert.Equal(t, path, info.Path)
+	assert.Equal(t, FolderInbox, info.Folder)
+
+	// IDs stay stable as messages move between folders
+	require.NoError(t, storage.MarkProcessed(ctx, id))
+	info, err = storage.Find(id)
+	require.NoError(t, err)
+	assert.Equal(t, FolderProcessed, info.Folder)
+
+	stored, ok := MessageTime(id)
+	require.True(t, ok)
+	assert.True(t, stored.Equal(info.StoredAt))
+}
@@ -2,6 +2,15 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when no message exists with the given ID
+	ErrNotFound = errors.New("message not found")
+	// ErrInvalidMessageID is returned when a message ID is not one NewMessageID could have generated
+	ErrInvalidMessageID = errors.New("invalid message ID")
 )
 
 // Storage defines the interface for email storage operations
@@ -11,3 +20,92 @@ type Storage interface {
 	List(ctx context.Context) ([]string, error)
 	Delete(ctx context.Context, emailID string) error
 }
+
+// Message delivery states reported by DeliveryStore.Status. Messages start
+// in the inbox and move to processed once delivered, or to deadletter when
+// delivery is given up on.
+const (
+	FolderInbox      = "inbox"
+	FolderProcessed  = "processed"
+	FolderDeadLetter = "deadletter"
+)
+
+// DeliveryStore is implemented by backends that track webhook delivery state
+type DeliveryStore interface {
+	Storage
+
+	// Status returns the folder currently holding a message
+	Status(ctx context.Context, emailID string) (string, error)
+	// Pending returns the IDs of messages awaiting delivery, oldest first
+	Pending(ctx context.Context) ([]string, error)
+	// MarkProcessed records a successful delivery
+	MarkProcessed(ctx context.Context, emailID string) error
+	// MarkDeadLetter records that delivery was given up on
+	MarkDeadLetter(ctx context.Context, emailID string, info DeadLetter) error
+	// ListDeadLetters returns all dead-lettered messages, oldest first
+	ListDeadLetters(ctx context.Context) ([]DeadLetter, error)
+	// ReplayDeadLetter returns a dead-lettered message to the inbox
+	ReplayDeadLetter(ctx context.Context, emailID string) error
+	// DeliveryState returns the retry state saved for an inbox message, or
+	// a zero state if none has been saved
+	DeliveryState(ctx context.Context, emailID string) (DeliveryState, error)
+	// SaveDeliveryState records the retry state of an inbox message. It is
+	// dropped when the message leaves the inbox.
+	SaveDeliveryState(ctx context.Context, emailID string, state DeliveryState) error
+}
+
+// DeliveryState is the webhook retry state of a message awaiting delivery,
+// kept with the message so a restart neither resends it to targets that
+// accepted it nor gives it a fresh set of retries
+type DeliveryState struct {
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Delivered   []string  `json:"delivered,omitempty"` // names of targets that accepted it
+}
+
+// DeadLetter describes a message that exhausted its webhook delivery attempts
+type DeadLetter struct {
+	ID         string    `json:"id"`
+	Path       string    `json:"path,omitempty"`
+	LastError  string    `json:"last_error"`
+	HTTPStatus int       `json:"http_status,omitempty"`
+	Attempts   int       `json:"attempts"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// Searcher is implemented by backends that index message metadata and can
+// filter without loading every message
+type Searcher interface {
+	// Search returns one page of matching messages, newest first, and the
+	// total number of matches
+	Search(ctx context.Context, query MessageQuery) ([]MessageSummary, int, error)
+}
+
+// MessageQuery filters a Search. Zero values match everything; Sender and
+// Recipient are case-insensitive substring matches, Recipient against any
+// envelope recipient, and Since and Until bound the storage time encoded in
+// the message ID.
+type MessageQuery struct {
+	Since     time.Time
+	Until     time.Time
+	Sender    string
+	Recipient string
+	Limit     int
+	Offset    int
+}
+
+// MessageSummary is the indexed metadata of a stored message
+type MessageSummary struct {
+	ID          string
+	Status      string
+	Sender      string
+	Recipient   string
+	Recipients  []string
+	Subject     string
+	MessageID   string
+	SPFResult   string
+	DKIMResult  string
+	DMARCResult string
+	SizeBytes   int
+	ReceivedAt  time.Time
+}
@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/mail"
+)
+
+// MaildirStorage implements Storage
+var _ Storage = (*MaildirStorage)(nil)
+
+// maildirSubdirs are the directories every Maildir must contain
+var maildirSubdirs = []string{"tmp", "new", "cur"}
+
+// maildirNamePattern matches the unique names written by MaildirStorage,
+// <unix>.R<hex>P<pid>.<host>, optionally followed by :2,<flags> once a
+// mail client has moved the message to cur
+var maildirNamePattern = regexp.MustCompile(`^([0-9]+)\.R([0-9a-f]+)P[0-9]+\.[^:]+(:.*)?$`)
+
+// Metadata headers prepended to each message and read back by Retrieve.
+// X-GoMail-Auth-Results is always written and always last, so the block
+// ends there even if the message itself starts with X-GoMail-* headers.
+const (
+	headerID             = "X-GoMail-ID"
+	headerReceivedAt     = "X-GoMail-Received-At"
+	headerSender         = "X-GoMail-Sender"
+	headerRecipient      = "X-GoMail-Recipient"
+	headerMailFrom       = "X-GoMail-Mail-From"
+	headerClientAddress  = "X-GoMail-Client-Address"
+	headerClientHostname = "X-GoMail-Client-Hostname"
+	headerClientHelo     = "X-GoMail-Client-Helo"
+	headerAuthResults    = "X-GoMail-Auth-Results"
+)
+
+// MaildirStorage writes each message as an RFC 5322 file into a Maildir
+// per recipient, <baseDir>/<recipient>/{tmp,new,cur}, so that mutt,
+// Dovecot and other Maildir tools can read them directly. GoMail's
+// metadata is kept in X-GoMail-* headers prepended to the message. A
+// message with several envelope recipients is written once and hard-linked
+// into each recipient's Maildir; X-GoMail-Recipient lists them all.
+//
+// Moving messages from new to cur is left to mail clients, so this backend
+// does not track webhook deliveries.
+type MaildirStorage struct {
+	baseDir  string
+	hostname string
+}
+
+func NewMaildirStorage(baseDir string) (*MaildirStorage, error) {
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", baseDir, err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "localhost"
+	}
+	// The Maildir spec reserves / and : in unique names
+	hostname = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+
+	return &MaildirStorage{
+		baseDir:  baseDir,
+		hostname: hostname,
+	}, nil
+}
+
+// Store writes a message to tmp and links it into the new directory of
+// every envelope recipient, returning the first recipient's path
+func (ms *MaildirStorage) Store(ctx context.Context, emailID string, data []byte) (string, error) {
+	if !ValidMessageID(emailID) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	var email mail.EmailData
+	if err := json.Unmarshal(data, &email); err != nil {
+		return "", fmt.Errorf("failed to decode email data: %w", err)
+	}
+	if email.Raw == "" {
+		return "", fmt.Errorf("message %s has no raw content to write", emailID)
+	}
+
+	var dirs []string
+	for _, folder := range maildirFolders(email.EnvelopeRecipients()) {
+		dir := filepath.Join(ms.baseDir, folder)
+		for _, sub := range maildirSubdirs {
+			if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+				return "", fmt.Errorf("failed to create maildir: %w", err)
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+
+	name := ms.uniqueName(emailID)
+	tmpPath := filepath.Join(dirs[0], "tmp", name)
+
+	// Deliver through tmp so readers never see a partial message
+	if err := writeSynced(tmpPath, maildirMessage(emailID, &email)); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	var paths []string
+	for _, dir := range dirs {
+		fullPath := filepath.Join(dir, "new", name)
+		if err := os.Link(tmpPath, fullPath); err != nil {
+			for _, path := range paths {
+				_ = os.Remove(path)
+			}
+			return "", fmt.Errorf("failed to write file: %w", err)
+		}
+		paths = append(paths, fullPath)
+	}
+
+	return paths[0], nil
+}
+
+// Retrieve reads a message from new or cur and rebuilds its JSON form
+// from the prepended headers
+func (ms *MaildirStorage) Retrieve(ctx context.Context, emailID string) ([]byte, error) {
+	path, err := ms.find(emailID)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Moved to cur or deleted between find and ReadFile
+			return nil, fmt.Errorf("message %s: %w", emailID, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	data, err := json.MarshalIndent(parseMaildirMessage(string(contents)), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal email data: %w", err)
+	}
+
+	return data, nil
+}
+
+// List returns the IDs of all stored messages across every recipient,
+// oldest first. A message linked into several Maildirs is listed once.
+func (ms *MaildirStorage) List(ctx context.Context) ([]string, error) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, sub := range []string{"new", "cur"} {
+		matches, err := filepath.Glob(filepath.Join(ms.baseDir, "*", sub, "*"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan maildirs: %w", err)
+		}
+		for _, path := range matches {
+			// Messages delivered by other tools have no GoMail ID
+			if id, ok := maildirMessageID(filepath.Base(path)); ok && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	// msg_<unix>_ IDs sort chronologically
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Delete permanently deletes a message from every Maildir that holds it
+func (ms *MaildirStorage) Delete(ctx context.Context, emailID string) error {
+	paths, err := ms.findAll(emailID)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+		removed = true
+	}
+	if !removed {
+		return fmt.Errorf("message %s: %w", emailID, ErrNotFound)
+	}
+
+	return nil
+}
+
+// uniqueName returns the Maildir unique name for a message ID. The time
+// and random parts of the ID are reused, so the ID can be recovered from
+// the file name.
+func (ms *MaildirStorage) uniqueName(emailID string) string {
+	parts := strings.Split(emailID, "_")
+	return fmt.Sprintf("%s.R%sP%d.%s", parts[1], parts[2], os.Getpid(), ms.hostname)
+}
+
+// find resolves a message ID to its path in any recipient's new or cur directory
+func (ms *MaildirStorage) find(emailID string) (string, error) {
+	paths, err := ms.findAll(emailID)
+	if err != nil {
+		return "", err
+	}
+	return paths[0], nil
+}
+
+// findAll resolves a message ID to its paths in every recipient's new or
+// cur directory
+func (ms *MaildirStorage) findAll(emailID string) ([]string, error) {
+	if !ValidMessageID(emailID) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	parts := strings.Split(emailID, "_")
+	prefix := parts[1] + ".R" + parts[2] + "P"
+
+	var paths []string
+	for _, sub := range []string{"new", "cur"} {
+		matches, err := filepath.Glob(filepath.Join(ms.baseDir, "*", sub, prefix+"*"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to search maildirs: %w", err)
+		}
+		for _, path := range matches {
+			if id, ok := maildirMessageID(filepath.Base(path)); ok && id == emailID {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("message %s: %w", emailID, ErrNotFound)
+	}
+	return paths, nil
+}
+
+// maildirMessageID recovers the message ID from a unique name
+func maildirMessageID(name string) (string, bool) {
+	match := maildirNamePattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+	return "msg_" + match[1] + "_" + match[2], true
+}
+
+// maildirFolder returns the directory name for a recipient
+func maildirFolder(recipient string) string {
+	folder := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(recipient)))
+
+	if folder == "" || folder == "." || folder == ".." {
+		return "unknown"
+	}
+	return folder
+}
+
+// maildirFolders returns the distinct directory names for a message's
+// recipients, in order
+func maildirFolders(recipients []string) []string {
+	var folders []string
+	seen := map[string]bool{}
+	for _, recipient := range recipients {
+		folder := maildirFolder(recipient)
+		if !seen[folder] {
+			seen[folder] = true
+			folders = append(folders, folder)
+		}
+	}
+	if len(folders) == 0 {
+		folders = append(folders, maildirFolder(""))
+	}
+	return folders
+}
+
+// maildirMessage prepends GoMail's metadata headers to the raw message,
+// using the message's own line endings
+func maildirMessage(emailID string, email *mail.EmailData) []byte {
+	newline := "\n"
+	if strings.Contains(email.Raw, "\r\n") {
+		newline = "\r\n"
+	}
+
+	var b strings.Builder
+	writeHeader := func(name, value string) {
+		// Values come from SMTP clients, so never let them start a new header
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			b.WriteString(name + ": " + value + newline)
+		}
+	}
+
+	writeHeader(headerID, emailID)
+	if !email.ReceivedAt.IsZero() {
+		writeHeader(headerReceivedAt, email.ReceivedAt.Format(time.RFC3339Nano))
+	}
+	writeHeader(headerSender, email.Sender)
+	writeHeader(headerRecipient, strings.Join(email.EnvelopeRecipients(), ", "))
+	writeHeader(headerMailFrom, email.Authentication.SPF.MailFrom)
+	writeHeader(headerClientAddress, email.Connection.ClientAddress)
+	writeHeader(headerClientHostname, email.Connection.ClientHostname)
+	writeHeader(headerClientHelo, email.Connection.ClientHelo)
+
+	results := email.Authentication.Results
+	if strings.TrimSpace(results) == "" {
+		results = "none"
+	}
+	writeHeader(headerAuthResults, results)
+
+	b.WriteString(email.Raw)
+	return []byte(b.String())
+}
+
+// parseMaildirMessage strips the X-GoMail-* headers from a stored message
+// and re-parses the original, the same way the inbound handler does
+func parseMaildirMessage(contents string) *mail.EmailData {
+	metadata := map[string]string{}
+	reader := bufio.NewReader(strings.NewReader(contents))
+	raw := contents
+	for {
+		line, err := reader.ReadString('\n')
+		name, value, ok := strings.Cut(strings.TrimRight(line, "\r\n"), ":")
+		if !ok || !strings.HasPrefix(name, "X-GoMail-") {
+			break
+		}
+		// Only the first of each header is GoMail's own
+		if _, seen := metadata[name]; !seen {
+			metadata[name] = strings.TrimSpace(value)
+		}
+		raw = raw[len(line):]
+		if err != nil || name == headerAuthResults {
+			break
+		}
+	}
+
+	connection := map[string]string{
+		"X-Original-Sender":          metadata[headerSender],
+		"X-Original-Recipient":       metadata[headerRecipient],
+		"X-Original-Mail-From":       metadata[headerMailFrom],
+		"X-Original-Client-Address":  metadata[headerClientAddress],
+		"X-Original-Client-Hostname": metadata[headerClientHostname],
+		"X-Original-Helo":            metadata[headerClientHelo],
+	}
+
+	email, err := mail.ParseRawEmail(raw, connection)
+	if err != nil {
+		// Keep what the headers recorded even if the message itself is malformed
+		email = &mail.EmailData{
+			Sender: metadata[headerSender],
+			Raw:    raw,
+			Connection: mail.ConnectionInfo{
+				ClientAddress:  metadata[headerClientAddress],
+				ClientHostname: metadata[headerClientHostname],
+				ClientHelo:     metadata[headerClientHelo],
+			},
+		}
+		email.SetRecipients(mail.SplitRecipients(metadata[headerRecipient]))
+	}
+
+	// The results GoMail computed, not the message's own headers
+	if results := metadata[headerAuthResults]; results != "" && results != "none" {
+		email.Authentication.Results = results
+		email.Authentication.DMARC.AuthenticationResults = results
+	}
+
+	email.ReceivedAt = time.Time{}
+	if receivedAt, err := time.Parse(time.RFC3339Nano, metadata[headerReceivedAt]); err == nil {
+		email.ReceivedAt = receivedAt
+	}
+
+	return email
+}
+
+// writeSynced writes data to a new file and flushes it to disk, as the
+// Maildir spec requires before the rename into new
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
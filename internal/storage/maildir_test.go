@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const maildirTestRaw = "From: Alice <alice@example.com>\n" +
+	"To: bob@example.com\n" +
+	"Subject: Maildir test\n" +
+	"Message-ID: <test@example.com>\n" +
+	"Authentication-Results: mx.example.com; spf=pass smtp.mailfrom=example.com; dkim=pass header.d=example.com; dmarc=pass\n" +
+	"\n" +
+	"Hello Bob\n"
+
+func maildirTestEmail() *mail.EmailData {
+	email, _ := mail.ParseRawEmail(maildirTestRaw, map[string]string{
+		"X-Original-Recipient":      "Bob@Example.com",
+		"X-Original-Mail-From":      "bounces@example.com",
+		"X-Original-Client-Address": "192.0.2.1",
+		"X-Original-Helo":           "mail.example.com",
+	})
+	email.ReceivedAt = time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+	email.Authentication.Results = "gomail.example.com; spf=pass smtp.mailfrom=example.com; dkim=pass header.d=example.com; dmarc=pass header.from=example.com"
+	email.Authentication.DMARC.AuthenticationResults = email.Authentication.Results
+	return email
+}
+
+func TestMaildirStorage_Store(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	store, err := NewMaildirStorage(baseDir)
+	require.NoError(t, err)
+
+	id, path, err := StoreEmail(ctx, store, maildirTestEmail())
+	require.NoError(t, err)
+
+	// Delivered to new in the recipient's Maildir
+	assert.Equal(t, filepath.Join(baseDir, "bob@example.com", "new"), filepath.Dir(path))
+	for _, sub := range maildirSubdirs {
+		assert.DirExists(t, filepath.Join(baseDir, "bob@example.com", sub))
+	}
+	tmp, err := os.ReadDir(filepath.Join(baseDir, "bob@example.com", "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tmp)
+
+	// Unique name: <unix>.R<hex>P<pid>.<host>
+	parts := strings.Split(id, "_")
+	assert.True(t, strings.HasPrefix(filepath.Base(path), parts[1]+".R"+parts[2]+"P"))
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	message := string(contents)
+	assert.True(t, strings.HasPrefix(message, "X-GoMail-ID: "+id+"\n"))
+	assert.Contains(t, message, "X-GoMail-Recipient: Bob@Example.com\n")
+	assert.Contains(t, message, "X-GoMail-Client-Address: 192.0.2.1\n")
+	assert.Contains(t, message, "X-GoMail-Auth-Results: gomail.example.com; spf=pass smtp.mailfrom=example.com; dkim=pass header.d=example.com; dmarc=pass header.from=example.com\n")
+	assert.True(t, strings.HasSuffix(message, maildirTestRaw), "original message follows the metadata headers")
+}
+
+func TestMaildirStorage_RetrieveListDelete(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMaildirStorage(t.TempDir())
+	require.NoError(t, err)
+
+	want := maildirTestEmail()
+	id, path, err := StoreEmail(ctx, store, want)
+	require.NoError(t, err)
+
+	// Retrieve returns the same JSON StoreEmail wrote
+	data, err := store.Retrieve(ctx, id)
+	require.NoError(t, err)
+	wantJSON, err := json.Marshal(want)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(wantJSON), string(data))
+
+	// Still found once a mail client has moved it to cur and flagged it seen
+	cur := filepath.Join(filepath.Dir(filepath.Dir(path)), "cur", filepath.Base(path)+":2,S")
+	require.NoError(t, os.Rename(path, cur))
+
+	got, err := LoadEmail(ctx, store, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Maildir test", got.Subject)
+
+	// Messages delivered by other tools are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(cur), "1700000000.M1P2.otherhost:2,"), []byte(maildirTestRaw), 0644))
+
+	ids, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, ids)
+
+	require.NoError(t, store.Delete(ctx, id))
+	assert.NoFileExists(t, cur)
+
+	_, err = store.Retrieve(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = store.Retrieve(ctx, "../../etc/passwd")
+	assert.ErrorIs(t, err, ErrInvalidMessageID)
+}
+
+func TestMaildirStorage_MultipleRecipients(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	store, err := NewMaildirStorage(baseDir)
+	require.NoError(t, err)
+
+	email := maildirTestEmail()
+	email.SetRecipients([]string{"Bob@Example.com", "carol@example.com"})
+
+	id, path, err := StoreEmail(ctx, store, email)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(baseDir, "bob@example.com", "new"), filepath.Dir(path))
+
+	// Every recipient gets the same file in their own Maildir
+	name := filepath.Base(path)
+	carol := filepath.Join(baseDir, "carol@example.com", "new", name)
+	bobInfo, err := os.Stat(path)
+	require.NoError(t, err)
+	carolInfo, err := os.Stat(carol)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(bobInfo, carolInfo))
+
+	for _, folder := range []string{"bob@example.com", "carol@example.com"} {
+		tmp, err := os.ReadDir(filepath.Join(baseDir, folder, "tmp"))
+		require.NoError(t, err)
+		assert.Empty(t, tmp)
+	}
+
+	ids, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, ids)
+
+	// Still found after one recipient has deleted their copy
+	require.NoError(t, os.Remove(path))
+	got, err := LoadEmail(ctx, store, id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bob@Example.com", "carol@example.com"}, got.EnvelopeRecipients())
+
+	// Deleting removes every copy
+	cur := filepath.Join(baseDir, "carol@example.com", "cur", name+":2,S")
+	require.NoError(t, os.Rename(carol, cur))
+	require.NoError(t, os.Link(cur, path))
+
+	require.NoError(t, store.Delete(ctx, id))
+	assert.NoFileExists(t, path)
+	assert.NoFileExists(t, cur)
+	assert.ErrorIs(t, store.Delete(ctx, id), ErrNotFound)
+}
+
+func TestMaildirStorage_CRLF(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMaildirStorage(t.TempDir())
+	require.NoError(t, err)
+
+	raw := strings.ReplaceAll(maildirTestRaw, "\n", "\r\n")
+	email, err := mail.ParseRawEmail(raw, map[string]string{"X-Original-Sender": "alice@example.com\r\nX-Injected: yes"})
+	require.NoError(t, err)
+
+	id, path, err := StoreEmail(ctx, store, email)
+	require.NoError(t, err)
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), "X-GoMail-ID: "+id+"\r\n")
+	assert.NotContains(t, string(contents), "\r\nX-Injected")
+
+	got, err := LoadEmail(ctx, store, id)
+	require.NoError(t, err)
+	assert.Equal(t, raw, got.Raw)
+}
+
+func TestMaildirStorage_MetadataRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMaildirStorage(t.TempDir())
+	require.NoError(t, err)
+
+	// The message claims its own GoMail metadata and a passing SPF result
+	raw := "X-GoMail-Recipient: attacker@evil.example\n" +
+		"X-GoMail-Sender: evil@evil.example\n" +
+		"X-GoMail-Auth-Results: evil.example; spf=pass\n" + maildirTestRaw
+	email, err := mail.ParseRawEmail(raw, map[string]string{
+		"X-Original-Sender":    "alice@example.com",
+		"X-Original-Recipient": "bob@example.com",
+	})
+	require.NoError(t, err)
+	gomailResults := "gomail.example.com; spf=fail smtp.mailfrom=example.com; dmarc=fail header.from=example.com"
+	email.Authentication.Results = gomailResults
+	email.Authentication.DMARC.AuthenticationResults = gomailResults
+
+	id, _, err := StoreEmail(ctx, store, email)
+	require.NoError(t, err)
+
+	got, err := LoadEmail(ctx, store, id)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", got.Sender)
+	assert.Equal(t, []string{"bob@example.com"}, got.EnvelopeRecipients())
+	assert.Equal(t, gomailResults, got.Authentication.Results)
+	assert.Equal(t, gomailResults, got.Authentication.DMARC.AuthenticationResults)
+	assert.Equal(t, "fail", got.Authentication.SPFResult())
+	assert.Equal(t, raw, got.Raw)
+
+	// Without results of GoMail's own, none are read from the message
+	email.Authentication.Results = ""
+	id, _, err = StoreEmail(ctx, store, email)
+	require.NoError(t, err)
+
+	got, err = LoadEmail(ctx, store, id)
+	require.NoError(t, err)
+	assert.Empty(t, got.Authentication.Results)
+	assert.Empty(t, got.Authentication.SPFResult())
+	assert.Equal(t, []string{"bob@example.com"}, got.EnvelopeRecipients())
+	assert.Equal(t, raw, got.Raw)
+}
+
+func TestMaildirStorage_RejectsMissingRaw(t *testing.T) {
+	store, err := NewMaildirStorage(t.TempDir())
+	require.NoError(t, err)
+
+	_, _, err = StoreEmail(context.Background(), store, &mail.EmailData{Sender: "alice@example.com"})
+	assert.Error(t, err)
+}
+
+func TestMaildirFolder(t *testing.T) {
+	tests := map[string]string{
+		"Bob@Example.com":    "bob@example.com",
+		"":                   "unknown",
+		"..":                 "unknown",
+		"../../etc@evil.com": ".._.._etc@evil.com",
+		"a\nb@example.com":   "a_b@example.com",
+	}
+
+	for recipient, want := range tests {
+		assert.Equal(t, want, maildirFolder(recipient), recipient)
+	}
+}
+
+func TestNew_Maildir(t *testing.T) {
+	cfg := &config.Config{StorageBackend: BackendMaildir, DataDir: t.TempDir()}
+
+	store, err := Open(cfg)
+	require.NoError(t, err)
+
+	assert.IsType(t, &MaildirStorage{}, store)
+	assert.DirExists(t, filepath.Join(cfg.DataDir, "maildir"))
+
+	// Mail clients own new/cur, so there is no delivery state to track
+	_, ok := store.(DeliveryStore)
+	assert.False(t, ok)
+}
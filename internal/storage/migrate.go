@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MigrateResult counts the messages handled by Migrate
+type MigrateResult struct {
+	Migrated int
+	Skipped  int
+}
+
+// Migrate copies every message from src to dst, keeping message IDs.
+// Messages already present in dst are skipped, so an interrupted migration
+// can be re-run. When both backends track delivery, processed and
+// dead-lettered messages keep their state.
+func Migrate(ctx context.Context, src, dst Storage) (MigrateResult, error) {
+	var result MigrateResult
+
+	ids, err := src.List(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to list source messages: %w", err)
+	}
+
+	srcQueue, srcTracks := src.(DeliveryStore)
+	dstQueue, dstTracks := dst.(DeliveryStore)
+	tracked := srcTracks && dstTracks
+
+	deadLetters := map[string]DeadLetter{}
+	if tracked {
+		letters, err := srcQueue.ListDeadLetters(ctx)
+		if err != nil {
+			return result, fmt.Errorf("failed to list source dead letters: %w", err)
+		}
+		for _, letter := range letters {
+			deadLetters[letter.ID] = letter
+		}
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		if _, err := dst.Retrieve(ctx, id); err == nil {
+			result.Skipped++
+			continue
+		} else if !errors.Is(err, ErrNotFound) {
+			return result, fmt.Errorf("failed to check %s: %w", id, err)
+		}
+
+		data, err := src.Retrieve(ctx, id)
+		if err != nil {
+			return result, fmt.Errorf("failed to read %s: %w", id, err)
+		}
+		if _, err := dst.Store(ctx, id, data); err != nil {
+			return result, fmt.Errorf("failed to store %s: %w", id, err)
+		}
+
+		if tracked {
+			if err := migrateStatus(ctx, srcQueue, dstQueue, id, deadLetters); err != nil {
+				return result, err
+			}
+		}
+
+		result.Migrated++
+	}
+
+	return result, nil
+}
+
+// migrateStatus replays a message's delivery state onto dst, where it
+// starts out in the inbox
+func migrateStatus(ctx context.Context, src, dst DeliveryStore, id string, deadLetters map[string]DeadLetter) error {
+	status, err := src.Status(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to read status of %s: %w", id, err)
+	}
+
+	switch status {
+	case FolderProcessed:
+		err = dst.MarkProcessed(ctx, id)
+	case FolderDeadLetter:
+		err = dst.MarkDeadLetter(ctx, id, deadLetters[id])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set status of %s: %w", id, err)
+	}
+
+	return nil
+}
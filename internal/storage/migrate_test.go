@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate_FileToSQLite(t *testing.T) {
+	ctx := context.Background()
+
+	src, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	dst := newTestSQLiteStorage(t)
+
+	pending := storeAt(t, src, time.Now().Add(-2*time.Hour), &mail.EmailData{Sender: "a@example.com", Subject: "Pending"})
+	processed := storeAt(t, src, time.Now().Add(-time.Hour), &mail.EmailData{Sender: "b@example.com"})
+	dead := storeAt(t, src, time.Now(), &mail.EmailData{Sender: "c@example.com"})
+
+	require.NoError(t, src.MarkProcessed(ctx, processed))
+	require.NoError(t, src.MarkDeadLetter(ctx, dead, DeadLetter{LastError: "HTTP 500", Attempts: 3}))
+
+	result, err := Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateResult{Migrated: 3}, result)
+
+	email, err := LoadEmail(ctx, dst, pending)
+	require.NoError(t, err)
+	assert.Equal(t, "Pending", email.Subject)
+
+	for id, want := range map[string]string{pending: FolderInbox, processed: FolderProcessed, dead: FolderDeadLetter} {
+		status, err := dst.Status(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, want, status, id)
+	}
+
+	letters, err := dst.ListDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "HTTP 500", letters[0].LastError)
+	assert.Equal(t, 3, letters[0].Attempts)
+
+	// Re-running skips what was already copied
+	storeAt(t, src, time.Now(), &mail.EmailData{Sender: "d@example.com"})
+	result, err = Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateResult{Migrated: 1, Skipped: 3}, result)
+}
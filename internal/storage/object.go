@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ObjectStorage implements DeliveryStore, Searcher and Rekeyer
+var (
+	_ DeliveryStore = (*ObjectStorage)(nil)
+	_ Searcher      = (*ObjectStorage)(nil)
+	_ Rekeyer       = (*ObjectStorage)(nil)
+)
+
+// S3Options configures the bucket used by ObjectStorage
+type S3Options struct {
+	Endpoint  string // URL; the scheme selects TLS
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool // endpoint/bucket/key instead of bucket.endpoint/key
+}
+
+// ObjectStorage keeps each message in an S3-compatible bucket under
+// <prefix>/YYYY/MM/DD/<id>.json, the same date-sharded layout FileStorage
+// uses, and its metadata and delivery state in a local SQLite index
+type ObjectStorage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+	index  *SQLiteStorage
+}
+
+// NewObjectStorage connects to the bucket and opens (creating if needed)
+// the local metadata index at indexPath
+func NewObjectStorage(opts S3Options, indexPath string) (*ObjectStorage, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+	}
+
+	lookup := minio.BucketLookupAuto
+	if opts.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure:       endpoint.Scheme == "https",
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	// Fail at startup rather than on the first message
+	exists, err := client.BucketExists(context.Background(), opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", opts.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", opts.Bucket)
+	}
+
+	index, err := NewSQLiteStorage(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectStorage{
+		client: client,
+		bucket: opts.Bucket,
+		prefix: opts.Prefix,
+		index:  index,
+	}, nil
+}
+
+// SetConnectionLimits bounds the metadata index's connection pool
+func (s *ObjectStorage) SetConnectionLimits(maxOpen, maxIdle int) {
+	s.index.SetConnectionLimits(maxOpen, maxIdle)
+}
+
+// SetCodec sets how messages are encoded at rest, both in the bucket and
+// for any blobs left in the index
+func (s *ObjectStorage) SetCodec(codec *Codec) {
+	s.index.SetCodec(codec)
+}
+
+// Close closes the metadata index
+func (s *ObjectStorage) Close() error {
+	return s.index.Close()
+}
+
+// Store uploads a message to the bucket and indexes its metadata
+func (s *ObjectStorage) Store(ctx context.Context, emailID string, data []byte) (string, error) {
+	key, err := s.key(emailID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.put(ctx, emailID, key, data); err != nil {
+		return "", err
+	}
+
+	if err := s.index.insert(ctx, emailID, data, []byte{}); err != nil {
+		// Don't leave an object nothing refers to
+		_ = s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+		return "", err
+	}
+
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+// Retrieve downloads a message. Messages indexed before the switch from
+// the sqlite backend are still served from the local database.
+func (s *ObjectStorage) Retrieve(ctx context.Context, emailID string) ([]byte, error) {
+	local, err := s.index.blob(ctx, emailID)
+	if err != nil {
+		return nil, err
+	}
+	if len(local) > 0 {
+		return s.index.codec.Decode(emailID, local)
+	}
+
+	key, err := s.key(emailID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.get(ctx, key)
+	if err != nil {
+		return nil, objectError(emailID, err)
+	}
+
+	return s.index.codec.Decode(emailID, data)
+}
+
+// List returns the IDs of all stored messages, oldest first
+func (s *ObjectStorage) List(ctx context.Context) ([]string, error) {
+	return s.index.List(ctx)
+}
+
+// Delete removes a message from the index and the bucket
+func (s *ObjectStorage) Delete(ctx context.Context, emailID string) error {
+	key, err := s.key(emailID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.index.Delete(ctx, emailID); err != nil {
+		return err
+	}
+
+	// Removing a missing object succeeds, so this only fails on real errors
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Status returns the delivery state of a message
+func (s *ObjectStorage) Status(ctx context.Context, emailID string) (string, error) {
+	return s.index.Status(ctx, emailID)
+}
+
+// Pending returns the IDs of all messages still waiting in the inbox, oldest first
+func (s *ObjectStorage) Pending(ctx context.Context) ([]string, error) {
+	return s.index.Pending(ctx)
+}
+
+// MarkProcessed records a successful delivery
+func (s *ObjectStorage) MarkProcessed(ctx context.Context, emailID string) error {
+	return s.index.MarkProcessed(ctx, emailID)
+}
+
+// MarkDeadLetter records that delivery was given up on and why
+func (s *ObjectStorage) MarkDeadLetter(ctx context.Context, emailID string, info DeadLetter) error {
+	return s.index.MarkDeadLetter(ctx, emailID, info)
+}
+
+// ListDeadLetters returns all dead-lettered messages, oldest first
+func (s *ObjectStorage) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	return s.index.ListDeadLetters(ctx)
+}
+
+// ReplayDeadLetter returns a dead-lettered message to the inbox
+func (s *ObjectStorage) ReplayDeadLetter(ctx context.Context, emailID string) error {
+	return s.index.ReplayDeadLetter(ctx, emailID)
+}
+
+// DeliveryState returns the retry state saved for an inbox message
+func (s *ObjectStorage) DeliveryState(ctx context.Context, emailID string) (DeliveryState, error) {
+	return s.index.DeliveryState(ctx, emailID)
+}
+
+// SaveDeliveryState records the retry state of an inbox message
+func (s *ObjectStorage) SaveDeliveryState(ctx context.Context, emailID string, state DeliveryState) error {
+	return s.index.SaveDeliveryState(ctx, emailID, state)
+}
+
+// Search queries the local metadata index
+func (s *ObjectStorage) Search(ctx context.Context, query MessageQuery) ([]MessageSummary, int, error) {
+	return s.index.Search(ctx, query)
+}
+
+// Rekey rewrites every message not encoded with the current codec, in the
+// index and in the bucket
+func (s *ObjectStorage) Rekey(ctx context.Context) (RekeyResult, error) {
+	result, err := s.index.Rekey(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	ids, err := s.index.ids(ctx, `SELECT id FROM messages WHERE length(data) = 0 ORDER BY id`)
+	if err != nil {
+		return result, err
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		key, err := s.key(id)
+		if err != nil {
+			return result, err
+		}
+		data, err := s.get(ctx, key)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				continue
+			}
+			return result, objectError(id, err)
+		}
+		if s.index.codec.Current(data) {
+			result.Skipped++
+			continue
+		}
+
+		plain, err := s.index.codec.Decode(id, data)
+		if err != nil {
+			return result, err
+		}
+		if err := s.put(ctx, id, key, plain); err != nil {
+			return result, err
+		}
+		result.Rewritten++
+	}
+
+	return result, nil
+}
+
+// put encodes and uploads a message
+func (s *ObjectStorage) put(ctx context.Context, emailID, key string, data []byte) error {
+	data, err := s.index.codec.Encode(emailID, data)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	contentType := "application/json"
+	if s.index.codec.Enabled() {
+		contentType = "application/octet-stream"
+	}
+
+	_, err = s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("failed to upload message: %w", err)
+	}
+	return nil
+}
+
+// get downloads an object as stored
+func (s *ObjectStorage) get(ctx context.Context, key string) ([]byte, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	return io.ReadAll(object)
+}
+
+// key returns the object key for a message ID
+func (s *ObjectStorage) key(emailID string) (string, error) {
+	storedAt, ok := MessageTime(emailID)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	return path.Join(s.prefix, storedAt.Format("2006"), storedAt.Format("01"), storedAt.Format("02"), emailID+".json"), nil
+}
+
+// objectError maps a missing object to ErrNotFound
+func objectError(emailID string, err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("message %s missing from bucket: %w", emailID, ErrNotFound)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("failed to download message: %w", err)
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is an in-process, path-style S3 endpoint holding a single bucket
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, *httptest.Server) {
+	t.Helper()
+
+	fake := &fakeS3{bucket: bucket, objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return fake, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key == "" {
+		if _, ok := r.URL.Query()["location"]; ok {
+			_, _ = io.WriteString(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+			return
+		}
+		// HEAD bucket
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err == nil && strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data, err = decodeAWSChunked(data)
+		}
+		if err != nil {
+			f.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = data
+		w.Header().Set("ETag", `"fake"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", `"fake"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeContent(w, r, key, time.Time{}, strings.NewReader(string(data)))
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// decodeAWSChunked strips the chunk headers, signatures and trailers of a
+// streaming upload
+func decodeAWSChunked(body []byte) ([]byte, error) {
+	var data []byte
+	for {
+		header, rest, ok := strings.Cut(string(body), "\r\n")
+		if !ok {
+			return nil, io.ErrUnexpectedEOF
+		}
+		sizeHex, _, _ := strings.Cut(header, ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		if int64(len(rest)) < size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data = append(data, rest[:size]...)
+		body = []byte(strings.TrimPrefix(rest[size:], "\r\n"))
+	}
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+code+"</Message></Error>")
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func newTestObjectStorage(t *testing.T) (*ObjectStorage, *fakeS3) {
+	t.Helper()
+
+	fake, server := newFakeS3(t, "mail")
+	store, err := NewObjectStorage(S3Options{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    "mail",
+		Prefix:    "raw",
+		AccessKey: "test",
+		SecretKey: "testsecret",
+		PathStyle: true,
+	}, filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+
+	return store, fake
+}
+
+func TestNewObjectStorage(t *testing.T) {
+	_, server := newFakeS3(t, "mail")
+	indexPath := filepath.Join(t.TempDir(), "index.db")
+
+	_, err := NewObjectStorage(S3Options{Endpoint: server.URL, Bucket: "missing", PathStyle: true}, indexPath)
+	assert.Error(t, err)
+
+	_, err = NewObjectStorage(S3Options{Endpoint: "not a url", Bucket: "mail"}, indexPath)
+	assert.Error(t, err)
+}
+
+func TestObjectStorage_StoreRetrieveDelete(t *testing.T) {
+	ctx := context.Background()
+	store, fake := newTestObjectStorage(t)
+
+	id, location, err := StoreEmail(ctx, store, &mail.EmailData{Sender: "alice@example.com", Subject: "Hello"})
+	require.NoError(t, err)
+
+	storedAt, ok := MessageTime(id)
+	require.True(t, ok)
+	key := "raw/" + storedAt.Format("2006/01/02") + "/" + id + ".json"
+	assert.Equal(t, "s3://mail/"+key, location)
+	assert.Equal(t, []string{key}, fake.keys())
+
+	// Only metadata is kept locally
+	local, err := store.index.blob(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, local)
+
+	email, err := LoadEmail(ctx, store, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", email.Subject)
+
+	summaries, total, err := store.Search(ctx, MessageQuery{Sender: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, id, summaries[0].ID)
+
+	require.NoError(t, store.Delete(ctx, id))
+	assert.Empty(t, fake.keys())
+
+	_, err = store.Retrieve(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestObjectStorage_MissingObject(t *testing.T) {
+	ctx := context.Background()
+	store, fake := newTestObjectStorage(t)
+
+	id, _, err := StoreEmail(ctx, store, &mail.EmailData{Sender: "alice@example.com"})
+	require.NoError(t, err)
+
+	fake.mu.Lock()
+	fake.objects = map[string][]byte{}
+	fake.mu.Unlock()
+
+	_, err = store.Retrieve(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestObjectStorage_DeliveryLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestObjectStorage(t)
+
+	id, _, err := StoreEmail(ctx, store, &mail.EmailData{Sender: "alice@example.com"})
+	require.NoError(t, err)
+
+	pending, err := store.Pending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, pending)
+
+	require.NoError(t, store.MarkDeadLetter(ctx, id, DeadLetter{LastError: "HTTP 500", Attempts: 3}))
+	letters, err := store.ListDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+
+	require.NoError(t, store.ReplayDeadLetter(ctx, id))
+	require.NoError(t, store.MarkProcessed(ctx, id))
+
+	status, err := store.Status(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, FolderProcessed, status)
+}
+
+func TestMigrate_SQLiteToS3(t *testing.T) {
+	ctx := context.Background()
+	src := newTestSQLiteStorage(t)
+	dst, fake := newTestObjectStorage(t)
+
+	id := storeAt(t, src, time.Now(), &mail.EmailData{Sender: "alice@example.com", Subject: "Moved"})
+
+	result, err := Migrate(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateResult{Migrated: 1}, result)
+	assert.Len(t, fake.keys(), 1)
+
+	email, err := LoadEmail(ctx, dst, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Moved", email.Subject)
+}
+
+func TestNew_S3(t *testing.T) {
+	_, server := newFakeS3(t, "mail")
+	cfg := &config.Config{
+		StorageBackend: BackendS3,
+		DataDir:        t.TempDir(),
+		S3Endpoint:     server.URL,
+		S3Bucket:       "mail",
+		S3PathStyle:    true,
+		MaxConnections: 5,
+	}
+
+	store, err := New(cfg)
+	require.NoError(t, err)
+	defer store.(*ObjectStorage).Close()
+
+	assert.IsType(t, &ObjectStorage{}, store)
+	assert.FileExists(t, filepath.Join(cfg.DataDir, "gomail.db"))
+}
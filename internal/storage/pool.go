@@ -261,3 +261,169 @@ func (pc *PooledConnection) Release() {
 		pc.pool.Put(pc)
 	}
 }
+
+// PooledStorage is a Storage that borrows a pooled connection for each operation
+type PooledStorage struct {
+	pool *ConnectionPool
+}
+
+// NewPooledStorage returns a Storage backed by the pool. If the pool's
+// connections implement DeliveryStore, so does the returned Storage.
+func NewPooledStorage(pool *ConnectionPool) Storage {
+	ps := &PooledStorage{pool: pool}
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		return ps
+	}
+	defer conn.Release()
+
+	if _, ok := conn.storage.(DeliveryStore); ok {
+		return &pooledDeliveryStore{PooledStorage: ps}
+	}
+	return ps
+}
+
+// Pool returns the underlying connection pool
+func (ps *PooledStorage) Pool() *ConnectionPool {
+	return ps.pool
+}
+
+// Store stores a message using a pooled connection
+func (ps *PooledStorage) Store(ctx context.Context, emailID string, data []byte) (string, error) {
+	var location string
+	err := ps.withConn(ctx, func(s Storage) error {
+		var err error
+		location, err = s.Store(ctx, emailID, data)
+		return err
+	})
+	return location, err
+}
+
+// Retrieve retrieves a message using a pooled connection
+func (ps *PooledStorage) Retrieve(ctx context.Context, emailID string) ([]byte, error) {
+	var data []byte
+	err := ps.withConn(ctx, func(s Storage) error {
+		var err error
+		data, err = s.Retrieve(ctx, emailID)
+		return err
+	})
+	return data, err
+}
+
+// List lists messages using a pooled connection
+func (ps *PooledStorage) List(ctx context.Context) ([]string, error) {
+	var ids []string
+	err := ps.withConn(ctx, func(s Storage) error {
+		var err error
+		ids, err = s.List(ctx)
+		return err
+	})
+	return ids, err
+}
+
+// Delete deletes a message using a pooled connection
+func (ps *PooledStorage) Delete(ctx context.Context, emailID string) error {
+	return ps.withConn(ctx, func(s Storage) error {
+		return s.Delete(ctx, emailID)
+	})
+}
+
+// withConn runs fn with a connection borrowed from the pool
+func (ps *PooledStorage) withConn(ctx context.Context, fn func(Storage) error) error {
+	conn, err := ps.pool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	return fn(conn.storage)
+}
+
+// pooledDeliveryStore adds DeliveryStore methods to PooledStorage
+type pooledDeliveryStore struct {
+	*PooledStorage
+}
+
+// withDelivery runs fn with a pooled connection's DeliveryStore
+func (ps *pooledDeliveryStore) withDelivery(ctx context.Context, fn func(DeliveryStore) error) error {
+	return ps.withConn(ctx, func(s Storage) error {
+		ds, ok := s.(DeliveryStore)
+		if !ok {
+			return fmt.Errorf("storage backend does not track delivery state")
+		}
+		return fn(ds)
+	})
+}
+
+// Status returns a message's folder using a pooled connection
+func (ps *pooledDeliveryStore) Status(ctx context.Context, emailID string) (string, error) {
+	var status string
+	err := ps.withDelivery(ctx, func(ds DeliveryStore) error {
+		var err error
+		status, err = ds.Status(ctx, emailID)
+		return err
+	})
+	return status, err
+}
+
+// Pending lists pending messages using a pooled connection
+func (ps *pooledDeliveryStore) Pending(ctx context.Context) ([]string, error) {
+	var ids []string
+	err := ps.withDelivery(ctx, func(ds DeliveryStore) error {
+		var err error
+		ids, err = ds.Pending(ctx)
+		return err
+	})
+	return ids, err
+}
+
+// MarkProcessed records a delivery using a pooled connection
+func (ps *pooledDeliveryStore) MarkProcessed(ctx context.Context, emailID string) error {
+	return ps.withDelivery(ctx, func(ds DeliveryStore) error {
+		return ds.MarkProcessed(ctx, emailID)
+	})
+}
+
+// MarkDeadLetter dead-letters a message using a pooled connection
+func (ps *pooledDeliveryStore) MarkDeadLetter(ctx context.Context, emailID string, info DeadLetter) error {
+	return ps.withDelivery(ctx, func(ds DeliveryStore) error {
+		return ds.MarkDeadLetter(ctx, emailID, info)
+	})
+}
+
+// ListDeadLetters lists dead letters using a pooled connection
+func (ps *pooledDeliveryStore) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := ps.withDelivery(ctx, func(ds DeliveryStore) error {
+		var err error
+		letters, err = ds.ListDeadLetters(ctx)
+		return err
+	})
+	return letters, err
+}
+
+// ReplayDeadLetter replays a dead letter using a pooled connection
+func (ps *pooledDeliveryStore) ReplayDeadLetter(ctx context.Context, emailID string) error {
+	return ps.withDelivery(ctx, func(ds DeliveryStore) error {
+		return ds.ReplayDeadLetter(ctx, emailID)
+	})
+}
+
+// DeliveryState reads retry state using a pooled connection
+func (ps *pooledDeliveryStore) DeliveryState(ctx context.Context, emailID string) (DeliveryState, error) {
+	var state DeliveryState
+	err := ps.withDelivery(ctx, func(ds DeliveryStore) error {
+		var err error
+		state, err = ds.DeliveryState(ctx, emailID)
+		return err
+	})
+	return state, err
+}
+
+// SaveDeliveryState records retry state using a pooled connection
+func (ps *pooledDeliveryStore) SaveDeliveryState(ctx context.Context, emailID string, state DeliveryState) error {
+	return ps.withDelivery(ctx, func(ds DeliveryStore) error {
+		return ds.SaveDeliveryState(ctx, emailID, state)
+	})
+}
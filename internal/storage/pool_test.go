@@ -356,3 +356,68 @@ func TestPooledConnection_Methods(t *testing.T) {
 		assert.Contains(t, err.Error(), "delete error")
 	})
 }
+
+func TestPooledStorage(t *testing.T) {
+	factory := func() (Storage, error) {
+		return &MockStorage{
+			storeFunc: func(ctx context.Context, emailID string, data []byte) (string, error) {
+				return "stored-" + emailID, nil
+			},
+		}, nil
+	}
+
+	pool, err := NewConnectionPool(factory, 2, 1, time.Second)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	store := NewPooledStorage(pool)
+	_, isDelivery := store.(DeliveryStore)
+	assert.False(t, isDelivery, "mock backend does not track delivery state")
+
+	ctx := context.Background()
+	location, err := store.Store(ctx, "id", []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "stored-id", location)
+
+	ids, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id1", "id2"}, ids)
+
+	// Connections are returned after each operation
+	assert.Equal(t, int64(0), pool.Stats().Active)
+}
+
+func TestPooledStorage_DeliveryStore(t *testing.T) {
+	baseDir := t.TempDir()
+	factory := func() (Storage, error) { return NewFileStorage(baseDir) }
+
+	pool, err := NewConnectionPool(factory, 4, 2, time.Second)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	store, ok := NewPooledStorage(pool).(DeliveryStore)
+	require.True(t, ok, "file backend tracks delivery state")
+
+	ctx := context.Background()
+	id, err := NewMessageID(time.Now())
+	require.NoError(t, err)
+	_, err = store.Store(ctx, id, []byte("{}"))
+	require.NoError(t, err)
+
+	pending, err := store.Pending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, pending)
+
+	require.NoError(t, store.MarkDeadLetter(ctx, id, DeadLetter{LastError: "boom"}))
+	letters, err := store.ListDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "boom", letters[0].LastError)
+
+	require.NoError(t, store.ReplayDeadLetter(ctx, id))
+	require.NoError(t, store.MarkProcessed(ctx, id))
+
+	status, err := store.Status(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, FolderProcessed, status)
+}
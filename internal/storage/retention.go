@@ -0,0 +1,396 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/domainmatch"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Reasons a message is pruned
+const (
+	PruneReasonAge  = "age"
+	PruneReasonSize = "size"
+)
+
+// RetentionPolicy decides which stored messages are deleted. Zero values
+// disable a limit. MaxBytes applies to the raw message sizes, so disk usage
+// runs somewhat higher.
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	MaxBytes int64
+	Domains  []DomainRetention // first match wins
+
+	// KeepPending stops MaxBytes from deleting inbox messages that have not
+	// been delivered to a webhook yet. Age limits still apply to them.
+	KeepPending bool
+}
+
+// DomainRetention overrides MaxAge for a recipient domain. "*.example.com"
+// matches the domain itself and any subdomain; a zero MaxAge exempts the
+// domain from age-based pruning.
+type DomainRetention struct {
+	Domain string
+	MaxAge time.Duration
+}
+
+// NewRetentionPolicy builds the policy described by the retention_* settings
+func NewRetentionPolicy(cfg *config.Config) RetentionPolicy {
+	policy := RetentionPolicy{
+		MaxAge:   time.Duration(cfg.RetentionMaxAge) * 24 * time.Hour,
+		MaxBytes: int64(cfg.RetentionMaxSizeMB) * 1024 * 1024,
+		// Inbox messages only wait for delivery when a webhook is configured
+		KeepPending: cfg.WebhookURL != "" || len(cfg.WebhookRoutes) > 0,
+	}
+	for _, domain := range cfg.RetentionDomains {
+		policy.Domains = append(policy.Domains, DomainRetention{
+			Domain: strings.ToLower(strings.TrimSpace(domain.Domain)),
+			MaxAge: time.Duration(domain.MaxAge) * 24 * time.Hour,
+		})
+	}
+	return policy
+}
+
+// Enabled reports whether the policy can delete anything
+func (p RetentionPolicy) Enabled() bool {
+	if p.MaxAge > 0 || p.MaxBytes > 0 {
+		return true
+	}
+	for _, domain := range p.Domains {
+		if domain.MaxAge > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// maxAge returns the age limit for mail to the given recipients: the
+// longest of their domains' limits, with zero (no limit) beating any other
+func (p RetentionPolicy) maxAge(recipients ...string) time.Duration {
+	if len(recipients) == 0 {
+		return p.MaxAge
+	}
+
+	longest := time.Duration(0)
+	for i, recipient := range recipients {
+		limit := p.recipientMaxAge(recipient)
+		if limit == 0 {
+			return 0
+		}
+		if i == 0 || limit > longest {
+			longest = limit
+		}
+	}
+	return longest
+}
+
+// recipientMaxAge returns the age limit for mail to one recipient
+func (p RetentionPolicy) recipientMaxAge(recipient string) time.Duration {
+	domain := strings.ToLower(strings.TrimSpace(recipient))
+	if at := strings.LastIndex(domain, "@"); at >= 0 {
+		domain = strings.TrimSuffix(domain[at+1:], ">")
+	}
+
+	for _, override := range p.Domains {
+		if domainmatch.Match(override.Domain, domain) {
+			return override.MaxAge
+		}
+	}
+	return p.MaxAge
+}
+
+// PrunedMessage describes a message deleted, or due for deletion, by Prune
+type PrunedMessage struct {
+	ID        string
+	Recipient string
+	Reason    string
+	SizeBytes int64
+	StoredAt  time.Time
+}
+
+// PruneResult summarises a Prune pass
+type PruneResult struct {
+	Pruned      []PrunedMessage
+	PrunedBytes int64
+	Kept        int
+	KeptBytes   int64
+}
+
+// retainedMessage is the metadata Prune needs about each stored message
+type retainedMessage struct {
+	id         string
+	recipients []string
+	size       int64
+	storedAt   time.Time
+	pending    bool // still in the inbox of a backend that tracks delivery
+}
+
+// Prune deletes the inbox and processed messages that fall outside policy:
+// first everything older than its recipient domain's age limit, then the
+// oldest remaining messages until the total size is within MaxBytes,
+// passing over undelivered ones when KeepPending is set. Dead-lettered
+// messages are left for `gomail deadletter` to handle. With dryRun set
+// nothing is deleted, but the result lists what would be.
+func Prune(ctx context.Context, s Storage, policy RetentionPolicy, now time.Time, dryRun bool) (PruneResult, error) {
+	var result PruneResult
+
+	messages, err := retainedMessages(ctx, s)
+	if err != nil {
+		return result, err
+	}
+
+	var kept []retainedMessage
+	for _, msg := range messages {
+		if maxAge := policy.maxAge(msg.recipients...); maxAge > 0 && now.Sub(msg.storedAt) > maxAge {
+			result.Pruned = append(result.Pruned, msg.pruned(PruneReasonAge))
+			continue
+		}
+		kept = append(kept, msg)
+		result.KeptBytes += msg.size
+	}
+
+	// Messages are oldest first, so trimming from the front drops the oldest
+	if policy.MaxBytes > 0 && result.KeptBytes > policy.MaxBytes {
+		remaining := kept[:0]
+		for _, msg := range kept {
+			if result.KeptBytes > policy.MaxBytes && !(policy.KeepPending && msg.pending) {
+				result.Pruned = append(result.Pruned, msg.pruned(PruneReasonSize))
+				result.KeptBytes -= msg.size
+				continue
+			}
+			remaining = append(remaining, msg)
+		}
+		kept = remaining
+	}
+	result.Kept = len(kept)
+
+	if !dryRun {
+		for i, msg := range result.Pruned {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			} else if deleteErr := s.Delete(ctx, msg.ID); deleteErr != nil && !errors.Is(deleteErr, ErrNotFound) {
+				err = fmt.Errorf("failed to delete %s: %w", msg.ID, deleteErr)
+			}
+			if err != nil {
+				result.Pruned = result.Pruned[:i]
+				break
+			}
+		}
+	}
+
+	for _, msg := range result.Pruned {
+		result.PrunedBytes += msg.SizeBytes
+	}
+
+	return result, err
+}
+
+// retainedMessages lists every message retention applies to, oldest first.
+// Sizes are those of the raw messages, which is what the metadata index
+// records; backends without an index are read in full.
+func retainedMessages(ctx context.Context, s Storage) ([]retainedMessage, error) {
+	if searcher, ok := s.(Searcher); ok {
+		summaries, _, err := searcher.Search(ctx, MessageQuery{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list messages: %w", err)
+		}
+
+		messages := make([]retainedMessage, 0, len(summaries))
+		// Search returns newest first
+		for i := len(summaries) - 1; i >= 0; i-- {
+			summary := summaries[i]
+			storedAt, ok := MessageTime(summary.ID)
+			if !ok || summary.Status == FolderDeadLetter {
+				continue
+			}
+			recipients := summary.Recipients
+			if len(recipients) == 0 && summary.Recipient != "" {
+				recipients = []string{summary.Recipient}
+			}
+			messages = append(messages, retainedMessage{
+				id:         summary.ID,
+				recipients: recipients,
+				size:       int64(summary.SizeBytes),
+				storedAt:   storedAt,
+				pending:    summary.Status == FolderInbox,
+			})
+		}
+		return messages, nil
+	}
+
+	ids, err := s.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	queue, tracked := s.(DeliveryStore)
+
+	messages := make([]retainedMessage, 0, len(ids))
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		storedAt, ok := MessageTime(id)
+		if !ok {
+			continue
+		}
+
+		pending := false
+		if tracked {
+			status, err := queue.Status(ctx, id)
+			if err != nil {
+				// Deleted since List
+				continue
+			}
+			if status == FolderDeadLetter {
+				continue
+			}
+			pending = status == FolderInbox
+		}
+
+		data, err := s.Retrieve(ctx, id)
+		if err != nil {
+			continue
+		}
+		var envelope mail.EmailData
+		_ = json.Unmarshal(data, &envelope)
+
+		messages = append(messages, retainedMessage{
+			id:         id,
+			recipients: envelope.EnvelopeRecipients(),
+			size:       int64(len(envelope.Raw)),
+			storedAt:   storedAt,
+			pending:    pending,
+		})
+	}
+
+	return messages, nil
+}
+
+func (m retainedMessage) pruned(reason string) PrunedMessage {
+	recipient := ""
+	if len(m.recipients) > 0 {
+		recipient = m.recipients[0]
+	}
+	return PrunedMessage{
+		ID:        m.id,
+		Recipient: recipient,
+		Reason:    reason,
+		SizeBytes: m.size,
+		StoredAt:  m.storedAt,
+	}
+}
+
+// Janitor enforces a retention policy on a schedule
+type Janitor struct {
+	storage  Storage
+	blobs    *BlobStore
+	policy   RetentionPolicy
+	interval time.Duration
+	logger   *zap.SugaredLogger
+	now      func() time.Time
+}
+
+// NewJanitor creates a janitor for the retention_* settings
+func NewJanitor(cfg *config.Config, store Storage) *Janitor {
+	interval := time.Duration(cfg.RetentionInterval) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	return &Janitor{
+		storage:  store,
+		policy:   NewRetentionPolicy(cfg),
+		interval: interval,
+		logger:   logging.Get(),
+		now:      time.Now,
+	}
+}
+
+// SetBlobs makes the janitor also delete attachment blobs no stored
+// message refers to any more
+func (j *Janitor) SetBlobs(blobs *BlobStore) {
+	j.blobs = blobs
+}
+
+// Enabled reports whether the janitor has anything to delete
+func (j *Janitor) Enabled() bool {
+	return j.policy.Enabled() || j.blobs != nil
+}
+
+// Run prunes once at startup and then every interval until the context is cancelled
+func (j *Janitor) Run(ctx context.Context) {
+	j.logger.Infof("Retention janitor started: max_age=%v, max_bytes=%d, domain_overrides=%d, interval=%v",
+		j.policy.MaxAge, j.policy.MaxBytes, len(j.policy.Domains), j.interval)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.RunOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			j.logger.Info("Retention janitor stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce makes a single pruning pass and records its metrics
+func (j *Janitor) RunOnce(ctx context.Context) {
+	if j.policy.Enabled() {
+		j.prune(ctx)
+	}
+	if j.blobs != nil {
+		j.sweepBlobs(ctx)
+	}
+}
+
+func (j *Janitor) prune(ctx context.Context) {
+	result, err := Prune(ctx, j.storage, j.policy, j.now(), false)
+
+	for _, msg := range result.Pruned {
+		metrics.RetentionPurgedMessages.WithLabelValues(msg.Reason).Inc()
+		metrics.RetentionPurgedBytes.WithLabelValues(msg.Reason).Add(float64(msg.SizeBytes))
+	}
+
+	if err != nil {
+		if ctx.Err() == nil {
+			metrics.RetentionRuns.WithLabelValues("error").Inc()
+			j.logger.Errorf("Retention run failed after pruning %d message(s): %v", len(result.Pruned), err)
+		}
+		return
+	}
+
+	metrics.RetentionRuns.WithLabelValues("success").Inc()
+	metrics.RetentionStoredBytes.Set(float64(result.KeptBytes))
+	if len(result.Pruned) > 0 {
+		j.logger.Infof("Retention pruned %d message(s), %d bytes; %d message(s) kept", len(result.Pruned), result.PrunedBytes, result.Kept)
+	}
+}
+
+func (j *Janitor) sweepBlobs(ctx context.Context) {
+	removed, removedBytes, err := SweepBlobs(ctx, j.storage, j.blobs, j.now())
+
+	metrics.RetentionPurgedBlobs.Add(float64(removed))
+	if err != nil {
+		if ctx.Err() == nil {
+			metrics.RetentionRuns.WithLabelValues("error").Inc()
+			j.logger.Errorf("Attachment blob sweep failed after deleting %d blob(s): %v", removed, err)
+		}
+		return
+	}
+	if removed > 0 {
+		j.logger.Infof("Deleted %d unreferenced attachment blob(s), %d bytes", removed, removedBytes)
+	}
+}
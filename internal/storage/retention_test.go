@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const day = 24 * time.Hour
+
+func prunedIDs(result PruneResult) map[string]string {
+	ids := map[string]string{}
+	for _, msg := range result.Pruned {
+		ids[msg.ID] = msg.Reason
+	}
+	return ids
+}
+
+func TestNewRetentionPolicy(t *testing.T) {
+	policy := NewRetentionPolicy(&config.Config{
+		RetentionMaxAge:    30,
+		RetentionMaxSizeMB: 2,
+		RetentionDomains:   []config.RetentionDomain{{Domain: " *.Example.com ", MaxAge: 7}},
+	})
+
+	assert.True(t, policy.Enabled())
+	assert.Equal(t, 30*day, policy.MaxAge)
+	assert.Equal(t, int64(2*1024*1024), policy.MaxBytes)
+	assert.Equal(t, 7*day, policy.maxAge("a@mail.example.com"))
+	assert.Equal(t, 7*day, policy.maxAge("Bob <bob@EXAMPLE.com>"))
+	assert.Equal(t, 30*day, policy.maxAge("carol@example.org"))
+	assert.Equal(t, 30*day, policy.maxAge("a@mail.example.com", "carol@example.org"), "the longest limit applies")
+
+	assert.False(t, policy.KeepPending, "without a webhook nothing waits in the inbox")
+	assert.True(t, NewRetentionPolicy(&config.Config{WebhookURL: "https://app.example/hook"}).KeepPending)
+
+	assert.False(t, NewRetentionPolicy(&config.Config{}).Enabled())
+	assert.True(t, NewRetentionPolicy(&config.Config{
+		RetentionDomains: []config.RetentionDomain{{Domain: "example.com", MaxAge: 1}},
+	}).Enabled())
+}
+
+func TestPrune_Age(t *testing.T) {
+	for name, newStore := range map[string]func(t *testing.T) DeliveryStore{
+		"file": func(t *testing.T) DeliveryStore {
+			store, err := NewFileStorage(t.TempDir())
+			require.NoError(t, err)
+			return store
+		},
+		"sqlite": func(t *testing.T) DeliveryStore { return newTestSQLiteStorage(t) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+			now := time.Now()
+
+			old := storeAt(t, store, now.Add(-40*day), &mail.EmailData{Recipient: "a@example.org"})
+			oldProcessed := storeAt(t, store, now.Add(-35*day), &mail.EmailData{Recipient: "b@example.org"})
+			oldDead := storeAt(t, store, now.Add(-35*day), &mail.EmailData{Recipient: "c@example.org"})
+			exempt := storeAt(t, store, now.Add(-40*day), &mail.EmailData{Recipient: "legal@archive.example.com"})
+			short := storeAt(t, store, now.Add(-3*day), &mail.EmailData{Recipient: "alerts@example.net"})
+			recent := storeAt(t, store, now.Add(-time.Hour), &mail.EmailData{Recipient: "d@example.org"})
+			// Mail to several domains is kept as long as the longest of their limits
+			mixed := storeAt(t, store, now.Add(-3*day), &mail.EmailData{
+				Recipient:  "alerts@example.net",
+				Recipients: []string{"alerts@example.net", "e@example.org"},
+			})
+
+			require.NoError(t, store.MarkProcessed(ctx, oldProcessed))
+			require.NoError(t, store.MarkDeadLetter(ctx, oldDead, DeadLetter{LastError: "HTTP 500"}))
+
+			policy := RetentionPolicy{
+				MaxAge: 30 * day,
+				Domains: []DomainRetention{
+					{Domain: "*.example.com", MaxAge: 0},
+					{Domain: "example.net", MaxAge: 2 * day},
+				},
+			}
+
+			// A dry run reports without deleting
+			result, err := Prune(ctx, store, policy, now, true)
+			require.NoError(t, err)
+			want := map[string]string{old: PruneReasonAge, oldProcessed: PruneReasonAge, short: PruneReasonAge}
+			assert.Equal(t, want, prunedIDs(result))
+			assert.Equal(t, 3, result.Kept)
+
+			ids, err := store.List(ctx)
+			require.NoError(t, err)
+			assert.Len(t, ids, 7)
+
+			result, err = Prune(ctx, store, policy, now, false)
+			require.NoError(t, err)
+			assert.Equal(t, want, prunedIDs(result))
+
+			ids, err = store.List(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{oldDead, exempt, recent, mixed}, ids)
+		})
+	}
+}
+
+func TestPrune_Size(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	now := time.Now()
+
+	raw := string(make([]byte, 1000))
+	first := storeAt(t, store, now.Add(-3*time.Hour), &mail.EmailData{Raw: raw})
+	second := storeAt(t, store, now.Add(-2*time.Hour), &mail.EmailData{Raw: raw})
+	third := storeAt(t, store, now.Add(-time.Hour), &mail.EmailData{Raw: raw})
+
+	all, err := Prune(ctx, store, RetentionPolicy{MaxBytes: 3000}, now, true)
+	require.NoError(t, err)
+	assert.Empty(t, all.Pruned)
+	assert.Equal(t, int64(3000), all.KeptBytes)
+
+	// Room for two messages: the oldest goes
+	result, err := Prune(ctx, store, RetentionPolicy{MaxBytes: 2999}, now, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{first: PruneReasonSize}, prunedIDs(result))
+	assert.Equal(t, int64(1000), result.PrunedBytes)
+	assert.Equal(t, int64(2000), result.KeptBytes)
+
+	ids, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{second, third}, ids)
+}
+
+func TestPrune_SizeKeepsPending(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	now := time.Now()
+
+	raw := string(make([]byte, 1000))
+	undelivered := storeAt(t, store, now.Add(-3*time.Hour), &mail.EmailData{Raw: raw})
+	delivered := storeAt(t, store, now.Add(-2*time.Hour), &mail.EmailData{Raw: raw})
+	storeAt(t, store, now.Add(-time.Hour), &mail.EmailData{Raw: raw})
+	require.NoError(t, store.MarkProcessed(ctx, delivered))
+
+	// The oldest message has not reached its webhook, so the next one goes instead
+	policy := RetentionPolicy{MaxBytes: 1500, KeepPending: true}
+	result, err := Prune(ctx, store, policy, now, true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{delivered: PruneReasonSize}, prunedIDs(result))
+	assert.Equal(t, int64(2000), result.KeptBytes, "undelivered mail may leave the store over its limit")
+
+	policy.KeepPending = false
+	result, err = Prune(ctx, store, policy, now, true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{undelivered: PruneReasonSize, delivered: PruneReasonSize}, prunedIDs(result))
+}
+
+func TestJanitor_RunOnce(t *testing.T) {
+	metrics.Init()
+	ctx := context.Background()
+	store, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	janitor := NewJanitor(&config.Config{RetentionMaxAge: 1}, store)
+	assert.True(t, janitor.Enabled())
+	assert.Equal(t, time.Hour, janitor.interval)
+
+	storeAt(t, store, time.Now().Add(-2*day), &mail.EmailData{Recipient: "a@example.org", Raw: "Subject: old\n\nbye"})
+	kept := storeAt(t, store, time.Now(), &mail.EmailData{Recipient: "b@example.org", Raw: "Subject: new\n\nhi"})
+
+	messagesBefore := testutil.ToFloat64(metrics.RetentionPurgedMessages.WithLabelValues(PruneReasonAge))
+	bytesBefore := testutil.ToFloat64(metrics.RetentionPurgedBytes.WithLabelValues(PruneReasonAge))
+
+	janitor.RunOnce(ctx)
+
+	assert.Equal(t, messagesBefore+1, testutil.ToFloat64(metrics.RetentionPurgedMessages.WithLabelValues(PruneReasonAge)))
+	assert.Greater(t, testutil.ToFloat64(metrics.RetentionPurgedBytes.WithLabelValues(PruneReasonAge)), bytesBefore)
+	assert.Positive(t, testutil.ToFloat64(metrics.RetentionStoredBytes))
+
+	ids, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{kept}, ids)
+}
@@ -0,0 +1,580 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/mail"
+
+	// Pure-Go SQLite driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStorage implements DeliveryStore, Searcher and Rekeyer
+var (
+	_ DeliveryStore = (*SQLiteStorage)(nil)
+	_ Searcher      = (*SQLiteStorage)(nil)
+	_ Rekeyer       = (*SQLiteStorage)(nil)
+)
+
+// sqliteSchema creates the messages table. The stored JSON, including the
+// raw message, is kept in the data blob; everything else is metadata
+// extracted from it so that lookups do not need to decode each message.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id           TEXT PRIMARY KEY,
+	status       TEXT NOT NULL DEFAULT 'inbox',
+	stored_at    INTEGER NOT NULL,
+	received_at  INTEGER,
+	sender       TEXT NOT NULL DEFAULT '',
+	recipient    TEXT NOT NULL DEFAULT '',
+	recipients   TEXT NOT NULL DEFAULT '',
+	subject      TEXT NOT NULL DEFAULT '',
+	message_id   TEXT NOT NULL DEFAULT '',
+	spf_result   TEXT NOT NULL DEFAULT '',
+	dkim_result  TEXT NOT NULL DEFAULT '',
+	dmarc_result TEXT NOT NULL DEFAULT '',
+	size_bytes   INTEGER NOT NULL DEFAULT 0,
+	dead_letter  TEXT,
+	delivery     TEXT,
+	data         BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_status ON messages (status, id);
+CREATE INDEX IF NOT EXISTS idx_messages_stored_at ON messages (stored_at);
+CREATE INDEX IF NOT EXISTS idx_messages_received_at ON messages (received_at);
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages (sender COLLATE NOCASE);
+CREATE INDEX IF NOT EXISTS idx_messages_recipient ON messages (recipient COLLATE NOCASE);
+CREATE INDEX IF NOT EXISTS idx_messages_subject ON messages (subject COLLATE NOCASE);
+CREATE INDEX IF NOT EXISTS idx_messages_message_id ON messages (message_id);
+CREATE INDEX IF NOT EXISTS idx_messages_auth ON messages (spf_result, dkim_result, dmarc_result);
+`
+
+// summaryColumns are the columns scanned by scanSummary
+const summaryColumns = `id, status, sender, recipient, recipients, subject, message_id,
+	spf_result, dkim_result, dmarc_result, size_bytes, received_at`
+
+// sqliteMigrations add columns introduced after a database was created.
+// Each runs once, when its column is missing.
+var sqliteMigrations = []struct {
+	column string
+	stmts  []string
+}{
+	{"recipients", []string{
+		`ALTER TABLE messages ADD COLUMN recipients TEXT NOT NULL DEFAULT ''`,
+		`UPDATE messages SET recipients = recipient`,
+	}},
+	{"delivery", []string{
+		`ALTER TABLE messages ADD COLUMN delivery TEXT`,
+	}},
+}
+
+// SQLiteStorage stores messages in a single SQLite database with their
+// metadata in indexed columns. A Codec applies to the data blob only; the
+// metadata columns stay searchable.
+type SQLiteStorage struct {
+	db    *sql.DB
+	path  string
+	codec *Codec
+}
+
+// NewSQLiteStorage opens (creating if needed) the database at path
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+
+	// WAL lets the dispatcher and API read while a message is being written
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize database schema: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+	}
+
+	return &SQLiteStorage{db: db, path: path}, nil
+}
+
+// migrateSQLite brings a database created by an older version up to date
+func migrateSQLite(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('messages')`)
+	if err != nil {
+		return err
+	}
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, migration := range sqliteMigrations {
+		if columns[migration.column] {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, stmt := range migration.stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("adding %s: %w", migration.column, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetConnectionLimits bounds the database connection pool. Values <= 0
+// leave the database/sql defaults in place.
+func (s *SQLiteStorage) SetConnectionLimits(maxOpen, maxIdle int) {
+	if maxOpen > 0 {
+		s.db.SetMaxOpenConns(maxOpen)
+	}
+	if maxIdle > 0 {
+		s.db.SetMaxIdleConns(maxIdle)
+	}
+}
+
+// SetCodec sets how message blobs are encoded at rest
+func (s *SQLiteStorage) SetCodec(codec *Codec) {
+	s.codec = codec
+}
+
+// Close closes the database
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// Store inserts a new message into the inbox, indexing whatever metadata
+// its JSON provides
+func (s *SQLiteStorage) Store(ctx context.Context, emailID string, data []byte) (string, error) {
+	blob, err := s.codec.Encode(emailID, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode message: %w", err)
+	}
+	if err := s.insert(ctx, emailID, data, blob); err != nil {
+		return "", err
+	}
+	return s.path + "#" + emailID, nil
+}
+
+// insert adds the metadata row for a message. blob is what the data column
+// holds; it is empty when the message itself lives elsewhere.
+func (s *SQLiteStorage) insert(ctx context.Context, emailID string, data, blob []byte) error {
+	storedAt, ok := MessageTime(emailID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	// Metadata is best effort; the blob is the source of truth
+	var email mail.EmailData
+	_ = json.Unmarshal(data, &email)
+
+	var receivedAt sql.NullInt64
+	if !email.ReceivedAt.IsZero() {
+		receivedAt = sql.NullInt64{Int64: email.ReceivedAt.UnixNano(), Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO messages (id, status, stored_at, received_at, sender, recipient, recipients,
+			subject, message_id, spf_result, dkim_result, dmarc_result, size_bytes, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		emailID, FolderInbox, storedAt.Unix(), receivedAt, email.Sender, email.Recipient,
+		strings.Join(email.EnvelopeRecipients(), "\n"), email.Subject,
+		email.MessageID, email.Authentication.SPFResult(), email.Authentication.DKIMResult(),
+		email.Authentication.DMARCResult(), len(email.Raw), blob)
+	if err != nil {
+		return fmt.Errorf("failed to insert message: %w", err)
+	}
+
+	return nil
+}
+
+// Retrieve returns the stored JSON for a message
+func (s *SQLiteStorage) Retrieve(ctx context.Context, emailID string) ([]byte, error) {
+	data, err := s.blob(ctx, emailID)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("message %s is held in object storage", emailID)
+	}
+
+	return s.codec.Decode(emailID, data)
+}
+
+// blob returns the data column for a message, which is empty for messages
+// indexed on behalf of ObjectStorage
+func (s *SQLiteStorage) blob(ctx context.Context, emailID string) ([]byte, error) {
+	if !ValidMessageID(emailID) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM messages WHERE id = ?`, emailID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("message %s: %w", emailID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	return data, nil
+}
+
+// List returns the IDs of all stored messages, oldest first
+func (s *SQLiteStorage) List(ctx context.Context) ([]string, error) {
+	return s.ids(ctx, `SELECT id FROM messages ORDER BY id`)
+}
+
+// Delete permanently deletes a message
+func (s *SQLiteStorage) Delete(ctx context.Context, emailID string) error {
+	if !ValidMessageID(emailID) {
+		return fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM messages WHERE id = ?`, emailID)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	return expectRow(result, emailID)
+}
+
+// Status returns the delivery state of a message
+func (s *SQLiteStorage) Status(ctx context.Context, emailID string) (string, error) {
+	if !ValidMessageID(emailID) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	var status string
+	err := s.db.QueryRowContext(ctx, `SELECT status FROM messages WHERE id = ?`, emailID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("message %s: %w", emailID, ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read message status: %w", err)
+	}
+
+	return status, nil
+}
+
+// Pending returns the IDs of all messages still waiting in the inbox, oldest first
+func (s *SQLiteStorage) Pending(ctx context.Context) ([]string, error) {
+	return s.ids(ctx, `SELECT id FROM messages WHERE status = ? ORDER BY id`, FolderInbox)
+}
+
+// MarkProcessed records a successful delivery
+func (s *SQLiteStorage) MarkProcessed(ctx context.Context, emailID string) error {
+	return s.transition(ctx, emailID, FolderInbox, FolderProcessed, nil)
+}
+
+// MarkDeadLetter records that delivery was given up on and why
+func (s *SQLiteStorage) MarkDeadLetter(ctx context.Context, emailID string, info DeadLetter) error {
+	info.ID = emailID
+	info.Path = ""
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead-letter metadata: %w", err)
+	}
+
+	meta := string(data)
+	return s.transition(ctx, emailID, FolderInbox, FolderDeadLetter, &meta)
+}
+
+// ListDeadLetters returns all dead-lettered messages, oldest first
+func (s *SQLiteStorage) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, dead_letter FROM messages WHERE status = ? ORDER BY id`, FolderDeadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		var id string
+		var meta sql.NullString
+		if err := rows.Scan(&id, &meta); err != nil {
+			return nil, fmt.Errorf("failed to read dead letter: %w", err)
+		}
+
+		letter := DeadLetter{}
+		if meta.Valid {
+			_ = json.Unmarshal([]byte(meta.String), &letter)
+		}
+		letter.ID = id
+		letters = append(letters, letter)
+	}
+
+	return letters, rows.Err()
+}
+
+// ReplayDeadLetter returns a dead-lettered message to the inbox
+func (s *SQLiteStorage) ReplayDeadLetter(ctx context.Context, emailID string) error {
+	return s.transition(ctx, emailID, FolderDeadLetter, FolderInbox, nil)
+}
+
+// DeliveryState returns the retry state saved for an inbox message, or a
+// zero state if none has been saved
+func (s *SQLiteStorage) DeliveryState(ctx context.Context, emailID string) (DeliveryState, error) {
+	if !ValidMessageID(emailID) {
+		return DeliveryState{}, fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	var delivery sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT delivery FROM messages WHERE id = ? AND status = ?`,
+		emailID, FolderInbox).Scan(&delivery)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeliveryState{}, fmt.Errorf("message %s not in %s: %w", emailID, FolderInbox, ErrNotFound)
+	}
+	if err != nil {
+		return DeliveryState{}, fmt.Errorf("failed to read delivery state: %w", err)
+	}
+
+	var state DeliveryState
+	if delivery.Valid {
+		if err := json.Unmarshal([]byte(delivery.String), &state); err != nil {
+			return DeliveryState{}, fmt.Errorf("failed to decode delivery state: %w", err)
+		}
+	}
+	return state, nil
+}
+
+// SaveDeliveryState records the retry state of an inbox message
+func (s *SQLiteStorage) SaveDeliveryState(ctx context.Context, emailID string, state DeliveryState) error {
+	if !ValidMessageID(emailID) {
+		return fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery state: %w", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, `UPDATE messages SET delivery = ? WHERE id = ? AND status = ?`,
+		string(data), emailID, FolderInbox)
+	if err != nil {
+		return fmt.Errorf("failed to save delivery state: %w", err)
+	}
+	if err := expectRow(result, emailID); err != nil {
+		return fmt.Errorf("message %s not in %s: %w", emailID, FolderInbox, ErrNotFound)
+	}
+	return nil
+}
+
+// Search returns one page of messages matching query, newest first
+func (s *SQLiteStorage) Search(ctx context.Context, query MessageQuery) ([]MessageSummary, int, error) {
+	var where []string
+	var args []interface{}
+
+	if !query.Since.IsZero() {
+		// stored_at has whole-second precision
+		since := query.Since.Unix()
+		if query.Since.Nanosecond() > 0 {
+			since++
+		}
+		where = append(where, "stored_at >= ?")
+		args = append(args, since)
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "stored_at <= ?")
+		args = append(args, query.Until.Unix())
+	}
+	if query.Sender != "" {
+		where = append(where, `sender LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(query.Sender))
+	}
+	if query.Recipient != "" {
+		where = append(where, `recipients LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(query.Recipient))
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM messages`+clause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	// SQLite treats a negative LIMIT as unbounded
+	limit := query.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+summaryColumns+` FROM messages`+clause+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, query.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := []MessageSummary{}
+	for rows.Next() {
+		summary, err := scanSummary(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		summaries = append(summaries, summary)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	return summaries, total, nil
+}
+
+// Rekey rewrites every message blob not encoded with the current codec.
+// Rows without a blob, indexed on behalf of ObjectStorage, are skipped.
+func (s *SQLiteStorage) Rekey(ctx context.Context) (RekeyResult, error) {
+	var result RekeyResult
+
+	ids, err := s.ids(ctx, `SELECT id FROM messages WHERE length(data) > 0 ORDER BY id`)
+	if err != nil {
+		return result, err
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		data, err := s.blob(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if s.codec.Current(data) {
+			result.Skipped++
+			continue
+		}
+
+		plain, err := s.codec.Decode(id, data)
+		if err != nil {
+			return result, err
+		}
+		encoded, err := s.codec.Encode(id, plain)
+		if err != nil {
+			return result, fmt.Errorf("failed to encode %s: %w", id, err)
+		}
+		if _, err := s.db.ExecContext(ctx, `UPDATE messages SET data = ? WHERE id = ?`, encoded, id); err != nil {
+			return result, fmt.Errorf("failed to update %s: %w", id, err)
+		}
+		result.Rewritten++
+	}
+
+	return result, nil
+}
+
+// transition moves a message between delivery states, replacing its
+// dead-letter metadata
+func (s *SQLiteStorage) transition(ctx context.Context, emailID, from, to string, deadLetter *string) error {
+	if !ValidMessageID(emailID) {
+		return fmt.Errorf("%w: %s", ErrInvalidMessageID, emailID)
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE messages SET status = ?, dead_letter = ?, delivery = NULL WHERE id = ? AND status = ?`,
+		to, deadLetter, emailID, from)
+	if err != nil {
+		return fmt.Errorf("failed to update message status: %w", err)
+	}
+
+	if err := expectRow(result, emailID); err != nil {
+		return fmt.Errorf("message %s not in %s: %w", emailID, from, ErrNotFound)
+	}
+	return nil
+}
+
+// ids runs a query returning a single column of message IDs
+func (s *SQLiteStorage) ids(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to list messages: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// scanSummary reads a row selected with summaryColumns
+func scanSummary(rows *sql.Rows) (MessageSummary, error) {
+	var summary MessageSummary
+	var recipients string
+	var receivedAt sql.NullInt64
+
+	if err := rows.Scan(&summary.ID, &summary.Status, &summary.Sender, &summary.Recipient, &recipients,
+		&summary.Subject, &summary.MessageID, &summary.SPFResult, &summary.DKIMResult,
+		&summary.DMARCResult, &summary.SizeBytes, &receivedAt); err != nil {
+		return summary, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	if recipients != "" {
+		summary.Recipients = strings.Split(recipients, "\n")
+	}
+	if receivedAt.Valid {
+		summary.ReceivedAt = time.Unix(0, receivedAt.Int64).UTC()
+	}
+	return summary, nil
+}
+
+// expectRow returns ErrNotFound if a statement affected no rows
+func expectRow(result sql.Result, emailID string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("message %s: %w", emailID, ErrNotFound)
+	}
+	return nil
+}
+
+// likePattern builds a LIKE pattern matching value as a substring
+func likePattern(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+	return "%" + escaped + "%"
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLiteStorage(t *testing.T) *SQLiteStorage {
+	store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "gomail.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+// storeAt stores an email under an ID encoding the given time
+func storeAt(t *testing.T, store Storage, storedAt time.Time, email *mail.EmailData) string {
+	id, err := NewMessageID(storedAt)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(email)
+	require.NoError(t, err)
+
+	_, err = store.Store(context.Background(), id, data)
+	require.NoError(t, err)
+	return id
+}
+
+func TestNewSQLiteStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "gomail.db")
+
+	store, err := NewSQLiteStorage(path)
+	require.NoError(t, err)
+	assert.FileExists(t, path)
+	require.NoError(t, store.Close())
+
+	// Reopening an existing database keeps the schema
+	store, err = NewSQLiteStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+}
+
+func TestSQLiteStorage_StoreRetrieveDelete(t *testing.T) {
+	store := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	id, location, err := StoreEmail(ctx, store, &mail.EmailData{
+		Sender:    "sender@example.com",
+		Recipient: "recipient@example.com",
+		Subject:   "Hello",
+		Raw:       "Subject: Hello\r\n\r\nBody",
+	})
+	require.NoError(t, err)
+	assert.Contains(t, location, id)
+
+	email, err := LoadEmail(ctx, store, id)
+	require.NoError(t, err)
+	assert.Equal(t, "sender@example.com", email.Sender)
+	assert.Equal(t, "Subject: Hello\r\n\r\nBody", email.Raw)
+
+	ids, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, ids)
+
+	require.NoError(t, store.Delete(ctx, id))
+	_, err = store.Retrieve(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, store.Delete(ctx, id), ErrNotFound)
+}
+
+func TestSQLiteStorage_InvalidID(t *testing.T) {
+	store := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	_, err := store.Store(ctx, "../escape", []byte("{}"))
+	assert.ErrorIs(t, err, ErrInvalidMessageID)
+	_, err = store.Retrieve(ctx, "../escape")
+	assert.ErrorIs(t, err, ErrInvalidMessageID)
+	assert.ErrorIs(t, store.Delete(ctx, "../escape"), ErrInvalidMessageID)
+	assert.ErrorIs(t, store.MarkProcessed(ctx, "../escape"), ErrInvalidMessageID)
+}
+
+func TestSQLiteStorage_IndexesMetadata(t *testing.T) {
+	store := newTestSQLiteStorage(t)
+	receivedAt := time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC)
+
+	id := storeAt(t, store, time.Now(), &mail.EmailData{
+		Sender:     "alice@example.com",
+		Recipient:  "bob@example.org",
+		Subject:    "Invoice",
+		MessageID:  "<abc@example.com>",
+		ReceivedAt: receivedAt,
+		Raw:        "0123456789",
+		Authentication: mail.AuthenticationMetadata{
+			Results: "mx.example.org; spf=pass; dkim=fail; dmarc=quarantine",
+		},
+	})
+
+	summaries, total, err := store.Search(context.Background(), MessageQuery{})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	assert.Equal(t, MessageSummary{
+		ID:          id,
+		Status:      FolderInbox,
+		Sender:      "alice@example.com",
+		Recipient:   "bob@example.org",
+		Recipients:  []string{"bob@example.org"},
+		Subject:     "Invoice",
+		MessageID:   "<abc@example.com>",
+		SPFResult:   "pass",
+		DKIMResult:  "fail",
+		DMARCResult: "quarantine",
+		SizeBytes:   10,
+		ReceivedAt:  receivedAt,
+	}, summaries[0])
+
+	// Lookups by indexed columns
+	var found string
+	err = store.db.QueryRow(`SELECT id FROM messages WHERE message_id = ? AND dmarc_result = ?`,
+		"<abc@example.com>", "quarantine").Scan(&found)
+	require.NoError(t, err)
+	assert.Equal(t, id, found)
+
+	var plan string
+	err = store.db.QueryRow(`EXPLAIN QUERY PLAN SELECT id FROM messages WHERE message_id = ?`, "x").
+		Scan(new(int), new(int), new(int), &plan)
+	require.NoError(t, err)
+	assert.Contains(t, plan, "idx_messages_message_id")
+}
+
+func TestSQLiteStorage_Search(t *testing.T) {
+	store := newTestSQLiteStorage(t)
+	ctx := context.Background()
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	first := storeAt(t, store, base, &mail.EmailData{Sender: "alice@example.com", Recipient: "support@shop.example"})
+	second := storeAt(t, store, base.Add(time.Hour), &mail.EmailData{Sender: "bob@example.org", Recipient: "sales@shop.example"})
+	third := storeAt(t, store, base.AddDate(0, 0, 1), &mail.EmailData{Sender: "Carol@Example.com", Recipient: "100%_off@other.example"})
+
+	// Newest first
+	summaries, total, err := store.Search(ctx, MessageQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, summaries, 3)
+	assert.Equal(t, []string{third, second, first}, []string{summaries[0].ID, summaries[1].ID, summaries[2].ID})
+
+	// Case-insensitive substring filters
+	_, total, err = store.Search(ctx, MessageQuery{Sender: "EXAMPLE.COM"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	// LIKE wildcards in the filter are literal
+	summaries, total, err = store.Search(ctx, MessageQuery{Recipient: "100%_"})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	assert.Equal(t, third, summaries[0].ID)
+	_, total, err = store.Search(ctx, MessageQuery{Recipient: "%"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+
+	// Date range on the storage time
+	summaries, total, err = store.Search(ctx, MessageQuery{Since: base.Add(time.Minute), Until: base.Add(2 * time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	assert.Equal(t, second, summaries[0].ID)
+
+	// Pagination reports the filtered total
+	summaries, total, err = store.Search(ctx, MessageQuery{Limit: 2, Offset: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, first, summaries[0].ID)
+}
+
+func TestSQLiteStorage_Recipients(t *testing.T) {
+	store := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	id := storeAt(t, store, time.Now(), &mail.EmailData{
+		Sender:     "alice@example.com",
+		Recipient:  "support@shop.example",
+		Recipients: []string{"support@shop.example", "sales@shop.example"},
+	})
+
+	// Any envelope recipient matches
+	summaries, total, err := store.Search(ctx, MessageQuery{Recipient: "sales@"})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	assert.Equal(t, id, summaries[0].ID)
+	assert.Equal(t, "support@shop.example", summaries[0].Recipient)
+	assert.Equal(t, []string{"support@shop.example", "sales@shop.example"}, summaries[0].Recipients)
+}
+
+func TestSQLiteStorage_MigratesRecipients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gomail.db")
+
+	// A database created before the recipients column existed
+	db, err := sql.Open("sqlite", "file:"+path)
+	require.NoError(t, err)
+	_, err = db.Exec(strings.Replace(sqliteSchema, "recipients   TEXT NOT NULL DEFAULT '',", "", 1))
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO messages (id, stored_at, recipient, data) VALUES (?, ?, ?, ?)`,
+		"msg_1705314600_a1b2c3d4", 1705314600, "bob@example.org", []byte("{}"))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	store, err := NewSQLiteStorage(path)
+	require.NoError(t, err)
+
+	summaries, _, err := store.Search(context.Background(), MessageQuery{Recipient: "bob@"})
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, []string{"bob@example.org"}, summaries[0].Recipients)
+
+	// Reopening does not migrate again
+	require.NoError(t, store.Close())
+	store, err = NewSQLiteStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+}
+
+func TestSQLiteStorage_DeliveryLifecycle(t *testing.T) {
+	store := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	delivered := storeAt(t, store, time.Now().Add(-time.Minute), &mail.EmailData{Sender: "a@example.com"})
+	failed := storeAt(t, store, time.Now(), &mail.EmailData{Sender: "b@example.com"})
+
+	pending, err := store.Pending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{delivered, failed}, pending)
+
+	require.NoError(t, store.MarkProcessed(ctx, delivered))
+	assert.ErrorIs(t, store.MarkProcessed(ctx, delivered), ErrNotFound)
+	_, err = store.DeliveryState(ctx, delivered)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Retry state is kept while the message waits in the inbox
+	state, err := store.DeliveryState(ctx, failed)
+	require.NoError(t, err)
+	assert.Zero(t, state)
+	saved := DeliveryState{Attempts: 4, NextAttempt: time.Now().UTC().Truncate(time.Second), Delivered: []string{"default"}}
+	require.NoError(t, store.SaveDeliveryState(ctx, failed, saved))
+	state, err = store.DeliveryState(ctx, failed)
+	require.NoError(t, err)
+	assert.Equal(t, saved.Attempts, state.Attempts)
+	assert.True(t, saved.NextAttempt.Equal(state.NextAttempt))
+	assert.Equal(t, saved.Delivered, state.Delivered)
+
+	failedAt := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, store.MarkDeadLetter(ctx, failed, DeadLetter{
+		LastError:  "webhook returned HTTP 503",
+		HTTPStatus: 503,
+		Attempts:   5,
+		FailedAt:   failedAt,
+	}))
+
+	pending, err = store.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	status, err := store.Status(ctx, delivered)
+	require.NoError(t, err)
+	assert.Equal(t, FolderProcessed, status)
+
+	letters, err := store.ListDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, failed, letters[0].ID)
+	assert.Equal(t, 503, letters[0].HTTPStatus)
+	assert.Equal(t, 5, letters[0].Attempts)
+	assert.True(t, failedAt.Equal(letters[0].FailedAt))
+
+	require.NoError(t, store.ReplayDeadLetter(ctx, failed))
+	assert.ErrorIs(t, store.ReplayDeadLetter(ctx, failed), ErrNotFound)
+
+	letters, err = store.ListDeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+
+	pending, err = store.Pending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{failed}, pending)
+
+	// A replayed message starts over
+	state, err = store.DeliveryState(ctx, failed)
+	require.NoError(t, err)
+	assert.Zero(t, state)
+
+	_, err = store.Status(ctx, "msg_1_abcdef01")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func BenchmarkSQLiteStorage_Store(b *testing.B) {
+	store, err := NewSQLiteStorage(filepath.Join(b.TempDir(), "gomail.db"))
+	require.NoError(b, err)
+	defer store.Close()
+
+	email := &mail.EmailData{
+		Sender:    "bench@example.com",
+		Recipient: "dest@example.com",
+		Subject:   "Benchmark Test",
+		Raw:       "Benchmark email content",
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := StoreEmail(context.Background(), store, email); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -43,8 +43,13 @@ func NewConfig(cfg *config.Config) *Config {
 // LoadCertificates loads TLS certificates from configured paths
 func (c *Config) LoadCertificates() error {
 	certDir := "/etc/mailserver/certs"
-	c.CertFile = filepath.Join(certDir, "cert.pem")
-	c.KeyFile = filepath.Join(certDir, "key.pem")
+	return c.LoadCertificatesFrom(filepath.Join(certDir, "cert.pem"), filepath.Join(certDir, "key.pem"))
+}
+
+// LoadCertificatesFrom loads TLS certificates from the given paths
+func (c *Config) LoadCertificatesFrom(certFile, keyFile string) error {
+	c.CertFile = certFile
+	c.KeyFile = keyFile
 
 	// Check if certificates exist
 	if _, err := os.Stat(c.CertFile); os.IsNotExist(err) {
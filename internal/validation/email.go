@@ -36,19 +36,25 @@ func (v *EmailValidator) Validate(email *emaildata.EmailData) error {
 		return err
 	}
 
-	// Validate recipient
-	if err := v.validateEmailAddress(email.Recipient, "recipient"); err != nil {
-		return err
+	// Validate every envelope recipient
+	recipients := email.EnvelopeRecipients()
+	if len(recipients) == 0 {
+		return fmt.Errorf("recipient address cannot be empty")
+	}
+	for _, recipient := range recipients {
+		if err := v.validateEmailAddress(recipient, "recipient"); err != nil {
+			return err
+		}
 	}
 
 	// Check blocked domains
-	if err := v.checkBlockedDomains(email.Sender, email.Recipient); err != nil {
+	if err := v.checkBlockedDomains(email.Sender, recipients); err != nil {
 		return err
 	}
 
 	// Check allowed TLDs if configured
 	if len(v.AllowedTLDs) > 0 {
-		if err := v.checkAllowedTLDs(email.Sender, email.Recipient); err != nil {
+		if err := v.checkAllowedTLDs(email.Sender, recipients); err != nil {
 			return err
 		}
 	}
@@ -139,30 +145,32 @@ func (v *EmailValidator) validateDomain(domain string) error {
 	return nil
 }
 
-// checkBlockedDomains checks if sender or recipient is from a blocked domain
-func (v *EmailValidator) checkBlockedDomains(sender, recipient string) error {
+// checkBlockedDomains checks if the sender or any recipient is from a blocked domain
+func (v *EmailValidator) checkBlockedDomains(sender string, recipients []string) error {
 	for _, blocked := range v.BlockedDomains {
 		if strings.Contains(sender, "@"+blocked) {
 			return fmt.Errorf("sender domain %s is blocked", blocked)
 		}
-		if strings.Contains(recipient, "@"+blocked) {
-			return fmt.Errorf("recipient domain %s is blocked", blocked)
+		for _, recipient := range recipients {
+			if strings.Contains(recipient, "@"+blocked) {
+				return fmt.Errorf("recipient domain %s is blocked", blocked)
+			}
 		}
 	}
 	return nil
 }
 
-// checkAllowedTLDs checks if sender and recipient use allowed TLDs
-func (v *EmailValidator) checkAllowedTLDs(sender, recipient string) error {
+// checkAllowedTLDs checks if the sender and every recipient use allowed TLDs
+func (v *EmailValidator) checkAllowedTLDs(sender string, recipients []string) error {
 	senderTLD := extractTLD(sender)
-	recipientTLD := extractTLD(recipient)
-
 	if !v.isTLDAllowed(senderTLD) {
 		return fmt.Errorf("sender TLD %s is not allowed", senderTLD)
 	}
 
-	if !v.isTLDAllowed(recipientTLD) {
-		return fmt.Errorf("recipient TLD %s is not allowed", recipientTLD)
+	for _, recipient := range recipients {
+		if recipientTLD := extractTLD(recipient); !v.isTLDAllowed(recipientTLD) {
+			return fmt.Errorf("recipient TLD %s is not allowed", recipientTLD)
+		}
 	}
 
 	return nil
@@ -94,6 +94,41 @@ func TestEmailValidator_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "sender TLD net is not allowed",
 		},
+		{
+			name:      "multiple recipients",
+			validator: NewEmailValidator(),
+			email: &emaildata.EmailData{
+				Sender:     "sender@example.com",
+				Recipient:  "one@example.com",
+				Recipients: []string{"one@example.com", "two@example.org"},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "invalid second recipient",
+			validator: NewEmailValidator(),
+			email: &emaildata.EmailData{
+				Sender:     "sender@example.com",
+				Recipient:  "one@example.com",
+				Recipients: []string{"one@example.com", "two@localhost"},
+			},
+			wantErr: true,
+			errMsg:  "invalid domain in recipient address two@localhost",
+		},
+		{
+			name: "blocked domain in any recipient",
+			validator: &EmailValidator{
+				MaxSize:        26214400,
+				BlockedDomains: []string{"blocked.org"},
+			},
+			email: &emaildata.EmailData{
+				Sender:     "sender@example.com",
+				Recipient:  "one@example.com",
+				Recipients: []string{"one@example.com", "two@blocked.org"},
+			},
+			wantErr: true,
+			errMsg:  "recipient domain blocked.org is blocked",
+		},
 		{
 			name: "size limit exceeded",
 			validator: &EmailValidator{
@@ -0,0 +1,433 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"github.com/grumpyguvner/gomail/internal/storage"
+	"github.com/grumpyguvner/gomail/pkg/webhooksig"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxRetryDelay caps the exponential backoff between attempts
+	maxRetryDelay = time.Hour
+
+	userAgent = "GoMail-Webhook/1.0"
+)
+
+// Inbound event types. Bounces and spam complaints about mail sent from here
+// get their own types so receivers can handle them without parsing every email.
+const (
+	EventEmailReceived  = "email.received"
+	EventEmailBounced   = "email.bounced"
+	EventEmailComplaint = "email.complaint"
+)
+
+// Payload is the JSON document POSTed to the webhook endpoint
+type Payload struct {
+	Event string `json:"event"` // EventEmailReceived, EventEmailBounced or EventEmailComplaint
+	*mail.EmailData
+	Metadata PayloadMetadata `json:"metadata"`
+}
+
+// PayloadMetadata describes the delivery itself rather than the email
+type PayloadMetadata struct {
+	ID        string `json:"id"`
+	SizeBytes int    `json:"size_bytes"`
+	Attempt   int    `json:"attempt"`
+}
+
+// deliveryState tracks retries for a single stored message. It is saved
+// with the message, so a restart neither resends it to targets that
+// accepted it nor gives it a fresh set of retries.
+type deliveryState struct {
+	attempts    int
+	nextAttempt time.Time
+	delivered   map[string]bool // names of targets that have accepted the message
+}
+
+// Dispatcher delivers stored emails to the webhook target chosen for each recipient
+type Dispatcher struct {
+	storage      storage.DeliveryStore
+	router       *Router
+	baseURL      string // attachment download URLs are built from it
+	secrets      []string
+	pollInterval time.Duration
+	logger       *zap.SugaredLogger
+
+	mu     sync.Mutex
+	states map[string]*deliveryState
+	now    func() time.Time
+}
+
+// NewDispatcher creates a webhook dispatcher reading from the given storage
+func NewDispatcher(cfg *config.Config, store storage.DeliveryStore) *Dispatcher {
+	pollInterval := time.Duration(cfg.WebhookPollInterval) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = 10 * time.Second
+	}
+
+	return &Dispatcher{
+		storage:      store,
+		router:       NewRouter(cfg),
+		baseURL:      strings.TrimRight(cfg.AttachmentBaseURL, "/"),
+		secrets:      []string{cfg.WebhookSecret, cfg.WebhookSecretPrevious},
+		pollInterval: pollInterval,
+		logger:       logging.Get(),
+		states:       make(map[string]*deliveryState),
+		now:          time.Now,
+	}
+}
+
+// Run polls the inbox and delivers pending messages until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.logger.Infof("Webhook dispatcher started: default_url=%s, routes=%d, poll_interval=%v",
+		d.defaultURL(), len(d.router.routes), d.pollInterval)
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.ProcessPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			d.logger.Info("Webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessPending makes one pass over the inbox, delivering every message that is due
+func (d *Dispatcher) ProcessPending(ctx context.Context) {
+	pending, err := d.storage.Pending(ctx)
+	if err != nil {
+		d.logger.Errorf("Failed to list pending messages: %v", err)
+		return
+	}
+	metrics.WebhookPending.Set(float64(len(pending)))
+
+	for _, id := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		state := d.state(ctx, id)
+		if !d.due(state) {
+			continue
+		}
+		d.attempt(ctx, id, state)
+	}
+}
+
+// state returns the retry state of a message, loading what was saved with
+// it the first time the message is seen
+func (d *Dispatcher) state(ctx context.Context, id string) *deliveryState {
+	d.mu.Lock()
+	state, ok := d.states[id]
+	d.mu.Unlock()
+	if ok {
+		return state
+	}
+
+	saved, err := d.storage.DeliveryState(ctx, id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		d.logger.Warnf("Failed to load delivery state of %s, starting afresh: %v", id, err)
+	}
+
+	state = &deliveryState{
+		attempts:    saved.Attempts,
+		nextAttempt: saved.NextAttempt,
+		delivered:   make(map[string]bool, len(saved.Delivered)),
+	}
+	for _, name := range saved.Delivered {
+		state.delivered[name] = true
+	}
+
+	d.mu.Lock()
+	d.states[id] = state
+	d.mu.Unlock()
+	return state
+}
+
+// save writes the retry state of a message to storage
+func (d *Dispatcher) save(ctx context.Context, id string, state *deliveryState) {
+	d.mu.Lock()
+	saved := storage.DeliveryState{Attempts: state.attempts, NextAttempt: state.nextAttempt}
+	for name := range state.delivered {
+		saved.Delivered = append(saved.Delivered, name)
+	}
+	d.mu.Unlock()
+	sort.Strings(saved.Delivered)
+
+	if err := d.storage.SaveDeliveryState(ctx, id, saved); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		d.logger.Errorf("Failed to save delivery state of %s: %v", id, err)
+	}
+}
+
+// due reports whether a message should be attempted now
+func (d *Dispatcher) due(state *deliveryState) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return !d.now().Before(state.nextAttempt)
+}
+
+// attempt delivers a single message and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, id string, state *deliveryState) {
+	d.mu.Lock()
+	state.attempts++
+	attempt := state.attempts
+	d.mu.Unlock()
+
+	// Counted before sending, so a crash mid-delivery still uses up an attempt
+	d.save(ctx, id, state)
+
+	email, err := storage.LoadEmail(ctx, d.storage, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// Deleted since the inbox was listed
+			d.logger.Warnf("Message %s disappeared before delivery: %v", id, err)
+			d.forget(id)
+			return
+		}
+		// A corrupt message or a lost encryption key will not fix itself, so
+		// it gets the global retries and then goes to the dead letters
+		d.retry(ctx, id, state, attempt, &d.router.defaults, 0, fmt.Errorf("failed to load message: %w", err))
+		return
+	}
+
+	routed, unrouted := d.router.RouteAll(email.EnvelopeRecipients())
+	if len(routed) == 0 {
+		// Nothing will ever accept this message, so retrying is pointless
+		d.deadLetter(ctx, id, attempt, 0, fmt.Errorf("no webhook route for recipients %q", unrouted))
+		return
+	}
+	if len(unrouted) > 0 && attempt == 1 {
+		d.logger.Warnw("No webhook route for some recipients", "message", id, "recipients", unrouted)
+	}
+
+	// Each target gets the message once, naming only its own recipients.
+	// Targets that accepted it are not sent it again on retries.
+	var failed *Target
+	var failedStatus int
+	var failures []error
+	for _, group := range routed {
+		d.mu.Lock()
+		done := state.delivered[group.Target.Name]
+		d.mu.Unlock()
+		if done {
+			continue
+		}
+
+		start := time.Now()
+		status, err := d.deliver(ctx, group.Target, id, forRecipients(email, group.Recipients), attempt)
+		metrics.WebhookDeliveryDuration.Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", group.Target.Name, err))
+			// The most patient failing target decides when to give up
+			if failed == nil || group.Target.MaxRetries > failed.MaxRetries {
+				failed, failedStatus = group.Target, status
+			}
+			continue
+		}
+
+		d.mu.Lock()
+		state.delivered[group.Target.Name] = true
+		d.mu.Unlock()
+		d.save(ctx, id, state)
+		metrics.WebhookDeliveries.WithLabelValues("success").Inc()
+		d.logger.Infow("Webhook delivered", "message", id, "target", group.Target.Name, "attempt", attempt)
+	}
+
+	if failed == nil {
+		if err := d.storage.MarkProcessed(ctx, id); err != nil {
+			d.logger.Errorf("Delivered %s but failed to mark it processed: %v", id, err)
+		}
+		d.forget(id)
+		return
+	}
+
+	err = failures[0]
+	if len(failures) > 1 {
+		err = errors.Join(failures...)
+	}
+	d.retry(ctx, id, state, attempt, failed, failedStatus, err)
+}
+
+// forRecipients returns email addressed to the given envelope recipients
+// only, so one target does not learn who else the message went to
+func forRecipients(email *mail.EmailData, recipients []string) *mail.EmailData {
+	all := email.EnvelopeRecipients()
+	if len(recipients) == len(all) {
+		return email
+	}
+
+	copied := *email
+	copied.SetRecipients(recipients)
+	return &copied
+}
+
+// retry schedules the next attempt after a failed one, or dead-letters the
+// message once the target's retries are used up
+func (d *Dispatcher) retry(ctx context.Context, id string, state *deliveryState, attempt int, target *Target, status int, err error) {
+	if attempt >= target.MaxRetries {
+		d.deadLetter(ctx, id, attempt, status, err)
+		return
+	}
+
+	d.mu.Lock()
+	state.nextAttempt = d.now().Add(backoff(target.RetryDelay, attempt))
+	nextAttempt := state.nextAttempt
+	d.mu.Unlock()
+	d.save(ctx, id, state)
+
+	metrics.WebhookDeliveries.WithLabelValues("error").Inc()
+	d.logger.Warnw("Webhook delivery failed, will retry",
+		"message", id, "target", target.Name, "attempt", attempt, "next_attempt", nextAttempt, "error", err)
+}
+
+// deadLetter moves a message that will not be retried to the dead-letter queue
+func (d *Dispatcher) deadLetter(ctx context.Context, id string, attempt, status int, cause error) {
+	metrics.WebhookDeliveries.WithLabelValues("exhausted").Inc()
+	d.logger.Errorw("Webhook delivery failed, moving to dead letters",
+		"message", id, "attempts", attempt, "http_status", status, "error", cause)
+
+	if err := d.storage.MarkDeadLetter(ctx, id, storage.DeadLetter{
+		LastError:  cause.Error(),
+		HTTPStatus: status,
+		Attempts:   attempt,
+		FailedAt:   d.now().UTC(),
+	}); err != nil {
+		d.logger.Errorf("Failed to dead-letter %s: %v", id, err)
+	}
+
+	d.forget(id)
+}
+
+// forget drops the retry state for a message
+func (d *Dispatcher) forget(id string) {
+	d.mu.Lock()
+	delete(d.states, id)
+	d.mu.Unlock()
+}
+
+// defaultURL returns the fallback webhook URL for logging
+func (d *Dispatcher) defaultURL() string {
+	if d.router.fallback == nil {
+		return ""
+	}
+	return d.router.fallback.URL
+}
+
+// backoff returns the delay before the next attempt, doubling retryDelay after each failure
+func backoff(retryDelay time.Duration, attempt int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return delay
+}
+
+// withAttachmentURLs returns email with a download URL on every attachment
+// when attachment_base_url is set. Extracted attachments are not in Raw, so
+// the URL is the only way a receiver can fetch them.
+func (d *Dispatcher) withAttachmentURLs(id string, email *mail.EmailData) *mail.EmailData {
+	if d.baseURL == "" || len(email.Attachments) == 0 {
+		return email
+	}
+
+	copied := *email
+	copied.Attachments = make([]mail.Attachment, len(email.Attachments))
+	for i, att := range email.Attachments {
+		att.URL = fmt.Sprintf("%s/api/emails/%s/attachments/%d", d.baseURL, id, i)
+		copied.Attachments[i] = att
+	}
+	return &copied
+}
+
+// deliver POSTs a stored message to the target and returns the HTTP status
+// received, or zero if no response was received
+func (d *Dispatcher) deliver(ctx context.Context, target *Target, id string, email *mail.EmailData, attempt int) (int, error) {
+	event := EventEmailReceived
+	switch {
+	case email.Bounce != nil:
+		event = EventEmailBounced
+	case email.Complaint != nil:
+		event = EventEmailComplaint
+	}
+
+	payload := Payload{
+		Event:     event,
+		EmailData: d.withAttachmentURLs(id, email),
+		Metadata: PayloadMetadata{
+			ID:        id,
+			SizeBytes: len(email.Raw),
+			Attempt:   attempt,
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set("X-GoMail-Event", event)
+	header.Set("X-GoMail-Delivery-Attempt", fmt.Sprintf("%d", attempt))
+	return post(ctx, target, body, header, d.secrets, d.now())
+}
+
+// post sends a JSON body to the target with its bearer token and, when a
+// secret is set, a signature. It returns the HTTP status received, or zero
+// if no response was received.
+func post(ctx context.Context, target *Target, body []byte, header http.Header, secrets []string, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	if target.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+target.BearerToken)
+	}
+	if secrets[0] != "" {
+		req.Header.Set(webhooksig.Header, webhooksig.Sign(body, now, secrets...))
+	}
+
+	resp, err := target.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return resp.StatusCode, nil
+}
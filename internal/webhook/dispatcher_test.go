@@ -0,0 +1,478 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/mail"
+	"github.com/grumpyguvner/gomail/internal/storage"
+	"github.com/grumpyguvner/gomail/pkg/webhooksig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDispatcher(t *testing.T, url string) (*Dispatcher, *storage.FileStorage) {
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	cfg := &config.Config{
+		WebhookURL:         url,
+		WebhookBearerToken: "webhook-token",
+		WebhookMaxRetries:  3,
+		WebhookRetryDelay:  1,
+	}
+
+	return NewDispatcher(cfg, store), store
+}
+
+func storeEmail(t *testing.T, store storage.Storage, email *mail.EmailData) string {
+	id, _, err := storage.StoreEmail(context.Background(), store, email)
+	require.NoError(t, err)
+	return id
+}
+
+func assertStatus(t *testing.T, store storage.DeliveryStore, id, want string) {
+	status, err := store.Status(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, want, status)
+}
+
+func TestDispatcher_DeliversAndMarksProcessed(t *testing.T) {
+	var received Payload
+	var authHeader, eventHeader string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		eventHeader = r.Header.Get("X-GoMail-Event")
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dispatcher, store := newTestDispatcher(t, server.URL)
+
+	id := storeEmail(t, store, &mail.EmailData{
+		Sender:    "sender@example.com",
+		Recipient: "recipient@example.com",
+		Subject:   "Hello",
+		Raw:       "Subject: Hello\r\n\r\nBody",
+	})
+
+	dispatcher.ProcessPending(context.Background())
+
+	assert.Equal(t, "Bearer webhook-token", authHeader)
+	assert.Equal(t, EventEmailReceived, eventHeader)
+	assert.Equal(t, EventEmailReceived, received.Event)
+	require.NotNil(t, received.EmailData)
+	assert.Equal(t, "sender@example.com", received.Sender)
+	assert.Equal(t, "recipient@example.com", received.Recipient)
+	assert.Equal(t, "Hello", received.Subject)
+	assert.Equal(t, 1, received.Metadata.Attempt)
+	assert.Equal(t, len("Subject: Hello\r\n\r\nBody"), received.Metadata.SizeBytes)
+	assert.Equal(t, id, received.Metadata.ID)
+
+	assertStatus(t, store, id, storage.FolderProcessed)
+	pending, err := store.Pending(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestDispatcher_BounceEvent(t *testing.T) {
+	var received Payload
+	var eventHeader string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eventHeader = r.Header.Get("X-GoMail-Event")
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	dispatcher, store := newTestDispatcher(t, server.URL)
+
+	storeEmail(t, store, &mail.EmailData{
+		Sender:    "MAILER-DAEMON@mx.example.net",
+		Recipient: "alice@example.com",
+		Bounce: &mail.Bounce{
+			Type:       mail.BounceHard,
+			Format:     mail.BounceFormatDSN,
+			Recipients: []mail.BouncedRecipient{{Recipient: "gone@example.net", Type: mail.BounceHard, Status: "5.1.1"}},
+		},
+	})
+
+	dispatcher.ProcessPending(context.Background())
+
+	assert.Equal(t, EventEmailBounced, eventHeader)
+	assert.Equal(t, EventEmailBounced, received.Event)
+	require.NotNil(t, received.Bounce)
+	assert.Equal(t, "gone@example.net", received.Bounce.Recipients[0].Recipient)
+
+	storeEmail(t, store, &mail.EmailData{
+		Sender:    "abuse@isp.example",
+		Recipient: "fbl@example.com",
+		Complaint: &mail.Complaint{FeedbackType: "abuse", Recipients: []string{"customer@isp.example"}},
+	})
+
+	dispatcher.ProcessPending(context.Background())
+
+	assert.Equal(t, EventEmailComplaint, eventHeader)
+	assert.Equal(t, EventEmailComplaint, received.Event)
+	require.NotNil(t, received.Complaint)
+	assert.Equal(t, []string{"customer@isp.example"}, received.Complaint.Recipients)
+}
+
+func TestDispatcher_SignsPayload(t *testing.T) {
+	var signatureErr error
+	var signature string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(webhooksig.Header)
+		body, _ := io.ReadAll(r.Body)
+		signatureErr = webhooksig.Verify(signature, body, time.Minute, "previous-shared-secret")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	dispatcher := NewDispatcher(&config.Config{
+		WebhookURL:            server.URL,
+		WebhookSecret:         "current-shared-secret",
+		WebhookSecretPrevious: "previous-shared-secret",
+	}, store)
+
+	storeEmail(t, store, &mail.EmailData{Sender: "sender@example.com"})
+
+	dispatcher.ProcessPending(context.Background())
+
+	assert.Equal(t, 2, strings.Count(signature, "v1="))
+	assert.NoError(t, signatureErr)
+}
+
+func TestDispatcher_AttachmentURLs(t *testing.T) {
+	var received Payload
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	dispatcher := NewDispatcher(&config.Config{
+		WebhookURL:        server.URL,
+		AttachmentBaseURL: "https://mail.example.com/",
+	}, store)
+
+	id := storeEmail(t, store, &mail.EmailData{
+		Sender: "sender@example.com",
+		Attachments: []mail.Attachment{
+			{Part: "2", ContentType: "application/pdf", Extracted: true},
+			{Part: "3", ContentType: "image/png"},
+		},
+	})
+
+	dispatcher.ProcessPending(context.Background())
+
+	require.Len(t, received.Attachments, 2)
+	assert.Equal(t, "https://mail.example.com/api/emails/"+id+"/attachments/0", received.Attachments[0].URL)
+	assert.True(t, received.Attachments[0].Extracted)
+	assert.Equal(t, "https://mail.example.com/api/emails/"+id+"/attachments/1", received.Attachments[1].URL)
+
+	// The stored message is left alone
+	email, err := storage.LoadEmail(context.Background(), store, id)
+	require.NoError(t, err)
+	assert.Empty(t, email.Attachments[0].URL)
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dispatcher, store := newTestDispatcher(t, server.URL)
+	now := time.Now()
+	dispatcher.now = func() time.Time { return now }
+
+	id := storeEmail(t, store, &mail.EmailData{Sender: "sender@example.com"})
+
+	// First attempt fails and schedules a retry
+	dispatcher.ProcessPending(context.Background())
+	assert.Equal(t, int32(1), calls.Load())
+	assertStatus(t, store, id, storage.FolderInbox)
+
+	// Not yet due
+	dispatcher.ProcessPending(context.Background())
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Due after the first backoff (1s)
+	now = now.Add(time.Second)
+	dispatcher.ProcessPending(context.Background())
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Second backoff doubles to 2s
+	now = now.Add(time.Second)
+	dispatcher.ProcessPending(context.Background())
+	assert.Equal(t, int32(2), calls.Load())
+	now = now.Add(time.Second)
+	dispatcher.ProcessPending(context.Background())
+	assert.Equal(t, int32(3), calls.Load())
+
+	// Retries exhausted, the message is dead-lettered
+	assertStatus(t, store, id, storage.FolderDeadLetter)
+	letters, err := store.ListDeadLetters(context.Background())
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, 3, letters[0].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, letters[0].HTTPStatus)
+	assert.Contains(t, letters[0].LastError, "HTTP 500")
+
+	now = now.Add(time.Hour)
+	dispatcher.ProcessPending(context.Background())
+	assert.Equal(t, int32(3), calls.Load())
+
+	// Replayed messages start over with a fresh attempt count
+	require.NoError(t, store.ReplayDeadLetter(context.Background(), id))
+	dispatcher.ProcessPending(context.Background())
+	assert.Equal(t, int32(4), calls.Load())
+	assertStatus(t, store, id, storage.FolderInbox)
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	assert.Equal(t, 5*time.Second, backoff(5*time.Second, 1))
+	assert.Equal(t, 10*time.Second, backoff(5*time.Second, 2))
+	assert.Equal(t, 20*time.Second, backoff(5*time.Second, 3))
+	assert.Equal(t, maxRetryDelay, backoff(5*time.Second, 20))
+}
+
+func TestDispatcher_RoutesByRecipient(t *testing.T) {
+	var defaultCalls, billingCalls atomic.Int32
+	var billingAuth string
+
+	defaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultCalls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer defaultServer.Close()
+
+	billingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		billingCalls.Add(1)
+		billingAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer billingServer.Close()
+
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	dispatcher := NewDispatcher(&config.Config{
+		WebhookURL:         defaultServer.URL,
+		WebhookBearerToken: "default-token",
+		WebhookRoutes: []config.WebhookRoute{
+			{Name: "billing", Match: []string{"billing.example"}, URL: billingServer.URL, BearerToken: "billing-token"},
+		},
+	}, store)
+
+	for _, recipient := range []string{"invoices@billing.example", "someone@other.example"} {
+		storeEmail(t, store, &mail.EmailData{Sender: "sender@example.com", Recipient: recipient, Raw: "Subject: x\r\n\r\nBody"})
+	}
+
+	dispatcher.ProcessPending(context.Background())
+
+	assert.Equal(t, int32(1), billingCalls.Load())
+	assert.Equal(t, int32(1), defaultCalls.Load())
+	assert.Equal(t, "Bearer billing-token", billingAuth)
+}
+
+func TestDispatcher_FansOutToEveryTarget(t *testing.T) {
+	var defaultCalls, billingCalls atomic.Int32
+	var defaultPayload, billingPayload Payload
+
+	defaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultCalls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &defaultPayload))
+	}))
+	defer defaultServer.Close()
+
+	billingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fails the first time
+		if billingCalls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &billingPayload))
+	}))
+	defer billingServer.Close()
+
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	dispatcher := NewDispatcher(&config.Config{
+		WebhookURL:        defaultServer.URL,
+		WebhookRetryDelay: 1,
+		WebhookRoutes: []config.WebhookRoute{
+			{Name: "billing", Match: []string{"billing.example"}, URL: billingServer.URL},
+		},
+	}, store)
+	now := time.Now()
+	dispatcher.now = func() time.Time { return now }
+
+	email := &mail.EmailData{Sender: "sender@example.com", Raw: "Subject: x\r\n\r\nBody"}
+	email.SetRecipients([]string{"alice@other.example", "invoices@billing.example", "bob@other.example"})
+	id := storeEmail(t, store, email)
+
+	dispatcher.ProcessPending(context.Background())
+	assert.Equal(t, int32(1), defaultCalls.Load())
+	assert.Equal(t, int32(1), billingCalls.Load())
+	assertStatus(t, store, id, storage.FolderInbox)
+
+	// Only the target that failed is retried
+	now = now.Add(time.Second)
+	dispatcher.ProcessPending(context.Background())
+	assert.Equal(t, int32(1), defaultCalls.Load())
+	assert.Equal(t, int32(2), billingCalls.Load())
+	assertStatus(t, store, id, storage.FolderProcessed)
+
+	// Each target only sees its own recipients
+	assert.Equal(t, []string{"alice@other.example", "bob@other.example"}, defaultPayload.Recipients)
+	assert.Equal(t, "alice@other.example", defaultPayload.Recipient)
+	assert.Equal(t, []string{"invoices@billing.example"}, billingPayload.Recipients)
+	assert.Equal(t, "invoices@billing.example", billingPayload.Recipient)
+}
+
+func TestDispatcher_StateSurvivesRestart(t *testing.T) {
+	var defaultCalls atomic.Int32
+	defaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultCalls.Add(1)
+	}))
+	defer defaultServer.Close()
+
+	var billingAttempts []string
+	billingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		billingAttempts = append(billingAttempts, r.Header.Get("X-GoMail-Delivery-Attempt"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer billingServer.Close()
+
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	cfg := &config.Config{
+		WebhookURL:        defaultServer.URL,
+		WebhookRetryDelay: 1,
+		WebhookRoutes: []config.WebhookRoute{
+			{Name: "billing", Match: []string{"billing.example"}, URL: billingServer.URL, MaxRetries: 2},
+		},
+	}
+	now := time.Now()
+	newDispatcher := func() *Dispatcher {
+		dispatcher := NewDispatcher(cfg, store)
+		dispatcher.now = func() time.Time { return now }
+		return dispatcher
+	}
+
+	email := &mail.EmailData{Sender: "sender@example.com", Raw: "Subject: x\r\n\r\nBody"}
+	email.SetRecipients([]string{"alice@other.example", "invoices@billing.example"})
+	id := storeEmail(t, store, email)
+
+	newDispatcher().ProcessPending(context.Background())
+	assert.Equal(t, int32(1), defaultCalls.Load())
+	assert.Equal(t, []string{"1"}, billingAttempts)
+
+	state, err := store.DeliveryState(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, state.Attempts)
+	assert.Equal(t, []string{"default"}, state.Delivered)
+
+	// After a restart the backoff still applies
+	newDispatcher().ProcessPending(context.Background())
+	assert.Equal(t, []string{"1"}, billingAttempts)
+
+	// The target that accepted the message is not sent it again, and the
+	// retries carry on where they left off
+	now = now.Add(time.Second)
+	newDispatcher().ProcessPending(context.Background())
+	assert.Equal(t, int32(1), defaultCalls.Load())
+	assert.Equal(t, []string{"1", "2"}, billingAttempts)
+
+	assertStatus(t, store, id, storage.FolderDeadLetter)
+	letters, err := store.ListDeadLetters(context.Background())
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, 2, letters[0].Attempts)
+
+	// A replayed message starts over
+	require.NoError(t, store.ReplayDeadLetter(context.Background(), id))
+	state, err = store.DeliveryState(context.Background(), id)
+	require.NoError(t, err)
+	assert.Zero(t, state.Attempts)
+}
+
+func TestDispatcher_DeadLettersUnroutable(t *testing.T) {
+	store, err := storage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	dispatcher := NewDispatcher(&config.Config{
+		WebhookRoutes: []config.WebhookRoute{
+			{Match: []string{"billing.example"}, URL: "http://127.0.0.1:1"},
+		},
+	}, store)
+
+	storeEmail(t, store, &mail.EmailData{Sender: "sender@example.com", Recipient: "someone@other.example", Raw: "Body"})
+
+	dispatcher.ProcessPending(context.Background())
+
+	letters, err := store.ListDeadLetters(context.Background())
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Contains(t, letters[0].LastError, "no webhook route")
+}
+
+func TestDispatcher_DeadLettersUnreadable(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dispatcher, store := newTestDispatcher(t, server.URL)
+	now := time.Now()
+	dispatcher.now = func() time.Time { return now }
+
+	id, err := storage.NewMessageID(now)
+	require.NoError(t, err)
+	_, err = store.Store(context.Background(), id, []byte("not json"))
+	require.NoError(t, err)
+
+	// Each failed load counts as an attempt, so the message is not retried forever
+	for i := 0; i < 3; i++ {
+		dispatcher.ProcessPending(context.Background())
+		now = now.Add(time.Hour)
+	}
+
+	assert.Equal(t, int32(0), calls.Load())
+	assertStatus(t, store, id, storage.FolderDeadLetter)
+	letters, err := store.ListDeadLetters(context.Background())
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, 3, letters[0].Attempts)
+	assert.Contains(t, letters[0].LastError, "failed to load message")
+}
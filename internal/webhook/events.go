@@ -0,0 +1,333 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/logging"
+	"github.com/grumpyguvner/gomail/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Event is a notification about something other than a received email, such
+// as the outcome of an outbound delivery
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"event"` // e.g. "delivery.delivered"
+	CreatedAt time.Time       `json:"created_at"`
+	RequestID string          `json:"request_id,omitempty"` // correlation ID of the request that caused it
+	Data      json.RawMessage `json:"data"`
+}
+
+// queuedEvent is an event waiting in data_dir/events, or given up on in
+// data_dir/events/dead
+type queuedEvent struct {
+	Event       *Event    `json:"event"`
+	Address     string    `json:"address"` // routes the event like a recipient would
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastStatus  int       `json:"last_status,omitempty"` // HTTP status of the last failed attempt
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Events delivers events to the webhook target chosen for an address. Events
+// are written to data_dir/events before they are sent, so they survive
+// restarts, and are retried with the target's retry policy. Each target
+// gets its events in order, and a slow target does not hold up the others.
+// Events whose retries run out are moved to data_dir/events/dead.
+type Events struct {
+	dir          string
+	deadDir      string
+	router       *Router
+	secrets      []string
+	pollInterval time.Duration
+	logger       *zap.SugaredLogger
+
+	// mu serializes passes over the queue with the targets they start
+	mu   sync.Mutex
+	busy map[*Target]bool // targets with events being sent
+	wg   sync.WaitGroup
+	wake chan struct{}
+	now  func() time.Time
+}
+
+// NewEvents creates the event queue under the data directory
+func NewEvents(cfg *config.Config) (*Events, error) {
+	dir := filepath.Join(cfg.DataDir, "events")
+	deadDir := filepath.Join(dir, "dead")
+	if err := os.MkdirAll(deadDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create event queue directory: %w", err)
+	}
+
+	pollInterval := time.Duration(cfg.WebhookPollInterval) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = 10 * time.Second
+	}
+
+	return &Events{
+		dir:          dir,
+		deadDir:      deadDir,
+		router:       NewRouter(cfg),
+		secrets:      []string{cfg.WebhookSecret, cfg.WebhookSecretPrevious},
+		pollInterval: pollInterval,
+		logger:       logging.Get(),
+		busy:         make(map[*Target]bool),
+		wake:         make(chan struct{}, 1),
+		now:          time.Now,
+	}, nil
+}
+
+// Publish queues an event of the given type for the webhook that address
+// routes to. Events for addresses without a webhook are dropped.
+func (e *Events) Publish(eventType, requestID, address string, data interface{}) error {
+	if e.router.Route(address) == nil {
+		return nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	now := e.now().UTC()
+	randomBytes := make([]byte, 4)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return fmt.Errorf("failed to generate event ID: %w", err)
+	}
+	event := &Event{
+		ID:        fmt.Sprintf("evt_%d_%s", now.UnixNano(), hex.EncodeToString(randomBytes)),
+		Type:      eventType,
+		CreatedAt: now,
+		RequestID: requestID,
+		Data:      encoded,
+	}
+	if err := e.save(&queuedEvent{Event: event, Address: address}); err != nil {
+		return err
+	}
+
+	e.poke()
+	return nil
+}
+
+// Run delivers queued events until the context is cancelled, then waits for
+// events being sent
+func (e *Events) Run(ctx context.Context) {
+	e.logger.Infof("Webhook event delivery started: poll_interval=%v", e.pollInterval)
+
+	ticker := time.NewTicker(e.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		e.ProcessPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			e.wg.Wait()
+			e.logger.Info("Webhook event delivery stopped")
+			return
+		case <-ticker.C:
+		case <-e.wake:
+		}
+	}
+}
+
+// ProcessPending makes one pass over the queue and starts sending the due
+// events of every target that is not still busy with earlier ones. Each
+// target gets its events oldest first.
+func (e *Events) ProcessPending(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entries, err := os.ReadDir(e.dir)
+	if err != nil {
+		e.logger.Errorf("Failed to read event queue: %v", err)
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "evt_") && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var targets []*Target
+	due := make(map[*Target][]*queuedEvent)
+	for _, name := range names {
+		queued, err := e.load(name)
+		if err != nil {
+			e.logger.Errorf("Dropping unreadable event %s: %v", name, err)
+			_ = os.Remove(filepath.Join(e.dir, name))
+			continue
+		}
+		if e.now().Before(queued.NextAttempt) {
+			continue
+		}
+
+		target := e.router.Route(queued.Address)
+		if target == nil {
+			// The routes changed since the event was queued
+			logging.WithRequestID(queued.Event.RequestID).Warnw("No webhook route for event, dropping it",
+				"event", queued.Event.Type, "event_id", queued.Event.ID, "address", queued.Address)
+			e.remove(queued.Event.ID)
+			continue
+		}
+		if e.busy[target] {
+			continue
+		}
+		if _, ok := due[target]; !ok {
+			targets = append(targets, target)
+		}
+		due[target] = append(due[target], queued)
+	}
+
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			return
+		}
+		e.busy[target] = true
+		e.wg.Add(1)
+		go func(target *Target, events []*queuedEvent) {
+			defer e.wg.Done()
+			defer e.release(target)
+			for _, queued := range events {
+				if ctx.Err() != nil {
+					return
+				}
+				e.attempt(ctx, target, queued)
+			}
+		}(target, due[target])
+	}
+}
+
+// release lets the next pass send to target again, and starts one at once
+// in case events for it were skipped while it was busy
+func (e *Events) release(target *Target) {
+	e.mu.Lock()
+	delete(e.busy, target)
+	e.mu.Unlock()
+
+	e.poke()
+}
+
+func (e *Events) poke() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// attempt sends one event to target and records the outcome
+func (e *Events) attempt(ctx context.Context, target *Target, queued *queuedEvent) {
+	event := queued.Event
+	logger := logging.WithRequestID(event.RequestID).With("event", event.Type, "event_id", event.ID)
+
+	queued.Attempts++
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf("Failed to encode event: %v", err)
+		e.remove(event.ID)
+		return
+	}
+
+	header := http.Header{}
+	header.Set("X-GoMail-Event", event.Type)
+	header.Set("X-GoMail-Delivery-Attempt", fmt.Sprintf("%d", queued.Attempts))
+	if event.RequestID != "" {
+		header.Set("X-Request-ID", event.RequestID)
+	}
+
+	status, err := post(ctx, target, body, header, e.secrets, e.now())
+	if err == nil {
+		metrics.WebhookEvents.WithLabelValues("success").Inc()
+		logger.Infow("Webhook event delivered", "target", target.Name, "attempt", queued.Attempts)
+		e.remove(event.ID)
+		return
+	}
+	if ctx.Err() != nil {
+		// Interrupted by shutdown; the attempt does not count
+		return
+	}
+	queued.LastStatus = status
+	queued.LastError = err.Error()
+
+	if queued.Attempts >= target.MaxRetries {
+		metrics.WebhookEvents.WithLabelValues("exhausted").Inc()
+		logger.Errorw("Webhook event delivery failed, moving to dead letters",
+			"target", target.Name, "attempts", queued.Attempts, "http_status", status, "error", err)
+		e.deadLetter(queued)
+		return
+	}
+
+	queued.NextAttempt = e.now().Add(backoff(target.RetryDelay, queued.Attempts))
+	metrics.WebhookEvents.WithLabelValues("error").Inc()
+	logger.Warnw("Webhook event delivery failed, will retry",
+		"target", target.Name, "attempt", queued.Attempts, "next_attempt", queued.NextAttempt, "error", err)
+	if err := e.save(queued); err != nil {
+		logger.Errorf("Failed to save event state: %v", err)
+	}
+}
+
+// deadLetter moves an event that will not be retried to data_dir/events/dead.
+// Moving the file back to data_dir/events sends it again.
+func (e *Events) deadLetter(queued *queuedEvent) {
+	if err := e.write(e.deadDir, queued); err != nil {
+		e.logger.Errorf("Failed to dead-letter event %s: %v", queued.Event.ID, err)
+		return
+	}
+	e.remove(queued.Event.ID)
+}
+
+func (e *Events) save(queued *queuedEvent) error {
+	return e.write(e.dir, queued)
+}
+
+func (e *Events) write(dir string, queued *queuedEvent) error {
+	data, err := json.Marshal(queued)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	path := filepath.Join(dir, queued.Event.ID+".json")
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return nil
+}
+
+func (e *Events) load(name string) (*queuedEvent, error) {
+	data, err := os.ReadFile(filepath.Join(e.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var queued queuedEvent
+	if err := json.Unmarshal(data, &queued); err != nil {
+		return nil, err
+	}
+	if queued.Event == nil {
+		return nil, errors.New("missing event")
+	}
+	return &queued, nil
+}
+
+func (e *Events) remove(id string) {
+	if err := os.Remove(filepath.Join(e.dir, id+".json")); err != nil && !os.IsNotExist(err) {
+		e.logger.Errorf("Failed to remove event %s: %v", id, err)
+	}
+}
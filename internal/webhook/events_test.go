@@ -0,0 +1,187 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/pkg/webhooksig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEvents(t *testing.T, cfg *config.Config) *Events {
+	t.Helper()
+
+	cfg.DataDir = t.TempDir()
+	events, err := NewEvents(cfg)
+	require.NoError(t, err)
+	return events
+}
+
+func queuedEvents(t *testing.T, events *Events) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(events.dir, "evt_*.json"))
+	require.NoError(t, err)
+	return matches
+}
+
+func TestEvents_Deliver(t *testing.T) {
+	var received Event
+	var header http.Header
+	var signatureErr error
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ := io.ReadAll(r.Body)
+		signatureErr = webhooksig.Verify(r.Header.Get(webhooksig.Header), body, time.Minute, "shared-secret")
+		require.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	events := newTestEvents(t, &config.Config{
+		WebhookURL:         server.URL,
+		WebhookBearerToken: "webhook-token",
+		WebhookSecret:      "shared-secret",
+	})
+
+	data := map[string]string{"recipient": "bob@example.net", "remote_mx": "mx.example.net"}
+	require.NoError(t, events.Publish("delivery.delivered", "req-42", "alice@example.com", data))
+	require.Len(t, queuedEvents(t, events), 1, "events are stored before they are sent")
+
+	events.ProcessPending(context.Background())
+	events.wg.Wait()
+
+	assert.Equal(t, "delivery.delivered", received.Type)
+	assert.Equal(t, "req-42", received.RequestID)
+	assert.Regexp(t, `^evt_\d+_[0-9a-f]{8}$`, received.ID)
+	assert.JSONEq(t, `{"recipient": "bob@example.net", "remote_mx": "mx.example.net"}`, string(received.Data))
+
+	assert.Equal(t, "Bearer webhook-token", header.Get("Authorization"))
+	assert.Equal(t, "delivery.delivered", header.Get("X-GoMail-Event"))
+	assert.Equal(t, "req-42", header.Get("X-Request-ID"))
+	assert.NoError(t, signatureErr)
+
+	assert.Empty(t, queuedEvents(t, events))
+}
+
+func TestEvents_Retry(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	events := newTestEvents(t, &config.Config{WebhookURL: server.URL, WebhookMaxRetries: 2, WebhookRetryDelay: 60})
+	now := time.Now()
+	events.now = func() time.Time { return now }
+
+	require.NoError(t, events.Publish("delivery.failed", "req-1", "alice@example.com", map[string]string{}))
+
+	events.ProcessPending(context.Background())
+	events.wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+	require.Len(t, queuedEvents(t, events), 1)
+
+	// Not retried before the backoff has passed
+	events.ProcessPending(context.Background())
+	events.wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+
+	// The last allowed attempt moves the event to the dead letters
+	now = now.Add(time.Minute)
+	events.ProcessPending(context.Background())
+	events.wg.Wait()
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Empty(t, queuedEvents(t, events))
+
+	dead, err := filepath.Glob(filepath.Join(events.dir, "dead", "evt_*.json"))
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	data, err := os.ReadFile(dead[0])
+	require.NoError(t, err)
+	var queued queuedEvent
+	require.NoError(t, json.Unmarshal(data, &queued))
+	assert.Equal(t, "delivery.failed", queued.Event.Type)
+	assert.Equal(t, 2, queued.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, queued.LastStatus)
+}
+
+func TestEvents_Routing(t *testing.T) {
+	var defaultCalls, routeCalls atomic.Int32
+	defaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultCalls.Add(1)
+	}))
+	defer defaultServer.Close()
+	routeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routeCalls.Add(1)
+	}))
+	defer routeServer.Close()
+
+	events := newTestEvents(t, &config.Config{
+		WebhookURL:    defaultServer.URL,
+		WebhookRoutes: []config.WebhookRoute{{Name: "billing", URL: routeServer.URL, Match: []string{"billing.example.com"}}},
+	})
+
+	require.NoError(t, events.Publish("delivery.delivered", "", "invoices@billing.example.com", nil))
+	require.NoError(t, events.Publish("delivery.delivered", "", "alice@example.com", nil))
+	events.ProcessPending(context.Background())
+	events.wg.Wait()
+
+	assert.Equal(t, int32(1), routeCalls.Load(), "events follow the sender's route")
+	assert.Equal(t, int32(1), defaultCalls.Load())
+
+	// Without a webhook for the address nothing is queued
+	unrouted := newTestEvents(t, &config.Config{
+		WebhookRoutes: []config.WebhookRoute{{URL: routeServer.URL, Match: []string{"billing.example.com"}}},
+	})
+	require.NoError(t, unrouted.Publish("delivery.delivered", "", "alice@example.com", nil))
+	assert.Empty(t, queuedEvents(t, unrouted))
+}
+
+func TestEvents_SlowTargetDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	var slowCalls, fastCalls atomic.Int32
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowCalls.Add(1)
+		<-release
+	}))
+	defer slowServer.Close()
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastCalls.Add(1)
+	}))
+	defer fastServer.Close()
+
+	events := newTestEvents(t, &config.Config{
+		WebhookURL:    fastServer.URL,
+		WebhookRoutes: []config.WebhookRoute{{Name: "slow", URL: slowServer.URL, Match: []string{"slow.example.com"}}},
+	})
+
+	require.NoError(t, events.Publish("delivery.delivered", "", "a@slow.example.com", nil))
+	require.NoError(t, events.Publish("delivery.delivered", "", "b@slow.example.com", nil))
+	require.NoError(t, events.Publish("delivery.delivered", "", "alice@example.com", nil))
+	events.ProcessPending(context.Background())
+
+	assert.Eventually(t, func() bool { return fastCalls.Load() == 1 && slowCalls.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, queuedEvents(t, events), 2, "a target gets one event at a time")
+
+	// A pass while the slow target is busy leaves its events alone
+	events.ProcessPending(context.Background())
+	assert.Equal(t, int32(1), slowCalls.Load())
+
+	close(release)
+	events.wg.Wait()
+	assert.Equal(t, int32(2), slowCalls.Load())
+	assert.Empty(t, queuedEvents(t, events))
+}
@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/grumpyguvner/gomail/internal/domainmatch"
+)
+
+// Target is a webhook endpoint together with its delivery policy
+type Target struct {
+	Name        string
+	URL         string
+	BearerToken string
+	MaxRetries  int
+	RetryDelay  time.Duration
+
+	client *http.Client
+}
+
+// route pairs a target with the recipients it serves
+type route struct {
+	target     *Target
+	recipients []string // lowercased address patterns
+	domains    []string // lowercased domains, "*." prefix matches subdomains
+}
+
+// Router chooses the webhook target for a recipient address
+type Router struct {
+	routes   []route
+	fallback *Target
+	defaults Target // global retry settings, used when a message cannot be routed
+}
+
+// NewRouter builds a router from the webhook configuration. Route timeouts
+// and retry settings left at zero inherit the global webhook_* values; bearer
+// tokens are never inherited so one product's credentials are not sent to another.
+func NewRouter(cfg *config.Config) *Router {
+	defaults := Target{
+		Name:        "default",
+		URL:         cfg.WebhookURL,
+		BearerToken: cfg.WebhookBearerToken,
+		MaxRetries:  cfg.WebhookMaxRetries,
+		RetryDelay:  time.Duration(cfg.WebhookRetryDelay) * time.Second,
+	}
+	if defaults.MaxRetries <= 0 {
+		defaults.MaxRetries = 5
+	}
+	if defaults.RetryDelay <= 0 {
+		defaults.RetryDelay = 5 * time.Second
+	}
+	timeout := time.Duration(cfg.WebhookTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	r := &Router{defaults: defaults}
+
+	if defaults.URL != "" {
+		fallback := defaults
+		fallback.client = &http.Client{Timeout: timeout}
+		r.fallback = &fallback
+	}
+
+	for i, rc := range cfg.WebhookRoutes {
+		target := &Target{
+			Name:        rc.Name,
+			URL:         rc.URL,
+			BearerToken: rc.BearerToken,
+			MaxRetries:  rc.MaxRetries,
+			RetryDelay:  time.Duration(rc.RetryDelay) * time.Second,
+		}
+		if target.Name == "" {
+			target.Name = fmt.Sprintf("route-%d", i+1)
+		}
+		if target.MaxRetries <= 0 {
+			target.MaxRetries = defaults.MaxRetries
+		}
+		if target.RetryDelay <= 0 {
+			target.RetryDelay = defaults.RetryDelay
+		}
+		routeTimeout := time.Duration(rc.Timeout) * time.Second
+		if routeTimeout <= 0 {
+			routeTimeout = timeout
+		}
+		target.client = &http.Client{Timeout: routeTimeout}
+
+		rt := route{target: target}
+		for _, pattern := range rc.Match {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if strings.Contains(pattern, "@") {
+				rt.recipients = append(rt.recipients, pattern)
+			} else if pattern != "" {
+				rt.domains = append(rt.domains, pattern)
+			}
+		}
+		r.routes = append(r.routes, rt)
+	}
+
+	return r
+}
+
+// Route returns the target for a recipient. Recipient patterns take
+// precedence over domain matches; within each kind the first configured
+// route wins. Unmatched recipients use the default target, which is nil
+// when webhook_url is not set.
+func (r *Router) Route(recipient string) *Target {
+	address := normalizeAddress(recipient)
+	domain := ""
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		domain = address[at+1:]
+	}
+
+	for _, rt := range r.routes {
+		for _, pattern := range rt.recipients {
+			if ok, _ := path.Match(pattern, address); ok {
+				return rt.target
+			}
+		}
+	}
+
+	if domain != "" {
+		for _, rt := range r.routes {
+			for _, pattern := range rt.domains {
+				if domainmatch.Match(pattern, domain) {
+					return rt.target
+				}
+			}
+		}
+	}
+
+	return r.fallback
+}
+
+// TargetRecipients is a target together with the recipients routed to it
+type TargetRecipients struct {
+	Target     *Target
+	Recipients []string
+}
+
+// RouteAll routes each recipient and groups them by target, in the order
+// the targets are first chosen. Recipients with no target are returned in
+// unrouted. An empty list is routed as a single blank recipient, which
+// goes to the default target.
+func (r *Router) RouteAll(recipients []string) (routed []TargetRecipients, unrouted []string) {
+	if len(recipients) == 0 {
+		recipients = []string{""}
+	}
+
+	index := map[*Target]int{}
+	for _, recipient := range recipients {
+		target := r.Route(recipient)
+		if target == nil {
+			unrouted = append(unrouted, recipient)
+			continue
+		}
+		i, ok := index[target]
+		if !ok {
+			i = len(routed)
+			index[target] = i
+			routed = append(routed, TargetRecipients{Target: target})
+		}
+		routed[i].Recipients = append(routed[i].Recipients, recipient)
+	}
+	return routed, unrouted
+}
+
+// Enabled reports whether any webhook target is configured
+func (r *Router) Enabled() bool {
+	return r.fallback != nil || len(r.routes) > 0
+}
+
+// normalizeAddress lowercases an address and strips any display name or angle brackets
+func normalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if start := strings.LastIndex(address, "<"); start >= 0 {
+		if end := strings.Index(address[start:], ">"); end > 0 {
+			address = address[start+1 : start+end]
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grumpyguvner/gomail/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_Route(t *testing.T) {
+	router := NewRouter(&config.Config{
+		WebhookURL:         "https://default.example/hook",
+		WebhookBearerToken: "default-token",
+		WebhookMaxRetries:  5,
+		WebhookRetryDelay:  5,
+		WebhookRoutes: []config.WebhookRoute{
+			{
+				Name:        "shop",
+				Match:       []string{"shop.example", "*.shop-mail.example"},
+				URL:         "https://shop.example/hook",
+				BearerToken: "shop-token",
+				MaxRetries:  10,
+			},
+			{
+				Name:       "support",
+				Match:      []string{"support@shop.example", "*+help@*"},
+				URL:        "https://support.example/hook",
+				RetryDelay: 30,
+			},
+		},
+	})
+
+	tests := []struct {
+		recipient string
+		want      string
+	}{
+		{"orders@shop.example", "shop"},
+		{"Orders@SHOP.example", "shop"},
+		{"Shop <orders@shop.example>", "shop"},
+		{"orders@shop-mail.example", "shop"},
+		{"orders@eu.shop-mail.example", "shop"},
+		{"support@shop.example", "support"}, // recipient pattern beats domain
+		{"alice+help@anything.example", "support"},
+		{"someone@other.example", "default"},
+		{"", "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.recipient, func(t *testing.T) {
+			target := router.Route(tt.recipient)
+			require.NotNil(t, target)
+			assert.Equal(t, tt.want, target.Name)
+		})
+	}
+}
+
+func TestRouter_RouteAll(t *testing.T) {
+	router := NewRouter(&config.Config{
+		WebhookRoutes: []config.WebhookRoute{
+			{Name: "shop", Match: []string{"shop.example"}, URL: "https://shop.example/hook"},
+			{Name: "billing", Match: []string{"billing.example"}, URL: "https://billing.example/hook"},
+		},
+	})
+
+	routed, unrouted := router.RouteAll([]string{"a@billing.example", "b@shop.example", "c@other.example", "d@billing.example"})
+	require.Len(t, routed, 2)
+	assert.Equal(t, "billing", routed[0].Target.Name)
+	assert.Equal(t, []string{"a@billing.example", "d@billing.example"}, routed[0].Recipients)
+	assert.Equal(t, "shop", routed[1].Target.Name)
+	assert.Equal(t, []string{"b@shop.example"}, routed[1].Recipients)
+	assert.Equal(t, []string{"c@other.example"}, unrouted)
+
+	// Without recipients only a default target can take the message
+	routed, unrouted = router.RouteAll(nil)
+	assert.Empty(t, routed)
+	assert.Equal(t, []string{""}, unrouted)
+}
+
+func TestRouter_Inheritance(t *testing.T) {
+	router := NewRouter(&config.Config{
+		WebhookURL:         "https://default.example/hook",
+		WebhookBearerToken: "default-token",
+		WebhookTimeout:     15,
+		WebhookMaxRetries:  7,
+		WebhookRetryDelay:  3,
+		WebhookRoutes: []config.WebhookRoute{
+			{Match: []string{"a.example"}, URL: "https://a.example/hook"},
+			{Match: []string{"b.example"}, URL: "https://b.example/hook", Timeout: 60, MaxRetries: 2, RetryDelay: 1},
+		},
+	})
+
+	a := router.Route("x@a.example")
+	assert.Equal(t, "route-1", a.Name)
+	assert.Equal(t, 7, a.MaxRetries)
+	assert.Equal(t, 3*time.Second, a.RetryDelay)
+	assert.Equal(t, 15*time.Second, a.client.Timeout)
+	assert.Empty(t, a.BearerToken, "bearer tokens must not leak across targets")
+
+	b := router.Route("x@b.example")
+	assert.Equal(t, 2, b.MaxRetries)
+	assert.Equal(t, time.Second, b.RetryDelay)
+	assert.Equal(t, 60*time.Second, b.client.Timeout)
+}
+
+func TestRouter_NoFallback(t *testing.T) {
+	router := NewRouter(&config.Config{
+		WebhookRoutes: []config.WebhookRoute{
+			{Match: []string{"a.example"}, URL: "https://a.example/hook"},
+		},
+	})
+
+	assert.True(t, router.Enabled())
+	assert.NotNil(t, router.Route("x@a.example"))
+	assert.Nil(t, router.Route("x@b.example"))
+
+	assert.False(t, NewRouter(&config.Config{}).Enabled())
+}
@@ -0,0 +1,257 @@
+// Package webhooksig signs and verifies GoMail webhook requests.
+//
+// Every webhook delivery carries an X-GoMail-Signature header of the form
+//
+//	t=1705314600,v1=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+//
+// where t is the Unix timestamp of the delivery and each v1 value is the
+// hex-encoded HMAC-SHA256 of "<t>.<body>" under one of the shared secrets.
+// During secret rotation GoMail signs with both the current and previous
+// secret, so receivers holding either one can verify the request.
+//
+// Requests sent to GoMail itself are signed with SignRequest, which also
+// covers the method and request target: each v1 value is the HMAC-SHA256 of
+// "<t>.<METHOD> <target>\n<body>". A signature made for one endpoint cannot
+// be replayed against another.
+package webhooksig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header is the HTTP header carrying the signature
+const Header = "X-GoMail-Signature"
+
+// DefaultTolerance is the maximum accepted age of a signed request
+const DefaultTolerance = 5 * time.Minute
+
+// maxBodySize bounds how much of a request body VerifyRequest will read
+const maxBodySize = 26214400 // 25MB
+
+var (
+	// ErrMissingHeader is returned when the request has no signature header
+	ErrMissingHeader = errors.New("webhooksig: missing signature header")
+	// ErrInvalidHeader is returned when the signature header cannot be parsed
+	ErrInvalidHeader = errors.New("webhooksig: invalid signature header")
+	// ErrTimestampOutOfRange is returned when the signature is too old or too far in the future
+	ErrTimestampOutOfRange = errors.New("webhooksig: timestamp outside tolerance")
+	// ErrSignatureMismatch is returned when no signature matches any secret
+	ErrSignatureMismatch = errors.New("webhooksig: signature mismatch")
+	// ErrReplayed is returned when a signature has already been accepted
+	ErrReplayed = errors.New("webhooksig: request already seen")
+	// ErrNoSecrets is returned when verification is attempted without secrets
+	ErrNoSecrets = errors.New("webhooksig: no secrets configured")
+)
+
+// Sign returns the signature header value for body at the given time.
+// Empty secrets are skipped.
+func Sign(body []byte, timestamp time.Time, secrets ...string) string {
+	return sign("", body, timestamp, secrets)
+}
+
+// SignRequest returns the signature header value for a request with the
+// given method and target (the path plus any query string) at the given time.
+// Empty secrets are skipped.
+func SignRequest(method, target string, body []byte, timestamp time.Time, secrets ...string) string {
+	return sign(requestLine(method, target), body, timestamp, secrets)
+}
+
+// sign builds the header value, covering the request line when it is set
+func sign(line string, body []byte, timestamp time.Time, secrets []string) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	parts := []string{"t=" + ts}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		parts = append(parts, "v1="+hex.EncodeToString(compute(secret, ts, line, body)))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Verify checks a signature header against body using any of the given secrets.
+// It does not protect against replays; use a Verifier for that.
+func Verify(header string, body []byte, tolerance time.Duration, secrets ...string) error {
+	_, err := verify(header, "", body, time.Now(), tolerance, secrets)
+	return err
+}
+
+// requestLine returns the "<METHOD> <target>" covered by SignRequest
+func requestLine(method, target string) string {
+	return strings.ToUpper(method) + " " + target
+}
+
+// compute returns the raw HMAC-SHA256 of "<timestamp>.<body>", or of
+// "<timestamp>.<line>\n<body>" when line is set
+func compute(secret, timestamp, line string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	if line != "" {
+		mac.Write([]byte(line))
+		mac.Write([]byte("\n"))
+	}
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// verify validates the header and returns the matched signature
+func verify(header, line string, body []byte, now time.Time, tolerance time.Duration, secrets []string) (string, error) {
+	if header == "" {
+		return "", ErrMissingHeader
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return "", ErrInvalidHeader
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	if timestamp == "" || len(signatures) == 0 {
+		return "", ErrInvalidHeader
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrInvalidHeader
+	}
+
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	age := now.Sub(time.Unix(unix, 0))
+	if age > tolerance || age < -tolerance {
+		return "", fmt.Errorf("%w: age %v", ErrTimestampOutOfRange, age.Round(time.Second))
+	}
+
+	hasSecret := false
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		hasSecret = true
+		expected := compute(secret, timestamp, line, body)
+		for _, sig := range signatures {
+			decoded, err := hex.DecodeString(sig)
+			if err != nil {
+				continue
+			}
+			if hmac.Equal(decoded, expected) {
+				return sig, nil
+			}
+		}
+	}
+
+	if !hasSecret {
+		return "", ErrNoSecrets
+	}
+	return "", ErrSignatureMismatch
+}
+
+// Verifier verifies signatures and rejects replays of already accepted requests
+type Verifier struct {
+	secrets   []string
+	tolerance time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+// NewVerifier creates a Verifier accepting any of the given secrets.
+// A zero tolerance uses DefaultTolerance.
+func NewVerifier(tolerance time.Duration, secrets ...string) *Verifier {
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	return &Verifier{
+		secrets:   secrets,
+		tolerance: tolerance,
+		seen:      make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// Verify checks the signature header for body and records it so the same
+// request cannot be accepted twice within the tolerance window
+func (v *Verifier) Verify(header string, body []byte) error {
+	return v.check(header, "", body)
+}
+
+// check verifies the header for line and body and records the signature
+func (v *Verifier) check(header, line string, body []byte) error {
+	now := v.now()
+
+	sig, err := verify(header, line, body, now, v.tolerance, v.secrets)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Drop entries that can no longer pass the timestamp check
+	for s, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, s)
+		}
+	}
+
+	if _, ok := v.seen[sig]; ok {
+		return ErrReplayed
+	}
+	v.seen[sig] = now.Add(2 * v.tolerance)
+
+	return nil
+}
+
+// VerifyRequest verifies an incoming HTTP request. The request body is read
+// and replaced so handlers can still consume it.
+func (v *Verifier) VerifyRequest(r *http.Request) error {
+	return v.verifyRequest(r, "")
+}
+
+// VerifyRequestTarget verifies an incoming HTTP request signed with
+// SignRequest, so the signature must also match its method and target. The
+// request body is read and replaced so handlers can still consume it.
+func (v *Verifier) VerifyRequestTarget(r *http.Request) error {
+	return v.verifyRequest(r, requestLine(r.Method, r.URL.RequestURI()))
+}
+
+func (v *Verifier) verifyRequest(r *http.Request, line string) error {
+	header := r.Header.Get(Header)
+	if header == "" {
+		return ErrMissingHeader
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("webhooksig: failed to read body: %w", err)
+	}
+	_ = r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return v.check(header, line, body)
+}
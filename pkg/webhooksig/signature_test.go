@@ -0,0 +1,132 @@
+package webhooksig
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"sender":"a@example.com"}`)
+	header := Sign(body, time.Now(), "current-secret-value")
+
+	assert.True(t, strings.HasPrefix(header, "t="))
+	assert.Equal(t, 1, strings.Count(header, "v1="))
+
+	assert.NoError(t, Verify(header, body, 0, "current-secret-value"))
+	assert.ErrorIs(t, Verify(header, body, 0, "other-secret-value"), ErrSignatureMismatch)
+	assert.ErrorIs(t, Verify(header, []byte("tampered"), 0, "current-secret-value"), ErrSignatureMismatch)
+}
+
+func TestSign_Rotation(t *testing.T) {
+	body := []byte("payload")
+	header := Sign(body, time.Now(), "new-secret-value", "old-secret-value")
+	assert.Equal(t, 2, strings.Count(header, "v1="))
+
+	// Receivers holding either secret can verify
+	assert.NoError(t, Verify(header, body, 0, "new-secret-value"))
+	assert.NoError(t, Verify(header, body, 0, "old-secret-value"))
+
+	// Empty secrets are skipped
+	header = Sign(body, time.Now(), "new-secret-value", "")
+	assert.Equal(t, 1, strings.Count(header, "v1="))
+}
+
+func TestVerify_Errors(t *testing.T) {
+	body := []byte("payload")
+
+	tests := []struct {
+		name   string
+		header string
+		want   error
+	}{
+		{"missing header", "", ErrMissingHeader},
+		{"garbage", "nonsense", ErrInvalidHeader},
+		{"no signature", "t=123", ErrInvalidHeader},
+		{"bad timestamp", "t=abc,v1=00", ErrInvalidHeader},
+		{"expired", Sign(body, time.Now().Add(-time.Hour), "secret-value"), ErrTimestampOutOfRange},
+		{"future", Sign(body, time.Now().Add(time.Hour), "secret-value"), ErrTimestampOutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, Verify(tt.header, body, time.Minute, "secret-value"), tt.want)
+		})
+	}
+
+	assert.ErrorIs(t, Verify(Sign(body, time.Now(), "secret-value"), body, 0), ErrNoSecrets)
+}
+
+func TestVerifier_RejectsReplay(t *testing.T) {
+	verifier := NewVerifier(time.Minute, "secret-value")
+	body := []byte("payload")
+	header := Sign(body, time.Now(), "secret-value")
+
+	require.NoError(t, verifier.Verify(header, body))
+	assert.ErrorIs(t, verifier.Verify(header, body), ErrReplayed)
+
+	// A fresh signature for the same body is accepted
+	later := Sign(body, time.Now().Add(time.Second), "secret-value")
+	assert.NoError(t, verifier.Verify(later, body))
+}
+
+func TestVerifier_ForgetsExpiredEntries(t *testing.T) {
+	verifier := NewVerifier(time.Minute, "secret-value")
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	body := []byte("payload")
+	require.NoError(t, verifier.Verify(Sign(body, now, "secret-value"), body))
+	assert.Len(t, verifier.seen, 1)
+
+	now = now.Add(3 * time.Minute)
+	require.NoError(t, verifier.Verify(Sign(body, now, "secret-value"), body))
+	assert.Len(t, verifier.seen, 1)
+}
+
+func TestVerifier_VerifyRequest(t *testing.T) {
+	verifier := NewVerifier(0, "secret-value")
+	body := `{"hello":"world"}`
+
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+	req.Header.Set(Header, Sign([]byte(body), time.Now(), "secret-value"))
+
+	require.NoError(t, verifier.VerifyRequest(req))
+
+	// Body is still readable by the handler
+	restored, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(restored))
+
+	unsigned := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+	assert.ErrorIs(t, verifier.VerifyRequest(unsigned), ErrMissingHeader)
+}
+
+func TestVerifier_VerifyRequestTarget(t *testing.T) {
+	verifier := NewVerifier(0, "secret-value")
+	body := `{"hello":"world"}`
+
+	send := func(method, target, signature string) error {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(Header, signature)
+		return verifier.VerifyRequestTarget(req)
+	}
+
+	now := time.Now()
+	require.NoError(t, send("POST", "/mail/inbound", SignRequest("POST", "/mail/inbound", []byte(body), now, "secret-value")))
+
+	// The signature covers the method and target as well as the body
+	now = now.Add(time.Second)
+	signature := SignRequest("POST", "/mail/inbound", []byte(body), now, "secret-value")
+	assert.ErrorIs(t, send("DELETE", "/mail/inbound", signature), ErrSignatureMismatch)
+	assert.ErrorIs(t, send("POST", "/api/suppressions", signature), ErrSignatureMismatch)
+	assert.ErrorIs(t, send("POST", "/mail/inbound?x=1", signature), ErrSignatureMismatch)
+
+	// A body-only signature, as sent on webhook deliveries, is not accepted
+	assert.ErrorIs(t, send("POST", "/mail/inbound", Sign([]byte(body), now, "secret-value")), ErrSignatureMismatch)
+}
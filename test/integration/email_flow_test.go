@@ -81,7 +81,7 @@ func TestCompleteEmailFlow(t *testing.T) {
 		ReceivedAt: time.Now(),
 	}
 
-	storedPath, err := store.Store(testEmail)
+	_, storedPath, err := storage.StoreEmail(context.Background(), store, testEmail)
 	require.NoError(t, err)
 	assert.FileExists(t, storedPath)
 
@@ -93,7 +93,7 @@ func TestCompleteEmailFlow(t *testing.T) {
 	assert.Equal(t, testEmail.Subject, loaded.Subject)
 
 	// Test 3: List stored emails
-	files, err := store.List(time.Now())
+	files, err := store.ListDate(time.Now())
 	require.NoError(t, err)
 	assert.NotEmpty(t, files)
 	assert.Contains(t, files, storedPath)
@@ -155,7 +155,7 @@ And even paragraphs.`
 	store, err := storage.NewFileStorage(tempDir)
 	require.NoError(t, err)
 
-	storedPath, err := store.Store(emailData)
+	_, storedPath, err := storage.StoreEmail(context.Background(), store, emailData)
 	require.NoError(t, err)
 	assert.FileExists(t, storedPath)
 
@@ -186,13 +186,13 @@ func TestStorageIntegration(t *testing.T) {
 			ReceivedAt: time.Now(),
 		}
 
-		path, err := store.Store(email)
+		_, path, err := storage.StoreEmail(context.Background(), store, email)
 		require.NoError(t, err)
 		storedPaths = append(storedPaths, path)
 	}
 
 	// List today's emails
-	files, err := store.List(time.Now())
+	files, err := store.ListDate(time.Now())
 	require.NoError(t, err)
 	assert.Len(t, files, 5)
 
@@ -206,17 +206,17 @@ func TestStorageIntegration(t *testing.T) {
 	assert.NoFileExists(t, storedPaths[0])
 
 	// List should now show 4 files
-	files, err = store.List(time.Now())
+	files, err = store.ListDate(time.Now())
 	require.NoError(t, err)
 	assert.Len(t, files, 4)
 
 	// Delete one
-	err = store.Delete(storedPaths[1])
+	err = store.Remove(storedPaths[1])
 	require.NoError(t, err)
 	assert.NoFileExists(t, storedPaths[1])
 
 	// List should now show 3 files
-	files, err = store.List(time.Now())
+	files, err = store.ListDate(time.Now())
 	require.NoError(t, err)
 	assert.Len(t, files, 3)
 }
@@ -245,7 +245,7 @@ func TestConcurrentEmailProcessing(t *testing.T) {
 				ReceivedAt: time.Now(),
 			}
 
-			path, err := store.Store(email)
+			_, path, err := storage.StoreEmail(context.Background(), store, email)
 			if err != nil {
 				errChan <- err
 			} else {
@@ -276,7 +276,7 @@ func TestConcurrentEmailProcessing(t *testing.T) {
 	}
 
 	// List and verify count
-	files, err := store.List(time.Now())
+	files, err := store.ListDate(time.Now())
 	require.NoError(t, err)
 	assert.Len(t, files, numEmails)
 }